@@ -17,6 +17,7 @@ package exec
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -37,7 +38,9 @@ import (
 	leasectrl "github.com/open-cluster-management/multicloud-operators-subscription/pkg/controller/subscription"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/subscriber"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/synchronizer"
+	awsutils "github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils/aws"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/webhook"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/webhook/listener"
 	ocinfrav1 "github.com/openshift/api/config/v1"
 )
 
@@ -117,6 +120,16 @@ func RunManager() {
 
 	klog.Info("Starting ... Registering Components for cluster: ", id)
 
+	if Options.LocalObjectStoreDir != "" {
+		klog.Info("Enabling the local filesystem object store in ", Options.LocalObjectStoreDir)
+		awsutils.EnableLocalObjectStore(Options.LocalObjectStoreDir)
+	}
+
+	if Options.ObjectStoreAmbientCredentials {
+		klog.Info("Enabling the default AWS credential chain for the S3 channels")
+		awsutils.EnableAmbientCredentials(true)
+	}
+
 	// Setup ansibleJob Scheme for manager
 	if err := ansiblejob.AddToScheme(mgr.GetScheme()); err != nil {
 		klog.Error(err, "")
@@ -136,8 +149,15 @@ func RunManager() {
 			os.Exit(1)
 		}
 
+		deliveryOpts, err := deliveryOptions()
+		if err != nil {
+			klog.Error(err, "")
+			os.Exit(1)
+		}
+
 		// Setup Webhook listner
-		if err := webhook.AddToManager(mgr, hubconfig, Options.TLSKeyFilePathName, Options.TLSCrtFilePathName, Options.DisableTLS, true); err != nil {
+		if err := webhook.AddToManager(mgr, hubconfig, Options.TLSKeyFilePathName, Options.TLSCrtFilePathName, Options.DisableTLS, true,
+			Options.WebhookPort, deliveryOpts); err != nil {
 			klog.Error("Failed to initialize WebHook listener with error:", err)
 			os.Exit(1)
 		}
@@ -213,8 +233,15 @@ func setupStandalone(mgr manager.Manager, hubconfig *rest.Config, id *types.Name
 	}
 
 	if standalone {
+		deliveryOpts, err := deliveryOptions()
+		if err != nil {
+			klog.Error(err, "")
+			return err
+		}
+
 		// Setup Webhook listner
-		if err := webhook.AddToManager(mgr, hubconfig, Options.TLSKeyFilePathName, Options.TLSCrtFilePathName, Options.DisableTLS, false); err != nil {
+		if err := webhook.AddToManager(mgr, hubconfig, Options.TLSKeyFilePathName, Options.TLSCrtFilePathName, Options.DisableTLS, false,
+			Options.WebhookPort, deliveryOpts); err != nil {
 			klog.Error("Failed to initialize WebHook listener with error:", err)
 			return err
 		}
@@ -222,3 +249,23 @@ func setupStandalone(mgr manager.Manager, hubconfig *rest.Config, id *types.Name
 
 	return nil
 }
+
+// deliveryOptions returns the webhook delivery options of the command line flags
+func deliveryOptions() (listener.DeliveryOptions, error) {
+	opts := listener.DeliveryOptions{
+		CoalesceWindow: time.Duration(Options.WebhookCoalesceWindow) * time.Second,
+		RateLimit:      Options.WebhookRateLimit,
+		HistorySize:    Options.WebhookHistorySize,
+	}
+
+	for _, cidr := range Options.WebhookTrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid webhook trusted proxy %s: %w", cidr, err)
+		}
+
+		opts.TrustedProxies = append(opts.TrustedProxies, network)
+	}
+
+	return opts, nil
+}
@@ -20,16 +20,23 @@ import (
 
 // SubscriptionCMDOptions for command line flag parsing
 type SubscriptionCMDOptions struct {
-	MetricsAddr           string
-	ClusterName           string
-	ClusterNamespace      string
-	HubConfigFilePathName string
-	TLSKeyFilePathName    string
-	TLSCrtFilePathName    string
-	SyncInterval          int
-	DisableTLS            bool
-	Standalone            bool
-	LeaseDurationSeconds  int
+	MetricsAddr                   string
+	ClusterName                   string
+	ClusterNamespace              string
+	HubConfigFilePathName         string
+	TLSKeyFilePathName            string
+	TLSCrtFilePathName            string
+	SyncInterval                  int
+	DisableTLS                    bool
+	Standalone                    bool
+	LeaseDurationSeconds          int
+	WebhookPort                   int
+	WebhookCoalesceWindow         int
+	WebhookRateLimit              int
+	WebhookHistorySize            int
+	WebhookTrustedProxies         []string
+	LocalObjectStoreDir           string
+	ObjectStoreAmbientCredentials bool
 }
 
 var Options = SubscriptionCMDOptions{
@@ -37,6 +44,11 @@ var Options = SubscriptionCMDOptions{
 	SyncInterval:         60,
 	LeaseDurationSeconds: 60,
 	Standalone:           false,
+	WebhookPort:          8443,
+	// The defaults of the webhook delivery options are listener.DefaultDeliveryOptions
+	WebhookCoalesceWindow: 10,
+	WebhookRateLimit:      120,
+	WebhookHistorySize:    100,
 }
 
 // ProcessFlags parses command line parameters into Options
@@ -112,4 +124,53 @@ func ProcessFlags() {
 		Options.DisableTLS,
 		"Disable TLS on WebHook event listener.",
 	)
+
+	flag.IntVar(
+		&Options.WebhookPort,
+		"webhook-port",
+		Options.WebhookPort,
+		"The port of the WebHook event listener.",
+	)
+
+	flag.IntVar(
+		&Options.WebhookCoalesceWindow,
+		"webhook-coalesce-window",
+		Options.WebhookCoalesceWindow,
+		"The window in seconds within which the webhook events of a subscription trigger a single sync. 0 disables coalescing.",
+	)
+
+	flag.IntVar(
+		&Options.WebhookRateLimit,
+		"webhook-rate-limit",
+		Options.WebhookRateLimit,
+		"The number of webhook events per minute accepted from a source IP and for a repository. 0 disables rate limiting.",
+	)
+
+	flag.IntVar(
+		&Options.WebhookHistorySize,
+		"webhook-history-size",
+		Options.WebhookHistorySize,
+		"The number of webhook deliveries kept in the delivery history.",
+	)
+
+	flag.StringSliceVar(
+		&Options.WebhookTrustedProxies,
+		"webhook-trusted-proxies",
+		Options.WebhookTrustedProxies,
+		"The CIDRs of the proxies in front of the WebHook event listener whose X-Forwarded-For header gives the source IP of the webhook events.",
+	)
+
+	flag.StringVar(
+		&Options.LocalObjectStoreDir,
+		"local-object-store-dir",
+		Options.LocalObjectStoreDir,
+		"The directory of the buckets of the file:// object bucket channels. The local filesystem object store is disabled if it's empty.",
+	)
+
+	flag.BoolVar(
+		&Options.ObjectStoreAmbientCredentials,
+		"object-store-ambient-credentials",
+		Options.ObjectStoreAmbientCredentials,
+		"Let the S3 channels without credentials in the channel secret use the default AWS credential chain of the pod and assume roles with it.",
+	)
 }
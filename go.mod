@@ -3,16 +3,24 @@ module github.com/open-cluster-management/multicloud-operators-subscription
 go 1.16
 
 require (
+	cloud.google.com/go/storage v1.11.0
+	cuelang.org/go v0.4.0
+	github.com/Azure/azure-storage-blob-go v0.13.0
+	github.com/Masterminds/semver/v3 v3.1.1
 	github.com/aws/aws-sdk-go-v2 v1.3.2
 	github.com/aws/aws-sdk-go-v2/config v1.1.5
+	github.com/aws/aws-sdk-go-v2/credentials v1.1.5
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.5.0
+	github.com/aws/aws-sdk-go-v2/service/sts v1.2.2
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/fsouza/fake-gcs-server v1.21.0
 	github.com/ghodss/yaml v1.0.1-0.20190212211648-25d852aebe32
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/go-logr/logr v0.3.0
 	github.com/go-openapi/spec v0.19.5
 	github.com/google/go-cmp v0.5.4
 	github.com/google/go-github/v32 v32.1.0
+	github.com/google/go-jsonnet v0.17.0
 	github.com/johannesboyne/gofakes3 v0.0.0-20200218152459-de0855a40bc1
 	github.com/onsi/ginkgo v1.12.1
 	github.com/onsi/gomega v1.10.1
@@ -28,6 +36,8 @@ require (
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	google.golang.org/api v0.31.0
 	gopkg.in/src-d/go-git.v4 v4.13.1
 	helm.sh/helm/v3 v3.5.2
 	k8s.io/api v0.20.2
@@ -54,16 +54,26 @@ var (
 	AnnotationGithubCommit = SchemeGroupVersion.Group + "/github-commit"
 	// AnnotationGitPath defines webhook secret
 	AnnotationGitPath = SchemeGroupVersion.Group + "/git-path"
+	// AnnotationGitPaths defines an ordered list of Git repo paths or path globs, each with optional include and exclude patterns
+	AnnotationGitPaths = SchemeGroupVersion.Group + "/git-paths"
 	// AnnotationGitBranch defines webhook secret
 	AnnotationGitBranch = SchemeGroupVersion.Group + "/git-branch"
 	// AnnotationGitCommit defines currently deployed Git repo commit ID
 	AnnotationGitCommit = SchemeGroupVersion.Group + "/git-current-commit"
 	// AnnotationGitCloneDepth defines Git repo clone depth to be able to check out previous commits
 	AnnotationGitCloneDepth = SchemeGroupVersion.Group + "/git-clone-depth"
+	// AnnotationGitCurrentTag defines the Git repo tag of the currently deployed commit
+	AnnotationGitCurrentTag = SchemeGroupVersion.Group + "/git-current-tag"
 	// AnnotationGitTargetCommit defines Git repo commit to be deployed
 	AnnotationGitTargetCommit = SchemeGroupVersion.Group + "/git-desired-commit"
 	// AnnotationGitTag defines Git repo revision tag
 	AnnotationGitTag = SchemeGroupVersion.Group + "/git-tag"
+	// AnnotationJsonnetExtVars defines the external variables of Jsonnet sources in a Git repo as a JSON object
+	AnnotationJsonnetExtVars = SchemeGroupVersion.Group + "/jsonnet-ext-vars"
+	// AnnotationJsonnetTLAs defines the top-level arguments of Jsonnet sources in a Git repo as a JSON object
+	AnnotationJsonnetTLAs = SchemeGroupVersion.Group + "/jsonnet-tlas"
+	// AnnotationCueTags defines the values injected into @tag() fields of CUE sources in a Git repo as a JSON object
+	AnnotationCueTags = SchemeGroupVersion.Group + "/cue-tags"
 	// AnnotationClusterAdmin indicates the subscription has cluster admin access
 	AnnotationClusterAdmin = SchemeGroupVersion.Group + "/cluster-admin"
 	// AnnotationChannelType indicates the channel type for subscription
@@ -78,14 +88,44 @@ var (
 	AnnotationResourceReconcileLevel = SchemeGroupVersion.Group + "/reconcile-rate"
 	// AnnotationManualReconcileTime is the time user triggers a manual resource reconcile
 	AnnotationManualReconcileTime = SchemeGroupVersion.Group + "/manual-refresh-time"
+	// AnnotationManualSyncID is the ID of the last sync requested with the manual sync API of the webhook listener
+	AnnotationManualSyncID = SchemeGroupVersion.Group + "/manual-sync-id"
+	// AnnotationManualSyncPin is the commit, tag or chart-version pinned by the last manual sync request, the pin is
+	// removed by the next request
+	AnnotationManualSyncPin = SchemeGroupVersion.Group + "/manual-sync-pin"
+	// AnnotationHookRollbackPin is the commit pinned by the rollback hook failure policy, the pin is removed when the
+	// branch has a newer commit than the failed one
+	AnnotationHookRollbackPin = SchemeGroupVersion.Group + "/hook-rollback-pin"
 	//LabelSubscriptionPause sits in subscription label to identify if the subscription is paused or not
 	LabelSubscriptionPause = "subscription-pause"
 	//LabelSubscriptionName is the subscription name
 	LabelSubscriptionName = SchemeGroupVersion.Group + "/subscription"
 	// AnnotationHookType defines ansible hook job type - prehook/posthook
 	AnnotationHookType = SchemeGroupVersion.Group + "/hook-type"
+	// AnnotationHookTargetClusters defines the comma separated clusters placed by the subscription of a hook
+	AnnotationHookTargetClusters = SchemeGroupVersion.Group + "/hook-target-clusters"
+	// AnnotationHookTimeout defines how long a hook runs before it's timed out, as a duration such as 10m
+	AnnotationHookTimeout = SchemeGroupVersion.Group + "/hook-timeout"
+	// AnnotationHookRetries defines how many times a failed or timed out hook is retried
+	AnnotationHookRetries = SchemeGroupVersion.Group + "/hook-retries"
+	// AnnotationHookBackoff defines the delay before the first retry of a hook, doubled for each next retry
+	AnnotationHookBackoff = SchemeGroupVersion.Group + "/hook-backoff"
+	// AnnotationHookFailurePolicy defines what the subscription does when a hook fails after its retries
+	AnnotationHookFailurePolicy = SchemeGroupVersion.Group + "/hook-failure-policy"
+	// AnnotationHookContextConfigMap defines the ConfigMap holding the hook context passed to a hook
+	AnnotationHookContextConfigMap = SchemeGroupVersion.Group + "/hook-context-configmap"
 	// AnnotationBucketPath defines s3 object bucket subfolder path
 	AnnotationBucketPath = SchemeGroupVersion.Group + "/bucket-path"
+	// AnnotationBucketSnapshot defines the time of the versioned object bucket snapshot to deploy, in RFC 3339 format
+	AnnotationBucketSnapshot = SchemeGroupVersion.Group + "/bucket-snapshot"
+	// AnnotationChartVerification defines how the provenance of Helm charts from a Helm repo is verified
+	AnnotationChartVerification = SchemeGroupVersion.Group + "/chart-verification"
+	// AnnotationHelmDependencySecret defines the secret with the credentials of the Helm repos of the chart dependencies
+	AnnotationHelmDependencySecret = SchemeGroupVersion.Group + "/helm-dependency-secret"
+	// AnnotationHelmTest runs the test hooks of the Helm charts after each successful install or upgrade when true
+	AnnotationHelmTest = SchemeGroupVersion.Group + "/helm-test"
+	// AnnotationHelmTestTimeout defines the time to wait for the test hooks of a Helm release
+	AnnotationHelmTestTimeout = SchemeGroupVersion.Group + "/helm-test-timeout"
 )
 
 const (
@@ -103,6 +143,45 @@ const (
 	SubscriptionNameSuffix = ""
 	// ChannelCertificateData is the configmap data spec field containing trust certificates
 	ChannelCertificateData = "caCerts"
+	// ChannelSSHKnownHostsData is the configmap data spec field containing the known_hosts of the Git SSH server
+	ChannelSSHKnownHostsData = "sshKnownHosts"
+	// ChannelSSHHostKeyPolicy is the configmap data spec field defining how unknown Git SSH host keys are handled
+	ChannelSSHHostKeyPolicy = "sshHostKeyPolicy"
+	// ChannelKeyringData is the secret data spec field containing the GnuPG public keyring that Helm charts are signed with
+	ChannelKeyringData = "keyring"
+	// ChartVerificationNone does not verify Helm charts
+	ChartVerificationNone = "none"
+	// ChartVerificationWarn verifies Helm charts and records the result but deploys the charts anyway
+	ChartVerificationWarn = "warn"
+	// ChartVerificationIfSigned refuses tampered Helm charts and deploys unsigned charts
+	ChartVerificationIfSigned = "ifSigned"
+	// ChartVerificationRequired refuses unsigned and tampered Helm charts
+	ChartVerificationRequired = "required"
+	// ChartVerified is the result of a Helm chart that matches its provenance file signed with the keyring
+	ChartVerified = "Verified"
+	// ChartUnsigned is the result of a Helm chart without provenance file
+	ChartUnsigned = "Unsigned"
+	// ChartVerificationFailed is the result of a Helm chart that failed the verification
+	ChartVerificationFailed = "Failed"
+	// HelmTestRunning is the result of Helm release tests that are running
+	HelmTestRunning = "Running"
+	// HelmTestPassed is the result of Helm release tests that all succeeded
+	HelmTestPassed = "Passed"
+	// HelmTestFailed is the result of Helm release tests of which one or more failed
+	HelmTestFailed = "Failed"
+	// HookFailurePolicyWait keeps the subscription waiting for a failed prehook and checks the hooks again at the hook
+	// requeue interval, it's the default
+	HookFailurePolicyWait = "wait"
+	// HookFailurePolicyAbort stops the subscription on a failed prehook, the resources are not deployed
+	HookFailurePolicyAbort = "abort"
+	// HookFailurePolicyContinue ignores the failed hook
+	HookFailurePolicyContinue = "continue"
+	// HookFailurePolicyRollback deploys the last commit of which all the hooks succeeded
+	HookFailurePolicyRollback = "rollback"
+	// HookConditionPrehooksFailed is the condition type of the failed or timed out prehooks
+	HookConditionPrehooksFailed = "PrehooksFailed"
+	// HookConditionPosthooksFailed is the condition type of the failed or timed out posthooks
+	HookConditionPosthooksFailed = "PosthooksFailed"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -115,6 +194,18 @@ type PackageFilter struct {
 	// +kubebuilder:validation:Pattern=([0-9]+)((\.[0-9]+)(\.[0-9]+)|(\.[0-9]+)?(\.[xX]))$
 	Version   string                       `json:"version,omitempty"`
 	FilterRef *corev1.LocalObjectReference `json:"filterRef,omitempty"`
+	// Packages selects Helm charts by name with their own version ranges. Without package name in the subscription,
+	// only the charts of the list are deployed.
+	Packages []PackageVersions `json:"packages,omitempty"`
+}
+
+// PackageVersions selects a Helm chart and the versions of it to deploy
+type PackageVersions struct {
+	// Name of the Helm chart
+	Name string `json:"name"`
+	// Versions are version ranges such as ">=1.2.0 <2.0.0". The latest version matching each range is deployed in its
+	// own HelmRelease. The version of the package filter is used when empty.
+	Versions []string `json:"versions,omitempty"`
 }
 
 // PackageOverride describes rules for override
@@ -193,6 +284,50 @@ type SubscriptionUnitStatus struct {
 	LastUpdateTime metav1.Time       `json:"lastUpdateTime"`
 
 	ResourceStatus *runtime.RawExtension `json:"resourceStatus,omitempty"`
+
+	// ChartVerification is the provenance verification result of the Helm chart of the package
+	ChartVerification *ChartVerificationStatus `json:"chartVerification,omitempty"`
+
+	// ChartVersion is the version of the Helm chart deployed for the package
+	ChartVersion string `json:"chartVersion,omitempty"`
+
+	// HelmTest is the result of the test hooks of the Helm release of the package
+	HelmTest *HelmTestStatus `json:"helmTest,omitempty"`
+}
+
+// ChartVerificationStatus defines the provenance verification result of a Helm chart
+type ChartVerificationStatus struct {
+	// Chart is the name and version of the verified chart
+	Chart string `json:"chart"`
+	// Result is Verified, Unsigned or Failed
+	Result string `json:"result"`
+	// Refused is true if the chart was not deployed because of the result
+	Refused  bool   `json:"refused,omitempty"`
+	SignedBy string `json:"signedBy,omitempty"`
+	FileHash string `json:"fileHash,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// HelmTestStatus defines the result of the test hooks of a Helm release
+type HelmTestStatus struct {
+	// Revision is the tested revision of the Helm release
+	Revision int `json:"revision"`
+	// Result is Running, Passed or Failed
+	Result        string               `json:"result"`
+	Message       string               `json:"message,omitempty"`
+	StartedTime   metav1.Time          `json:"startedTime,omitempty"`
+	CompletedTime *metav1.Time         `json:"completedTime,omitempty"`
+	Tests         []HelmTestHookStatus `json:"tests,omitempty"`
+}
+
+// HelmTestHookStatus defines the result of a test hook of a Helm release
+type HelmTestHookStatus struct {
+	// Name of the test pod
+	Name string `json:"name"`
+	// Phase is the phase of the last run of the test hook, such as Succeeded or Failed
+	Phase string `json:"phase,omitempty"`
+	// Log is an excerpt of the end of the test pod log
+	Log string `json:"log,omitempty"`
 }
 
 // SubscriptionPerClusterStatus defines status for subscription in each cluster, key is package name
@@ -212,6 +347,57 @@ type AnsibleJobsStatus struct {
 	PosthookJobsHistory []string `json:"posthookjobshistory,omitempty"`
 }
 
+// HookPhase defines the phase of a hook resource
+type HookPhase string
+
+const (
+	// HookPending is the phase of a hook resource that is not created yet
+	HookPending HookPhase = "Pending"
+	// HookRunning is the phase of a hook resource that is not completed
+	HookRunning HookPhase = "Running"
+	// HookSucceeded is the phase of a hook resource that completed successfully
+	HookSucceeded HookPhase = "Succeeded"
+	// HookFailed is the phase of a hook resource that failed
+	HookFailed HookPhase = "Failed"
+	// HookTimedOut is the phase of a hook resource that ran longer than its timeout
+	HookTimedOut HookPhase = "TimedOut"
+)
+
+// HookStatus defines the status of a hook resource applied by a subscription
+type HookStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	// Phase is Pending, Running, Succeeded or Failed, evaluated from the status of the resource by the evaluator of
+	// its kind, or TimedOut
+	Phase HookPhase `json:"phase,omitempty"`
+	// Attempts is the number of runs of the hook for the commit, including the retries
+	Attempts int `json:"attempts,omitempty"`
+	// NextRetryTime is when the failed or timed out hook is run again
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+}
+
+// HooksStatus defines the status of the prehooks and posthooks of a subscription of all kinds, such as AnsibleJob,
+// Job, PipelineRun and TaskRun
+type HooksStatus struct {
+	// LastPrehooks are the last applied prehooks
+	LastPrehooks []HookStatus `json:"lastPrehooks,omitempty"`
+	// PrehooksHistory are the applied prehooks, formatted as <kind>/<namespace>/<name>
+	PrehooksHistory []string `json:"prehooksHistory,omitempty"`
+
+	// LastPosthooks are the last applied posthooks
+	LastPosthooks []HookStatus `json:"lastPosthooks,omitempty"`
+	// PosthooksHistory are the applied posthooks, formatted as <kind>/<namespace>/<name>
+	PosthooksHistory []string `json:"posthooksHistory,omitempty"`
+
+	// LastSucceededCommit is the last commit of which all the hooks succeeded, the commit deployed by the rollback
+	// failure policy
+	LastSucceededCommit string `json:"lastSucceededCommit,omitempty"`
+	// Conditions are the PrehooksFailed and PosthooksFailed conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
 // SubscriptionStatus defines the observed state of Subscription
 // Examples - status of a subscription on hub
 //Status:
@@ -243,6 +429,9 @@ type SubscriptionStatus struct {
 
 	// +optional
 	AnsibleJobsStatus AnsibleJobsStatus `json:"ansiblejobs,omitempty"`
+	// HooksStatus is the status of the hooks of all kinds. AnsibleJobsStatus only has the AnsibleJob hooks.
+	// +optional
+	HooksStatus HooksStatus `json:"hooks,omitempty"`
 	// For endpoint, it is the status of subscription, key is packagename,
 	// For hub, it aggregates all status, key is cluster name
 	Statuses SubscriptionClusterStatusMap `json:"statuses,omitempty"`
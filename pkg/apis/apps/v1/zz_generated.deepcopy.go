@@ -52,6 +52,126 @@ func (in *AnsibleJobsStatus) DeepCopy() *AnsibleJobsStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartVerificationStatus) DeepCopyInto(out *ChartVerificationStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartVerificationStatus.
+func (in *ChartVerificationStatus) DeepCopy() *ChartVerificationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartVerificationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmTestHookStatus) DeepCopyInto(out *HelmTestHookStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmTestHookStatus.
+func (in *HelmTestHookStatus) DeepCopy() *HelmTestHookStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmTestHookStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmTestStatus) DeepCopyInto(out *HelmTestStatus) {
+	*out = *in
+	in.StartedTime.DeepCopyInto(&out.StartedTime)
+	if in.CompletedTime != nil {
+		in, out := &in.CompletedTime, &out.CompletedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Tests != nil {
+		in, out := &in.Tests, &out.Tests
+		*out = make([]HelmTestHookStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmTestStatus.
+func (in *HelmTestStatus) DeepCopy() *HelmTestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmTestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HookStatus) DeepCopyInto(out *HookStatus) {
+	*out = *in
+	if in.NextRetryTime != nil {
+		in, out := &in.NextRetryTime, &out.NextRetryTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HookStatus.
+func (in *HookStatus) DeepCopy() *HookStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HookStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HooksStatus) DeepCopyInto(out *HooksStatus) {
+	*out = *in
+	if in.LastPrehooks != nil {
+		in, out := &in.LastPrehooks, &out.LastPrehooks
+		*out = make([]HookStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PrehooksHistory != nil {
+		in, out := &in.PrehooksHistory, &out.PrehooksHistory
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastPosthooks != nil {
+		in, out := &in.LastPosthooks, &out.LastPosthooks
+		*out = make([]HookStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PosthooksHistory != nil {
+		in, out := &in.PosthooksHistory, &out.PosthooksHistory
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HooksStatus.
+func (in *HooksStatus) DeepCopy() *HooksStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HooksStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HourRange) DeepCopyInto(out *HourRange) {
 	*out = *in
@@ -109,6 +229,13 @@ func (in *PackageFilter) DeepCopyInto(out *PackageFilter) {
 		*out = new(corev1.LocalObjectReference)
 		**out = **in
 	}
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make([]PackageVersions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageFilter.
@@ -137,6 +264,26 @@ func (in *PackageOverride) DeepCopy() *PackageOverride {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageVersions) DeepCopyInto(out *PackageVersions) {
+	*out = *in
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageVersions.
+func (in *PackageVersions) DeepCopy() *PackageVersions {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageVersions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubscriberItem) DeepCopyInto(out *SubscriberItem) {
 	*out = *in
@@ -353,6 +500,7 @@ func (in *SubscriptionStatus) DeepCopyInto(out *SubscriptionStatus) {
 	*out = *in
 	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
 	in.AnsibleJobsStatus.DeepCopyInto(&out.AnsibleJobsStatus)
+	in.HooksStatus.DeepCopyInto(&out.HooksStatus)
 	if in.Statuses != nil {
 		in, out := &in.Statuses, &out.Statuses
 		*out = make(SubscriptionClusterStatusMap, len(*in))
@@ -389,6 +537,16 @@ func (in *SubscriptionUnitStatus) DeepCopyInto(out *SubscriptionUnitStatus) {
 		*out = new(runtime.RawExtension)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ChartVerification != nil {
+		in, out := &in.ChartVerification, &out.ChartVerification
+		*out = new(ChartVerificationStatus)
+		**out = **in
+	}
+	if in.HelmTest != nil {
+		in, out := &in.HelmTest, &out.HelmTest
+		*out = new(HelmTestStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriptionUnitStatus.
@@ -0,0 +1,446 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	releasev1 "github.com/open-cluster-management/multicloud-operators-subscription-release/pkg/apis/apps/v1"
+	helmclient "github.com/open-cluster-management/multicloud-operators-subscription-release/pkg/client"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+const (
+	// defaultTestTimeout is the time to wait for the test hooks of a Helm release without helm-test-timeout annotation
+	defaultTestTimeout = 5 * time.Minute
+	// logTailLines is the number of lines at the end of the test pod logs kept in the package status
+	logTailLines = 20
+	// logLimitBytes limits the size of the test pod log excerpts kept in the package status
+	logLimitBytes = 2048
+	// testPollInterval is how often a running helm test is checked for its result
+	testPollInterval = 10 * time.Second
+)
+
+// configFunc returns the helm configuration of the HelmRelease controller for the namespace
+type configFunc func(namespace string) (*action.Configuration, error)
+
+// podLogFunc returns the end of the log of a test pod
+type podLogFunc func(namespace, pod string) (string, error)
+
+// Add creates a new Helm test controller and adds it to the Manager. It runs where the HelmRelease controller runs.
+func Add(mgr manager.Manager) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	rec := &ReconcileHelmTest{
+		Client: mgr.GetClient(),
+		runs:   map[types.NamespacedName]*helmTestRun{},
+		podLog: newPodLogFunc(clientset),
+		newConfig: func(namespace string) (*action.Configuration, error) {
+			return newActionConfig(mgr, clientset, namespace)
+		},
+	}
+
+	return add(mgr, rec)
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	klog.Info("Adding helm test controller.")
+
+	c, err := controller.New("helm-test-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Kind{Type: &releasev1.HelmRelease{}}, &handler.EnqueueRequestForObject{}, helmReleasePredicateFunctions)
+}
+
+// helmReleasePredicateFunctions watches the HelmReleases deployed by subscriptions for status changes
+var helmReleasePredicateFunctions = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return utils.GetHostSubscriptionFromObject(e.Meta) != nil
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if utils.GetHostSubscriptionFromObject(e.MetaNew) == nil {
+			return false
+		}
+
+		oldHr, oldOk := e.ObjectOld.(*releasev1.HelmRelease)
+		newHr, newOk := e.ObjectNew.(*releasev1.HelmRelease)
+
+		return !oldOk || !newOk || !reflect.DeepEqual(oldHr.Status, newHr.Status)
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return false
+	},
+}
+
+// blank assignment to verify that ReconcileHelmTest implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileHelmTest{}
+
+// ReconcileHelmTest runs the test hooks of the HelmReleases of the subscriptions with the helm-test annotation after
+// each successful install or upgrade, and records the result in the package status of the subscription.
+// The tests run in the background so that they don't hold up the reconciliation of other HelmReleases.
+type ReconcileHelmTest struct {
+	client.Client
+	newConfig configFunc
+	podLog    podLogFunc
+
+	lock sync.Mutex
+	runs map[types.NamespacedName]*helmTestRun
+}
+
+// helmTestRun is a helm test of a HelmRelease revision running in the background
+type helmTestRun struct {
+	revision int
+	result   *appv1.HelmTestStatus
+}
+
+// Reconcile tests the last revision of the HelmRelease once it is deployed
+func (r *ReconcileHelmTest) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	klog.V(1).Info("Reconciling helm test of ", request.NamespacedName)
+
+	hr := &releasev1.HelmRelease{}
+	if err := r.Get(context.TODO(), request.NamespacedName, hr); err != nil {
+		if kerrors.IsNotFound(err) {
+			r.deleteRun(request.NamespacedName)
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	subkey := utils.GetHostSubscriptionFromObject(hr)
+	dplkey := utils.GetHostDeployableFromObject(hr)
+
+	if subkey == nil || dplkey == nil {
+		return reconcile.Result{}, nil
+	}
+
+	sub := &appv1.Subscription{}
+	if err := r.Get(context.TODO(), *subkey, sub); err != nil {
+		if kerrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	lastResult := getPackageHelmTest(sub, dplkey.Name)
+
+	if !isHelmTestEnabled(sub) {
+		if lastResult != nil {
+			return reconcile.Result{}, utils.SetInClusterPackageHelmTest(r.Client, *subkey, dplkey.Name, nil)
+		}
+
+		return reconcile.Result{}, nil
+	}
+
+	if !isReleaseDeployed(hr) {
+		return reconcile.Result{}, nil
+	}
+
+	cfg, err := r.newConfig(hr.Namespace)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	last, err := cfg.Releases.Last(hr.Name)
+	if err != nil {
+		klog.Error("Failed to get the helm release of ", request.NamespacedName, ", err: ", err)
+		return reconcile.Result{}, err
+	}
+
+	if last.Info == nil || last.Info.Status != release.StatusDeployed {
+		return reconcile.Result{}, nil
+	}
+
+	revision := last.Version
+
+	// Each deployed revision is tested once
+	if lastResult != nil && lastResult.Revision == revision && lastResult.Result != appv1.HelmTestRunning {
+		return reconcile.Result{}, nil
+	}
+
+	run := r.getRun(request.NamespacedName)
+
+	if run != nil && run.result == nil {
+		// the test of a previous revision still runs, the next revision is tested after it
+		return reconcile.Result{RequeueAfter: testPollInterval}, nil
+	}
+
+	if run != nil && run.revision == revision {
+		if err := utils.SetInClusterPackageHelmTest(r.Client, *subkey, dplkey.Name, run.result); err != nil {
+			klog.Error("Failed to record the helm test result of ", request.NamespacedName, ", err: ", err)
+			return reconcile.Result{}, err
+		}
+
+		r.deleteRun(request.NamespacedName)
+
+		klog.Infof("Helm test of %v revision %d: %s %s", request.NamespacedName, revision, run.result.Result, run.result.Message)
+
+		return reconcile.Result{}, nil
+	}
+
+	timeout := getHelmTestTimeout(sub)
+
+	running := &appv1.HelmTestStatus{Revision: revision, Result: appv1.HelmTestRunning, StartedTime: metav1.Now()}
+	if err := utils.SetInClusterPackageHelmTest(r.Client, *subkey, dplkey.Name, running); err != nil {
+		klog.Error("Failed to record the running helm test of ", request.NamespacedName, ", err: ", err)
+		return reconcile.Result{}, err
+	}
+
+	klog.Infof("Running helm test of %v revision %d with timeout %v", request.NamespacedName, revision, timeout)
+
+	r.startRun(request.NamespacedName, revision, func() *appv1.HelmTestStatus {
+		rel, err := runReleaseTest(cfg, hr, timeout)
+
+		result := r.newHelmTestStatus(hr.Namespace, rel, err)
+		result.Revision = revision
+		result.StartedTime = running.StartedTime
+
+		return result
+	})
+
+	return reconcile.Result{RequeueAfter: testPollInterval}, nil
+}
+
+// startRun runs the helm test of the HelmRelease revision in the background
+func (r *ReconcileHelmTest) startRun(key types.NamespacedName, revision int, test func() *appv1.HelmTestStatus) {
+	run := &helmTestRun{revision: revision}
+
+	r.lock.Lock()
+	r.runs[key] = run
+	r.lock.Unlock()
+
+	go func() {
+		result := test()
+
+		r.lock.Lock()
+		run.result = result
+		r.lock.Unlock()
+	}()
+}
+
+// getRun returns a copy of the helm test of the HelmRelease that runs or has a result to record, if any
+func (r *ReconcileHelmTest) getRun(key types.NamespacedName) *helmTestRun {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	run, ok := r.runs[key]
+	if !ok {
+		return nil
+	}
+
+	return &helmTestRun{revision: run.revision, result: run.result}
+}
+
+func (r *ReconcileHelmTest) deleteRun(key types.NamespacedName) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.runs, key)
+}
+
+// newHelmTestStatus returns the result of the test hooks of the release with the end of the test pod logs
+func (r *ReconcileHelmTest) newHelmTestStatus(namespace string, rel *release.Release, testErr error) *appv1.HelmTestStatus {
+	now := metav1.Now()
+	result := &appv1.HelmTestStatus{Result: appv1.HelmTestPassed, CompletedTime: &now}
+
+	if testErr != nil {
+		result.Result = appv1.HelmTestFailed
+		result.Message = testErr.Error()
+	}
+
+	if rel == nil {
+		return result
+	}
+
+	for _, hook := range rel.Hooks {
+		if !isTestHook(hook) {
+			continue
+		}
+
+		test := appv1.HelmTestHookStatus{Name: hook.Name}
+
+		// Hooks that did not run, like the ones after a failed test, have no phase
+		if hook.LastRun.Phase != release.HookPhaseUnknown {
+			test.Phase = hook.LastRun.Phase.String()
+		}
+
+		// Only the logs of Pod test hooks are kept, like helm test --logs
+		if test.Phase != "" && hook.Kind == "Pod" && r.podLog != nil {
+			log, err := r.podLog(namespace, hook.Name)
+			if err != nil {
+				klog.Warning("Failed to get the log of the helm test pod ", namespace, "/", hook.Name, ", err: ", err)
+			}
+
+			test.Log = log
+		}
+
+		result.Tests = append(result.Tests, test)
+	}
+
+	sort.Slice(result.Tests, func(i, j int) bool { return result.Tests[i].Name < result.Tests[j].Name })
+
+	if testErr == nil && len(result.Tests) == 0 {
+		result.Message = "the chart has no test hooks"
+	}
+
+	return result
+}
+
+func isTestHook(hook *release.Hook) bool {
+	for _, e := range hook.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runReleaseTest runs the test hooks of the last revision of the HelmRelease like helm test
+func runReleaseTest(cfg *action.Configuration, hr *releasev1.HelmRelease, timeout time.Duration) (*release.Release, error) {
+	rt := action.NewReleaseTesting(cfg)
+	rt.Namespace = hr.Namespace
+	rt.Timeout = timeout
+
+	return rt.Run(hr.Name)
+}
+
+// newActionConfig returns the helm configuration of the HelmRelease controller for the namespace
+func newActionConfig(mgr manager.Manager, clientset kubernetes.Interface, namespace string) (*action.Configuration, error) {
+	rcg, err := helmclient.NewRESTClientGetter(mgr, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get REST client getter from manager: %w", err)
+	}
+
+	return &action.Configuration{
+		RESTClientGetter: rcg,
+		Releases:         storage.Init(driver.NewSecrets(clientset.CoreV1().Secrets(namespace))),
+		KubeClient:       kube.New(rcg),
+		Log:              func(_ string, _ ...interface{}) {},
+	}, nil
+}
+
+func newPodLogFunc(clientset kubernetes.Interface) podLogFunc {
+	return func(namespace, pod string) (string, error) {
+		tailLines := int64(logTailLines)
+		limitBytes := int64(logLimitBytes)
+
+		stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pod,
+			&corev1.PodLogOptions{TailLines: &tailLines, LimitBytes: &limitBytes}).Stream(context.TODO())
+		if err != nil {
+			return "", err
+		}
+
+		defer stream.Close()
+
+		log, err := ioutil.ReadAll(stream)
+
+		return strings.TrimSpace(string(log)), err
+	}
+}
+
+// isReleaseDeployed returns true if the last install or upgrade of the HelmRelease succeeded
+func isReleaseDeployed(hr *releasev1.HelmRelease) bool {
+	deployed := getCondition(hr, releasev1.ConditionDeployed)
+	if deployed == nil || deployed.Status != releasev1.StatusTrue {
+		return false
+	}
+
+	if deployed.Reason != releasev1.ReasonInstallSuccessful && deployed.Reason != releasev1.ReasonUpgradeSuccessful {
+		return false
+	}
+
+	// A failed release is not tested
+	failed := getCondition(hr, releasev1.ConditionReleaseFailed)
+
+	return failed == nil || failed.Status != releasev1.StatusTrue
+}
+
+func getCondition(hr *releasev1.HelmRelease, conditionType releasev1.HelmAppConditionType) *releasev1.HelmAppCondition {
+	for i := range hr.Status.Conditions {
+		if hr.Status.Conditions[i].Type == conditionType {
+			return &hr.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// isHelmTestEnabled returns true if the subscription has the helm-test annotation
+func isHelmTestEnabled(sub *appv1.Subscription) bool {
+	return strings.EqualFold(sub.GetAnnotations()[appv1.AnnotationHelmTest], "true")
+}
+
+// getHelmTestTimeout returns the helm-test-timeout annotation of the subscription
+func getHelmTestTimeout(sub *appv1.Subscription) time.Duration {
+	value := sub.GetAnnotations()[appv1.AnnotationHelmTestTimeout]
+	if value == "" {
+		return defaultTestTimeout
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		klog.Warningf("Invalid %s annotation %q of subscription %s/%s, using %v", appv1.AnnotationHelmTestTimeout, value,
+			sub.Namespace, sub.Name, defaultTestTimeout)
+
+		return defaultTestTimeout
+	}
+
+	return timeout
+}
+
+func getPackageHelmTest(sub *appv1.Subscription, pkgname string) *appv1.HelmTestStatus {
+	clst := sub.Status.Statuses["/"]
+	if clst == nil || clst.SubscriptionPackageStatus[pkgname] == nil {
+		return nil
+	}
+
+	return clst.SubscriptionPackageStatus[pkgname].HelmTest
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmtest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dplv1 "github.com/open-cluster-management/multicloud-operators-deployable/pkg/apis/apps/v1"
+	releasev1 "github.com/open-cluster-management/multicloud-operators-subscription-release/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func newTestConfig(g *gomega.GomegaWithT, watchErr error, hooks ...*release.Hook) *action.Configuration {
+	cfg := &action.Configuration{
+		Releases: storage.Init(driver.NewMemory()),
+		KubeClient: &kubefake.FailingKubeClient{
+			PrintingKubeClient:   kubefake.PrintingKubeClient{Out: ioutil.Discard},
+			WatchUntilReadyError: watchErr,
+		},
+		Log: func(_ string, _ ...interface{}) {},
+	}
+
+	rel := &release.Release{
+		Name:      "nginx-ingress-1",
+		Namespace: "default",
+		Version:   2,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "nginx-ingress", Version: "1.26.0"}},
+		Hooks:     hooks,
+	}
+
+	g.Expect(cfg.Releases.Create(rel)).To(gomega.Succeed())
+
+	return cfg
+}
+
+func newTestHook(name string, weight int) *release.Hook {
+	return &release.Hook{
+		Name:     name,
+		Kind:     "Pod",
+		Path:     "templates/tests/" + name + ".yaml",
+		Manifest: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: " + name,
+		Events:   []release.HookEvent{release.HookTest},
+		Weight:   weight,
+	}
+}
+
+func TestRunReleaseTest(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hr := &releasev1.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: "nginx-ingress-1", Namespace: "default"}}
+
+	r := &ReconcileHelmTest{podLog: func(namespace, pod string) (string, error) {
+		return "log of " + namespace + "/" + pod, nil
+	}}
+
+	// All test hooks succeed
+	cfg := newTestConfig(g, nil, newTestHook("test-b", 1), newTestHook("test-a", 0),
+		&release.Hook{Name: "pre-install", Events: []release.HookEvent{release.HookPreInstall}})
+
+	rel, err := runReleaseTest(cfg, hr, time.Minute)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	result := r.newHelmTestStatus(hr.Namespace, rel, err)
+	g.Expect(result.Result).To(gomega.Equal(appv1.HelmTestPassed))
+	g.Expect(result.CompletedTime).NotTo(gomega.BeNil())
+	g.Expect(result.Tests).To(gomega.Equal([]appv1.HelmTestHookStatus{
+		{Name: "test-a", Phase: "Succeeded", Log: "log of default/test-a"},
+		{Name: "test-b", Phase: "Succeeded", Log: "log of default/test-b"},
+	}))
+
+	// The logs of test hooks that are not Pods are not fetched
+	job := newTestHook("test-job", 2)
+	job.Kind = "Job"
+	job.Manifest = "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: test-job"
+
+	cfg = newTestConfig(g, nil, newTestHook("test-a", 0), job)
+
+	rel, err = runReleaseTest(cfg, hr, time.Minute)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	result = r.newHelmTestStatus(hr.Namespace, rel, err)
+	g.Expect(result.Result).To(gomega.Equal(appv1.HelmTestPassed))
+	g.Expect(result.Tests).To(gomega.Equal([]appv1.HelmTestHookStatus{
+		{Name: "test-a", Phase: "Succeeded", Log: "log of default/test-a"},
+		{Name: "test-job", Phase: "Succeeded"},
+	}))
+
+	// The first test hook fails and the next one does not run
+	cfg = newTestConfig(g, errors.New("pod test-a failed"), newTestHook("test-b", 1), newTestHook("test-a", 0))
+
+	rel, err = runReleaseTest(cfg, hr, time.Minute)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	result = r.newHelmTestStatus(hr.Namespace, rel, err)
+	g.Expect(result.Result).To(gomega.Equal(appv1.HelmTestFailed))
+	g.Expect(result.Message).To(gomega.ContainSubstring("pod test-a failed"))
+	g.Expect(result.Tests).To(gomega.Equal([]appv1.HelmTestHookStatus{
+		{Name: "test-a", Phase: "Failed", Log: "log of default/test-a"},
+		{Name: "test-b"},
+	}))
+
+	// A chart without test hooks passes
+	cfg = newTestConfig(g, nil)
+
+	rel, err = runReleaseTest(cfg, hr, time.Minute)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	result = r.newHelmTestStatus(hr.Namespace, rel, err)
+	g.Expect(result.Result).To(gomega.Equal(appv1.HelmTestPassed))
+	g.Expect(result.Message).To(gomega.Equal("the chart has no test hooks"))
+	g.Expect(result.Tests).To(gomega.BeEmpty())
+}
+
+func TestIsReleaseDeployed(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hr := &releasev1.HelmRelease{}
+	g.Expect(isReleaseDeployed(hr)).To(gomega.BeFalse())
+
+	hr.Status.Conditions = []releasev1.HelmAppCondition{
+		{Type: releasev1.ConditionDeployed, Status: releasev1.StatusTrue, Reason: releasev1.ReasonInstallSuccessful},
+	}
+	g.Expect(isReleaseDeployed(hr)).To(gomega.BeTrue())
+
+	hr.Status.Conditions[0].Reason = releasev1.ReasonUpgradeSuccessful
+	g.Expect(isReleaseDeployed(hr)).To(gomega.BeTrue())
+
+	hr.Status.Conditions = append(hr.Status.Conditions,
+		releasev1.HelmAppCondition{Type: releasev1.ConditionReleaseFailed, Status: releasev1.StatusTrue})
+	g.Expect(isReleaseDeployed(hr)).To(gomega.BeFalse())
+}
+
+func TestHelmTestAnnotations(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1.Subscription{}
+	g.Expect(isHelmTestEnabled(sub)).To(gomega.BeFalse())
+	g.Expect(getHelmTestTimeout(sub)).To(gomega.Equal(defaultTestTimeout))
+
+	sub.SetAnnotations(map[string]string{
+		appv1.AnnotationHelmTest:        "true",
+		appv1.AnnotationHelmTestTimeout: "90s",
+	})
+	g.Expect(isHelmTestEnabled(sub)).To(gomega.BeTrue())
+	g.Expect(getHelmTestTimeout(sub)).To(gomega.Equal(90 * time.Second))
+
+	sub.GetAnnotations()[appv1.AnnotationHelmTestTimeout] = "soon"
+	g.Expect(getHelmTestTimeout(sub)).To(gomega.Equal(defaultTestTimeout))
+}
+
+func TestReconcileHelmTest(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(releasev1.SchemeBuilder.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(appv1.SchemeBuilder.AddToScheme(scheme)).To(gomega.Succeed())
+
+	sub := &appv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "helm-sub",
+			Namespace:   "default",
+			Annotations: map[string]string{appv1.AnnotationHelmTest: "true"},
+		},
+	}
+
+	hr := &releasev1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nginx-ingress-1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				appv1.AnnotationSyncSource: "subnsdpl-default/helm-sub",
+				dplv1.AnnotationHosting:    "default/nginx-ingress-1-deployable",
+			},
+		},
+		Status: releasev1.HelmAppStatus{
+			Conditions: []releasev1.HelmAppCondition{
+				{Type: releasev1.ConditionDeployed, Status: releasev1.StatusTrue, Reason: releasev1.ReasonInstallSuccessful},
+			},
+		},
+	}
+
+	clt := fake.NewFakeClientWithScheme(scheme, sub, hr)
+
+	cfg := newTestConfig(g, nil, newTestHook("test-a", 0))
+
+	r := &ReconcileHelmTest{
+		Client: clt,
+		runs:   map[types.NamespacedName]*helmTestRun{},
+		newConfig: func(namespace string) (*action.Configuration, error) {
+			return cfg, nil
+		},
+	}
+
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: hr.Name, Namespace: hr.Namespace}}
+
+	getHelmTest := func() *appv1.HelmTestStatus {
+		s := &appv1.Subscription{}
+		g.Expect(clt.Get(context.TODO(), types.NamespacedName{Name: sub.Name, Namespace: sub.Namespace}, s)).To(gomega.Succeed())
+
+		return getPackageHelmTest(s, "nginx-ingress-1-deployable")
+	}
+
+	// the test runs in the background, the reconcile is requeued to record its result
+	result, err := r.Reconcile(request)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.Equal(testPollInterval))
+	g.Expect(getHelmTest().Result).To(gomega.Equal(appv1.HelmTestRunning))
+	g.Expect(getHelmTest().Revision).To(gomega.Equal(2))
+
+	g.Eventually(func() reconcile.Result {
+		result, err := r.Reconcile(request)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		return result
+	}, 5*time.Second).Should(gomega.Equal(reconcile.Result{}))
+
+	g.Expect(getHelmTest().Result).To(gomega.Equal(appv1.HelmTestPassed))
+	g.Expect(getHelmTest().Tests).To(gomega.HaveLen(1))
+	g.Expect(r.getRun(request.NamespacedName)).To(gomega.BeNil())
+
+	// the revision is tested once
+	result, err = r.Reconcile(request)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result).To(gomega.Equal(reconcile.Result{}))
+	g.Expect(getHelmTest().Result).To(gomega.Equal(appv1.HelmTestPassed))
+}
@@ -18,17 +18,18 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
-	ansiblejob "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/ansible/v1alpha1"
 	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -36,10 +37,24 @@ import (
 type Job struct {
 	mux sync.Mutex
 
-	Original ansiblejob.AnsibleJob
-	Instance []ansiblejob.AnsibleJob
+	Original unstructured.Unstructured
+	Instance []unstructured.Unstructured
 	// track the create instance
 	InstanceSet map[types.NamespacedName]struct{}
+
+	// policy is the timeout, retries and failure policy of the hook
+	policy hookPolicy
+	// runName is the name of the first instance of the current run, the
+	// retries are named after it
+	runName string
+	// attempts is the number of instances of the current run
+	attempts int
+	// nextRetry is when the failed last instance is retried
+	nextRetry time.Time
+	// lastPhase is Failed or TimedOut once the last instance failed
+	lastPhase subv1.HookPhase
+	// failed is set once the current run failed after all its retries
+	failed bool
 }
 
 // JobInstances can be applied and can be quired to see if the most applied
@@ -52,7 +67,7 @@ type appliedJobs struct {
 }
 
 func (jIns *JobInstances) registryJobs(gClt GitOps, subIns *subv1.Subscription,
-	suffixFunc SuffixFunc, jobs []ansiblejob.AnsibleJob, kubeclient client.Client,
+	suffixFunc SuffixFunc, jobs []unstructured.Unstructured, kubeclient client.Client,
 	logger logr.Logger, placementDecisionUpdated bool, placementRuleRv string, hookType string,
 	commitIDChanged bool) error {
 	logger.Info(fmt.Sprintf("In registryJobs, placementDecisionUpdated = %v, commitIDChanged = %v", placementDecisionUpdated, commitIDChanged))
@@ -62,8 +77,13 @@ func (jIns *JobInstances) registryJobs(gClt GitOps, subIns *subv1.Subscription,
 
 		jobKey := types.NamespacedName{Name: job.GetName(), Namespace: job.GetNamespace()}
 
-		ins, err := overrideAnsibleInstance(subIns, job, kubeclient, logger, hookType)
+		ins, err := overrideHookInstance(subIns, job, kubeclient, logger, hookType)
+
+		if err != nil {
+			return err
+		}
 
+		policy, err := getHookPolicy(&ins)
 		if err != nil {
 			return err
 		}
@@ -82,14 +102,15 @@ func (jIns *JobInstances) registryJobs(gClt GitOps, subIns *subv1.Subscription,
 			continue
 		}
 
-		if nx.Spec.ExtraVars == nil {
-			// No ExtraVars, skip
+		if !hasHookTargets(subIns, nx) {
+			// No target clusters, skip
 			continue
 		}
 
 		jobRecords := (*jIns)[jobKey]
 		jobRecords.mux.Lock()
 		jobRecords.Original = ins
+		jobRecords.policy = policy
 
 		if placementDecisionUpdated && len(jobRecords.Instance) != 0 {
 			plrSuffixFunc := func() string {
@@ -142,38 +163,19 @@ func (jIns *JobInstances) registryJobs(gClt GitOps, subIns *subv1.Subscription,
 
 			if jobRecordsInstancePopulated {
 				if placementDecisionUpdated {
-					// If placement decision is updated, then see if the previously run ansible job
-					// has the same target cluster. If so, skip creating a new ansible job. Otherwise,
-					// re-create the ansible job since the target clusters are different now.
+					// If placement decision is updated, then see if the previously run hook
+					// has the same target cluster. If so, skip creating a new hook. Otherwise,
+					// re-create the hook since the target clusters are different now.
 					lastJob := jobRecords.Instance[len(jobRecords.Instance)-1]
 
-					// No need to check this because an ansiblejob will not be created if Spec.ExtraVars == nil.
-					//if nx.Spec.ExtraVars != nil && lastJob.Spec.ExtraVars != nil {
 					jobDoneOrRunning := isJobDoneOrRunning(lastJob, logger)
 
 					if jobDoneOrRunning {
-						jobMap := make(map[string]interface{})
-						lastJobMap := make(map[string]interface{})
-
-						err := json.Unmarshal(nx.Spec.ExtraVars, &jobMap)
-						if err != nil {
-							jobRecords.mux.Unlock()
-
-							return err
-						}
+						targetClusters := nx.GetAnnotations()[subv1.AnnotationHookTargetClusters]
+						lastJobTargetClusters := lastJob.GetAnnotations()[subv1.AnnotationHookTargetClusters]
 
-						err = json.Unmarshal(lastJob.Spec.ExtraVars, &lastJobMap)
-						if err != nil {
-							jobRecords.mux.Unlock()
-
-							return err
-						}
-
-						targetClusters := jobMap["target_clusters"]
-						lastJobTargetClusters := lastJobMap["target_clusters"]
-
-						if reflect.DeepEqual(targetClusters, lastJobTargetClusters) {
-							logger.Info("Both last and new ansible job target cluster list are equal")
+						if targetClusters == lastJobTargetClusters {
+							logger.Info("Both last and new hook target cluster list are equal")
 							jobRecords.mux.Unlock()
 
 							continue
@@ -196,9 +198,11 @@ func (jIns *JobInstances) registryJobs(gClt GitOps, subIns *subv1.Subscription,
 
 			jobRecords.InstanceSet[nxKey] = struct{}{}
 
-			logger.Info(fmt.Sprintf("registered ansiblejob %s", nxKey))
+			logger.Info(fmt.Sprintf("registered %s %s", nx.GetKind(), nxKey))
 
 			jobRecords.Instance = append(jobRecords.Instance, *nx)
+			jobRecords.resetRun(*nx)
+			jobRecords.restoreRun(lastHookStatus(subIns, hookType))
 		}
 
 		jobRecords.mux.Unlock()
@@ -228,7 +232,7 @@ func getSyncTimeHash(syncTimeAnnotation string) string {
 // applyjobs will get the original job and create a instance, the applied
 // instance will is put into the job.Instance array upon the success of the
 // creation
-func (jIns *JobInstances) applyJobs(clt client.Client, subIns *subv1.Subscription, logger logr.Logger) error {
+func (jIns *JobInstances) applyJobs(clt client.Client, subIns *subv1.Subscription, hookCtx HookContext, logger logr.Logger) error {
 	if utils.IsSubscriptionBeDeleted(clt, types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}) {
 		return nil
 	}
@@ -246,12 +250,21 @@ func (jIns *JobInstances) applyJobs(clt client.Client, subIns *subv1.Subscriptio
 
 		nx := j.Instance[n-1]
 
+		// the failed or timed out instance is not applied again, it waits for
+		// its retry
+		waiting := j.lastPhase != "" || j.failed
+
 		j.mux.Unlock()
 		logger.Info("released lock")
 
+		if waiting {
+			continue
+		}
+
 		//add the created job to the ansiblejob Set if not exist
 
-		job := &ansiblejob.AnsibleJob{}
+		job := &unstructured.Unstructured{}
+		job.SetGroupVersionKind(nx.GroupVersionKind())
 		jKey := types.NamespacedName{Name: nx.GetName(), Namespace: nx.GetNamespace()}
 
 		if err := clt.Get(context.TODO(), jKey, job); err != nil {
@@ -259,102 +272,111 @@ func (jIns *JobInstances) applyJobs(clt client.Client, subIns *subv1.Subscriptio
 				return fmt.Errorf("failed to get job %v, err: %v", jKey, err)
 			}
 
-			if err := clt.Create(context.TODO(), &nx); err != nil {
+			ins := nx.DeepCopy()
+			if err := injectHookContext(clt, subIns, ins, hookCtx); err != nil {
+				return fmt.Errorf("failed to pass the hook context to job %v, err: %v", k.String(), err)
+			}
+
+			if err := clt.Create(context.TODO(), ins); err != nil {
 				if !kerr.IsAlreadyExists(err) {
 					return fmt.Errorf("failed to apply job %v, err: %v", k.String(), err)
 				}
 			}
 
-			logger.Info(fmt.Sprintf("applied ansiblejob %s/%s", nx.GetNamespace(), nx.GetName()))
+			logger.Info(fmt.Sprintf("applied %s %s/%s", nx.GetKind(), nx.GetNamespace(), nx.GetName()))
 		}
 	}
 
 	return nil
 }
 
-// check the last instance of the ansiblejobs to see if it's applied and
-// completed or not
-func (jIns *JobInstances) isJobsCompleted(clt client.Client, logger logr.Logger) (bool, error) {
+// check the last instance of the hooks to see if it's applied and
+// completed or not, the failed or timed out instances are retried
+func (jIns *JobInstances) isJobsCompleted(clt client.Client, logger logr.Logger) (bool, []hookEvent, error) {
+	completed := true
+	events := []hookEvent{}
+
 	for k, job := range *jIns {
 		logger.V(DebugLog).Info(fmt.Sprintf("checking if%v job for completed or not", k.String()))
 
-		n := len(job.Instance)
-		if n == 0 {
-			return true, nil
-		}
+		job.mux.Lock()
+		done, evts, err := job.evaluate(clt, logger, time.Now())
+		job.mux.Unlock()
+
+		events = append(events, evts...)
 
-		j := job.Instance[n-1]
-		jKey := types.NamespacedName{Name: j.GetName(), Namespace: j.GetNamespace()}
+		if err != nil {
+			return false, events, err
+		}
 
-		if ok, err := isJobDone(clt, jKey, logger); err != nil || !ok {
-			return ok, err
+		if !done {
+			completed = false
 		}
 	}
 
-	return true, nil
+	return completed, events, nil
 }
 
-func isJobDone(clt client.Client, key types.NamespacedName, logger logr.Logger) (bool, error) {
-	job := &ansiblejob.AnsibleJob{}
-
-	if err := clt.Get(context.TODO(), key, job); err != nil {
-		// it might not be created by the k8s side yet
-		if kerr.IsNotFound(err) {
-			return false, nil
-		}
-
-		return false, err
+// getHookPhase evaluates the phase of the applied hook instance, the instance
+// is Pending if it's not created by the k8s side yet
+func getHookPhase(clt client.Client, instance unstructured.Unstructured) (subv1.HookPhase, error) {
+	job, err := getHookInstance(clt, instance)
+	if err != nil {
+		return "", err
 	}
 
-	if isJobRunSuccessful(job, logger) {
-		return true, nil
+	if job == nil {
+		return subv1.HookPending, nil
 	}
 
-	return false, nil
+	return hookPhase(job), nil
 }
 
 // Check if last job is running or already done
 // The last job could have not been created in k8s. e.g. posthook job will be created only after prehook jobs
-// and main subscription are done. But the posthook jobs have been created in memory ansible job list.
-func isJobDoneOrRunning(lastJob ansiblejob.AnsibleJob, logger logr.Logger) bool {
-	job := &lastJob
+// and main subscription are done. But the posthook jobs have been created in memory hook list.
+func isJobDoneOrRunning(lastJob unstructured.Unstructured, logger logr.Logger) bool {
+	phase := hookPhase(&lastJob)
+	logger.V(3).Info(fmt.Sprintf("job %s/%s phase: %v", lastJob.GetNamespace(), lastJob.GetName(), phase))
 
-	if job == nil {
-		return false
-	}
+	return phase != subv1.HookFailed
+}
 
-	if isJobRunning(job, logger) {
-		return true
+// FormatFunc formats a hook instance, an empty string leaves the instance out
+type FormatFunc func(unstructured.Unstructured) string
+
+func ansiblestatusFormat(j unstructured.Unstructured) string {
+	if j.GroupVersionKind().GroupKind() != AnsibleJobGroupKind {
+		return ""
 	}
 
-	if isJobRunSuccessful(job, logger) {
-		return true
+	ns := j.GetNamespace()
+	if len(ns) == 0 {
+		ns = "default"
 	}
 
-	return false
+	return fmt.Sprintf("%s/%s", ns, j.GetName())
 }
 
-type FormatFunc func(ansiblejob.AnsibleJob) string
-
-func ansiblestatusFormat(j ansiblejob.AnsibleJob) string {
+func hookStatusFormat(j unstructured.Unstructured) string {
 	ns := j.GetNamespace()
 	if len(ns) == 0 {
 		ns = "default"
 	}
 
-	return fmt.Sprintf("%s/%s", ns, j.GetName())
+	return fmt.Sprintf("%s/%s/%s", j.GetKind(), ns, j.GetName())
 }
 
-func formatAnsibleFromTopo(j ansiblejob.AnsibleJob) string {
+func formatAnsibleFromTopo(j unstructured.Unstructured) string {
 	ns := j.GetNamespace()
 	if len(ns) == 0 {
 		ns = "default"
 	}
 
-	return fmt.Sprintf("%v/%v/%v/%v/%v/%v", hookParent, "", AnsibleJobKind, ns, j.GetName(), 0)
+	return fmt.Sprintf("%v/%v/%v/%v/%v/%v", hookParent, "", j.GetKind(), ns, j.GetName(), 0)
 }
 
-func getJobsString(jobs []ansiblejob.AnsibleJob, format FormatFunc) []string {
+func getJobsString(jobs []unstructured.Unstructured, format FormatFunc) []string {
 	if len(jobs) == 0 {
 		return []string{}
 	}
@@ -362,7 +384,9 @@ func getJobsString(jobs []ansiblejob.AnsibleJob, format FormatFunc) []string {
 	res := []string{}
 
 	for _, j := range jobs {
-		res = append(res, format(j))
+		if s := format(j); s != "" {
+			res = append(res, s)
+		}
 	}
 
 	return res
@@ -383,9 +407,10 @@ func (jIns *JobInstances) outputAppliedJobs(format FormatFunc) appliedJobs {
 		jobRecords.mux.Lock()
 		applied := getJobsString(jobRecords.Instance, format)
 
-		n := len(applied)
-		lastApplied = append(lastApplied, applied[n-1])
-		lastAppliedJobs = append(lastAppliedJobs, applied...)
+		if n := len(applied); n != 0 {
+			lastApplied = append(lastApplied, applied[n-1])
+			lastAppliedJobs = append(lastAppliedJobs, applied...)
+		}
 		jobRecords.mux.Unlock()
 	}
 
@@ -395,3 +420,59 @@ func (jIns *JobInstances) outputAppliedJobs(format FormatFunc) appliedJobs {
 
 	return res
 }
+
+// outputHookStatus reports the last instance of each hook with its phase, and
+// the history of all the applied instances
+func (jIns *JobInstances) outputHookStatus(clt client.Client, logger logr.Logger) ([]subv1.HookStatus, []string) {
+	last := []subv1.HookStatus{}
+	history := []string{}
+
+	for _, jobRecords := range *jIns {
+		jobRecords.mux.Lock()
+		instances := append([]unstructured.Unstructured{}, jobRecords.Instance...)
+		attempts, lastPhase, nextRetry := jobRecords.attempts, jobRecords.lastPhase, jobRecords.nextRetry
+		failed := jobRecords.failed
+		jobRecords.mux.Unlock()
+
+		if len(instances) == 0 {
+			continue
+		}
+
+		history = append(history, getJobsString(instances, hookStatusFormat)...)
+
+		j := instances[len(instances)-1]
+		st := subv1.HookStatus{
+			APIVersion: j.GetAPIVersion(),
+			Kind:       j.GetKind(),
+			Namespace:  j.GetNamespace(),
+			Name:       j.GetName(),
+			Attempts:   attempts,
+			Phase:      lastPhase,
+		}
+
+		if !failed && !nextRetry.IsZero() {
+			st.NextRetryTime = &metav1.Time{Time: nextRetry}
+		}
+
+		// the timed out instance is deleted, keep reporting its phase
+		if st.Phase == "" {
+			phase, err := getHookPhase(clt, j)
+			if err != nil {
+				logger.Error(err, fmt.Sprintf("failed to get the phase of %s %s/%s", j.GetKind(), j.GetNamespace(), j.GetName()))
+			}
+
+			st.Phase = phase
+		}
+
+		last = append(last, st)
+	}
+
+	// keep the status stable, the records are kept in a map
+	sort.Slice(last, func(i, k int) bool {
+		return fmt.Sprintf("%s/%s/%s", last[i].Kind, last[i].Namespace, last[i].Name) <
+			fmt.Sprintf("%s/%s/%s", last[k].Kind, last[k].Namespace, last[k].Name)
+	})
+	sort.Strings(history)
+
+	return last, history
+}
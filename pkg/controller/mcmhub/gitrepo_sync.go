@@ -103,9 +103,13 @@ func (r *ReconcileSubscription) UpdateGitDeployablesAnnotation(sub *appv1.Subscr
 			r.deleteSubscriptionDeployables(sub)
 
 			baseDir := r.hubGitOps.GetRepoRootDirctory(sub)
-			resourcePath := getResourcePath(r.hubGitOps.ResolveLocalGitFolder, channel, sub)
+			resourcePaths, err := getResourcePaths(r.hubGitOps.ResolveLocalGitFolder, channel, sub)
+			if err != nil {
+				klog.Error(err.Error())
+				return false, err
+			}
 
-			err = r.processRepo(channel, sub, r.hubGitOps.ResolveLocalGitFolder(channel, sub), resourcePath, baseDir)
+			err = r.processRepo(channel, sub, r.hubGitOps.ResolveLocalGitFolder(channel, sub), resourcePaths, baseDir)
 
 			if err != nil {
 				klog.Error(err.Error())
@@ -130,11 +134,36 @@ func (r *ReconcileSubscription) UpdateGitDeployablesAnnotation(sub *appv1.Subscr
 		} else {
 			klog.Infof("The Git commit has not changed since the last reconcile. last: %s, new: %s", annotations[appv1.AnnotationGitCommit], commit)
 		}
+
+		if setCurrentTag(sub, commit) {
+			updated = true
+		}
 	}
 
 	return updated, nil
 }
 
+// setCurrentTag records the tag of the deployed commit, the manual sync requests pinning a tag check it. It returns
+// true if the annotation is changed.
+func setCurrentTag(sub *appv1.Subscription, commit string) bool {
+	annotations := sub.GetAnnotations()
+	tag := annotations[appv1.AnnotationGitTag]
+
+	if !strings.EqualFold(getCommitID(sub), commit) || annotations[appv1.AnnotationGitCurrentTag] == tag {
+		return false
+	}
+
+	if tag == "" {
+		delete(annotations, appv1.AnnotationGitCurrentTag)
+	} else {
+		annotations[appv1.AnnotationGitCurrentTag] = tag
+	}
+
+	sub.SetAnnotations(annotations)
+
+	return true
+}
+
 func (r *ReconcileSubscription) isHookUpdate(a map[string]string, subKey types.NamespacedName) bool {
 	applied := r.hooks.GetLastAppliedInstance(subKey)
 
@@ -243,21 +272,19 @@ func (r *ReconcileSubscription) AddClusterAdminAnnotation(sub *appv1.Subscriptio
 	return false
 }
 
-func getResourcePath(localFolderFunc func(*chnv1.Channel, *appv1.Subscription) string, chn *chnv1.Channel, sub *appv1.Subscription) string {
-	resourcePath := localFolderFunc(chn, sub)
-
-	annotations := sub.GetAnnotations()
-	if annotations[appv1.AnnotationGithubPath] != "" {
-		resourcePath = filepath.Join(localFolderFunc(chn, sub), annotations[appv1.AnnotationGithubPath])
-	} else if annotations[appv1.AnnotationGitPath] != "" {
-		resourcePath = filepath.Join(localFolderFunc(chn, sub), annotations[appv1.AnnotationGitPath])
+// getResourcePaths resolves the Git paths of the subscription in the local clone of the channel repo
+func getResourcePaths(localFolderFunc func(*chnv1.Channel, *appv1.Subscription) string, chn *chnv1.Channel,
+	sub *appv1.Subscription) ([]utils.GitResourcePath, error) {
+	gitPaths, err := utils.GetGitPaths(sub, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return resourcePath
+	return utils.ResolveGitPaths(localFolderFunc(chn, sub), gitPaths)
 }
 
-func getGitChart(sub *appv1.Subscription, localRepoRoot, subPath string) (*repo.IndexFile, error) {
-	chartDirs, a, b, c, d, err := utils.SortResources(localRepoRoot, subPath)
+func getGitChart(sub *appv1.Subscription, localRepoRoot string, resourcePaths []utils.GitResourcePath) (*repo.IndexFile, error) {
+	chartDirs, a, b, c, d, err := utils.SortResourcesInPaths(localRepoRoot, resourcePaths)
 	if err != nil {
 		return nil, gerr.Wrap(err, "failed to get helm index for topo annotation")
 	}
@@ -277,7 +304,13 @@ func getGitChart(sub *appv1.Subscription, localRepoRoot, subPath string) (*repo.
 }
 
 func (r *ReconcileSubscription) gitHelmResourceString(sub *appv1.Subscription, chn *chnv1.Channel) string {
-	idxFile, err := getGitChart(sub, utils.GetLocalGitFolder(chn, sub), getResourcePath(r.hubGitOps.ResolveLocalGitFolder, chn, sub))
+	resourcePaths, err := getResourcePaths(r.hubGitOps.ResolveLocalGitFolder, chn, sub)
+	if err != nil {
+		klog.Error(err.Error())
+		return ""
+	}
+
+	idxFile, err := getGitChart(sub, utils.GetLocalGitFolder(chn, sub), resourcePaths)
 	if err != nil {
 		klog.Error(err.Error())
 		return ""
@@ -370,14 +403,36 @@ func (r *ReconcileSubscription) updateAnnotationTopo(sub *subv1.Subscription, al
 	return nil
 }
 
-func (r *ReconcileSubscription) processRepo(chn *chnv1.Channel, sub *appv1.Subscription, localRepoRoot, subPath, baseDir string) error {
-	chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, err := utils.SortResources(localRepoRoot, subPath)
+func (r *ReconcileSubscription) processRepo(chn *chnv1.Channel, sub *appv1.Subscription, localRepoRoot string,
+	resourcePaths []utils.GitResourcePath, baseDir string) error {
+	// Render Jsonnet and CUE sources into resource files before sorting them
+	for _, resourcePath := range resourcePaths {
+		if err := utils.RenderManifestSources(localRepoRoot, resourcePath.Dir, sub); err != nil {
+			klog.Error(err, "Failed to render Jsonnet and CUE sources.")
+			return err
+		}
+	}
+
+	chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, err := utils.SortResourcesInPaths(localRepoRoot, resourcePaths)
 
 	if err != nil {
 		klog.Error(err, "Failed to sort kubernetes resources and helm charts.")
 		return err
 	}
 
+	// Download the chart dependencies into the charts folder of the cloned charts, so that the charts can be indexed
+	// and rendered on the hub. The dependency archives are cached between reconciles. The charts are not packaged,
+	// the managed clusters build the dependencies of the charts they install.
+	dependencyCredentials, err := utils.GetHelmDependencyCredentials(r.Client, chn, nil, sub)
+	if err != nil {
+		klog.Error(err, "Failed to get the Helm dependency credentials.")
+		return err
+	}
+
+	if _, err := utils.BuildHelmDependencies(chartDirs, dependencyCredentials, ""); err != nil {
+		return err
+	}
+
 	// Build a helm repo index file
 	indexFile, err := utils.GenerateHelmIndexFile(sub, localRepoRoot, chartDirs)
 
@@ -631,18 +686,38 @@ type sourceURLs struct {
 }
 
 func (r *ReconcileSubscription) subscribeHelmCharts(chn *chnv1.Channel, sub *appv1.Subscription, indexFile *repo.IndexFile) (err error) {
-	for packageName, chartVersions := range indexFile.Entries {
-		klog.Infof("chart: %s\n%v", packageName, chartVersions)
+	chartReleases, err := utils.GetChartReleases(sub, indexFile)
+	if err != nil {
+		return err
+	}
+
+	for _, chartRelease := range chartReleases {
+		packageName := chartRelease.PackageName
+		chartVersion := chartRelease.ChartVersions[0]
+
+		klog.Infof("chart: %s\n%v", chartRelease.Name(), chartRelease.ChartVersions)
 
 		obj := &unstructured.Unstructured{}
 		obj.SetKind("HelmRelease")
 		obj.SetAPIVersion("apps.open-cluster-management.io/v1")
-		obj.SetName(packageName + "-" + chartVersions[0].Version)
+		obj.SetName(packageName + "-" + chartVersion.Version)
 
 		spec := &helmSpec{}
 		spec.ChartName = packageName
 		spec.ReleaseName = packageName
-		spec.Version = chartVersions[0].Version
+		spec.Version = chartVersion.Version
+
+		// The versions of a chart deployed side by side are named by their version range, so the release of a
+		// range is upgraded in place when a newer version of the range is released
+		if chartRelease.VersionKey != "" {
+			releaseCRName, err := utils.PkgVersionToReleaseCRName(sub, packageName, chartRelease.VersionKey)
+			if err != nil {
+				return err
+			}
+
+			obj.SetName(releaseCRName)
+			spec.ReleaseName = releaseCRName
+		}
 
 		sourceurls := &sourceURLs{}
 		sourceurls.URLs = []string{chn.Spec.Pathname}
@@ -651,7 +726,6 @@ func (r *ReconcileSubscription) subscribeHelmCharts(chn *chnv1.Channel, sub *app
 
 		src.Type = chnv1.ChannelTypeGit
 		src.Git = sourceurls
-		chartVersion, _ := indexFile.Get(packageName, chartVersions[0].Version)
 		src.Git.ChartPath = chartVersion.URLs[0]
 
 		spec.Source = src
@@ -670,7 +744,7 @@ func (r *ReconcileSubscription) subscribeHelmCharts(chn *chnv1.Channel, sub *app
 		err = r.createDeployable(chn, sub, "", dplSpec)
 
 		if err != nil {
-			klog.Error("failed to create deployable for helmrelease: " + packageName + "-" + chartVersions[0].Version)
+			klog.Error("failed to create deployable for helmrelease: " + obj.GetName())
 			return err
 		}
 	}
@@ -20,11 +20,17 @@ import (
 	"time"
 
 	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	dplv1 "github.com/open-cluster-management/multicloud-operators-deployable/pkg/apis/apps/v1"
 	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 )
 
@@ -112,3 +118,73 @@ func TestUpdateGitDeployablesAnnotation(t *testing.T) {
 	err = c.Delete(context.TODO(), githubchn)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 }
+
+func TestSubscribeHelmChartReleaseNames(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	s := runtime.NewScheme()
+	g.Expect(dplv1.SchemeBuilder.AddToScheme(s)).To(gomega.Succeed())
+	g.Expect(appv1.SchemeBuilder.AddToScheme(s)).To(gomega.Succeed())
+
+	r := &ReconcileSubscription{Client: fake.NewFakeClientWithScheme(s), scheme: s}
+
+	chartVersion := func(name, version string) *repo.ChartVersion {
+		return &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: name, Version: version},
+			URLs:     []string{"charts/" + name},
+		}
+	}
+
+	sub := githubsub.DeepCopy()
+	sub.UID = "abcdef"
+	sub.Spec.PackageFilter = &appv1.PackageFilter{
+		Packages: []appv1.PackageVersions{{Name: "nginx", Versions: []string{"1.x", "2.x"}}},
+	}
+
+	indexFile := &repo.IndexFile{Entries: map[string]repo.ChartVersions{
+		"nginx": {chartVersion("nginx", "2.1.0"), chartVersion("nginx", "1.2.0")},
+		"redis": {chartVersion("redis", "3.0.0")},
+	}}
+
+	g.Expect(r.subscribeHelmCharts(githubchn, sub, indexFile)).To(gomega.Succeed())
+
+	dpls := &dplv1.DeployableList{}
+	g.Expect(r.Client.List(context.TODO(), dpls)).To(gomega.Succeed())
+
+	releases := map[string]string{}
+
+	for _, dpl := range dpls.Items {
+		template := &unstructured.Unstructured{}
+		g.Expect(template.UnmarshalJSON(dpl.Spec.Template.Raw)).To(gomega.Succeed())
+
+		releaseName, _, _ := unstructured.NestedString(template.Object, "spec", "releaseName")
+		version, _, _ := unstructured.NestedString(template.Object, "spec", "version")
+		releases[releaseName] = version
+	}
+
+	// The versions deployed side by side are released by version range, so their names do not change on upgrades
+	g.Expect(releases).To(gomega.Equal(map[string]string{
+		"nginx-1-x-abcde": "1.2.0",
+		"nginx-2-x-abcde": "2.1.0",
+		"redis":           "3.0.0",
+	}))
+}
+
+func TestSetCurrentTag(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1.Subscription{}
+	sub.SetAnnotations(map[string]string{appv1.AnnotationGitCommit: "abc", appv1.AnnotationGitTag: "v1.0.0"})
+
+	// the tag isn't recorded till its commit is deployed
+	g.Expect(setCurrentTag(sub, "def")).To(gomega.BeFalse())
+	g.Expect(sub.GetAnnotations()).NotTo(gomega.HaveKey(appv1.AnnotationGitCurrentTag))
+
+	g.Expect(setCurrentTag(sub, "abc")).To(gomega.BeTrue())
+	g.Expect(sub.GetAnnotations()[appv1.AnnotationGitCurrentTag]).To(gomega.Equal("v1.0.0"))
+	g.Expect(setCurrentTag(sub, "abc")).To(gomega.BeFalse())
+
+	delete(sub.GetAnnotations(), appv1.AnnotationGitTag)
+	g.Expect(setCurrentTag(sub, "abc")).To(gomega.BeTrue())
+	g.Expect(sub.GetAnnotations()).NotTo(gomega.HaveKey(appv1.AnnotationGitCurrentTag))
+}
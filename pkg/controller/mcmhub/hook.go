@@ -16,8 +16,8 @@ package mcmhub
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,12 +26,13 @@ import (
 	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
 	plrv1 "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/apis/apps/v1"
 	placementutils "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/utils"
-	ansiblejob "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/ansible/v1alpha1"
 	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 
 	"k8s.io/client-go/kubernetes/scheme"
@@ -71,6 +72,10 @@ type HookProcessor interface {
 	IsPreHooksCompleted(types.NamespacedName) (bool, error)
 	ApplyPostHooks(types.NamespacedName) error
 	IsPostHooksCompleted(types.NamespacedName) (bool, error)
+	//GetFailurePolicy returns the failure policy of the hooks of the hook type
+	//that failed after their retries, rollback takes precedence over abort
+	//and continue. An empty string is returned if no hook failed.
+	GetFailurePolicy(string, types.NamespacedName) string
 
 	HasHooks(string, types.NamespacedName) bool
 	//WriteStatusToSubscription gets the status at the entry of the reconcile,
@@ -91,6 +96,14 @@ type Hooks struct {
 
 	//store last subscription instance used for the hook operation
 	lastSub *subv1.Subscription
+	//the commit the hooks are registered for
+	commit string
+	//the commit deployed before the registered one
+	previousCommit string
+	//the resources of the registered commit and the ones changed since the
+	//previous commit, they're passed to the hooks in the hook context
+	resources        resourceSnapshot
+	changedResources []HookContextResource
 }
 
 func (h *Hooks) ConstructStatus() subv1.AnsibleJobsStatus {
@@ -131,6 +144,60 @@ func (h *Hooks) constructPosthookStatus() subv1.AnsibleJobsStatus {
 	return st
 }
 
+// ConstructHooksStatus reports the hooks of all kinds, the phase of the last
+// instances is evaluated from the resources on the hub. The last succeeded
+// commit and the conditions are carried over from the previous status.
+func (h *Hooks) ConstructHooksStatus(clt client.Client, logger logr.Logger, prev subv1.HooksStatus) subv1.HooksStatus {
+	st := h.constructPrehooksStatus(clt, logger, prev)
+
+	if h.postHooks != nil {
+		st.LastPosthooks, st.PosthooksHistory = h.postHooks.outputHookStatus(clt, logger)
+	}
+
+	setHooksFailedCondition(&st.Conditions, subv1.HookConditionPosthooksFailed, h.postHooks)
+
+	if h.commit != "" && isAllHooksSucceeded(st.LastPrehooks, st.LastPosthooks) {
+		st.LastSucceededCommit = h.commit
+	}
+
+	return st
+}
+
+func (h *Hooks) constructPrehooksStatus(clt client.Client, logger logr.Logger, prev subv1.HooksStatus) subv1.HooksStatus {
+	st := subv1.HooksStatus{
+		LastSucceededCommit: prev.LastSucceededCommit,
+	}
+
+	for _, c := range prev.Conditions {
+		st.Conditions = append(st.Conditions, *c.DeepCopy())
+	}
+
+	if h.preHooks != nil {
+		st.LastPrehooks, st.PrehooksHistory = h.preHooks.outputHookStatus(clt, logger)
+	}
+
+	setHooksFailedCondition(&st.Conditions, subv1.HookConditionPrehooksFailed, h.preHooks)
+	meta.RemoveStatusCondition(&st.Conditions, subv1.HookConditionPosthooksFailed)
+
+	return st
+}
+
+func isAllHooksSucceeded(hookLists ...[]subv1.HookStatus) bool {
+	found := false
+
+	for _, hooks := range hookLists {
+		for _, hook := range hooks {
+			if hook.Phase != subv1.HookSucceeded {
+				return false
+			}
+
+			found = true
+		}
+	}
+
+	return found
+}
+
 type AnsibleHooks struct {
 	gitClt GitOps
 	clt    client.Client
@@ -139,8 +206,9 @@ type AnsibleHooks struct {
 	registry   map[types.NamespacedName]*Hooks
 	suffixFunc SuffixFunc
 	//logger
-	logger       logr.Logger
-	hookInterval time.Duration
+	logger        logr.Logger
+	eventRecorder *utils.EventRecorder
+	hookInterval  time.Duration
 }
 
 // make sure the AnsibleHooks implementate the HookProcessor
@@ -154,6 +222,12 @@ func setLogger(logger logr.Logger) HookOps {
 	}
 }
 
+func setEventRecorder(rec *utils.EventRecorder) HookOps {
+	return func(a *AnsibleHooks) {
+		a.eventRecorder = rec
+	}
+}
+
 func setGitOps(g GitOps) HookOps {
 	return func(a *AnsibleHooks) {
 		a.gitClt = g
@@ -211,6 +285,7 @@ func (a *AnsibleHooks) AppendStatusToSubscription(subIns *subv1.Subscription) su
 	}
 
 	out.AnsibleJobsStatus = hooks.ConstructStatus()
+	out.HooksStatus = hooks.ConstructHooksStatus(a.clt, a.logger, subIns.Status.HooksStatus)
 
 	return out
 }
@@ -226,6 +301,7 @@ func (a *AnsibleHooks) AppendPreHookStatusToSubscription(subIns *subv1.Subscript
 	}
 
 	out.AnsibleJobsStatus = hooks.constructPrehookStatus()
+	out.HooksStatus = hooks.constructPrehooksStatus(a.clt, a.logger, subIns.Status.HooksStatus)
 
 	return out
 }
@@ -291,7 +367,7 @@ func (a *AnsibleHooks) RegisterSubscription(subIns *subv1.Subscription, placemen
 		return fmt.Errorf("failed to download from git source of subscription %s, err: %w", subKey, err)
 	}
 
-	//update the base Ansible job and append a generated job to the preHooks
+	//update the base hooks and append the generated hooks to the preHooks
 	return a.addHookToRegisitry(subIns, placementDecisionUpdated, placementRuleRv, commitIDChanged)
 }
 
@@ -318,7 +394,7 @@ func suffixBasedOnSpecAndCommitID(gClt GitOps, subIns *subv1.Subscription) strin
 }
 
 func (a *AnsibleHooks) registerHook(subIns *subv1.Subscription, hookFlag string,
-	jobs []ansiblejob.AnsibleJob, placementDecisionUpdated bool, placementRuleRv string,
+	jobs []unstructured.Unstructured, placementDecisionUpdated bool, placementRuleRv string,
 	commitIDChanged bool) error {
 	subKey := types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}
 
@@ -378,6 +454,8 @@ func (a *AnsibleHooks) addHookToRegisitry(subIns *subv1.Subscription, placementD
 	if len(preJobs) != 0 || len(postJobs) != 0 {
 		subKey := types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}
 		a.registry[subKey].lastSub = unmaskFakeCommiIDOnSubIns(subIns)
+
+		a.registry[subKey].recordCommit(subIns, a.gitClt, a.logger)
 	}
 
 	if len(preJobs) != 0 {
@@ -399,7 +477,8 @@ func GetReferenceString(ref *corev1.ObjectReference) string {
 	return ref.Name
 }
 
-func addingHostingSubscriptionAnno(job ansiblejob.AnsibleJob, subKey types.NamespacedName, hookType string) ansiblejob.AnsibleJob {
+func addingHostingSubscriptionAnno(job unstructured.Unstructured, subKey types.NamespacedName, hookType string,
+	targetClusters []string) unstructured.Unstructured {
 	a := job.GetAnnotations()
 	if len(a) == 0 {
 		a = map[string]string{}
@@ -408,24 +487,38 @@ func addingHostingSubscriptionAnno(job ansiblejob.AnsibleJob, subKey types.Names
 	a[subv1.AnnotationHosting] = subKey.String()
 	a[subv1.AnnotationHookType] = hookType
 
+	if len(targetClusters) != 0 {
+		a[subv1.AnnotationHookTargetClusters] = strings.Join(targetClusters, ",")
+	} else {
+		delete(a, subv1.AnnotationHookTargetClusters)
+	}
+
 	job.SetAnnotations(a)
 
 	return job
 }
 
-//overrideAnsibleInstance adds the owner reference to job, and also reset the
-//secret file of ansibleJob
-func overrideAnsibleInstance(subIns *subv1.Subscription, job ansiblejob.AnsibleJob,
-	kubeclient client.Client, logger logr.Logger, hookType string) (ansiblejob.AnsibleJob, error) {
+//overrideHookInstance adds the owner reference and the target clusters to the
+//hook, and also reset the secret file of ansibleJob
+func overrideHookInstance(subIns *subv1.Subscription, job unstructured.Unstructured,
+	kubeclient client.Client, logger logr.Logger, hookType string) (unstructured.Unstructured, error) {
+	job = *job.DeepCopy()
 	job.SetResourceVersion("")
 	// avoid the error:
 	// status.conditions.lastTransitionTime in body must be of type string: \"null\""
-	job.Status = ansiblejob.AnsibleJobStatus{}
+	unstructured.RemoveNestedField(job.Object, "status")
 
-	if subIns.Spec.HookSecretRef != nil {
-		job.Spec.TowerAuthSecretName = GetReferenceString(subIns.Spec.HookSecretRef)
+	isAnsibleJob := job.GroupVersionKind().GroupKind() == AnsibleJobGroupKind
+
+	if isAnsibleJob && subIns.Spec.HookSecretRef != nil {
+		if err := unstructured.SetNestedField(job.Object, GetReferenceString(subIns.Spec.HookSecretRef),
+			"spec", "tower_auth_secret"); err != nil {
+			return job, err
+		}
 	}
 
+	var targetClusters []string
+
 	if subIns.Spec.Placement != nil &&
 		(subIns.Spec.Placement.Local == nil || !*subIns.Spec.Placement.Local) {
 		clusters, err := GetClustersByPlacement(subIns, kubeclient, logger)
@@ -433,40 +526,25 @@ func overrideAnsibleInstance(subIns *subv1.Subscription, job ansiblejob.AnsibleJ
 			return job, err
 		}
 
-		if len(clusters) > 0 {
-			var targetClusters []string
-
-			for _, cluster := range clusters {
-				targetClusters = append(targetClusters, cluster.Name)
-			}
-
-			extraVarsMap := make(map[string]interface{})
-
-			if job.Spec.ExtraVars != nil {
-				err := json.Unmarshal(job.Spec.ExtraVars, &extraVarsMap)
-				if err != nil {
-					return job, err
-				}
-			}
-
-			extraVarsMap["target_clusters"] = targetClusters
+		for _, cluster := range clusters {
+			targetClusters = append(targetClusters, cluster.Name)
+		}
 
-			extraVars, err := json.Marshal(extraVarsMap)
-			if err != nil {
+		if isAnsibleJob {
+			if err := overrideAnsibleExtraVars(&job, targetClusters); err != nil {
 				return job, err
 			}
-
-			job.Spec.ExtraVars = extraVars
-		} else {
-			job.Spec.ExtraVars = nil
 		}
 	}
 
-	//make sure all the ansiblejob is deployed at the subscription namespace
+	//make sure all the hooks are deployed at the subscription namespace
 	job.SetNamespace(subIns.GetNamespace())
 
+	sortedClusters := append([]string{}, targetClusters...)
+	sort.Strings(sortedClusters)
+
 	job = addingHostingSubscriptionAnno(job,
-		types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}, hookType)
+		types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}, hookType, sortedClusters)
 
 	//set owerreferce
 	if err := ctrlutil.SetOwnerReference(subIns.DeepCopy(), &job, scheme.Scheme); err != nil {
@@ -476,6 +554,53 @@ func overrideAnsibleInstance(subIns *subv1.Subscription, job ansiblejob.AnsibleJ
 	return job, nil
 }
 
+// overrideAnsibleExtraVars passes the target clusters to the ansible job
+// template, the extra_vars is dropped if there's no target cluster
+func overrideAnsibleExtraVars(job *unstructured.Unstructured, targetClusters []string) error {
+	if len(targetClusters) == 0 {
+		unstructured.RemoveNestedField(job.Object, "spec", "extra_vars")
+
+		return nil
+	}
+
+	extraVarsMap, _, err := unstructured.NestedMap(job.Object, "spec", "extra_vars")
+	if err != nil {
+		return err
+	}
+
+	if extraVarsMap == nil {
+		extraVarsMap = map[string]interface{}{}
+	}
+
+	// unstructured content only takes []interface{}
+	clusters := make([]interface{}, 0, len(targetClusters))
+	for _, cluster := range targetClusters {
+		clusters = append(clusters, cluster)
+	}
+
+	extraVarsMap["target_clusters"] = clusters
+
+	return unstructured.SetNestedMap(job.Object, extraVarsMap, "spec", "extra_vars")
+}
+
+// hasHookTargets tells if the hook has cluster to run for, an ansible job
+// without extra_vars is skipped, the other hooks are skipped if the placement
+// of the subscription doesn't select any cluster
+func hasHookTargets(subIns *subv1.Subscription, job *unstructured.Unstructured) bool {
+	if job.GroupVersionKind().GroupKind() == AnsibleJobGroupKind {
+		_, found, _ := unstructured.NestedFieldNoCopy(job.Object, "spec", "extra_vars")
+
+		return found
+	}
+
+	if subIns.Spec.Placement != nil &&
+		(subIns.Spec.Placement.Local == nil || !*subIns.Spec.Placement.Local) {
+		return job.GetAnnotations()[subv1.AnnotationHookTargetClusters] != ""
+	}
+
+	return true
+}
+
 func (a *AnsibleHooks) isRegistered(subKey types.NamespacedName) bool {
 	return a.registry[subKey] != nil
 }
@@ -483,8 +608,9 @@ func (a *AnsibleHooks) isRegistered(subKey types.NamespacedName) bool {
 func (a *AnsibleHooks) ApplyPreHooks(subKey types.NamespacedName) error {
 	if a.HasHooks(PreHookType, subKey) {
 		hks := a.registry[subKey].preHooks
+		lastSub := a.registry[subKey].lastSub
 
-		return hks.applyJobs(a.clt, a.registry[subKey].lastSub, a.logger)
+		return hks.applyJobs(a.clt, lastSub, a.buildHookContext(lastSub, PreHookType), a.logger)
 	}
 
 	return nil
@@ -580,7 +706,52 @@ func (a *AnsibleHooks) IsPreHooksCompleted(subKey types.NamespacedName) (bool, e
 		return true, nil
 	}
 
-	return hks.isJobsCompleted(a.clt, a.logger)
+	return a.isJobsCompleted(subKey, hks)
+}
+
+// isJobsCompleted checks the hooks, and records their failures, timeouts and
+// retries as events of the subscription
+func (a *AnsibleHooks) isJobsCompleted(subKey types.NamespacedName, hks *JobInstances) (bool, error) {
+	completed, events, err := hks.isJobsCompleted(a.clt, a.logger)
+
+	if a.eventRecorder != nil && a.registry[subKey].lastSub != nil {
+		for _, e := range events {
+			var evtErr error
+			if e.warning {
+				evtErr = fmt.Errorf("%s", e.msg)
+			}
+
+			a.eventRecorder.RecordEvent(a.registry[subKey].lastSub, e.reason, e.msg, evtErr)
+		}
+	}
+
+	return completed, err
+}
+
+func (a *AnsibleHooks) GetFailurePolicy(hookType string, subKey types.NamespacedName) string {
+	if !a.isRegistered(subKey) {
+		return ""
+	}
+
+	hks := a.registry[subKey].postHooks
+	if hookType == PreHookType {
+		hks = a.registry[subKey].preHooks
+	}
+
+	policy := ""
+
+	for _, f := range hks.failedHooks() {
+		switch {
+		case f.failurePolicy == subv1.HookFailurePolicyRollback:
+			return subv1.HookFailurePolicyRollback
+		case f.failurePolicy == subv1.HookFailurePolicyAbort:
+			policy = subv1.HookFailurePolicyAbort
+		case policy == "":
+			policy = f.failurePolicy
+		}
+	}
+
+	return policy
 }
 
 func (a *AnsibleHooks) HasHooks(hookType string, subKey types.NamespacedName) bool {
@@ -611,37 +782,32 @@ func (a *AnsibleHooks) HasHooks(hookType string, subKey types.NamespacedName) bo
 func (a *AnsibleHooks) ApplyPostHooks(subKey types.NamespacedName) error {
 	if a.HasHooks(PostHookType, subKey) {
 		hks := a.registry[subKey].postHooks
-		return hks.applyJobs(a.clt, a.registry[subKey].lastSub, a.logger)
+		lastSub := a.registry[subKey].lastSub
+
+		// the posthooks get the deployment result of the latest status
+		sub := &subv1.Subscription{}
+		if err := a.clt.Get(context.TODO(), subKey, sub); err != nil {
+			return err
+		}
+
+		return hks.applyJobs(a.clt, lastSub, a.buildHookContext(sub, PostHookType), a.logger)
 	}
 
 	return nil
 }
 
 func (a *AnsibleHooks) IsPostHooksCompleted(subKey types.NamespacedName) (bool, error) {
+	if !a.isRegistered(subKey) {
+		return true, nil
+	}
+
 	hks := a.registry[subKey].postHooks
 
 	if hks == nil || len(*hks) == 0 {
 		return true, nil
 	}
 
-	return hks.isJobsCompleted(a.clt, a.logger)
-}
-
-func isJobRunSuccessful(job *ansiblejob.AnsibleJob, logger logr.Logger) bool {
-	curStatus := job.Status.AnsibleJobResult.Status
-	logger.Info(fmt.Sprintf("job %s status: %v", job.Status.AnsibleJobResult.URL, curStatus))
-	logger.V(1).Info(fmt.Sprintf("job %s status: %v", PrintHelper(job), curStatus))
-
-	return strings.EqualFold(curStatus, JobCompleted)
-}
-
-func isJobRunning(job *ansiblejob.AnsibleJob, logger logr.Logger) bool {
-	curStatus := job.Status.AnsibleJobResult.Status
-	logger.Info(fmt.Sprintf("job %s status: %v", job.Status.AnsibleJobResult.URL, curStatus))
-	logger.V(3).Info(fmt.Sprintf("job status: %v", curStatus))
-
-	return curStatus == "" || curStatus == "pending" || curStatus == "new" ||
-		curStatus == "waiting" || curStatus == "running"
+	return a.isJobsCompleted(subKey, hks)
 }
 
 // Top priority: placementRef, ignore others
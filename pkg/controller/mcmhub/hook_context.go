@@ -0,0 +1,582 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// HookContextVersion is the version of the HookContext passed to the hooks,
+	// it's bumped on any incompatible change of the HookContext fields
+	HookContextVersion = "v1"
+
+	// HookContextExtraVar is the AnsibleJob extra var holding the hook context
+	HookContextExtraVar = "hook_context"
+	// HookContextFileEnv is the environment variable holding the path of the
+	// hook context file in the containers of a Job hook
+	HookContextFileEnv = "HOOK_CONTEXT_FILE"
+	// HookContextConfigMapKey is the key of the hook context in its ConfigMap
+	HookContextConfigMapKey = "context.json"
+
+	hookContextVolume          = "hook-context"
+	hookContextMountPath       = "/etc/hook-context"
+	hookContextConfigMapSuffix = "-hook-context"
+
+	ResourceCreated = "created"
+	ResourceUpdated = "updated"
+	ResourceDeleted = "deleted"
+)
+
+// HookContext is passed to every hook, as the hook_context extra var of an
+// AnsibleJob and as the context.json ConfigMap file of a Job, the file path
+// is in the HOOK_CONTEXT_FILE environment variable
+type HookContext struct {
+	Version string `json:"version"`
+	// HookType is either prehook or posthook
+	HookType       string             `json:"hook_type"`
+	Subscription   HookContextObject  `json:"subscription"`
+	Channel        HookContextChannel `json:"channel"`
+	Commit         string             `json:"commit,omitempty"`
+	PreviousCommit string             `json:"previous_commit,omitempty"`
+	TargetClusters []string           `json:"target_clusters"`
+	// ChangedResources are the resources changed by the commit, only set for
+	// the posthooks and omitted when the change isn't known
+	ChangedResources []HookContextResource `json:"changed_resources,omitempty"`
+	// ClusterResults is the deployment result per cluster, only set for the posthooks
+	ClusterResults map[string]HookContextClusterResult `json:"cluster_results,omitempty"`
+}
+
+type HookContextObject struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type HookContextChannel struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Pathname  string `json:"pathname,omitempty"`
+	// Path is the path of the subscription resources in the repository, it's
+	// only set when the subscription has a single path
+	Path string `json:"path,omitempty"`
+	// Paths are all the paths of the subscription resources in the repository
+	Paths []string `json:"paths,omitempty"`
+}
+
+type HookContextResource struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Change is one of created, updated or deleted
+	Change string `json:"change"`
+}
+
+type HookContextClusterResult struct {
+	Phase    string                              `json:"phase,omitempty"`
+	Packages map[string]HookContextPackageResult `json:"packages,omitempty"`
+}
+
+type HookContextPackageResult struct {
+	Phase   string `json:"phase,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// resourceSnapshot maps the resources deployed from a commit to the digest
+// of their content, the key is apiVersion/kind/namespace/name
+type resourceSnapshot map[string]snapshotEntry
+
+type snapshotEntry struct {
+	resource HookContextResource
+	digest   string
+}
+
+// getGitPaths returns the paths of the subscription resources in the
+// repository, including the paths of the git-paths annotation
+func getGitPaths(subIns *subv1.Subscription) []string {
+	gitPaths, err := utils.GetGitPaths(subIns, nil)
+	if err != nil {
+		return nil
+	}
+
+	paths := []string{}
+
+	for _, p := range gitPaths {
+		paths = append(paths, p.Path)
+	}
+
+	return paths
+}
+
+// snapshotResources reads the resources the subscription deploys from the
+// cloned repository, the hook folders are skipped
+func snapshotResources(repoRoot string, subIns *subv1.Subscription, logger logr.Logger) (resourceSnapshot, error) {
+	gitPaths, err := utils.GetGitPaths(subIns, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resourcePaths, err := utils.ResolveGitPaths(repoRoot, gitPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	_, kustomizeDirs, crds, rbacs, others, err := utils.SortResourcesInPaths(repoRoot, resourcePaths, utils.SkipHooksOnManaged)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := resourceSnapshot{}
+
+	for _, dir := range kustomizeDirs {
+		out, err := utils.RunKustomizeBuild(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.add(out, logger)
+	}
+
+	files := append(append(append([]string{}, crds...), rbacs...), others...)
+
+	for _, f := range files {
+		file, err := ioutil.ReadFile(f) // #nosec G304 f is not user input
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.add(file, logger)
+	}
+
+	return snapshot, nil
+}
+
+func (s resourceSnapshot) add(file []byte, logger logr.Logger) {
+	cond := func(t utils.KubeResource) bool {
+		return t.APIVersion == "" || t.Kind == ""
+	}
+
+	for _, resource := range utils.KubeResourceParser(file, cond) {
+		// the json of the yaml has sorted keys, it's stable over formatting changes
+		jsonRes, err := yaml.YAMLToJSON(resource)
+		if err != nil {
+			logger.Error(err, "failed to parse a resource")
+			continue
+		}
+
+		obj := unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(jsonRes); err != nil {
+			logger.Error(err, "failed to parse a resource")
+			continue
+		}
+
+		res := HookContextResource{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+
+		digest := sha1.Sum(jsonRes) // #nosec G401 Used only to detect changes
+
+		s[fmt.Sprintf("%s/%s/%s/%s", res.APIVersion, res.Kind, res.Namespace, res.Name)] = snapshotEntry{
+			resource: res,
+			digest:   hex.EncodeToString(digest[:]),
+		}
+	}
+}
+
+// diffSnapshots lists the resources created, updated or deleted from the old
+// to the new snapshot, sorted by their key
+func diffSnapshots(old, cur resourceSnapshot) []HookContextResource {
+	keys := []string{}
+
+	for k := range old {
+		keys = append(keys, k)
+	}
+
+	for k := range cur {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	changed := []HookContextResource{}
+
+	for _, k := range keys {
+		o, inOld := old[k]
+		c, inCur := cur[k]
+
+		switch {
+		case !inOld:
+			c.resource.Change = ResourceCreated
+			changed = append(changed, c.resource)
+		case !inCur:
+			o.resource.Change = ResourceDeleted
+			changed = append(changed, o.resource)
+		case o.digest != c.digest:
+			c.resource.Change = ResourceUpdated
+			changed = append(changed, c.resource)
+		}
+	}
+
+	return changed
+}
+
+// recordCommit keeps the commit the hooks are registered for along with the
+// previous one and the resources changed in between
+func (h *Hooks) recordCommit(subIns *subv1.Subscription, gitClt GitOps, logger logr.Logger) {
+	commit, err := gitClt.GetLatestCommitID(subIns)
+	if err != nil || commit == h.commit {
+		return
+	}
+
+	snapshot, err := snapshotResources(gitClt.GetRepoRootDirctory(subIns), subIns, logger)
+	if err != nil {
+		logger.Error(err, "failed to read the resources of the commit")
+	}
+
+	switch {
+	case h.commit != "":
+		h.previousCommit = h.commit
+		h.changedResources = nil
+
+		if h.resources != nil && snapshot != nil {
+			h.changedResources = diffSnapshots(h.resources, snapshot)
+		}
+	case getCommitID(subIns) == "":
+		// first deployment of the subscription, all the resources are new
+		h.previousCommit = ""
+		h.changedResources = nil
+
+		if snapshot != nil {
+			h.changedResources = diffSnapshots(nil, snapshot)
+		}
+	default:
+		// the registry is rebuilt, e.g. after a restart, the resources of the
+		// previous commit aren't known
+		h.previousCommit, _ = gitClt.GetPreviousCommitID(subIns)
+		h.changedResources = nil
+	}
+
+	h.commit = commit
+	h.resources = snapshot
+}
+
+// buildHookContext builds the context of the given hook type, the posthooks
+// get the changed resources and the deployment result of subIns status
+func (a *AnsibleHooks) buildHookContext(subIns *subv1.Subscription, hookType string) HookContext {
+	subKey := types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}
+	chnKey := utils.NamespacedNameFormat(subIns.Spec.Channel)
+
+	hookCtx := HookContext{
+		Version:      HookContextVersion,
+		HookType:     hookType + "hook",
+		Subscription: HookContextObject{Namespace: subKey.Namespace, Name: subKey.Name},
+		Channel: HookContextChannel{
+			Namespace: chnKey.Namespace,
+			Name:      chnKey.Name,
+			Paths:     getGitPaths(subIns),
+		},
+		TargetClusters: []string{},
+	}
+
+	if len(hookCtx.Channel.Paths) == 1 {
+		hookCtx.Channel.Path = hookCtx.Channel.Paths[0]
+	}
+
+	chn := &chnv1.Channel{}
+	if err := a.clt.Get(context.TODO(), chnKey, chn); err == nil {
+		hookCtx.Channel.Type = string(chn.Spec.Type)
+		hookCtx.Channel.Pathname = chn.Spec.Pathname
+	} else {
+		a.logger.Error(err, "failed to get the channel for the hook context")
+	}
+
+	if hks, ok := a.registry[subKey]; ok {
+		hookCtx.Commit = hks.commit
+		hookCtx.PreviousCommit = hks.previousCommit
+
+		if hookType == PostHookType {
+			hookCtx.ChangedResources = hks.changedResources
+		}
+	}
+
+	if hookType == PostHookType {
+		hookCtx.ClusterResults = clusterResults(subIns.Status.Statuses)
+	}
+
+	return hookCtx
+}
+
+func clusterResults(statuses subv1.SubscriptionClusterStatusMap) map[string]HookContextClusterResult {
+	results := map[string]HookContextClusterResult{}
+
+	for cluster, cst := range statuses {
+		result := HookContextClusterResult{Packages: map[string]HookContextPackageResult{}}
+
+		if cst == nil {
+			results[cluster] = result
+			continue
+		}
+
+		phases := map[string]struct{}{}
+
+		for pkg, pst := range cst.SubscriptionPackageStatus {
+			if pst == nil {
+				continue
+			}
+
+			result.Packages[pkg] = HookContextPackageResult{
+				Phase:   string(pst.Phase),
+				Reason:  pst.Reason,
+				Message: pst.Message,
+			}
+
+			phases[string(pst.Phase)] = struct{}{}
+		}
+
+		// a failed package fails the cluster, otherwise the cluster has the
+		// phase its packages agree on
+		if _, ok := phases[string(subv1.SubscriptionFailed)]; ok {
+			result.Phase = string(subv1.SubscriptionFailed)
+		} else if len(phases) == 1 {
+			for phase := range phases {
+				result.Phase = phase
+			}
+		}
+
+		results[cluster] = result
+	}
+
+	return results
+}
+
+// injectHookContext passes the hook context to the hook instance before it's
+// created, the target clusters are the ones the instance is created for
+func injectHookContext(clt client.Client, subIns *subv1.Subscription, instance *unstructured.Unstructured,
+	hookCtx HookContext) error {
+	if clusters := instance.GetAnnotations()[subv1.AnnotationHookTargetClusters]; clusters != "" {
+		hookCtx.TargetClusters = strings.Split(clusters, ",")
+	}
+
+	data, err := json.Marshal(hookCtx)
+	if err != nil {
+		return err
+	}
+
+	if instance.GroupVersionKind().GroupKind() == AnsibleJobGroupKind {
+		return setHookContextExtraVar(instance, data)
+	}
+
+	cmName, err := applyHookContextConfigMap(clt, subIns, instance, data)
+	if err != nil {
+		return err
+	}
+
+	anno := instance.GetAnnotations()
+	if anno == nil {
+		anno = map[string]string{}
+	}
+
+	anno[subv1.AnnotationHookContextConfigMap] = cmName
+	instance.SetAnnotations(anno)
+
+	if instance.GroupVersionKind().GroupKind() == JobGroupKind {
+		return setHookContextOnJob(instance, cmName)
+	}
+
+	return nil
+}
+
+// setHookContextExtraVar adds the hook context to the extra_vars of an
+// AnsibleJob, the AnsibleJob without extra_vars is left alone
+func setHookContextExtraVar(instance *unstructured.Unstructured, data []byte) error {
+	extraVarsMap, found, err := unstructured.NestedMap(instance.Object, "spec", "extra_vars")
+	if err != nil || !found {
+		return err
+	}
+
+	// the json decoded value only has the types the unstructured content takes
+	var hookCtx map[string]interface{}
+	if err := json.Unmarshal(data, &hookCtx); err != nil {
+		return err
+	}
+
+	extraVarsMap[HookContextExtraVar] = hookCtx
+
+	return unstructured.SetNestedMap(instance.Object, extraVarsMap, "spec", "extra_vars")
+}
+
+// applyHookContextConfigMap creates or updates the ConfigMap holding the hook
+// context of the instance, it's owned by the subscription
+func applyHookContextConfigMap(clt client.Client, subIns *subv1.Subscription, instance *unstructured.Unstructured,
+	data []byte) (string, error) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.GetName() + hookContextConfigMapSuffix,
+			Namespace: instance.GetNamespace(),
+			Labels: map[string]string{
+				subv1.LabelSubscriptionName: subIns.GetName(),
+			},
+		},
+		Data: map[string]string{HookContextConfigMapKey: string(data)},
+	}
+
+	if err := ctrlutil.SetOwnerReference(subIns.DeepCopy(), cm, scheme.Scheme); err != nil {
+		return "", err
+	}
+
+	cmKey := types.NamespacedName{Name: cm.GetName(), Namespace: cm.GetNamespace()}
+	existing := &corev1.ConfigMap{}
+
+	if err := clt.Get(context.TODO(), cmKey, existing); err != nil {
+		if !kerr.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get hook context %v, err: %v", cmKey, err)
+		}
+
+		if err := clt.Create(context.TODO(), cm); err != nil {
+			return "", fmt.Errorf("failed to create hook context %v, err: %v", cmKey, err)
+		}
+
+		return cm.GetName(), nil
+	}
+
+	existing.Data = cm.Data
+
+	if err := clt.Update(context.TODO(), existing); err != nil {
+		return "", fmt.Errorf("failed to update hook context %v, err: %v", cmKey, err)
+	}
+
+	return cm.GetName(), nil
+}
+
+// setHookContextOnJob passes the hook context to all the containers of a Job
+// as a file mounted from its ConfigMap, the context can be larger than an
+// environment variable allows so only the file path is set in the environment
+func setHookContextOnJob(instance *unstructured.Unstructured, cmName string) error {
+	job := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(instance.Object, job); err != nil {
+		return err
+	}
+
+	podSpec := &job.Spec.Template.Spec
+
+	hasVolume := false
+
+	for _, v := range podSpec.Volumes {
+		if v.Name == hookContextVolume {
+			hasVolume = true
+			break
+		}
+	}
+
+	if !hasVolume {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: hookContextVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cmName},
+				},
+			},
+		})
+	}
+
+	env := []corev1.EnvVar{
+		{Name: HookContextFileEnv, Value: filepath.Join(hookContextMountPath, HookContextConfigMapKey)},
+	}
+
+	setContainer := func(c *corev1.Container) {
+		c.Env = setEnvVars(c.Env, env)
+
+		if hasVolume {
+			return
+		}
+
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      hookContextVolume,
+			MountPath: hookContextMountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	for i := range podSpec.InitContainers {
+		setContainer(&podSpec.InitContainers[i])
+	}
+
+	for i := range podSpec.Containers {
+		setContainer(&podSpec.Containers[i])
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return err
+	}
+
+	// the converter outputs the empty status and creationTimestamp
+	unstructured.RemoveNestedField(obj, "status")
+	instance.Object = obj
+
+	return nil
+}
+
+func setEnvVars(env []corev1.EnvVar, vars []corev1.EnvVar) []corev1.EnvVar {
+	for _, v := range vars {
+		replaced := false
+
+		for i := range env {
+			if env[i].Name == v.Name {
+				env[i] = v
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			env = append(env, v)
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	cmV1 = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: default
+data:
+  key: v1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: svc
+spec:
+  ports:
+  - port: 80
+`
+	// same resources as cmV1, reformatted
+	cmV1Reformatted = `kind: ConfigMap
+apiVersion: v1
+data: {key: v1}
+metadata: {namespace: default, name: cm}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: svc
+spec:
+  ports:
+  - port: 80
+`
+	cmV2 = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: default
+data:
+  key: v2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	v1 := resourceSnapshot{}
+	v1.add([]byte(cmV1), log.NullLogger{})
+	g.Expect(v1).To(gomega.HaveLen(2))
+
+	reformatted := resourceSnapshot{}
+	reformatted.add([]byte(cmV1Reformatted), log.NullLogger{})
+	g.Expect(diffSnapshots(v1, reformatted)).To(gomega.BeEmpty())
+
+	v2 := resourceSnapshot{}
+	v2.add([]byte(cmV2), log.NullLogger{})
+
+	g.Expect(diffSnapshots(v1, v2)).To(gomega.Equal([]HookContextResource{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Change: ResourceCreated},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "cm", Change: ResourceUpdated},
+		{APIVersion: "v1", Kind: "Service", Name: "svc", Change: ResourceDeleted},
+	}))
+
+	g.Expect(diffSnapshots(nil, v1)).To(gomega.HaveLen(2))
+}
+
+func TestSnapshotResources(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "hook-context")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	appDir := filepath.Join(dir, "app")
+	g.Expect(os.MkdirAll(filepath.Join(appDir, "prehook"), 0750)).To(gomega.Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(appDir, "resources.yaml"), []byte(cmV2), 0600)).To(gomega.Succeed())
+
+	hook := `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: hook
+`
+	g.Expect(ioutil.WriteFile(filepath.Join(appDir, "prehook", "hook.yaml"), []byte(hook), 0600)).To(gomega.Succeed())
+
+	subIns := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "sub",
+			Namespace:   "default",
+			Annotations: map[string]string{subv1.AnnotationGitPath: "app"},
+		},
+	}
+
+	snapshot, err := snapshotResources(dir, subIns, log.NullLogger{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the hooks aren't deployed resources
+	g.Expect(snapshot).To(gomega.HaveLen(2))
+	g.Expect(snapshot).To(gomega.HaveKey("apps/v1/Deployment//app"))
+	g.Expect(snapshot).To(gomega.HaveKey("v1/ConfigMap/default/cm"))
+
+	// the resources of all the git-paths are in the snapshot
+	svc := `apiVersion: v1
+kind: Service
+metadata:
+  name: svc
+`
+	g.Expect(os.MkdirAll(filepath.Join(dir, "other"), 0750)).To(gomega.Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "other", "svc.yaml"), []byte(svc), 0600)).To(gomega.Succeed())
+
+	subIns.SetAnnotations(map[string]string{subv1.AnnotationGitPaths: `["app", "other"]`})
+
+	snapshot, err = snapshotResources(dir, subIns, log.NullLogger{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(snapshot).To(gomega.HaveLen(3))
+	g.Expect(snapshot).To(gomega.HaveKey("v1/Service//svc"))
+	g.Expect(getGitPaths(subIns)).To(gomega.Equal([]string{"app", "other"}))
+}
+
+func TestClusterResults(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	statuses := subv1.SubscriptionClusterStatusMap{
+		"cluster1": &subv1.SubscriptionPerClusterStatus{
+			SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+				"cm":  {Phase: subv1.SubscriptionSubscribed},
+				"app": {Phase: subv1.SubscriptionFailed, Reason: "Error", Message: "failed to apply"},
+			},
+		},
+		"cluster2": &subv1.SubscriptionPerClusterStatus{
+			SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+				"cm": {Phase: subv1.SubscriptionSubscribed},
+			},
+		},
+	}
+
+	results := clusterResults(statuses)
+
+	g.Expect(results["cluster1"].Phase).To(gomega.Equal(string(subv1.SubscriptionFailed)))
+	g.Expect(results["cluster1"].Packages["app"]).To(gomega.Equal(HookContextPackageResult{
+		Phase: string(subv1.SubscriptionFailed), Reason: "Error", Message: "failed to apply"}))
+	g.Expect(results["cluster2"].Phase).To(gomega.Equal(string(subv1.SubscriptionSubscribed)))
+}
+
+func TestInjectHookContext(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(subv1.SchemeBuilder.AddToScheme(scheme.Scheme)).To(gomega.Succeed())
+
+	subIns := &subv1.Subscription{
+		TypeMeta:   metav1.TypeMeta{APIVersion: subv1.SchemeGroupVersion.String(), Kind: "Subscription"},
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "default", UID: "sub-uid"},
+	}
+
+	hookCtx := HookContext{
+		Version:        HookContextVersion,
+		HookType:       "posthook",
+		Subscription:   HookContextObject{Namespace: "default", Name: "sub"},
+		Commit:         "new",
+		PreviousCommit: "old",
+		TargetClusters: []string{},
+		ChangedResources: []HookContextResource{
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "cm", Change: ResourceUpdated},
+		},
+	}
+
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+	// ansible job gets the context as an extra var
+	ansible := newHook(AnsibleJobVersion, AnsibleJobKind, "ansible-hook", nil)
+	ansible.SetAnnotations(map[string]string{subv1.AnnotationHookTargetClusters: "cluster1,cluster2"})
+	g.Expect(unstructured.SetNestedField(ansible.Object, map[string]interface{}{"target_clusters": []interface{}{"cluster1"}},
+		"spec", "extra_vars")).To(gomega.Succeed())
+
+	g.Expect(injectHookContext(clt, subIns, &ansible, hookCtx)).To(gomega.Succeed())
+
+	extraCtx, found, err := unstructured.NestedMap(ansible.Object, "spec", "extra_vars", HookContextExtraVar)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeTrue())
+	g.Expect(extraCtx["version"]).To(gomega.Equal(HookContextVersion))
+	g.Expect(extraCtx["commit"]).To(gomega.Equal("new"))
+	g.Expect(extraCtx["previous_commit"]).To(gomega.Equal("old"))
+	g.Expect(extraCtx["target_clusters"]).To(gomega.Equal([]interface{}{"cluster1", "cluster2"}))
+	g.Expect(extraCtx["changed_resources"]).To(gomega.HaveLen(1))
+	g.Expect(ansible.GetAnnotations()).NotTo(gomega.HaveKey(subv1.AnnotationHookContextConfigMap))
+
+	// job gets the context as a mounted file and its path as an environment variable
+	job := &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: "job-hook", Namespace: "default"},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "main", Image: "busybox", Env: []corev1.EnvVar{{Name: HookContextFileEnv, Value: "stale"}}},
+					},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	ins := &unstructured.Unstructured{Object: obj}
+	g.Expect(injectHookContext(clt, subIns, ins, hookCtx)).To(gomega.Succeed())
+
+	cmName := "job-hook" + hookContextConfigMapSuffix
+	g.Expect(ins.GetAnnotations()).To(gomega.HaveKeyWithValue(subv1.AnnotationHookContextConfigMap, cmName))
+
+	g.Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(ins.Object, job)).To(gomega.Succeed())
+
+	podSpec := job.Spec.Template.Spec
+	g.Expect(podSpec.Volumes).To(gomega.HaveLen(1))
+	g.Expect(podSpec.Volumes[0].ConfigMap.Name).To(gomega.Equal(cmName))
+	g.Expect(podSpec.Containers[0].VolumeMounts).To(gomega.HaveLen(1))
+	g.Expect(podSpec.Containers[0].Env).To(gomega.Equal([]corev1.EnvVar{{
+		Name: HookContextFileEnv, Value: "/etc/hook-context/context.json"}}))
+
+	cm := &corev1.ConfigMap{}
+	g.Expect(clt.Get(context.TODO(), types.NamespacedName{Name: cmName, Namespace: "default"}, cm)).To(gomega.Succeed())
+
+	fileCtx := HookContext{}
+	g.Expect(json.Unmarshal([]byte(cm.Data[HookContextConfigMapKey]), &fileCtx)).To(gomega.Succeed())
+	g.Expect(fileCtx.TargetClusters).To(gomega.BeEmpty())
+	g.Expect(fileCtx.PreviousCommit).To(gomega.Equal("old"))
+	g.Expect(cm.GetOwnerReferences()).To(gomega.HaveLen(1))
+
+	// the injected job is accepted by the client
+	g.Expect(clt.Create(context.TODO(), ins)).To(gomega.Succeed())
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"strings"
+	"sync"
+
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// HookEvaluator evaluates the phase of the hook resources of a kind from their status
+type HookEvaluator interface {
+	// Phase returns Running, Succeeded or Failed
+	Phase(hook *unstructured.Unstructured) subv1.HookPhase
+}
+
+// HookEvaluatorFunc is a function used as a HookEvaluator
+type HookEvaluatorFunc func(hook *unstructured.Unstructured) subv1.HookPhase
+
+// Phase calls the function
+func (f HookEvaluatorFunc) Phase(hook *unstructured.Unstructured) subv1.HookPhase {
+	return f(hook)
+}
+
+var (
+	AnsibleJobGroupKind  = schema.GroupKind{Group: "tower.ansible.com", Kind: AnsibleJobKind}
+	JobGroupKind         = schema.GroupKind{Group: "batch", Kind: "Job"}
+	PipelineRunGroupKind = schema.GroupKind{Group: "tekton.dev", Kind: "PipelineRun"}
+	TaskRunGroupKind     = schema.GroupKind{Group: "tekton.dev", Kind: "TaskRun"}
+)
+
+var (
+	hookEvaluatorsMtx sync.RWMutex
+	// hookEvaluators are the evaluators of the kinds of the resources in the prehook and posthook folders. The
+	// resources of other kinds are ignored.
+	hookEvaluators = map[schema.GroupKind]HookEvaluator{
+		AnsibleJobGroupKind:  HookEvaluatorFunc(ansibleJobPhase),
+		JobGroupKind:         HookEvaluatorFunc(jobPhase),
+		PipelineRunGroupKind: HookEvaluatorFunc(tektonRunPhase),
+		TaskRunGroupKind:     HookEvaluatorFunc(tektonRunPhase),
+	}
+)
+
+// RegisterHookEvaluator makes the resources of the kind in the prehook and posthook folders hooks, evaluated by the
+// evaluator. It replaces the evaluator of a kind already registered.
+func RegisterHookEvaluator(gk schema.GroupKind, evaluator HookEvaluator) {
+	hookEvaluatorsMtx.Lock()
+	defer hookEvaluatorsMtx.Unlock()
+
+	hookEvaluators[gk] = evaluator
+}
+
+// getHookEvaluator returns the evaluator of the kind, or nil if the kind is not a hook kind
+func getHookEvaluator(gk schema.GroupKind) HookEvaluator {
+	hookEvaluatorsMtx.RLock()
+	defer hookEvaluatorsMtx.RUnlock()
+
+	return hookEvaluators[gk]
+}
+
+// isHookKind returns true if the resources of the apiVersion and kind are hooks
+func isHookKind(apiVersion, kind string) bool {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return false
+	}
+
+	return getHookEvaluator(gv.WithKind(kind).GroupKind()) != nil
+}
+
+// hookPhase evaluates the phase of the hook resource with the evaluator of its kind
+func hookPhase(hook *unstructured.Unstructured) subv1.HookPhase {
+	evaluator := getHookEvaluator(hook.GroupVersionKind().GroupKind())
+	if evaluator == nil {
+		return subv1.HookRunning
+	}
+
+	return evaluator.Phase(hook)
+}
+
+// ansibleJobPhase evaluates the Ansible Tower job status of the AnsibleJob
+func ansibleJobPhase(hook *unstructured.Unstructured) subv1.HookPhase {
+	status, _, _ := unstructured.NestedString(hook.Object, "status", "ansibleJobResult", "status")
+
+	switch strings.ToLower(status) {
+	case JobCompleted:
+		return subv1.HookSucceeded
+	case "failed", "error", "canceled":
+		return subv1.HookFailed
+	}
+
+	return subv1.HookRunning
+}
+
+// jobPhase evaluates the Complete and Failed conditions of the Job
+func jobPhase(hook *unstructured.Unstructured) subv1.HookPhase {
+	switch {
+	case hasTrueCondition(hook, "Complete"):
+		return subv1.HookSucceeded
+	case hasTrueCondition(hook, "Failed"):
+		return subv1.HookFailed
+	}
+
+	return subv1.HookRunning
+}
+
+// tektonRunPhase evaluates the Succeeded condition of the PipelineRun or TaskRun
+func tektonRunPhase(hook *unstructured.Unstructured) subv1.HookPhase {
+	switch conditionStatus(hook, "Succeeded") {
+	case "True":
+		return subv1.HookSucceeded
+	case "False":
+		return subv1.HookFailed
+	}
+
+	return subv1.HookRunning
+}
+
+func hasTrueCondition(obj *unstructured.Unstructured, condType string) bool {
+	return conditionStatus(obj, condType) == "True"
+}
+
+// conditionStatus returns the status of the condition of the type in status.conditions
+func conditionStatus(obj *unstructured.Unstructured, condType string) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != condType {
+			continue
+		}
+
+		status, _ := cond["status"].(string)
+
+		return status
+	}
+
+	return ""
+}
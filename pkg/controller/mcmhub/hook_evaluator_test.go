@@ -0,0 +1,269 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	plrv1 "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/apis/apps/v1"
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func newHook(apiVersion, kind, name string, status map[string]interface{}) unstructured.Unstructured {
+	hook := unstructured.Unstructured{Object: map[string]interface{}{}}
+	hook.SetAPIVersion(apiVersion)
+	hook.SetKind(kind)
+	hook.SetName(name)
+	hook.SetNamespace("default")
+
+	if status != nil {
+		hook.Object["status"] = status
+	}
+
+	return hook
+}
+
+func conditions(condType, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": condType, "status": status},
+		},
+	}
+}
+
+func TestHookEvaluators(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ansibleResult := func(status string) map[string]interface{} {
+		return map[string]interface{}{"ansibleJobResult": map[string]interface{}{"status": status}}
+	}
+
+	tests := []struct {
+		hook unstructured.Unstructured
+		want subv1.HookPhase
+	}{
+		{newHook(AnsibleJobVersion, AnsibleJobKind, "a", nil), subv1.HookRunning},
+		{newHook(AnsibleJobVersion, AnsibleJobKind, "a", ansibleResult("pending")), subv1.HookRunning},
+		{newHook(AnsibleJobVersion, AnsibleJobKind, "a", ansibleResult("successful")), subv1.HookSucceeded},
+		{newHook(AnsibleJobVersion, AnsibleJobKind, "a", ansibleResult("failed")), subv1.HookFailed},
+		{newHook("batch/v1", "Job", "j", nil), subv1.HookRunning},
+		{newHook("batch/v1", "Job", "j", conditions("Complete", "True")), subv1.HookSucceeded},
+		{newHook("batch/v1", "Job", "j", conditions("Failed", "True")), subv1.HookFailed},
+		{newHook("batch/v1", "Job", "j", conditions("Complete", "False")), subv1.HookRunning},
+		{newHook("tekton.dev/v1beta1", "PipelineRun", "p", conditions("Succeeded", "Unknown")), subv1.HookRunning},
+		{newHook("tekton.dev/v1beta1", "PipelineRun", "p", conditions("Succeeded", "True")), subv1.HookSucceeded},
+		{newHook("tekton.dev/v1beta1", "TaskRun", "t", conditions("Succeeded", "False")), subv1.HookFailed},
+	}
+
+	for _, tt := range tests {
+		hook := tt.hook
+		g.Expect(hookPhase(&hook)).To(gomega.Equal(tt.want), "%s %v", hook.GetKind(), hook.Object["status"])
+	}
+
+	g.Expect(isHookKind("batch/v1", "Job")).To(gomega.BeTrue())
+	g.Expect(isHookKind("tekton.dev/v1alpha1", "TaskRun")).To(gomega.BeTrue())
+	g.Expect(isHookKind("v1", "ConfigMap")).To(gomega.BeFalse())
+
+	gk := schema.GroupKind{Group: "example.com", Kind: "Workflow"}
+	g.Expect(isHookKind("example.com/v1", "Workflow")).To(gomega.BeFalse())
+
+	RegisterHookEvaluator(gk, HookEvaluatorFunc(func(*unstructured.Unstructured) subv1.HookPhase {
+		return subv1.HookSucceeded
+	}))
+
+	defer func() {
+		hookEvaluatorsMtx.Lock()
+		delete(hookEvaluators, gk)
+		hookEvaluatorsMtx.Unlock()
+	}()
+
+	workflow := newHook("example.com/v1", "Workflow", "w", nil)
+	g.Expect(isHookKind("example.com/v1", "Workflow")).To(gomega.BeTrue())
+	g.Expect(hookPhase(&workflow)).To(gomega.Equal(subv1.HookSucceeded))
+}
+
+func TestParseHookResoures(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	file := []byte(`apiVersion: tower.ansible.com/v1alpha1
+kind: AnsibleJob
+metadata:
+  name: ansible-hook
+spec:
+  job_template_name: test
+  extra_vars:
+    foo: bar
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: job-hook
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: hook
+        image: busybox
+---
+apiVersion: tekton.dev/v1beta1
+kind: PipelineRun
+metadata:
+  name: pipeline-hook
+spec:
+  pipelineRef:
+    name: deploy-check
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-hook
+`)
+
+	hooks := parseHooks(parseHookResoures(file), log.NullLogger{})
+	g.Expect(hooks).To(gomega.HaveLen(3))
+
+	kinds := []string{}
+	for _, h := range hooks {
+		kinds = append(kinds, h.GetKind())
+	}
+
+	g.Expect(kinds).To(gomega.Equal([]string{AnsibleJobKind, "Job", "PipelineRun"}))
+
+	extraVars, _, _ := unstructured.NestedMap(hooks[0].Object, "spec", "extra_vars")
+	g.Expect(extraVars).To(gomega.HaveKeyWithValue("foo", "bar"))
+}
+
+func TestOverrideHookInstance(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(subv1.SchemeBuilder.AddToScheme(scheme.Scheme)).To(gomega.Succeed())
+
+	local := true
+	subIns := &subv1.Subscription{
+		TypeMeta:   metav1.TypeMeta{APIVersion: subv1.SchemeGroupVersion.String(), Kind: "Subscription"},
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "sub-ns", UID: "sub-uid"},
+		Spec: subv1.SubscriptionSpec{
+			HookSecretRef: &corev1.ObjectReference{Name: "tower"},
+			Placement:     &plrv1.Placement{Local: &local},
+		},
+	}
+
+	job := newHook("batch/v1", "Job", "job-hook", conditions("Complete", "True"))
+	job.SetResourceVersion("10")
+
+	ins, err := overrideHookInstance(subIns, job, nil, log.NullLogger{}, "prehook")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(ins.GetNamespace()).To(gomega.Equal("sub-ns"))
+	g.Expect(ins.GetResourceVersion()).To(gomega.BeEmpty())
+	g.Expect(ins.Object).NotTo(gomega.HaveKey("status"))
+	g.Expect(ins.GetAnnotations()).To(gomega.HaveKeyWithValue(subv1.AnnotationHosting, "sub-ns/sub"))
+	g.Expect(ins.GetAnnotations()).To(gomega.HaveKeyWithValue(subv1.AnnotationHookType, "prehook"))
+	g.Expect(ins.GetAnnotations()).NotTo(gomega.HaveKey(subv1.AnnotationHookTargetClusters))
+	g.Expect(ins.GetOwnerReferences()).To(gomega.HaveLen(1))
+	g.Expect(ins.Object["spec"]).To(gomega.BeNil())
+	g.Expect(hasHookTargets(subIns, &ins)).To(gomega.BeTrue())
+
+	// the original hook is kept as it's parsed from git
+	g.Expect(job.GetNamespace()).To(gomega.Equal("default"))
+
+	ansible := newHook(AnsibleJobVersion, AnsibleJobKind, "ansible-hook", nil)
+
+	ins, err = overrideHookInstance(subIns, ansible, nil, log.NullLogger{}, "posthook")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	secret, _, _ := unstructured.NestedString(ins.Object, "spec", "tower_auth_secret")
+	g.Expect(secret).To(gomega.Equal("tower"))
+	g.Expect(hasHookTargets(subIns, &ins)).To(gomega.BeFalse())
+
+	g.Expect(overrideAnsibleExtraVars(&ins, []string{"cluster2", "cluster1"})).To(gomega.Succeed())
+
+	clusters, _, _ := unstructured.NestedStringSlice(ins.Object, "spec", "extra_vars", "target_clusters")
+	g.Expect(clusters).To(gomega.Equal([]string{"cluster2", "cluster1"}))
+	g.Expect(hasHookTargets(subIns, &ins)).To(gomega.BeTrue())
+
+	g.Expect(overrideAnsibleExtraVars(&ins, nil)).To(gomega.Succeed())
+	g.Expect(hasHookTargets(subIns, &ins)).To(gomega.BeFalse())
+
+	remote := subIns.DeepCopy()
+	remote.Spec.Placement = &plrv1.Placement{}
+	g.Expect(hasHookTargets(remote, &job)).To(gomega.BeFalse())
+
+	job.SetAnnotations(map[string]string{subv1.AnnotationHookTargetClusters: "cluster1"})
+	g.Expect(hasHookTargets(remote, &job)).To(gomega.BeTrue())
+}
+
+func TestOutputHookStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	applied := newHook("batch/v1", "Job", "job-hook-1-abcdef", conditions("Complete", "True"))
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme, []runtime.Object{&applied}...)
+
+	jobKey := types.NamespacedName{Name: "job-hook", Namespace: "default"}
+	pipelineKey := types.NamespacedName{Name: "pipeline-hook", Namespace: "default"}
+
+	jobs := JobInstances{
+		jobKey: &Job{
+			InstanceSet: map[types.NamespacedName]struct{}{},
+			Instance: []unstructured.Unstructured{
+				newHook("batch/v1", "Job", "job-hook-1-012345", nil),
+				newHook("batch/v1", "Job", "job-hook-1-abcdef", nil),
+			},
+		},
+		pipelineKey: &Job{
+			InstanceSet: map[types.NamespacedName]struct{}{},
+			Instance: []unstructured.Unstructured{
+				newHook("tekton.dev/v1beta1", "PipelineRun", "pipeline-hook-1-abcdef", nil),
+			},
+		},
+	}
+
+	last, history := jobs.outputHookStatus(clt, log.NullLogger{})
+
+	g.Expect(last).To(gomega.Equal([]subv1.HookStatus{
+		{APIVersion: "batch/v1", Kind: "Job", Namespace: "default", Name: "job-hook-1-abcdef", Phase: subv1.HookSucceeded},
+		{APIVersion: "tekton.dev/v1beta1", Kind: "PipelineRun", Namespace: "default", Name: "pipeline-hook-1-abcdef",
+			Phase: subv1.HookPending},
+	}))
+	g.Expect(history).To(gomega.Equal([]string{
+		"Job/default/job-hook-1-012345",
+		"Job/default/job-hook-1-abcdef",
+		"PipelineRun/default/pipeline-hook-1-abcdef",
+	}))
+
+	// the AnsibleJob status only reports the AnsibleJob hooks
+	g.Expect(jobs.outputAppliedJobs(ansiblestatusFormat).lastApplied).To(gomega.BeEmpty())
+	g.Expect(jobs.outputAppliedJobs(formatAnsibleFromTopo).lastAppliedJobs).To(gomega.ContainElement(
+		"hook//Job/default/job-hook-1-abcdef/0"))
+
+	phase, err := getHookPhase(clt, jobs[jobKey].Instance[1])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(phase).To(gomega.Equal(subv1.HookSucceeded))
+
+	phase, err = getHookPhase(clt, jobs[pipelineKey].Instance[0])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(phase).To(gomega.Equal(subv1.HookPending))
+}
@@ -0,0 +1,378 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultHookBackoff = 30 * time.Second
+	maxHookBackoff     = 10 * time.Minute
+
+	hookFailedReason    = "HookFailed"
+	hookTimedOutReason  = "HookTimedOut"
+	hookRetryReason     = "HookRetry"
+	hookNotFailedReason = "HooksNotFailed"
+)
+
+// hookPolicy is the timeout, retries and failure policy a hook declares in its
+// annotations
+type hookPolicy struct {
+	// timeout is 0 if the hook is never timed out
+	timeout       time.Duration
+	retries       int
+	backoff       time.Duration
+	failurePolicy string
+}
+
+// getHookPolicy reads the policy of the hook, a hook is not timed out nor
+// retried and the subscription keeps waiting for it on failure by default
+func getHookPolicy(hook *unstructured.Unstructured) (hookPolicy, error) {
+	p := hookPolicy{
+		backoff:       defaultHookBackoff,
+		failurePolicy: subv1.HookFailurePolicyWait,
+	}
+
+	anno := hook.GetAnnotations()
+	hookName := fmt.Sprintf("%s %s", hook.GetKind(), hook.GetName())
+
+	if v := anno[subv1.AnnotationHookTimeout]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid %s annotation %q of %s", subv1.AnnotationHookTimeout, v, hookName)
+		}
+
+		p.timeout = d
+	}
+
+	if v := anno[subv1.AnnotationHookRetries]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid %s annotation %q of %s", subv1.AnnotationHookRetries, v, hookName)
+		}
+
+		p.retries = n
+	}
+
+	if v := anno[subv1.AnnotationHookBackoff]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid %s annotation %q of %s", subv1.AnnotationHookBackoff, v, hookName)
+		}
+
+		p.backoff = d
+	}
+
+	if v := anno[subv1.AnnotationHookFailurePolicy]; v != "" {
+		switch strings.ToLower(v) {
+		case subv1.HookFailurePolicyWait, subv1.HookFailurePolicyAbort, subv1.HookFailurePolicyContinue,
+			subv1.HookFailurePolicyRollback:
+			p.failurePolicy = strings.ToLower(v)
+		default:
+			return p, fmt.Errorf("invalid %s annotation %q of %s", subv1.AnnotationHookFailurePolicy, v, hookName)
+		}
+	}
+
+	return p, nil
+}
+
+// retryDelay is the delay before retrying the failed attempt, the backoff is
+// doubled for each attempt
+func (p hookPolicy) retryDelay(attempt int) time.Duration {
+	d := p.backoff
+
+	for i := 1; i < attempt && d < maxHookBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxHookBackoff {
+		d = maxHookBackoff
+	}
+
+	return d
+}
+
+// hookEvent is a transition of a hook, recorded as an event of the subscription
+type hookEvent struct {
+	reason  string
+	msg     string
+	warning bool
+}
+
+// failedHook is a hook that failed after all its retries
+type failedHook struct {
+	name          string
+	phase         subv1.HookPhase
+	attempts      int
+	failurePolicy string
+}
+
+func hookName(j unstructured.Unstructured) string {
+	return fmt.Sprintf("%s %s/%s", j.GetKind(), j.GetNamespace(), j.GetName())
+}
+
+// getHookInstance gets the applied hook instance from the hub, nil is returned
+// if it's not created by the k8s side yet
+func getHookInstance(clt client.Client, instance unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	job := &unstructured.Unstructured{}
+	job.SetGroupVersionKind(instance.GroupVersionKind())
+
+	key := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+	if err := clt.Get(context.TODO(), key, job); err != nil {
+		if kerr.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// resetRun starts a new run of the hook with the instance
+func (j *Job) resetRun(instance unstructured.Unstructured) {
+	j.runName = instance.GetName()
+	j.attempts = 1
+	j.nextRetry = time.Time{}
+	j.lastPhase = ""
+	j.failed = false
+}
+
+// lastHookStatus returns the status of the last instances of the prehooks or
+// the posthooks of the subscription
+func lastHookStatus(subIns *subv1.Subscription, hookType string) []subv1.HookStatus {
+	if hookType == "prehook" {
+		return subIns.Status.HooksStatus.LastPrehooks
+	}
+
+	return subIns.Status.HooksStatus.LastPosthooks
+}
+
+// restoreRun restores the attempts and the retry of the current run from the
+// status of the hook, so the retries aren't started over after a restart
+func (j *Job) restoreRun(last []subv1.HookStatus) {
+	first := j.Instance[len(j.Instance)-1]
+
+	for _, st := range last {
+		if st.Kind != first.GetKind() || st.Namespace != first.GetNamespace() {
+			continue
+		}
+
+		if st.Name != j.runName && !strings.HasPrefix(st.Name, j.runName+"-retry-") {
+			continue
+		}
+
+		if st.Name != j.runName {
+			retry := *first.DeepCopy()
+			retry.SetName(st.Name)
+
+			j.InstanceSet[types.NamespacedName{Name: retry.GetName(), Namespace: retry.GetNamespace()}] = struct{}{}
+			j.Instance = append(j.Instance, retry)
+		}
+
+		if st.Attempts > 0 {
+			j.attempts = st.Attempts
+		}
+
+		if st.Phase == subv1.HookFailed || st.Phase == subv1.HookTimedOut {
+			j.lastPhase = st.Phase
+			j.failed = j.attempts > j.policy.retries
+
+			if !j.failed && st.NextRetryTime != nil {
+				j.nextRetry = st.NextRetryTime.Time
+			}
+		}
+
+		return
+	}
+}
+
+// evaluate checks the last instance of the hook, the instance is timed out,
+// retried or failed as its policy declares. The hook is done if it succeeded,
+// or failed with the continue failure policy.
+func (j *Job) evaluate(clt client.Client, logger logr.Logger, now time.Time) (bool, []hookEvent, error) {
+	n := len(j.Instance)
+	if n == 0 {
+		return true, nil, nil
+	}
+
+	if j.failed {
+		return j.policy.failurePolicy == subv1.HookFailurePolicyContinue, nil, nil
+	}
+
+	last := j.Instance[n-1]
+	events := []hookEvent{}
+
+	if j.lastPhase != "" {
+		// the last attempt failed, wait for the retry
+		if now.Before(j.nextRetry) {
+			return false, events, nil
+		}
+
+		retry := *last.DeepCopy()
+		retry.SetName(fmt.Sprintf("%s-retry-%d", j.runName, j.attempts))
+
+		j.InstanceSet[types.NamespacedName{Name: retry.GetName(), Namespace: retry.GetNamespace()}] = struct{}{}
+		j.Instance = append(j.Instance, retry)
+		j.attempts++
+		j.lastPhase = ""
+		j.nextRetry = time.Time{}
+
+		logger.Info(fmt.Sprintf("registered %s, attempt %d", hookName(retry), j.attempts))
+
+		return false, events, nil
+	}
+
+	job, err := getHookInstance(clt, last)
+	if err != nil || job == nil {
+		return false, events, err
+	}
+
+	phase := hookPhase(job)
+
+	switch {
+	case phase == subv1.HookSucceeded:
+		return true, events, nil
+	case phase == subv1.HookFailed:
+		j.lastPhase = subv1.HookFailed
+		events = append(events, hookEvent{reason: hookFailedReason, msg: fmt.Sprintf("%s failed", hookName(last)), warning: true})
+	case j.policy.timeout > 0 && now.Sub(job.GetCreationTimestamp().Time) > j.policy.timeout:
+		j.lastPhase = subv1.HookTimedOut
+		events = append(events, hookEvent{reason: hookTimedOutReason,
+			msg: fmt.Sprintf("%s timed out after %s", hookName(last), j.policy.timeout), warning: true})
+
+		// stop the timed out instance, so it won't run along with the retry
+		if err := clt.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!kerr.IsNotFound(err) {
+			logger.Error(err, fmt.Sprintf("failed to delete the timed out %s", hookName(last)))
+		}
+	default:
+		return false, events, nil
+	}
+
+	if j.attempts <= j.policy.retries {
+		delay := j.policy.retryDelay(j.attempts)
+		j.nextRetry = now.Add(delay)
+
+		events = append(events, hookEvent{reason: hookRetryReason,
+			msg: fmt.Sprintf("retrying %s in %s, attempt %d of %d", hookName(last), delay, j.attempts+1, j.policy.retries+1)})
+
+		return false, events, nil
+	}
+
+	j.failed = true
+
+	events = append(events, hookEvent{reason: failedReason(j.lastPhase),
+		msg:     fmt.Sprintf("%s failed after %d attempt(s), failure policy: %s", hookName(last), j.attempts, j.policy.failurePolicy),
+		warning: true})
+
+	return j.policy.failurePolicy == subv1.HookFailurePolicyContinue, events, nil
+}
+
+func failedReason(phase subv1.HookPhase) string {
+	if phase == subv1.HookTimedOut {
+		return hookTimedOutReason
+	}
+
+	return hookFailedReason
+}
+
+// failedHooks returns the hooks that failed after all their retries
+func (jIns *JobInstances) failedHooks() []failedHook {
+	res := []failedHook{}
+
+	if jIns == nil {
+		return res
+	}
+
+	for _, j := range *jIns {
+		j.mux.Lock()
+
+		if j.failed && len(j.Instance) != 0 {
+			res = append(res, failedHook{
+				name:          hookName(j.Instance[len(j.Instance)-1]),
+				phase:         j.lastPhase,
+				attempts:      j.attempts,
+				failurePolicy: j.policy.failurePolicy,
+			})
+		}
+
+		j.mux.Unlock()
+	}
+
+	sort.Slice(res, func(i, k int) bool { return res[i].name < res[k].name })
+
+	return res
+}
+
+// setHooksFailedCondition sets the condition of the failed hooks of the
+// hooks, the condition is removed if there's no hook
+func setHooksFailedCondition(conditions *[]metav1.Condition, condType string, jIns *JobInstances) {
+	if jIns == nil || len(*jIns) == 0 {
+		meta.RemoveStatusCondition(conditions, condType)
+
+		return
+	}
+
+	failed := jIns.failedHooks()
+	if len(failed) == 0 {
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:   condType,
+			Status: metav1.ConditionFalse,
+			Reason: hookNotFailedReason,
+		})
+
+		return
+	}
+
+	reason := hookTimedOutReason
+	msgs := []string{}
+
+	for _, f := range failed {
+		if f.phase != subv1.HookTimedOut {
+			reason = hookFailedReason
+		}
+
+		verb := "failed"
+		if f.phase == subv1.HookTimedOut {
+			verb = "timed out"
+		}
+
+		msgs = append(msgs, fmt.Sprintf("%s %s after %d attempt(s), failure policy: %s",
+			f.name, verb, f.attempts, f.failurePolicy))
+	}
+
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:    condType,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: strings.Join(msgs, "; "),
+	})
+}
@@ -0,0 +1,286 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcmhub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestGetHookPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hook := newHook("batch/v1", "Job", "job-hook", nil)
+
+	p, err := getHookPolicy(&hook)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(p).To(gomega.Equal(hookPolicy{backoff: defaultHookBackoff, failurePolicy: subv1.HookFailurePolicyWait}))
+
+	hook.SetAnnotations(map[string]string{
+		subv1.AnnotationHookTimeout:       "10m",
+		subv1.AnnotationHookRetries:       "2",
+		subv1.AnnotationHookBackoff:       "5s",
+		subv1.AnnotationHookFailurePolicy: "Rollback",
+	})
+
+	p, err = getHookPolicy(&hook)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(p).To(gomega.Equal(hookPolicy{timeout: 10 * time.Minute, retries: 2, backoff: 5 * time.Second,
+		failurePolicy: subv1.HookFailurePolicyRollback}))
+
+	g.Expect(p.retryDelay(1)).To(gomega.Equal(5 * time.Second))
+	g.Expect(p.retryDelay(3)).To(gomega.Equal(20 * time.Second))
+	g.Expect(p.retryDelay(20)).To(gomega.Equal(maxHookBackoff))
+
+	for k, v := range map[string]string{
+		subv1.AnnotationHookTimeout:       "ten",
+		subv1.AnnotationHookRetries:       "-1",
+		subv1.AnnotationHookBackoff:       "1",
+		subv1.AnnotationHookFailurePolicy: "ignore",
+	} {
+		hook.SetAnnotations(map[string]string{k: v})
+
+		_, err = getHookPolicy(&hook)
+		g.Expect(err).To(gomega.HaveOccurred(), "%s: %s", k, v)
+	}
+}
+
+func TestHookTimeoutAndRetry(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+
+	applied := newHook("batch/v1", "Job", "job-hook-1-abcdef", nil)
+	applied.SetCreationTimestamp(metav1.NewTime(now.Add(-2 * time.Minute)))
+
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme, []runtime.Object{&applied}...)
+
+	job := &Job{
+		InstanceSet: map[types.NamespacedName]struct{}{},
+		Instance:    []unstructured.Unstructured{newHook("batch/v1", "Job", "job-hook-1-abcdef", nil)},
+		policy:      hookPolicy{timeout: time.Minute, retries: 1, backoff: 10 * time.Second, failurePolicy: subv1.HookFailurePolicyAbort},
+	}
+	job.resetRun(job.Instance[0])
+
+	// the first attempt is timed out and deleted
+	done, events, err := job.evaluate(clt, log.NullLogger{}, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(events).To(gomega.HaveLen(2))
+	g.Expect(events[0].reason).To(gomega.Equal(hookTimedOutReason))
+	g.Expect(events[1].reason).To(gomega.Equal(hookRetryReason))
+	g.Expect(job.lastPhase).To(gomega.Equal(subv1.HookTimedOut))
+
+	phase, err := getHookPhase(clt, applied)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(phase).To(gomega.Equal(subv1.HookPending))
+
+	// the retry waits for the backoff
+	done, _, err = job.evaluate(clt, log.NullLogger{}, now.Add(5*time.Second))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(job.Instance).To(gomega.HaveLen(1))
+
+	done, _, err = job.evaluate(clt, log.NullLogger{}, now.Add(11*time.Second))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(job.Instance).To(gomega.HaveLen(2))
+	g.Expect(job.Instance[1].GetName()).To(gomega.Equal("job-hook-1-abcdef-retry-1"))
+	g.Expect(job.attempts).To(gomega.Equal(2))
+
+	// the retry fails, the hook is failed after all its retries
+	retry := newHook("batch/v1", "Job", "job-hook-1-abcdef-retry-1", conditions("Failed", "True"))
+	g.Expect(clt.Create(context.TODO(), &retry)).To(gomega.Succeed())
+
+	done, events, err = job.evaluate(clt, log.NullLogger{}, now.Add(20*time.Second))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(events).To(gomega.HaveLen(2))
+	g.Expect(events[1].reason).To(gomega.Equal(hookFailedReason))
+	g.Expect(events[1].msg).To(gomega.ContainSubstring("failed after 2 attempt(s), failure policy: abort"))
+	g.Expect(job.failed).To(gomega.BeTrue())
+
+	jobs := JobInstances{types.NamespacedName{Name: "job-hook", Namespace: "default"}: job}
+
+	conds := []metav1.Condition{}
+	setHooksFailedCondition(&conds, subv1.HookConditionPrehooksFailed, &jobs)
+
+	cond := meta.FindStatusCondition(conds, subv1.HookConditionPrehooksFailed)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Status).To(gomega.Equal(metav1.ConditionTrue))
+	g.Expect(cond.Reason).To(gomega.Equal(hookFailedReason))
+
+	last, _ := jobs.outputHookStatus(clt, log.NullLogger{})
+	g.Expect(last).To(gomega.HaveLen(1))
+	g.Expect(last[0].Phase).To(gomega.Equal(subv1.HookFailed))
+	g.Expect(last[0].Attempts).To(gomega.Equal(2))
+
+	subKey := types.NamespacedName{Name: "sub", Namespace: "default"}
+	hooks := &AnsibleHooks{registry: map[types.NamespacedName]*Hooks{subKey: {preHooks: &jobs, postHooks: &JobInstances{}}}}
+	g.Expect(hooks.GetFailurePolicy(PreHookType, subKey)).To(gomega.Equal(subv1.HookFailurePolicyAbort))
+	g.Expect(hooks.GetFailurePolicy(PostHookType, subKey)).To(gomega.BeEmpty())
+
+	// the continue failure policy lets the subscription go on
+	job.policy.failurePolicy = subv1.HookFailurePolicyContinue
+
+	done, events, err = job.evaluate(clt, log.NullLogger{}, now.Add(30*time.Second))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeTrue())
+	g.Expect(events).To(gomega.BeEmpty())
+
+	// a new run resets the failure
+	job.resetRun(newHook("batch/v1", "Job", "job-hook-1-012345", nil))
+	setHooksFailedCondition(&conds, subv1.HookConditionPrehooksFailed, &jobs)
+
+	cond = meta.FindStatusCondition(conds, subv1.HookConditionPrehooksFailed)
+	g.Expect(cond.Status).To(gomega.Equal(metav1.ConditionFalse))
+
+	setHooksFailedCondition(&conds, subv1.HookConditionPrehooksFailed, &JobInstances{})
+	g.Expect(conds).To(gomega.BeEmpty())
+}
+
+func TestDefaultHookFailurePolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// a hook without policy annotations is not retried
+	hook := newHook("batch/v1", "Job", "job-hook", nil)
+
+	policy, err := getHookPolicy(&hook)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	applied := newHook("batch/v1", "Job", "job-hook-1-abcdef", conditions("Failed", "True"))
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme, []runtime.Object{&applied}...)
+
+	job := &Job{
+		InstanceSet: map[types.NamespacedName]struct{}{},
+		Instance:    []unstructured.Unstructured{newHook("batch/v1", "Job", "job-hook-1-abcdef", nil)},
+		policy:      policy,
+	}
+	job.resetRun(job.Instance[0])
+
+	done, events, err := job.evaluate(clt, log.NullLogger{}, time.Now())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(events).To(gomega.HaveLen(2))
+	g.Expect(events[1].msg).To(gomega.ContainSubstring("failed after 1 attempt(s), failure policy: wait"))
+	g.Expect(job.failed).To(gomega.BeTrue())
+
+	// the subscription keeps waiting for the failed prehook, like before the failure policies, neither the abort nor
+	// the rollback of the reconcile apply
+	jobs := JobInstances{types.NamespacedName{Name: "job-hook", Namespace: "default"}: job}
+	subKey := types.NamespacedName{Name: "sub", Namespace: "default"}
+	hooks := &AnsibleHooks{clt: clt, logger: log.NullLogger{},
+		registry: map[types.NamespacedName]*Hooks{subKey: {preHooks: &jobs, postHooks: &JobInstances{}}}}
+
+	completed, err := hooks.IsPreHooksCompleted(subKey)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(completed).To(gomega.BeFalse())
+	g.Expect(hooks.GetFailurePolicy(PreHookType, subKey)).To(gomega.Equal(subv1.HookFailurePolicyWait))
+}
+
+func TestRestoreHookRun(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+
+	applied := newHook("batch/v1", "Job", "job-hook-1-abcdef", nil)
+	applied.SetCreationTimestamp(metav1.NewTime(now.Add(-2 * time.Minute)))
+
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme, []runtime.Object{&applied}...)
+	policy := hookPolicy{timeout: time.Minute, retries: 2, backoff: 10 * time.Second, failurePolicy: subv1.HookFailurePolicyAbort}
+
+	newJob := func() *Job {
+		job := &Job{
+			InstanceSet: map[types.NamespacedName]struct{}{},
+			Instance:    []unstructured.Unstructured{newHook("batch/v1", "Job", "job-hook-1-abcdef", nil)},
+			policy:      policy,
+		}
+		job.resetRun(job.Instance[0])
+
+		return job
+	}
+
+	job := newJob()
+
+	// the timed out attempt reports when it's retried
+	_, _, err := job.evaluate(clt, log.NullLogger{}, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	jobs := JobInstances{types.NamespacedName{Name: "job-hook", Namespace: "default"}: job}
+
+	last, _ := jobs.outputHookStatus(clt, log.NullLogger{})
+	g.Expect(last).To(gomega.HaveLen(1))
+	g.Expect(last[0].Phase).To(gomega.Equal(subv1.HookTimedOut))
+	g.Expect(last[0].NextRetryTime).NotTo(gomega.BeNil())
+	g.Expect(last[0].NextRetryTime.Time).To(gomega.Equal(now.Add(10 * time.Second)))
+
+	// the restarted controller waits for the retry instead of starting over
+	restored := newJob()
+	restored.restoreRun(last)
+	g.Expect(restored.attempts).To(gomega.Equal(1))
+	g.Expect(restored.lastPhase).To(gomega.Equal(subv1.HookTimedOut))
+	g.Expect(restored.nextRetry).To(gomega.Equal(now.Add(10 * time.Second)))
+	g.Expect(restored.failed).To(gomega.BeFalse())
+
+	done, _, err := restored.evaluate(clt, log.NullLogger{}, now.Add(11*time.Second))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+	g.Expect(restored.Instance).To(gomega.HaveLen(2))
+	g.Expect(restored.attempts).To(gomega.Equal(2))
+
+	// the last retry failed after all the attempts
+	failed := []subv1.HookStatus{{APIVersion: "batch/v1", Kind: "Job", Namespace: applied.GetNamespace(),
+		Name: "job-hook-1-abcdef-retry-2", Phase: subv1.HookFailed, Attempts: 3}}
+
+	restored = newJob()
+	restored.restoreRun(failed)
+	g.Expect(restored.Instance).To(gomega.HaveLen(2))
+	g.Expect(restored.Instance[1].GetName()).To(gomega.Equal("job-hook-1-abcdef-retry-2"))
+	g.Expect(restored.failed).To(gomega.BeTrue())
+
+	done, _, err = restored.evaluate(clt, log.NullLogger{}, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(done).To(gomega.BeFalse())
+
+	// the status of another run isn't restored
+	restored = newJob()
+	restored.restoreRun([]subv1.HookStatus{{Kind: "Job", Namespace: applied.GetNamespace(), Name: "job-hook-1-012345",
+		Phase: subv1.HookFailed, Attempts: 3}})
+	g.Expect(restored.attempts).To(gomega.Equal(1))
+	g.Expect(restored.lastPhase).To(gomega.BeEmpty())
+}
+
+func TestIsAllHooksSucceeded(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	succeeded := subv1.HookStatus{Kind: "Job", Phase: subv1.HookSucceeded}
+	running := subv1.HookStatus{Kind: "Job", Phase: subv1.HookRunning}
+
+	g.Expect(isAllHooksSucceeded(nil, nil)).To(gomega.BeFalse())
+	g.Expect(isAllHooksSucceeded([]subv1.HookStatus{succeeded}, nil)).To(gomega.BeTrue())
+	g.Expect(isAllHooksSucceeded([]subv1.HookStatus{succeeded}, []subv1.HookStatus{running})).To(gomega.BeFalse())
+}
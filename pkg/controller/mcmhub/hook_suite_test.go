@@ -76,7 +76,7 @@ var _ = BeforeSuite(func(done Done) {
 	k8sManager, err = mgr.New(cfg, mgr.Options{MetricsBindAddress: "0"})
 	Expect(err).ToNot(HaveOccurred())
 
-	cFunc := func(repo, branch, user, pwd string) (string, error) {
+	cFunc := func(repo string, cloneOptions *utils.GitCloneOption) (string, error) {
 		return defaultCommit, nil
 	}
 
@@ -120,6 +120,14 @@ func (r *ReconcileSubscription) doMCMHubReconcile(sub *appv1alpha1.Subscription)
 
 	if targetDpl != nil {
 		dplAnno := setTargetDplAnnotation(sub, dpl, targetDpl)
+
+		if hasFailedHelmTest(sub) {
+			klog.Infof("Holding the rolling update of subscription %v/%v to %v, a managed cluster reports a failed helm test",
+				sub.GetNamespace(), sub.GetName(), targetDpl.GetName())
+
+			dplAnno[appv1alpha1.AnnotationRollingUpdateMaxUnavailable] = rollingUpdateHold
+		}
+
 		dpl.SetAnnotations(dplAnno)
 	}
 
@@ -144,6 +152,24 @@ func (r *ReconcileSubscription) doMCMHubReconcile(sub *appv1alpha1.Subscription)
 
 	updateSubDpl := checkSubDeployables(found, dpl)
 
+	// Holding or resuming an ongoing rolling update only changes the max unavailable clusters, keep the rollout progress
+	if !updateSub && !updateSubDpl && updateTargetAnno && isRollingUpdateInProgress(found, targetDpl) {
+		found.SetAnnotations(setFoundDplAnnotation(found, dpl, targetDpl, true))
+
+		err = r.Update(context.TODO(), found)
+
+		addtionalMsg := "Rolling update of depolyable " + dplkey.String() + " to " + targetDpl.GetName() +
+			" continues with max unavailable " + dpl.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateMaxUnavailable]
+		if dpl.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateMaxUnavailable] == rollingUpdateHold {
+			addtionalMsg = "Rolling update of depolyable " + dplkey.String() + " to " + targetDpl.GetName() +
+				" is held because a managed cluster reports a failed helm test"
+		}
+
+		r.eventRecorder.RecordEvent(sub, "RollingUpdate", addtionalMsg, err)
+
+		return err
+	}
+
 	if updateSub || updateSubDpl || updateTargetAnno {
 		klog.V(1).Infof("updateSub: %v, updateSubDpl: %v, updateTargetAnno: %v", updateSub, updateSubDpl, updateTargetAnno)
 
@@ -396,6 +422,11 @@ func setTargetDplAnnotation(sub *appv1alpha1.Subscription, dpl, targetDpl *dplv1
 	return dplAnno
 }
 
+// isRollingUpdateInProgress returns true if the subscription deployable is already rolling to the target deployable
+func isRollingUpdateInProgress(found, targetDpl *dplv1alpha1.Deployable) bool {
+	return targetDpl != nil && found.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateTarget] == targetDpl.GetName()
+}
+
 // checkRollingUpdateAnno check if there needs to update rolling update target annotation to the subscription deployable
 func checkRollingUpdateAnno(found, targetDpl, subDpl *dplv1alpha1.Deployable) bool {
 	foundanno := found.GetAnnotations()
@@ -770,6 +801,10 @@ func (r *ReconcileSubscription) updateSubAnnotations(sub *appv1alpha1.Subscripti
 		subepanno[appv1alpha1.AnnotationGitPath] = origsubanno[appv1alpha1.AnnotationGithubPath]
 	}
 
+	if !strings.EqualFold(origsubanno[appv1alpha1.AnnotationGitPaths], "") {
+		subepanno[appv1alpha1.AnnotationGitPaths] = origsubanno[appv1alpha1.AnnotationGitPaths]
+	}
+
 	if !strings.EqualFold(origsubanno[appv1alpha1.AnnotationBucketPath], "") {
 		subepanno[appv1alpha1.AnnotationBucketPath] = origsubanno[appv1alpha1.AnnotationBucketPath]
 	}
@@ -837,6 +872,7 @@ func (r *ReconcileSubscription) updateSubscriptionStatus(sub *appv1alpha1.Subscr
 	newsubstatus := appv1alpha1.SubscriptionStatus{}
 
 	newsubstatus.AnsibleJobsStatus = *sub.Status.AnsibleJobsStatus.DeepCopy()
+	newsubstatus.HooksStatus = *sub.Status.HooksStatus.DeepCopy()
 
 	newsubstatus.Phase = appv1alpha1.SubscriptionPropagated
 	newsubstatus.Message = ""
@@ -921,13 +957,57 @@ func getStatusPerPackage(pkgStatus *appv1alpha1.SubscriptionUnitStatus, chn *chn
 			subUnitStatus.Message = pkgStatus.Message
 			subUnitStatus.Reason = pkgStatus.Reason
 		}
+
+		subUnitStatus.ChartVersion = pkgStatus.ChartVersion
+
+		// A refused chart fails the package even if a previously deployed release is healthy
+		if pkgStatus.ChartVerification != nil {
+			subUnitStatus.ChartVerification = pkgStatus.ChartVerification.DeepCopy()
+
+			if pkgStatus.ChartVerification.Refused {
+				subUnitStatus.Phase = appv1alpha1.SubscriptionFailed
+				subUnitStatus.Reason = "chart " + pkgStatus.ChartVerification.Chart + " is refused by the chart verification"
+				subUnitStatus.Message = pkgStatus.ChartVerification.Message
+			}
+		}
 	default:
 		subUnitStatus = pkgStatus
 	}
 
+	// A failed helm test fails the package, which blocks the posthooks and holds the rolling update
+	if pkgStatus.HelmTest != nil {
+		subUnitStatus.HelmTest = pkgStatus.HelmTest.DeepCopy()
+
+		if pkgStatus.HelmTest.Result == appv1alpha1.HelmTestFailed {
+			subUnitStatus.Phase = appv1alpha1.SubscriptionFailed
+			subUnitStatus.Reason = fmt.Sprintf("helm test of revision %d failed", pkgStatus.HelmTest.Revision)
+			subUnitStatus.Message = pkgStatus.HelmTest.Message
+		}
+	}
+
 	return subUnitStatus
 }
 
+// rollingUpdateHold is the max unavailable clusters of a held rolling update, no more cluster is updated
+const rollingUpdateHold = "0"
+
+// hasFailedHelmTest returns true if a managed cluster reports a failed helm test of the subscription
+func hasFailedHelmTest(sub *appv1alpha1.Subscription) bool {
+	for _, cst := range sub.Status.Statuses {
+		if cst == nil {
+			continue
+		}
+
+		for _, pst := range cst.SubscriptionPackageStatus {
+			if pst != nil && pst.HelmTest != nil && pst.HelmTest.Result == appv1alpha1.HelmTestFailed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func setHelmSubUnitStatus(pkgResourceStatus *runtime.RawExtension, subUnitStatus *appv1alpha1.SubscriptionUnitStatus) {
 	if pkgResourceStatus == nil || subUnitStatus == nil {
 		klog.Errorf("failed to setHelmSubUnitStatus due to pkgResourceStatus %v or subUnitStatus %v is nil", pkgResourceStatus, subUnitStatus)
@@ -1216,11 +1296,7 @@ func (r *ReconcileSubscription) updateTargetSubscriptionDeployable(sub *appv1alp
 	return nil
 }
 
-func (r *ReconcileSubscription) initObjectStore(channel *chnv1alpha1.Channel) (*awsutils.Handler, string, error) {
-	var err error
-
-	awshandler := &awsutils.Handler{}
-
+func (r *ReconcileSubscription) initObjectStore(channel *chnv1alpha1.Channel) (awsutils.ObjectStore, string, error) {
 	pathName := channel.Spec.Pathname
 
 	if pathName == "" {
@@ -1230,14 +1306,19 @@ func (r *ReconcileSubscription) initObjectStore(channel *chnv1alpha1.Channel) (*
 		return nil, "", errors.New(errmsg)
 	}
 
-	if strings.HasSuffix(pathName, "/") {
-		last := len(pathName) - 1
-		pathName = pathName[:last]
+	endpoint, bucket, err := awsutils.ParseObjectBucketPath(pathName)
+	if err != nil {
+		klog.Error(err, " in channel ", channel.Name)
+
+		return nil, "", err
 	}
 
-	loc := strings.LastIndex(pathName, "/")
-	endpoint := pathName[:loc]
-	bucket := pathName[loc+1:]
+	objectStore, err := awsutils.NewObjectStore(endpoint)
+	if err != nil {
+		klog.Error(err, " in channel ", channel.Name)
+
+		return nil, "", err
+	}
 
 	accessKeyID := ""
 	secretAccessKey := ""
@@ -1278,23 +1359,31 @@ func (r *ReconcileSubscription) initObjectStore(channel *chnv1alpha1.Channel) (*
 				return nil, "", err
 			}
 		}
+
+		if configurable, ok := objectStore.(awsutils.ConfigurableObjectStore); ok {
+			if err := configurable.SetOptions(channelSecret.Data); err != nil {
+				klog.Error(err, " in the secret of channel ", channel.Name)
+
+				return nil, "", err
+			}
+		}
 	}
 
 	klog.V(1).Info("Trying to connect to object bucket ", endpoint, "|", bucket)
 
-	if err := awshandler.InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region); err != nil {
+	if err := objectStore.InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region); err != nil {
 		klog.Error(err, "unable initialize object store settings")
 
 		return nil, "", err
 	}
 	// Check whether the connection is setup successfully
-	if err := awshandler.Exists(bucket); err != nil {
+	if err := objectStore.Exists(bucket); err != nil {
 		klog.Error(err, "Unable to access object store bucket ", bucket, " for channel ", channel.Name)
 
 		return nil, "", err
 	}
 
-	return awshandler, bucket, nil
+	return objectStore, bucket, nil
 }
 
 func (r *ReconcileSubscription) updateObjectBucketAnnotation(
@@ -1338,24 +1427,39 @@ func (r *ReconcileSubscription) updateObjectBucketAnnotation(
 			continue
 		}
 
-		dpl := &dplv1.Deployable{}
-		dpl.Name = generateDplNameFromKey(key)
-		dpl.Namespace = bucket
-		dpl.Spec.Template = &runtime.RawExtension{}
-		dpl.GenerateName = tplb.GenerateName
-		verionAnno := map[string]string{dplv1.AnnotationDeployableVersion: tplb.Version}
-		dpl.SetAnnotations(verionAnno)
-		err = yaml.Unmarshal(tplb.Content, dpl.Spec.Template)
+		// Objects can hold several resources, Helm chart and kustomize archives are not in the topology
+		resources := utils.ParseKubeResoures(tplb.Content)
 
-		if err != nil {
-			klog.Error("Failed to unmashall ", bucket, "/", key, " err:", err)
-			continue
-		}
+		for _, resource := range resources {
+			dpl := &dplv1.Deployable{}
+			dpl.Name = generateDplNameFromKey(key)
+			dpl.Namespace = bucket
+			dpl.Spec.Template = &runtime.RawExtension{}
+			dpl.GenerateName = tplb.GenerateName
+			verionAnno := map[string]string{dplv1.AnnotationDeployableVersion: tplb.Version}
+			dpl.SetAnnotations(verionAnno)
+			err = yaml.Unmarshal(resource, dpl.Spec.Template)
 
-		klog.V(5).Infof("Retived Dpl: %v", dpl)
+			if err != nil {
+				klog.Error("Failed to unmashall ", bucket, "/", key, " err:", err)
+				continue
+			}
 
-		dplkey := types.NamespacedName{Name: dpl.Name, Namespace: dpl.Namespace}.String()
-		allDpls[dplkey] = dpl
+			if len(resources) > 1 {
+				rsc := &unstructured.Unstructured{}
+				if err := rsc.UnmarshalJSON(dpl.Spec.Template.Raw); err != nil {
+					klog.Error("Failed to unmashall ", bucket, "/", key, " err:", err)
+					continue
+				}
+
+				dpl.Name += "-" + rsc.GetKind() + "-" + rsc.GetName()
+			}
+
+			klog.V(5).Infof("Retived Dpl: %v", dpl)
+
+			dplkey := types.NamespacedName{Name: dpl.Name, Namespace: dpl.Namespace}.String()
+			allDpls[dplkey] = dpl
+		}
 	}
 
 	topoFlag := extracResourceListFromDeployables(sub, allDpls, parentType)
@@ -29,10 +29,10 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/google/go-github/v32/github"
 	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
-	ansiblejob "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/ansible/v1alpha1"
 	subv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -50,9 +50,10 @@ type GitOps interface {
 
 	HasHookFolders(*subv1.Subscription) bool
 
-	// GetHooks returns the ansiblejob from a given folder, if the folder is
-	// inaccessible, then os.Error is returned
-	GetHooks(*subv1.Subscription, string) ([]ansiblejob.AnsibleJob, error)
+	// GetHooks returns the hooks(ansiblejob, job, tekton pipelinerun or
+	// taskrun) from a given folder, if the folder is inaccessible, then
+	// os.Error is returned
+	GetHooks(*subv1.Subscription, string) ([]unstructured.Unstructured, error)
 
 	// RegisterBranch to git watcher and do a initial download for other
 	// components to consume
@@ -67,6 +68,9 @@ type GitOps interface {
 
 	//GetLatestCommitID will output the latest commit id from local git record
 	GetLatestCommitID(*subv1.Subscription) (string, error)
+	//GetPreviousCommitID will output the commit id before the latest one from
+	//local git record, it's empty till the git watcher finds a new commit
+	GetPreviousCommitID(*subv1.Subscription) (string, error)
 	//ResolveLocalGitFolder is used to open a local folder for downloading the
 	//repo branch
 	ResolveLocalGitFolder(*chnv1.Channel, *subv1.Subscription) string
@@ -78,9 +82,10 @@ type GitOps interface {
 }
 
 type branchInfo struct {
-	gitCloneOptions utils.GitCloneOption
-	lastCommitID    string
-	registeredSub   map[types.NamespacedName]struct{}
+	gitCloneOptions  utils.GitCloneOption
+	lastCommitID     string
+	previousCommitID string
+	registeredSub    map[types.NamespacedName]struct{}
 }
 
 type RepoRegistery struct {
@@ -88,7 +93,7 @@ type RepoRegistery struct {
 	branchs map[string]*branchInfo
 }
 
-type GetCommitFunc func(url string, branchName string, user string, secret string) (string, error)
+type GetCommitFunc func(url string, cloneOptions *utils.GitCloneOption) (string, error)
 
 type cloneFunc func(cloneOptions *utils.GitCloneOption) (string, error)
 
@@ -186,7 +191,14 @@ func (h *HubGitOps) GitWatch() {
 			// If tag is provided, resolve tag to commit SHA and compare it to the currently deployed commit
 			// Otherwise, compare the latest commit of the repo branch to the currently deployed commit
 			h.logger.Info(fmt.Sprintf("Checking commit for Git: %s Branch: %s", url, branchInfoName))
-			newCommit, err := h.getCommitFunc(url, branchInfo.gitCloneOptions.Branch.Short(), branchInfo.gitCloneOptions.User, branchInfo.gitCloneOptions.Password)
+
+			// short-lived access tokens are refreshed before they expire
+			if err := branchInfo.gitCloneOptions.RefreshToken(); err != nil {
+				h.logger.Error(err, "failed to refresh the Git access token")
+				continue
+			}
+
+			newCommit, err := h.getCommitFunc(url, &branchInfo.gitCloneOptions)
 
 			cloneDone := false
 
@@ -220,6 +232,7 @@ func (h *HubGitOps) GitWatch() {
 				continue
 			}
 
+			h.repoRecords[repoName].branchs[branchInfoName].previousCommitID = branchInfo.lastCommitID
 			h.repoRecords[repoName].branchs[branchInfoName].lastCommitID = newCommit
 			h.logger.Info("The repo has new commit: " + newCommit)
 
@@ -366,13 +379,32 @@ func (h *HubGitOps) RegisterBranch(subIns *subv1.Subscription) error {
 		return nil
 	}
 
-	user, pwd, sshKey, passphrase, err := utils.GetChannelSecret(h.clt, channel)
+	// the channel secret is read once for the credentials, the token source, the client certificate and the known hosts
+	secret, err := utils.GetChannelSecretObject(h.clt, channel)
 
 	if err != nil {
 		h.logger.Error(err, "failed to register subscription to git watcher register")
 		return err
 	}
 
+	user, pwd, sshKey, passphrase := "", "", []byte(""), []byte("")
+
+	if secret != nil {
+		user, pwd, sshKey, passphrase, err = utils.ParseChannelSecret(secret)
+
+		if err != nil {
+			h.logger.Error(err, "failed to register subscription to git watcher register")
+			return err
+		}
+	}
+
+	clientCert, clientKey, err := utils.GetClientCertificateData(secret)
+
+	if err != nil {
+		h.logger.Error(err, "failed to get the TLS client certificate of the channel secret")
+		return err
+	}
+
 	channelConfig := utils.GetChannelConfigMap(h.clt, channel)
 	caCert := ""
 
@@ -383,6 +415,8 @@ func (h *HubGitOps) RegisterBranch(subIns *subv1.Subscription) error {
 		}
 	}
 
+	knownHosts, hostKeyPolicy := utils.GetKnownHosts(secret, channelConfig)
+
 	skipCertVerify := false
 
 	if channel.Spec.InsecureSkipVerify {
@@ -391,6 +425,14 @@ func (h *HubGitOps) RegisterBranch(subIns *subv1.Subscription) error {
 		h.logger.Info("Channel spec has insecureSkipVerify: true.")
 	}
 
+	// the token endpoint is trusted like the Git server of the channel
+	tokenSource, err := utils.GetGitTokenSource(secret, caCert, skipCertVerify)
+
+	if err != nil {
+		h.logger.Error(err, "failed to get the Git token source of the channel secret")
+		return err
+	}
+
 	repoURL := channel.Spec.Pathname
 	// repoName is the key of a map that stores repository information for subscription.
 	// It needs to be unique for each subscription because each subscription need to
@@ -432,6 +474,12 @@ func (h *HubGitOps) RegisterBranch(subIns *subv1.Subscription) error {
 		RepoURL:            repoURL,
 		CloneDepth:         depthInt,
 		CaCerts:            caCert,
+		KnownHosts:         knownHosts,
+		HostKeyPolicy:      hostKeyPolicy,
+		HostKeyStore:       utils.NewChannelHostKeyStore(h.clt, channel),
+		TokenSource:        tokenSource,
+		ClientCert:         clientCert,
+		ClientKey:          clientKey,
 	}
 
 	commitID, err := h.cloneFunc(cloneOptions)
@@ -537,13 +585,21 @@ func (h *HubGitOps) DeregisterBranch(subKey types.NamespacedName) {
 	}
 }
 
-func GetLatestRemoteGitCommitID(repo, branch, user, pwd string) (string, error) {
+// GetLatestRemoteGitCommitID gets the latest commit of the branch with the
+// credentials and the TLS settings of the channel
+func GetLatestRemoteGitCommitID(repo string, cloneOptions *utils.GitCloneOption) (string, error) {
+	rt, err := utils.NewGitTransport(cloneOptions)
+	if err != nil {
+		return "", err
+	}
+
 	tp := github.BasicAuthTransport{
-		Username: strings.TrimSpace(user),
-		Password: strings.TrimSpace(pwd),
+		Username:  strings.TrimSpace(cloneOptions.User),
+		Password:  strings.TrimSpace(cloneOptions.Password),
+		Transport: rt,
 	}
 
-	return utils.GetLatestCommitID(repo, branch, github.NewClient(tp.Client()))
+	return utils.GetLatestCommitID(repo, cloneOptions.Branch.Short(), github.NewClient(tp.Client()))
 }
 
 func (h *HubGitOps) GetLatestCommitID(subIns *subv1.Subscription) (string, error) {
@@ -559,9 +615,33 @@ func (h *HubGitOps) GetLatestCommitID(subIns *subv1.Subscription) (string, error
 		return "", fmt.Errorf("failed to register the branch")
 	}
 
-	repoName := h.subRecords[subKey]
+	repo := h.repoRecords[h.subRecords[subKey]]
+	if repo == nil {
+		return "", fmt.Errorf("failed to find the repo of subscription %s", subKey)
+	}
+
+	branch := repo.branchs[genBranchString(subIns)]
+	if branch == nil {
+		return "", fmt.Errorf("failed to find the branch %s of subscription %s", genBranchString(subIns), subKey)
+	}
+
+	return branch.lastCommitID, nil
+}
 
-	return h.repoRecords[repoName].branchs[genBranchString(subIns)].lastCommitID, nil
+func (h *HubGitOps) GetPreviousCommitID(subIns *subv1.Subscription) (string, error) {
+	subKey := types.NamespacedName{Name: subIns.GetName(), Namespace: subIns.GetNamespace()}
+
+	repoName, ok := h.subRecords[subKey]
+	if !ok {
+		return "", fmt.Errorf("subscription %s is not registered to the git watcher", subKey)
+	}
+
+	branch := h.repoRecords[repoName].branchs[genBranchString(subIns)]
+	if branch == nil {
+		return "", fmt.Errorf("failed to find the branch of subscription %s", subKey)
+	}
+
+	return branch.previousCommitID, nil
 }
 
 func (h *HubGitOps) GetRepoRootDirctory(subIns *subv1.Subscription) string {
@@ -636,63 +716,59 @@ func sortClonedGitRepoGievnDestPath(repoRoot string, destPath string, logger log
 	return kubeRes, nil
 }
 
-func parseAnsibleJobResoures(file []byte) [][]byte {
+// parseHookResoures keeps the resources of the kinds with a registered
+// HookEvaluator
+func parseHookResoures(file []byte) [][]byte {
 	cond := func(t utils.KubeResource) bool {
-		return t.APIVersion == "" || t.Kind == "" || !strings.EqualFold(t.Kind, AnsibleJobKind)
+		return t.APIVersion == "" || t.Kind == "" || !isHookKind(t.APIVersion, t.Kind)
 	}
 
 	return utils.KubeResourceParser(file, cond)
 }
 
-func parseFromKutomizedAsAnsibleJobs(kustomizes [][]byte, parser func([]byte) [][]byte, logger logr.Logger) ([]ansiblejob.AnsibleJob, error) {
-	jobs := []ansiblejob.AnsibleJob{}
-	// sync kube resource deployables
-	for _, kus := range kustomizes {
-		resources := parser(kus)
-
-		if len(resources) > 0 {
-			for _, resource := range resources {
-				job := &ansiblejob.AnsibleJob{}
-				err := yaml.Unmarshal(resource, job)
+func parseHooks(resources [][]byte, logger logr.Logger) []unstructured.Unstructured {
+	jobs := []unstructured.Unstructured{}
 
-				if err != nil {
-					logger.Error(err, "failed to parse a resource")
-					continue
-				}
+	for _, resource := range resources {
+		jsonRes, err := yaml.YAMLToJSON(resource)
+		if err != nil {
+			logger.Error(err, "failed to parse a resource")
+			continue
+		}
 
-				jobs = append(jobs, *job)
-			}
+		job := unstructured.Unstructured{}
+		if err := job.UnmarshalJSON(jsonRes); err != nil {
+			logger.Error(err, "failed to parse a resource")
+			continue
 		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+func parseFromKutomizedAsHooks(kustomizes [][]byte, parser func([]byte) [][]byte, logger logr.Logger) ([]unstructured.Unstructured, error) {
+	jobs := []unstructured.Unstructured{}
+	// sync kube resource deployables
+	for _, kus := range kustomizes {
+		jobs = append(jobs, parseHooks(parser(kus), logger)...)
 	}
 
 	return jobs, nil
 }
 
-func parseAsAnsibleJobs(rscFiles []string, parser func([]byte) [][]byte, logger logr.Logger) ([]ansiblejob.AnsibleJob, error) {
-	jobs := []ansiblejob.AnsibleJob{}
+func parseAsHooks(rscFiles []string, parser func([]byte) [][]byte, logger logr.Logger) ([]unstructured.Unstructured, error) {
+	jobs := []unstructured.Unstructured{}
 	// sync kube resource deployables
 	for _, rscFile := range rscFiles {
 		file, err := ioutil.ReadFile(rscFile) // #nosec G304 rscFile is not user input
 
 		if err != nil {
-			return []ansiblejob.AnsibleJob{}, err
+			return []unstructured.Unstructured{}, err
 		}
 
-		resources := parser(file)
-
-		if len(resources) > 0 {
-			for _, resource := range resources {
-				job := &ansiblejob.AnsibleJob{}
-				err := yaml.Unmarshal(resource, job)
-
-				if err != nil {
-					logger.Error(err, "failed to parse a resource")
-					continue
-				}
-
-				jobs = append(jobs, *job)
-			}
-		}
+		jobs = append(jobs, parseHooks(parser(file), logger)...)
 	}
 
 	return jobs, nil
@@ -716,28 +792,28 @@ func (h *HubGitOps) HasHookFolders(subIns *subv1.Subscription) bool {
 	return preErr == nil || postErr == nil
 }
 
-//GetHooks will provided the ansibleJobs at the given hookPath(if given a
-//posthook path, then posthook ansiblejob is returned)
-func (h *HubGitOps) GetHooks(subIns *subv1.Subscription, hookPath string) ([]ansiblejob.AnsibleJob, error) {
+//GetHooks will provided the hooks at the given hookPath(if given a
+//posthook path, then posthook hooks are returned)
+func (h *HubGitOps) GetHooks(subIns *subv1.Subscription, hookPath string) ([]unstructured.Unstructured, error) {
 	fullPath := fmt.Sprintf("%v/%v", h.GetRepoRootDirctory(subIns), hookPath)
 	if _, err := os.Stat(fullPath); err != nil {
 		if os.IsNotExist(err) {
-			return []ansiblejob.AnsibleJob{}, nil
+			return []unstructured.Unstructured{}, nil
 		}
 
 		h.logger.Error(err, "fail to access the hook path")
 
-		return []ansiblejob.AnsibleJob{}, err
+		return []unstructured.Unstructured{}, err
 	}
 
 	sortedRes, err := sortClonedGitRepoGievnDestPath(h.GetRepoRootDirctory(subIns), hookPath, h.logger)
 	if err != nil {
-		return []ansiblejob.AnsibleJob{}, err
+		return []unstructured.Unstructured{}, err
 	}
 
 	if len(sortedRes.kustomized) != 0 {
-		return parseFromKutomizedAsAnsibleJobs(sortedRes.kustomized, parseAnsibleJobResoures, h.logger)
+		return parseFromKutomizedAsHooks(sortedRes.kustomized, parseHookResoures, h.logger)
 	}
 
-	return parseAsAnsibleJobs(sortedRes.kubRes, parseAnsibleJobResoures, h.logger)
+	return parseAsHooks(sortedRes.kubRes, parseHookResoures, h.logger)
 }
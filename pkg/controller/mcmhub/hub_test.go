@@ -21,6 +21,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
@@ -292,3 +293,37 @@ func TestUpdateSubscriptionStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestHelmTestStatusPerPackage(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	chn := &chnv1.Channel{Spec: chnv1.ChannelSpec{Type: "HelmRepo"}}
+
+	pkgStatus := &appv1.SubscriptionUnitStatus{
+		Phase:          appv1.SubscriptionSubscribed,
+		ResourceStatus: &runtime.RawExtension{Raw: []byte(`{"conditions":[{"type":"Deployed","status":"True","reason":"UpgradeSuccessful"}]}`)},
+		HelmTest:       &appv1.HelmTestStatus{Revision: 3, Result: appv1.HelmTestPassed},
+	}
+
+	unitStatus := getStatusPerPackage(pkgStatus, chn)
+	g.Expect(string(unitStatus.Phase)).To(gomega.Equal("Subscribed"))
+	g.Expect(unitStatus.HelmTest.Result).To(gomega.Equal(appv1.HelmTestPassed))
+
+	sub := &appv1.Subscription{Status: appv1.SubscriptionStatus{Statuses: appv1.SubscriptionClusterStatusMap{
+		"cluster1": &appv1.SubscriptionPerClusterStatus{
+			SubscriptionPackageStatus: map[string]*appv1.SubscriptionUnitStatus{"pkg": unitStatus},
+		},
+	}}}
+	g.Expect(hasFailedHelmTest(sub)).To(gomega.BeFalse())
+
+	// A failed helm test fails the package of a deployed release
+	pkgStatus.HelmTest = &appv1.HelmTestStatus{Revision: 4, Result: appv1.HelmTestFailed, Message: "pod nginx-test failed"}
+
+	unitStatus = getStatusPerPackage(pkgStatus, chn)
+	g.Expect(string(unitStatus.Phase)).To(gomega.Equal("Failed"))
+	g.Expect(unitStatus.Reason).To(gomega.Equal("helm test of revision 4 failed"))
+	g.Expect(unitStatus.Message).To(gomega.Equal("pod nginx-test failed"))
+
+	sub.Status.Statuses["cluster1"].SubscriptionPackageStatus["pkg"] = unitStatus
+	g.Expect(hasFailedHelmTest(sub)).To(gomega.BeTrue())
+}
@@ -16,6 +16,7 @@ package mcmhub
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -105,6 +106,8 @@ func newReconciler(mgr manager.Manager, op ...Option) reconcile.Reconciler {
 	logger := klogr.New().WithName(reconcileName)
 
 	gitOps := NewHookGit(mgr.GetClient(), setHubGitOpsLogger(logger))
+	hooks := NewAnsibleHooks(mgr.GetClient(), defaultHookRequeueInterval, setLogger(logger), setGitOps(gitOps),
+		setEventRecorder(erecorder))
 
 	rec := &ReconcileSubscription{
 		name:   reconcileName,
@@ -115,7 +118,7 @@ func newReconciler(mgr manager.Manager, op ...Option) reconcile.Reconciler {
 		eventRecorder:       erecorder,
 		logger:              logger,
 		hookRequeueInterval: defaultHookRequeueInterval,
-		hooks:               NewAnsibleHooks(mgr.GetClient(), defaultHookRequeueInterval, setLogger(logger), setGitOps(gitOps)),
+		hooks:               hooks,
 		hubGitOps:           gitOps,
 	}
 
@@ -547,6 +550,12 @@ func (r *ReconcileSubscription) Reconcile(request reconcile.Request) (result rec
 			return reconcile.Result{}, nil
 		}
 
+		// the subscription follows its branch again once the branch moves past
+		// the commit of the failed hooks
+		if r.releaseHookRollback(instance) {
+			return reconcile.Result{}, nil
+		}
+
 		// register will skip the failed clone repo
 		if err := r.hooks.RegisterSubscription(instance, placementDecisionUpdated, placementRuleRv); err != nil {
 			logger.Error(err, "failed to register hooks, skip the subscription reconcile")
@@ -582,9 +591,25 @@ func (r *ReconcileSubscription) Reconcile(request reconcile.Request) (result rec
 					return reconcile.Result{}, nil
 				}
 
-				result.RequeueAfter = r.hookRequeueInterval
 				passedPrehook = false
 
+				// the prehooks that failed with the rollback or abort failure policy stop the subscription till the
+				// hooks are registered again, the subscription keeps waiting for the other prehooks
+				switch r.hooks.GetFailurePolicy(PreHookType, request.NamespacedName) {
+				case appv1.HookFailurePolicyRollback:
+					preErr = fmt.Errorf("prehook for %v failed", request.String())
+
+					r.rollbackOnHookFailure(instance, PreHookType)
+
+					return result, nil
+				case appv1.HookFailurePolicyAbort:
+					preErr = fmt.Errorf("prehook for %v failed, the subscription is aborted", request.String())
+
+					return result, nil
+				}
+
+				result.RequeueAfter = r.hookRequeueInterval
+
 				return result, nil
 			}
 		}
@@ -714,6 +739,12 @@ func (r *ReconcileSubscription) IsSubscriptionCompleted(subKey types.NamespacedN
 					"subscription is not completed")
 				return false, nil
 			}
+
+			// The posthooks wait for the helm test of the deployed release
+			if pSt.HelmTest != nil && pSt.HelmTest.Result != subv1.HelmTestPassed {
+				r.logger.Info(fmt.Sprintf("cluster %s package %s helm test is %s", cluster, pkg, pSt.HelmTest.Result))
+				return false, nil
+			}
 		}
 	}
 
@@ -832,6 +863,31 @@ func (r *ReconcileSubscription) finalCommit(passedBranchRegistration bool, passe
 		r.logger.Error(err, "failed to apply postHook, skip the subscription reconcile, err:")
 	}
 
+	// the posthooks are checked for their timeouts and retries
+	done, err := r.hooks.IsPostHooksCompleted(request.NamespacedName)
+	if err != nil {
+		r.logger.Error(err, "failed to check posthook status")
+	}
+
+	if !done {
+		switch r.hooks.GetFailurePolicy(PostHookType, request.NamespacedName) {
+		case appv1.HookFailurePolicyRollback:
+			if r.rollbackOnHookFailure(nIns, PostHookType) {
+				if err := r.Client.Update(context.TODO(), nIns.DeepCopy(), &client.UpdateOptions{FieldManager: r.name}); err != nil {
+					r.logger.Error(err, fmt.Sprintf("%s failed to roll back", PrintHelper(nIns)))
+				}
+
+				res.RequeueAfter = defaulRequeueInterval
+
+				return
+			}
+		case appv1.HookFailurePolicyAbort:
+			// the resources are deployed, the failure is reported in the status
+		default:
+			res.RequeueAfter = r.hookRequeueInterval
+		}
+	}
+
 	nIns.Status = r.hooks.AppendStatusToSubscription(nIns)
 	nIns.Status.LastUpdateTime = metav1.Now()
 
@@ -847,6 +903,83 @@ func (r *ReconcileSubscription) finalCommit(passedBranchRegistration bool, passe
 	}
 }
 
+// rollbackOnHookFailure pins the subscription to the last commit of which all
+// the hooks succeeded, false is returned if there's no commit to roll back to.
+// The pin is recorded in the hook rollback pin annotation with the failed
+// commit and the previous desired commit, so it can be removed later.
+func (r *ReconcileSubscription) rollbackOnHookFailure(sub *subv1.Subscription, hookType string) bool {
+	commit := sub.Status.HooksStatus.LastSucceededCommit
+	anno := sub.GetAnnotations()
+
+	if commit == "" || anno[subv1.AnnotationGitTargetCommit] == commit {
+		msg := fmt.Sprintf("the %s failed, there's no commit to roll back to", hookType)
+		r.eventRecorder.RecordEvent(sub, "HookRollback", msg, fmt.Errorf("%s", msg))
+
+		return false
+	}
+
+	if anno == nil {
+		anno = map[string]string{}
+	}
+
+	failedCommit, err := r.hubGitOps.GetLatestCommitID(sub)
+	if err != nil {
+		r.logger.Error(err, fmt.Sprintf("failed to get the failed commit of %s", PrintHelper(sub)))
+	}
+
+	pin, err := json.Marshal(utils.HookRollbackPin{
+		Commit:       commit,
+		FailedCommit: failedCommit,
+		Previous:     anno[subv1.AnnotationGitTargetCommit],
+	})
+	if err != nil {
+		r.logger.Error(err, fmt.Sprintf("failed to record the rollback of %s", PrintHelper(sub)))
+
+		return false
+	}
+
+	anno[subv1.AnnotationHookRollbackPin] = string(pin)
+	anno[subv1.AnnotationGitTargetCommit] = commit
+	sub.SetAnnotations(anno)
+
+	r.eventRecorder.RecordEvent(sub, "HookRollback", fmt.Sprintf("the %s failed, rolling back to commit %s", hookType, commit), nil)
+
+	return true
+}
+
+// releaseHookRollback removes the rollback pin once the branch has a newer
+// commit than the failed one, the newer commit is deployed and its hooks run.
+// If they fail, the subscription is rolled back again. The pin is dropped
+// without restoring the previous desired commit if the desired commit was
+// changed since the rollback. True is returned if the annotations changed.
+func (r *ReconcileSubscription) releaseHookRollback(sub *subv1.Subscription) bool {
+	anno := sub.GetAnnotations()
+
+	pin := utils.GetHookRollbackPin(anno)
+	if pin == nil {
+		return false
+	}
+
+	if pin.Commit != "" && anno[subv1.AnnotationGitTargetCommit] == pin.Commit {
+		unpinned := sub.DeepCopy()
+		utils.RemoveHookRollbackPin(unpinned.GetAnnotations())
+
+		// the git watcher keeps polling the branch the subscription followed before the rollback
+		head, err := r.hubGitOps.GetLatestCommitID(unpinned)
+		if err != nil || head == "" || head == pin.FailedCommit {
+			return false
+		}
+
+		r.eventRecorder.RecordEvent(sub, "HookRollback",
+			fmt.Sprintf("commit %s is newer than the failed commit %s, the rollback to commit %s is removed", head, pin.FailedCommit, pin.Commit), nil)
+	}
+
+	utils.RemoveHookRollbackPin(anno)
+	sub.SetAnnotations(anno)
+
+	return true
+}
+
 func after(value string, a string) string {
 	// Get substring after a string.
 	pos := strings.LastIndex(value, a)
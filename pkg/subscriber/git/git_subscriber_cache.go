@@ -0,0 +1,312 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog"
+
+	dplv1 "github.com/open-cluster-management/multicloud-operators-deployable/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	kubesynchronizer "github.com/open-cluster-management/multicloud-operators-subscription/pkg/synchronizer/kubernetes"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+// renderCache holds the deployables packaged from one Git commit. When the next commit is deployed, only the
+// resource files, kustomizations and helm charts affected by the files changed in between are rendered again.
+type renderCache struct {
+	// key identifies everything other than the Git content that the deployables were rendered from
+	key string
+	// treeHashes are the Git object hashes of the files in the rendered commit
+	treeHashes map[string]string
+	// files are the deployables of each resource file
+	files map[string][]kubesynchronizer.DplUnit
+	// kustomizations are the deployables of each kustomize directory
+	kustomizations map[string]kustomizeUnits
+	// charts are the deployables of each helm chart package
+	charts map[string]chartUnit
+}
+
+type kustomizeUnits struct {
+	units []kubesynchronizer.DplUnit
+	// deps are the absolute paths kustomize read to build the kustomization
+	deps []string
+}
+
+type chartUnit struct {
+	unit kubesynchronizer.DplUnit
+	// chartDirs are the absolute paths of the chart directories of the package
+	chartDirs []string
+}
+
+func newRenderCache(key string, treeHashes map[string]string) *renderCache {
+	return &renderCache{
+		key:            key,
+		treeHashes:     treeHashes,
+		files:          map[string][]kubesynchronizer.DplUnit{},
+		kustomizations: map[string]kustomizeUnits{},
+		charts:         map[string]chartUnit{},
+	}
+}
+
+// renderAnnotations are the subscription annotations that change how the Git content is rendered. Annotations that
+// only record the sync state, like the current commit or the webhook event count, are left out of the render key so
+// that they do not invalidate the cache.
+var renderAnnotations = []string{
+	appv1.AnnotationGitPath,
+	appv1.AnnotationGitPaths,
+	appv1.AnnotationGithubPath,
+	appv1.AnnotationJsonnetExtVars,
+	appv1.AnnotationJsonnetTLAs,
+	appv1.AnnotationCueTags,
+	appv1.AnnotationClusterAdmin,
+	appv1.AnnotationResourceReconcileOption,
+	appv1.AnnotationHelmDependencySecret,
+	dplv1.AnnotationHosting,
+}
+
+// renderKey returns a digest of the subscription, channel and user settings that the deployables depend on
+func (ghsi *SubscriberItem) renderKey() string {
+	annotations := map[string]string{}
+
+	for _, key := range renderAnnotations {
+		if value, ok := ghsi.Subscription.GetAnnotations()[key]; ok {
+			annotations[key] = value
+		}
+	}
+
+	input := struct {
+		Subscription interface{}
+		Channel      interface{}
+		FilterRef    map[string]string
+		ClusterAdmin bool
+		UserID       string
+		UserGroup    string
+	}{
+		Subscription: struct {
+			Name             string
+			Namespace        string
+			UID              string
+			PartOf           string
+			Annotations      map[string]string
+			Package          string
+			PackageFilter    interface{}
+			PackageOverrides interface{}
+			Overrides        interface{}
+		}{
+			Name:             ghsi.Subscription.Name,
+			Namespace:        ghsi.Subscription.Namespace,
+			UID:              string(ghsi.Subscription.UID),
+			PartOf:           ghsi.Subscription.GetLabels()["app.kubernetes.io/part-of"],
+			Annotations:      annotations,
+			Package:          ghsi.Subscription.Spec.Package,
+			PackageFilter:    ghsi.Subscription.Spec.PackageFilter,
+			PackageOverrides: ghsi.Subscription.Spec.PackageOverrides,
+			Overrides:        ghsi.Subscription.Spec.Overrides,
+		},
+		ClusterAdmin: ghsi.clusterAdmin,
+		UserID:       ghsi.userID,
+		UserGroup:    ghsi.userGroup,
+	}
+
+	if ghsi.Channel != nil {
+		input.Channel = struct {
+			Name      string
+			Namespace string
+			Spec      interface{}
+		}{
+			Name:      ghsi.Channel.Name,
+			Namespace: ghsi.Channel.Namespace,
+			Spec:      ghsi.Channel.Spec,
+		}
+	}
+
+	if ghsi.SubscriptionConfigMap != nil {
+		input.FilterRef = ghsi.SubscriptionConfigMap.Data
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		klog.Error("Failed to compute the render key, err: ", err)
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// startRender prepares the cache of the commit about to be rendered and finds the files changed since the
+// previously rendered commit. Without a usable previous render, everything is rendered.
+func (ghsi *SubscriberItem) startRender(commitID string) {
+	ghsi.changedFiles = nil
+
+	treeHashes, err := utils.GetGitTreeHashes(ghsi.repoRoot, commitID)
+	if err != nil {
+		klog.Error(err, " Rendering all resources.")
+	}
+
+	ghsi.rendering = newRenderCache(ghsi.renderKey(), treeHashes)
+
+	if ghsi.rendered == nil || ghsi.rendered.treeHashes == nil || treeHashes == nil ||
+		ghsi.rendering.key == "" || ghsi.rendering.key != ghsi.rendered.key {
+		klog.Infof("No reusable render of a previous commit. Rendering all resources of commit %s", commitID)
+		return
+	}
+
+	changed := utils.GetChangedGitFiles(ghsi.rendered.treeHashes, treeHashes)
+
+	klog.Infof("%d files changed between commit %s and commit %s", len(changed), ghsi.commitID, commitID)
+	klog.V(4).Info("Changed files: ", changed)
+
+	ghsi.changedFiles = make(map[string]bool, len(changed))
+
+	for _, path := range changed {
+		ghsi.changedFiles[path] = true
+	}
+}
+
+// finishRender keeps the cache of the commit that was just deployed for the next commit
+func (ghsi *SubscriberItem) finishRender() {
+	if ghsi.rendering != nil && ghsi.rendering.treeHashes != nil {
+		ghsi.rendered = ghsi.rendering
+	} else {
+		ghsi.rendered = nil
+	}
+
+	ghsi.rendering = nil
+	ghsi.changedFiles = nil
+}
+
+// abortRender drops the cache of a commit that failed to be deployed. The cache of the previous commit is kept.
+func (ghsi *SubscriberItem) abortRender() {
+	ghsi.rendering = nil
+	ghsi.changedFiles = nil
+}
+
+// isChanged returns true if the file at the absolute path, or any file under it if it is a directory,
+// may have changed since the previously rendered commit
+func (ghsi *SubscriberItem) isChanged(path string) bool {
+	if ghsi.changedFiles == nil {
+		return true
+	}
+
+	root := strings.TrimSuffix(ghsi.repoRoot, "/") + "/"
+	path = strings.TrimSuffix(path, "/")
+
+	if path+"/" == root {
+		return len(ghsi.changedFiles) > 0
+	}
+
+	if !strings.HasPrefix(path, root) {
+		// anything outside the repo, like a remote kustomize base, can change any time
+		return true
+	}
+
+	rel := strings.TrimPrefix(path, root)
+
+	if ghsi.changedFiles[rel] {
+		return true
+	}
+
+	for changed := range ghsi.changedFiles {
+		if strings.HasPrefix(changed, rel+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validUnits returns true if all the resource kinds of the cached deployables are still supported on the cluster
+func (ghsi *SubscriberItem) validUnits(units []kubesynchronizer.DplUnit) bool {
+	for _, unit := range units {
+		if ghsi.synchronizer.GetValidatedGVK(unit.Gvk) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cachedFileUnits returns the deployables of a resource file from the previously rendered commit if the file is unchanged.
+// Files rendered from Jsonnet or CUE sources are not in the Git tree and are never reused.
+func (ghsi *SubscriberItem) cachedFileUnits(rscFile string) ([]kubesynchronizer.DplUnit, bool) {
+	if ghsi.rendered == nil || ghsi.rendering == nil || utils.IsRenderedManifest(rscFile) || ghsi.isChanged(rscFile) {
+		return nil, false
+	}
+
+	units, ok := ghsi.rendered.files[rscFile]
+	if !ok || !ghsi.validUnits(units) {
+		return nil, false
+	}
+
+	return units, true
+}
+
+// cachedKustomizeUnits returns the deployables of a kustomization from the previously rendered commit if none of
+// the files read to build it has changed
+func (ghsi *SubscriberItem) cachedKustomizeUnits(kustomizeDir string) ([]kubesynchronizer.DplUnit, []string, bool) {
+	if ghsi.rendered == nil || ghsi.rendering == nil || ghsi.isChanged(kustomizeDir) {
+		return nil, nil, false
+	}
+
+	cached, ok := ghsi.rendered.kustomizations[kustomizeDir]
+	if !ok || !ghsi.validUnits(cached.units) {
+		return nil, nil, false
+	}
+
+	for _, dep := range cached.deps {
+		if ghsi.isChanged(dep) {
+			return nil, nil, false
+		}
+	}
+
+	return cached.units, cached.deps, true
+}
+
+// cachedChartUnit returns the deployable of a helm chart package from the previously rendered commit if none of its
+// chart directories has changed
+func (ghsi *SubscriberItem) cachedChartUnit(packageName string, chartDirs []string) (kubesynchronizer.DplUnit, bool) {
+	if ghsi.rendered == nil || ghsi.rendering == nil {
+		return kubesynchronizer.DplUnit{}, false
+	}
+
+	cached, ok := ghsi.rendered.charts[packageName]
+	if !ok || strings.Join(cached.chartDirs, ",") != strings.Join(chartDirs, ",") {
+		return kubesynchronizer.DplUnit{}, false
+	}
+
+	for _, chartDir := range chartDirs {
+		if ghsi.isChanged(chartDir) {
+			return kubesynchronizer.DplUnit{}, false
+		}
+	}
+
+	return cached.unit, true
+}
+
+// copyUnits returns deep copies of deployables so that the cached ones are never modified
+func copyUnits(units []kubesynchronizer.DplUnit) []kubesynchronizer.DplUnit {
+	copied := make([]kubesynchronizer.DplUnit, 0, len(units))
+
+	for _, unit := range units {
+		copied = append(copied, kubesynchronizer.DplUnit{Dpl: unit.Dpl.DeepCopy(), Gvk: unit.Gvk})
+	}
+
+	return copied
+}
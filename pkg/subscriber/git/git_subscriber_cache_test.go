@@ -0,0 +1,222 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	kubesynchronizer "github.com/open-cluster-management/multicloud-operators-subscription/pkg/synchronizer/kubernetes"
+)
+
+// fakeSyncSource accepts every resource kind and does not deploy anything
+type fakeSyncSource struct{}
+
+func (f *fakeSyncSource) GetInterval() int                                  { return 0 }
+func (f *fakeSyncSource) GetLocalClient() client.Client                     { return nil }
+func (f *fakeSyncSource) GetRemoteClient() client.Client                    { return nil }
+func (f *fakeSyncSource) IsResourceNamespaced(schema.GroupVersionKind) bool { return true }
+func (f *fakeSyncSource) CleanupByHost(types.NamespacedName, string) error  { return nil }
+
+func (f *fakeSyncSource) GetValidatedGVK(gvk schema.GroupVersionKind) *schema.GroupVersionKind {
+	return &gvk
+}
+
+func (f *fakeSyncSource) AddTemplates(string, types.NamespacedName, []kubesynchronizer.DplUnit) error {
+	return nil
+}
+
+const (
+	testConfigMapA = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm-a
+data:
+  key: %s
+`
+	testKustomization = `resources:
+- cm.yaml
+`
+)
+
+func commitTestRepo(g *gomega.GomegaWithT, repo *gogit.Repository, dir string, files map[string]string) string {
+	wt, err := repo.Worktree()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(gomega.Succeed())
+		g.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+
+		_, err = wt.Add(name)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	hash, err := wt.Commit("test", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return hash.String()
+}
+
+func newTestSubscriberItem(repoRoot string) *SubscriberItem {
+	subitem := &SubscriberItem{}
+	subitem.Subscription = &appv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "incremental", Namespace: "default"},
+	}
+	subitem.Channel = &chnv1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Name: "incremental-channel", Namespace: "default"},
+		Spec:       chnv1.ChannelSpec{Type: chnv1.ChannelTypeGit},
+	}
+	subitem.synchronizer = &fakeSyncSource{}
+	subitem.repoRoot = repoRoot
+
+	return subitem
+}
+
+// renderCommit renders the checked out commit the same way doSubscription does
+func renderCommit(g *gomega.GomegaWithT, subitem *SubscriberItem, commitID string) []kubesynchronizer.DplUnit {
+	subitem.resources = []kubesynchronizer.DplUnit{}
+
+	g.Expect(subitem.sortClonedGitRepo()).To(gomega.Succeed())
+
+	subitem.startRender(commitID)
+
+	g.Expect(subitem.subscribeResources(subitem.crdsAndNamespaceFiles)).To(gomega.Succeed())
+	g.Expect(subitem.subscribeResources(subitem.rbacFiles)).To(gomega.Succeed())
+	g.Expect(subitem.subscribeResources(subitem.otherFiles)).To(gomega.Succeed())
+	g.Expect(subitem.subscribeKustomizations()).To(gomega.Succeed())
+	g.Expect(subitem.subscribeHelmCharts(subitem.indexFile)).To(gomega.Succeed())
+
+	subitem.commitID = commitID
+	subitem.finishRender()
+
+	resources := subitem.resources
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Dpl.Name < resources[j].Dpl.Name })
+
+	return resources
+}
+
+func TestIncrementalRender(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "incremental")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	repo, err := gogit.PlainInit(dir, false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	commit1 := commitTestRepo(g, repo, dir, map[string]string{
+		"a.yaml":                       fmt.Sprintf(testConfigMapA, "one"),
+		"b.yaml":                       strings.Replace(fmt.Sprintf(testConfigMapA, "one"), "cm-a", "cm-b", 1),
+		"kustomize/kustomization.yaml": testKustomization,
+		"kustomize/cm.yaml":            strings.Replace(fmt.Sprintf(testConfigMapA, "one"), "cm-a", "cm-k", 1),
+	})
+
+	subitem := newTestSubscriberItem(dir)
+
+	resources := renderCommit(g, subitem, commit1)
+	g.Expect(resources).To(gomega.HaveLen(3))
+
+	fileA := filepath.Join(dir, "a.yaml")
+	fileB := filepath.Join(dir, "b.yaml")
+	kustomizeDir := filepath.Join(dir, "kustomize") + "/"
+
+	cachedA := subitem.rendered.files[fileA][0].Dpl
+	cachedB := subitem.rendered.files[fileB][0].Dpl
+	cachedK := subitem.rendered.kustomizations[kustomizeDir].units[0].Dpl
+
+	// Only a.yaml changes in the second commit
+	commit2 := commitTestRepo(g, repo, dir, map[string]string{
+		"a.yaml": fmt.Sprintf(testConfigMapA, "two"),
+	})
+
+	resources = renderCommit(g, subitem, commit2)
+	g.Expect(resources).To(gomega.HaveLen(3))
+	g.Expect(subitem.rendered.files[fileA][0].Dpl).NotTo(gomega.BeIdenticalTo(cachedA))
+	g.Expect(subitem.rendered.files[fileB][0].Dpl).To(gomega.BeIdenticalTo(cachedB))
+	g.Expect(subitem.rendered.kustomizations[kustomizeDir].units[0].Dpl).To(gomega.BeIdenticalTo(cachedK))
+
+	// The incremental render produces the same resources as a full render
+	g.Expect(resources).To(gomega.Equal(renderCommit(g, newTestSubscriberItem(dir), commit2)))
+
+	// A change in the kustomization renders it again
+	commit3 := commitTestRepo(g, repo, dir, map[string]string{
+		"kustomize/cm.yaml": strings.Replace(fmt.Sprintf(testConfigMapA, "two"), "cm-a", "cm-k", 1),
+	})
+
+	resources = renderCommit(g, subitem, commit3)
+	g.Expect(subitem.rendered.files[fileB][0].Dpl).To(gomega.BeIdenticalTo(cachedB))
+	g.Expect(subitem.rendered.kustomizations[kustomizeDir].units[0].Dpl).NotTo(gomega.BeIdenticalTo(cachedK))
+	g.Expect(resources).To(gomega.Equal(renderCommit(g, newTestSubscriberItem(dir), commit3)))
+
+	// A subscription change renders everything again
+	cachedB = subitem.rendered.files[fileB][0].Dpl
+	subitem.Subscription.Annotations = map[string]string{appv1.AnnotationResourceReconcileOption: appv1.MergeReconcile}
+
+	renderCommit(g, subitem, commit3)
+	g.Expect(subitem.rendered.files[fileB][0].Dpl).NotTo(gomega.BeIdenticalTo(cachedB))
+}
+
+func TestRenderKey(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	subitem := &SubscriberItem{}
+	subitem.Subscription = &appv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "render-key",
+			Namespace: "default",
+			Annotations: map[string]string{
+				appv1.AnnotationGitPath: "resources",
+			},
+		},
+	}
+
+	key := subitem.renderKey()
+	g.Expect(key).NotTo(gomega.BeEmpty())
+
+	// The annotations recording the sync state do not invalidate the render
+	subitem.Subscription.Annotations[appv1.AnnotationGitCommit] = "abc"
+	subitem.Subscription.Annotations[appv1.AnnotationWebhookEventCount] = "1"
+	subitem.Subscription.Annotations[appv1.AnnotationManualReconcileTime] = time.Now().String()
+	g.Expect(subitem.renderKey()).To(gomega.Equal(key))
+
+	subitem.Subscription.Annotations[appv1.AnnotationGitPath] = "other"
+	g.Expect(subitem.renderKey()).NotTo(gomega.Equal(key))
+
+	subitem.Subscription.Annotations[appv1.AnnotationGitPath] = "resources"
+	subitem.Subscription.Spec.Package = "chart"
+	g.Expect(subitem.renderKey()).NotTo(gomega.Equal(key))
+}
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -82,6 +83,7 @@ type SubscriberItem struct {
 	count                 int
 	synchronizer          SyncSource
 	chartDirs             map[string]string
+	chartArchives         map[string]string
 	kustomizeDirs         map[string]string
 	resources             []kubesynchronizer.DplUnit
 	indexFile             *repo.IndexFile
@@ -90,6 +92,9 @@ type SubscriberItem struct {
 	clusterAdmin          bool
 	userID                string
 	userGroup             string
+	rendered              *renderCache
+	rendering             *renderCache
+	changedFiles          map[string]bool
 }
 
 type kubeResource struct {
@@ -206,6 +211,10 @@ func (ghsi *SubscriberItem) doSubscription() error {
 		klog.Error(err, "Unable to clone the git repo ", ghsi.Channel.Spec.Pathname)
 		ghsi.successful = false
 
+		if utils.IsHostKeyMismatch(err) {
+			ghsi.reportCloneError(err)
+		}
+
 		return err
 	}
 
@@ -242,6 +251,8 @@ func (ghsi *SubscriberItem) doSubscription() error {
 		return err
 	}
 
+	ghsi.startRender(commitID)
+
 	errMsg := ""
 
 	syncsource := githubk8ssyncsource + hostkey.String()
@@ -336,6 +347,8 @@ func (ghsi *SubscriberItem) doSubscription() error {
 			}
 		}
 
+		ghsi.abortRender()
+
 		return errors.New("failed to prepare resources to apply and there is no resource to apply. err: " + errMsg)
 	}
 
@@ -344,13 +357,18 @@ func (ghsi *SubscriberItem) doSubscription() error {
 
 		ghsi.successful = false
 
+		ghsi.abortRender()
+
 		return err
 	}
 
 	ghsi.commitID = commitID
 
+	ghsi.finishRender()
+
 	ghsi.resources = nil
 	ghsi.chartDirs = nil
+	ghsi.chartArchives = nil
 	ghsi.kustomizeDirs = nil
 	ghsi.crdsAndNamespaceFiles = nil
 	ghsi.rbacFiles = nil
@@ -363,6 +381,15 @@ func (ghsi *SubscriberItem) doSubscription() error {
 
 func (ghsi *SubscriberItem) subscribeKustomizations() error {
 	for _, kustomizeDir := range ghsi.kustomizeDirs {
+		if units, deps, ok := ghsi.cachedKustomizeUnits(kustomizeDir); ok {
+			klog.V(4).Info("Kustomization is unchanged since the last commit ", kustomizeDir)
+
+			ghsi.resources = append(ghsi.resources, copyUnits(units)...)
+			ghsi.rendering.kustomizations[kustomizeDir] = kustomizeUnits{units: units, deps: deps}
+
+			continue
+		}
+
 		klog.Info("Applying kustomization ", kustomizeDir)
 
 		relativePath := kustomizeDir
@@ -373,13 +400,16 @@ func (ghsi *SubscriberItem) subscribeKustomizations() error {
 
 		utils.VerifyAndOverrideKustomize(ghsi.Subscription.Spec.PackageOverrides, relativePath, kustomizeDir)
 
-		out, err := utils.RunKustomizeBuild(kustomizeDir)
+		out, deps, err := utils.RunKustomizeBuildWithDeps(kustomizeDir)
 
 		if err != nil {
 			klog.Error("Failed to apply kustomization, error: ", err.Error())
 			return err
 		}
 
+		first := len(ghsi.resources)
+		complete := true
+
 		// Split the output of kustomize build output into individual kube resource YAML files
 		resources := utils.ParseYAML(out)
 		for _, resource := range resources {
@@ -401,9 +431,16 @@ func (ghsi *SubscriberItem) subscribeKustomizations() error {
 					klog.Errorf("Failed to apply %s/%s resource. err: %s", t.APIVersion, t.Kind, err)
 				}
 
-				ghsi.subscribeResourceFile(resourceFile)
+				if err := ghsi.subscribeResourceFile(resourceFile); err != nil {
+					complete = false
+				}
 			}
 		}
+
+		// A kustomization is only reused when all of its resources were packaged
+		if complete && ghsi.rendering != nil {
+			ghsi.rendering.kustomizations[kustomizeDir] = kustomizeUnits{units: copyUnits(ghsi.resources[first:]), deps: deps}
+		}
 	}
 
 	return nil
@@ -424,6 +461,15 @@ func checkSubscriptionAnnotation(resource kubeResource) error {
 func (ghsi *SubscriberItem) subscribeResources(rscFiles []string) error {
 	// sync kube resource deployables
 	for _, rscFile := range rscFiles {
+		if units, ok := ghsi.cachedFileUnits(rscFile); ok {
+			klog.V(4).Info("Resource file is unchanged since the last commit ", rscFile)
+
+			ghsi.resources = append(ghsi.resources, copyUnits(units)...)
+			ghsi.rendering.files[rscFile] = units
+
+			continue
+		}
+
 		file, err := ioutil.ReadFile(rscFile) // #nosec G304 rscFile is not user input
 
 		if err != nil {
@@ -431,6 +477,9 @@ func (ghsi *SubscriberItem) subscribeResources(rscFiles []string) error {
 			return err
 		}
 
+		first := len(ghsi.resources)
+		complete := true
+
 		resources := utils.ParseKubeResoures(file)
 
 		if len(resources) > 0 {
@@ -470,15 +519,22 @@ func (ghsi *SubscriberItem) subscribeResources(rscFiles []string) error {
 					}
 				}
 
-				ghsi.subscribeResourceFile(resource)
+				if err := ghsi.subscribeResourceFile(resource); err != nil {
+					complete = false
+				}
 			}
 		}
+
+		// A resource file is only reused when all of its resources were packaged
+		if complete && ghsi.rendering != nil {
+			ghsi.rendering.files[rscFile] = copyUnits(ghsi.resources[first:])
+		}
 	}
 
 	return nil
 }
 
-func (ghsi *SubscriberItem) subscribeResourceFile(file []byte) {
+func (ghsi *SubscriberItem) subscribeResourceFile(file []byte) error {
 	dpltosync, validgvk, err := ghsi.subscribeResource(file)
 	if err != nil {
 		klog.Error(err)
@@ -486,10 +542,12 @@ func (ghsi *SubscriberItem) subscribeResourceFile(file []byte) {
 
 	if dpltosync == nil || validgvk == nil {
 		klog.Info("Skipping resource")
-		return
+		return err
 	}
 
 	ghsi.resources = append(ghsi.resources, kubesynchronizer.DplUnit{Dpl: dpltosync, Gvk: *validgvk})
+
+	return nil
 }
 
 func (ghsi *SubscriberItem) subscribeResource(file []byte) (*dplv1.Deployable, *schema.GroupVersionKind, error) {
@@ -696,11 +754,33 @@ func (ghsi *SubscriberItem) checkFilters(rsc *unstructured.Unstructured) (errMsg
 }
 
 func (ghsi *SubscriberItem) subscribeHelmCharts(indexFile *repo.IndexFile) (err error) {
-	for packageName, chartVersions := range indexFile.Entries {
+	chartReleases, err := utils.GetChartReleases(ghsi.Subscription, indexFile)
+	if err != nil {
+		return err
+	}
+
+	for _, chartRelease := range chartReleases {
+		packageName := chartRelease.Name()
+		chartVersions := chartRelease.ChartVersions
+
 		klog.V(4).Infof("chart: %s\n%v", packageName, chartVersions)
 
-		dpl, err := utils.CreateHelmCRDeployable(
-			"", packageName, chartVersions, ghsi.synchronizer.GetLocalClient(), ghsi.Channel, ghsi.Subscription)
+		chartDirs, cacheable := ghsi.packageChartDirs(chartVersions)
+
+		if cacheable {
+			if unit, ok := ghsi.cachedChartUnit(packageName, chartDirs); ok {
+				klog.V(4).Info("Helm chart is unchanged since the last commit ", packageName)
+
+				ghsi.resources = append(ghsi.resources, copyUnits([]kubesynchronizer.DplUnit{unit})...)
+				ghsi.rendering.charts[packageName] = chartUnit{unit: unit, chartDirs: chartDirs}
+
+				continue
+			}
+		}
+
+		dpl, err := utils.CreateHelmCRDeployable("", chartRelease.PackageName, chartRelease.VersionKey,
+			ghsi.chartArchiveVersions(chartVersions),
+			ghsi.synchronizer.GetLocalClient(), ghsi.Channel, ghsi.Subscription)
 
 		if err != nil {
 			klog.Error("Failed to create a helmrelease CR deployable, err: ", err)
@@ -708,11 +788,67 @@ func (ghsi *SubscriberItem) subscribeHelmCharts(indexFile *repo.IndexFile) (err
 		}
 
 		ghsi.resources = append(ghsi.resources, kubesynchronizer.DplUnit{Dpl: dpl, Gvk: helmGvk})
+
+		if cacheable && ghsi.rendering != nil {
+			ghsi.rendering.charts[packageName] = chartUnit{
+				unit:      kubesynchronizer.DplUnit{Dpl: dpl.DeepCopy(), Gvk: helmGvk},
+				chartDirs: chartDirs,
+			}
+		}
 	}
 
 	return err
 }
 
+// packageChartDirs returns the chart directories of the chart versions of a package in the cloned repo
+func (ghsi *SubscriberItem) packageChartDirs(chartVersions repo.ChartVersions) ([]string, bool) {
+	chartDirs := []string{}
+
+	for _, chartVersion := range chartVersions {
+		if len(chartVersion.URLs) == 0 {
+			return nil, false
+		}
+
+		chartDir := filepath.Join(ghsi.repoRoot, chartVersion.URLs[0]) + "/"
+
+		if _, ok := ghsi.chartDirs[chartDir]; !ok {
+			return nil, false
+		}
+
+		chartDirs = append(chartDirs, chartDir)
+	}
+
+	return chartDirs, true
+}
+
+// chartsDir returns the directory of the Helm charts packaged with their dependencies. The HelmRelease controller runs
+// in the same process and reads the charts from this directory.
+func (ghsi *SubscriberItem) chartsDir() string {
+	return filepath.Join(os.TempDir(), "git-charts", ghsi.Subscription.Namespace, ghsi.Subscription.Name)
+}
+
+// chartArchiveVersions returns the chart versions with the URL of the local archive for the charts packaged with their
+// dependencies, the HelmRelease controller does not build the dependencies of the charts it clones from Git
+func (ghsi *SubscriberItem) chartArchiveVersions(chartVersions repo.ChartVersions) repo.ChartVersions {
+	versions := repo.ChartVersions{}
+
+	for _, chartVersion := range chartVersions {
+		if len(chartVersion.URLs) > 0 {
+			chartDir := filepath.Join(ghsi.repoRoot, chartVersion.URLs[0]) + "/"
+
+			if archive, ok := ghsi.chartArchives[chartDir]; ok {
+				cv := *chartVersion
+				cv.URLs = []string{"file://localhost" + filepath.ToSlash(archive)}
+				chartVersion = &cv
+			}
+		}
+
+		versions = append(versions, chartVersion)
+	}
+
+	return versions
+}
+
 func (ghsi *SubscriberItem) cloneGitRepo() (commitID string, err error) {
 	ghsi.repoRoot = utils.GetLocalGitFolder(ghsi.Channel, ghsi.Subscription)
 
@@ -721,20 +857,38 @@ func (ghsi *SubscriberItem) cloneGitRepo() (commitID string, err error) {
 	sshKey := []byte("")
 	passphrase := []byte("")
 
+	var tokenSource utils.GitTokenSource
+
+	var clientCert, clientKey []byte
+
+	caCert := ""
+
+	if ghsi.SubscriberItem.ChannelConfigMap != nil {
+		caCert = ghsi.SubscriberItem.ChannelConfigMap.Data[appv1.ChannelCertificateData]
+	}
+
 	if ghsi.SubscriberItem.ChannelSecret != nil {
 		user, token, sshKey, passphrase, err = utils.ParseChannelSecret(ghsi.SubscriberItem.ChannelSecret)
 
 		if err != nil {
 			return "", err
 		}
-	}
 
-	caCert := ""
+		tokenSource, err = utils.GetGitTokenSource(ghsi.SubscriberItem.ChannelSecret, caCert, ghsi.Channel.Spec.InsecureSkipVerify)
 
-	if ghsi.SubscriberItem.ChannelConfigMap != nil {
-		caCert = ghsi.SubscriberItem.ChannelConfigMap.Data[appv1.ChannelCertificateData]
+		if err != nil {
+			return "", err
+		}
+
+		clientCert, clientKey, err = utils.GetClientCertificateData(ghsi.SubscriberItem.ChannelSecret)
+
+		if err != nil {
+			return "", err
+		}
 	}
 
+	knownHosts, hostKeyPolicy := utils.GetKnownHosts(ghsi.SubscriberItem.ChannelSecret, ghsi.SubscriberItem.ChannelConfigMap)
+
 	annotations := ghsi.Subscription.GetAnnotations()
 
 	cloneDepth := 1
@@ -762,11 +916,50 @@ func (ghsi *SubscriberItem) cloneGitRepo() (commitID string, err error) {
 		DestDir:            ghsi.repoRoot,
 		InsecureSkipVerify: ghsi.Channel.Spec.InsecureSkipVerify,
 		CaCerts:            caCert,
+		KnownHosts:         knownHosts,
+		HostKeyPolicy:      hostKeyPolicy,
+		HostKeyStore:       utils.NewChannelHostKeyStore(ghsi.synchronizer.GetLocalClient(), ghsi.Channel),
+		TokenSource:        tokenSource,
+		ClientCert:         clientCert,
+		ClientKey:          clientKey,
 	}
 
 	return utils.CloneGitRepo(cloneOptions)
 }
 
+// reportCloneError surfaces a clone error in the subscription status since no resource gets deployed to report it
+func (ghsi *SubscriberItem) reportCloneError(cloneErr error) {
+	annotations := ghsi.Subscription.GetAnnotations()
+
+	if annotations != nil && annotations[dplv1.AnnotationHosting] != "" {
+		if ghsi.synchronizer.GetRemoteClient() == nil {
+			return
+		}
+
+		if err := utils.UpdateDeployableStatus(ghsi.synchronizer.GetRemoteClient(), cloneErr, ghsi.Subscription, nil); err != nil {
+			klog.Error("Failed to update the host deployable status with the clone error, err: ", err)
+		}
+
+		return
+	}
+
+	sub := &appv1.Subscription{}
+	subkey := types.NamespacedName{Name: ghsi.Subscription.Name, Namespace: ghsi.Subscription.Namespace}
+
+	if err := ghsi.synchronizer.GetLocalClient().Get(context.TODO(), subkey, sub); err != nil {
+		klog.Error("Failed to get the subscription to report the clone error, err: ", err)
+		return
+	}
+
+	sub.Status.Phase = appv1.SubscriptionFailed
+	sub.Status.Reason = cloneErr.Error()
+	sub.Status.LastUpdateTime = metav1.Now()
+
+	if err := ghsi.synchronizer.GetLocalClient().Status().Update(context.TODO(), sub); err != nil {
+		klog.Error("Failed to update the subscription status with the clone error, err: ", err)
+	}
+}
+
 func (ghsi *SubscriberItem) sortClonedGitRepo() error {
 	if ghsi.Subscription.Spec.PackageFilter != nil && ghsi.Subscription.Spec.PackageFilter.FilterRef != nil {
 		ghsi.SubscriberItem.SubscriptionConfigMap = &corev1.ConfigMap{}
@@ -781,29 +974,53 @@ func (ghsi *SubscriberItem) sortClonedGitRepo() error {
 		}
 	}
 
-	resourcePath := ghsi.repoRoot
+	gitPaths, err := utils.GetGitPaths(ghsi.Subscription, ghsi.SubscriberItem.SubscriptionConfigMap)
+	if err != nil {
+		klog.Error(err, " Failed to get the Git paths.")
+		return err
+	}
 
-	annotations := ghsi.Subscription.GetAnnotations()
+	resourcePaths, err := utils.ResolveGitPaths(ghsi.repoRoot, gitPaths)
+	if err != nil {
+		klog.Error(err, " Failed to resolve the Git paths.")
+		return err
+	}
 
-	if annotations[appv1.AnnotationGithubPath] != "" {
-		resourcePath = filepath.Join(ghsi.repoRoot, annotations[appv1.AnnotationGithubPath])
-	} else if annotations[appv1.AnnotationGitPath] != "" {
-		resourcePath = filepath.Join(ghsi.repoRoot, annotations[appv1.AnnotationGitPath])
-	} else if ghsi.SubscriberItem.SubscriptionConfigMap != nil {
-		resourcePath = filepath.Join(ghsi.repoRoot, ghsi.SubscriberItem.SubscriptionConfigMap.Data["path"])
+	// Render Jsonnet and CUE sources into resource files before sorting them
+	for _, resourcePath := range resourcePaths {
+		if err := utils.RenderManifestSources(ghsi.repoRoot, resourcePath.Dir, ghsi.Subscription); err != nil {
+			klog.Error(err, " Failed to render Jsonnet and CUE sources.")
+			return err
+		}
 	}
 
 	// chartDirs contains helm chart directories
 	// crdsAndNamespaceFiles contains CustomResourceDefinition and Namespace Kubernetes resources file paths
 	// rbacFiles contains ServiceAccount, ClusterRole and Role Kubernetes resource file paths
 	// otherFiles contains all other Kubernetes resource file paths
-	chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, err := utils.SortResources(ghsi.repoRoot, resourcePath, utils.SkipHooksOnManaged)
+	// The resources of all Git paths are merged
+	chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, err := utils.SortResourcesInPaths(ghsi.repoRoot,
+		resourcePaths, utils.SkipHooksOnManaged)
 	if err != nil {
 		klog.Error(err, "Failed to sort kubernetes resources and helm charts.")
 		return err
 	}
 
+	// Download the chart dependencies into the charts folder of the cloned charts
+	dependencyCredentials, err := utils.GetHelmDependencyCredentials(ghsi.synchronizer.GetLocalClient(), ghsi.Channel,
+		ghsi.SubscriberItem.ChannelSecret, ghsi.Subscription)
+	if err != nil {
+		klog.Error(err, " Failed to get the Helm dependency credentials.")
+		return err
+	}
+
+	chartArchives, err := utils.BuildHelmDependencies(chartDirs, dependencyCredentials, ghsi.chartsDir())
+	if err != nil {
+		return err
+	}
+
 	ghsi.chartDirs = chartDirs
+	ghsi.chartArchives = chartArchives
 	ghsi.kustomizeDirs = kustomizeDirs
 	ghsi.crdsAndNamespaceFiles = crdsAndNamespaceFiles
 	ghsi.rbacFiles = rbacFiles
@@ -0,0 +1,109 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmrepo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+func newTestClientCertificate(g *gomega.GomegaWithT) (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "subscription"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestHelmRepoClientCertificate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cert, certPEM, keyPEM := newTestClientCertificate(g)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testIndex))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+
+	defer server.Close()
+
+	sub := &appv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "default"},
+		Spec:       appv1.SubscriptionSpec{Package: "redis"},
+	}
+
+	// The server refuses clients without certificate
+	client, err := getHelmRepoClient(nil, nil, true)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, _, err = getHelmRepoIndex(client, sub, nil, server.URL)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	secret := &corev1.Secret{Data: map[string][]byte{utils.ClientCert: certPEM, utils.ClientKey: keyPEM}}
+
+	client, err = getHelmRepoClient(nil, secret, true)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	indexFile, _, err := getHelmRepoIndex(client, sub, secret, server.URL)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(indexFile.Entries).To(gomega.HaveKey("redis"))
+
+	// The clients of other channels are not changed
+	g.Expect(http.DefaultClient.Transport).To(gomega.BeNil())
+
+	_, err = getHelmRepoClient(nil, &corev1.Secret{Data: map[string][]byte{utils.ClientCert: certPEM}}, true)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -19,9 +19,10 @@ import (
 	"crypto/sha1" // #nosec G505 Used only to generate random value to be used to generate hash string
 	"crypto/tls"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -148,7 +149,7 @@ func (hrsi *SubscriberItem) doSubscription() {
 	//Retrieve the helm repo
 	repoURL := hrsi.Channel.Spec.Pathname
 
-	httpClient, err := getHelmRepoClient(hrsi.ChannelConfigMap, hrsi.Channel.Spec.InsecureSkipVerify)
+	httpClient, err := getHelmRepoClient(hrsi.ChannelConfigMap, hrsi.ChannelSecret, hrsi.Channel.Spec.InsecureSkipVerify)
 
 	if err != nil {
 		klog.Error(err, "Unable to create client for helm repo", repoURL)
@@ -216,10 +217,16 @@ func getHelmReleaseNames(indexFile *repo.IndexFile, sub *appv1.Subscription) []s
 
 	var hrNames []string
 
-	for packageName := range indexFile.Entries {
-		releaseCRName, err := utils.PkgToReleaseCRName(sub, packageName)
+	chartReleases, err := utils.GetChartReleases(sub, indexFile)
+	if err != nil {
+		klog.Error(err, "Unable to get the chart releases of the subscription")
+		return hrNames
+	}
+
+	for _, chartRelease := range chartReleases {
+		releaseCRName, err := utils.PkgVersionToReleaseCRName(sub, chartRelease.PackageName, chartRelease.VersionKey)
 		if err != nil {
-			klog.Error(err, "Unable to get HelmRelease name for package: ", packageName)
+			klog.Error(err, "Unable to get HelmRelease name for package: ", chartRelease.Name())
 			continue
 		}
 
@@ -315,8 +322,9 @@ func (hrsi *SubscriberItem) processSubscription(indexFile *repo.IndexFile, hash
 	return nil
 }
 
-func getHelmRepoClient(chnCfg *corev1.ConfigMap, insecureSkipVerify bool) (*http.Client, error) {
-	client := http.DefaultClient
+func getHelmRepoClient(chnCfg *corev1.ConfigMap, chnSrt *corev1.Secret, insecureSkipVerify bool) (*http.Client, error) {
+	// Each channel gets its own client since the TLS config depends on the channel
+	client := &http.Client{}
 
 	if insecureSkipVerify {
 		klog.Info("Channel spec has insecureSkipVerify: true. Skipping Helm repo server certificate verification.")
@@ -363,64 +371,76 @@ func getHelmRepoClient(chnCfg *corev1.ConfigMap, insecureSkipVerify bool) (*http
 		klog.V(5).Info("s.HelmRepoConfig is nil")
 	}
 
+	clientCert, err := utils.GetClientCertificate(chnSrt)
+	if err != nil {
+		klog.Error(err, "Unable to load the TLS client certificate of the channel secret")
+		return nil, err
+	}
+
+	if clientCert != nil {
+		klog.V(2).Info("Channel secret has a TLS client certificate. Presenting it to the Helm repo server.")
+
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
 	client.Transport = transport
 
 	return client, nil
 }
 
-//getHelmRepoIndex retreives the index.yaml, loads it into a repo.IndexFile and filters it
+//getHelmRepoIndex retreives the index.yaml from the index cache and filters it
 func getHelmRepoIndex(client rest.HTTPClient, sub *appv1.Subscription,
 	chnSrt *corev1.Secret, repoURL string) (indexFile *repo.IndexFile, hash string, err error) {
 	cleanRepoURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
-	req, err := http.NewRequest(http.MethodGet, cleanRepoURL, nil)
 
+	req, err := newHelmRepoRequest(cleanRepoURL, chnSrt)
 	if err != nil {
-		klog.Error(err, "Can not build request: ", cleanRepoURL)
 		return nil, "", err
 	}
 
-	if chnSrt != nil && chnSrt.Data != nil {
-		if authHeader, ok := chnSrt.Data["authHeader"]; ok {
-			req.Header.Set("Authorization", string(authHeader))
-		} else if user, ok := chnSrt.Data["user"]; ok {
-			if password, ok := chnSrt.Data["password"]; ok {
-				req.SetBasicAuth(string(user), string(password))
-			} else {
-				return nil, "", fmt.Errorf("password not found in secret for basic authentication")
-			}
-		}
-	}
-
 	klog.V(5).Info(req)
-	resp, err := client.Do(req)
 
+	// The index may depend on the TLS client certificate the client presents
+	clientCert, _, err := utils.GetClientCertificateData(chnSrt)
 	if err != nil {
-		klog.Error(err, "Http request failed: ", cleanRepoURL)
-
 		return nil, "", err
 	}
 
-	klog.V(5).Info("Get succeeded: ", cleanRepoURL)
-
-	body, err := ioutil.ReadAll(resp.Body)
+	cached, hash, err := helmIndexCache.get(client, req, clientCert)
 	if err != nil {
-		klog.Error(err, "Unable to read body: ", cleanRepoURL)
 		return nil, "", err
 	}
 
-	defer resp.Body.Close()
+	// Filter a copy of the entries the subscription may select. The cached index is shared by all subscriptions.
+	indexfile := copyIndexEntries(cached, sub.Spec.Package)
+
+	err = utils.FilterCharts(sub, indexfile)
+
+	return indexfile, hash, err
+}
 
-	hash = hashKey(body)
-	indexfile, err := loadIndex(body)
+// newHelmRepoRequest builds a GET request to the Helm repo with the credentials of the channel secret
+func newHelmRepoRequest(url string, chnSrt *corev1.Secret) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 
 	if err != nil {
-		klog.Error(err, "Unable to parse the indexfile: ", cleanRepoURL)
-		return nil, "", err
+		klog.Error(err, "Can not build request: ", url)
+		return nil, err
 	}
 
-	err = utils.FilterCharts(sub, indexfile)
+	if chnSrt != nil && chnSrt.Data != nil {
+		if authHeader, ok := chnSrt.Data["authHeader"]; ok {
+			req.Header.Set("Authorization", string(authHeader))
+		} else if user, ok := chnSrt.Data["user"]; ok {
+			if password, ok := chnSrt.Data["password"]; ok {
+				req.SetBasicAuth(string(user), string(password))
+			} else {
+				return nil, fmt.Errorf("password not found in secret for basic authentication")
+			}
+		}
+	}
 
-	return indexfile, hash, err
+	return req, nil
 }
 
 func GetSubscriptionChartsOnHub(hubClt client.Client, sub *appv1.Subscription, insecureSkipVerify bool) ([]*releasev1.HelmRelease, error) {
@@ -470,7 +490,7 @@ func GetSubscriptionChartsOnHub(hubClt client.Client, sub *appv1.Subscription, i
 		}
 	}
 
-	httpClient, err := getHelmRepoClient(chnCfg, insecureSkipVerify)
+	httpClient, err := getHelmRepoClient(chnCfg, chSrt, insecureSkipVerify)
 	if err != nil {
 		return nil, gerr.Wrapf(err, "Unable to create client for helm repo %v", repoURL)
 	}
@@ -486,13 +506,20 @@ func GetSubscriptionChartsOnHub(hubClt client.Client, sub *appv1.Subscription, i
 func ChartIndexToHelmReleases(hclt client.Client, chn *chnv1.Channel, sub *appv1.Subscription, indexFile *repo.IndexFile) ([]*releasev1.HelmRelease, error) {
 	helms := make([]*releasev1.HelmRelease, 0)
 
-	for pkgName, chartVer := range indexFile.Entries {
-		releaseCRName, err := utils.PkgToReleaseCRName(sub, pkgName)
+	chartReleases, err := utils.GetChartReleases(sub, indexFile)
+	if err != nil {
+		return nil, gerr.Wrapf(err, "failed to get the chart releases of subscription %v", sub)
+	}
+
+	for _, chartRelease := range chartReleases {
+		pkgName := chartRelease.PackageName
+
+		releaseCRName, err := utils.PkgVersionToReleaseCRName(sub, pkgName, chartRelease.VersionKey)
 		if err != nil {
 			return nil, gerr.Wrapf(err, "failed to generate releaseCRName of helm chart %v for subscription %v", pkgName, sub)
 		}
 
-		helm, err := utils.CreateOrUpdateHelmChart(pkgName, releaseCRName, chartVer, hclt, chn, sub)
+		helm, err := utils.CreateOrUpdateHelmChart(pkgName, releaseCRName, chartRelease.ChartVersions, hclt, chn, sub)
 		if err != nil {
 			return nil, gerr.Wrapf(err, "failed to get helm chart of %v for subscription %v", pkgName, sub)
 		}
@@ -533,7 +560,7 @@ func hashKey(b []byte) string {
 		klog.Error("Failed to hash key with error:", err)
 	}
 
-	return string(h.Sum(nil))
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 func (hrsi *SubscriberItem) manageHelmCR(indexFile *repo.IndexFile, repoURL string) error {
@@ -545,12 +572,51 @@ func (hrsi *SubscriberItem) manageHelmCR(indexFile *repo.IndexFile, repoURL stri
 
 	dplUnits := make([]kubesynchronizer.DplUnit, 0)
 
+	policy, err := getChartVerificationPolicy(hrsi.Subscription)
+	if err != nil {
+		return err
+	}
+
+	var httpClient rest.HTTPClient
+
+	if policy != appv1.ChartVerificationNone {
+		if httpClient, err = getHelmRepoClient(hrsi.ChannelConfigMap, hrsi.ChannelSecret, hrsi.Channel.Spec.InsecureSkipVerify); err != nil {
+			return err
+		}
+	}
+
+	verifications := make(map[string]*appv1.ChartVerificationStatus)
+	versions := make(map[string]string)
+	archives := make(map[string]bool)
+	refused := false
+
+	chartReleases, err := utils.GetChartReleases(hrsi.Subscription, indexFile)
+	if err != nil {
+		return err
+	}
+
 	//Loop on all packages selected by the subscription
-	for packageName, chartVersions := range indexFile.Entries {
-		klog.V(5).Infof("chart: %s\n%v", packageName, chartVersions)
+	for _, chartRelease := range chartReleases {
+		chartVersions := chartRelease.ChartVersions
+
+		klog.V(5).Infof("chart: %s\n%v", chartRelease.Name(), chartVersions)
+
+		var verification *appv1.ChartVerificationStatus
+
+		if policy != appv1.ChartVerificationNone {
+			var archivePath string
 
-		dpl, err := utils.CreateHelmCRDeployable(
-			repoURL, packageName, chartVersions, hrsi.synchronizer.GetLocalClient(), hrsi.Channel, hrsi.Subscription)
+			verification, archivePath = verifyChart(httpClient, hrsi.ChannelSecret, repoURL, chartVersions[0], hrsi.chartsDir())
+			verification.Refused = isChartRefused(policy, verification)
+
+			if archivePath != "" {
+				chartVersions = pinChartArchive(chartVersions, archivePath)
+				archives[archivePath] = true
+			}
+		}
+
+		dpl, err := utils.CreateHelmCRDeployable(repoURL, chartRelease.PackageName, chartRelease.VersionKey, chartVersions,
+			hrsi.synchronizer.GetLocalClient(), hrsi.Channel, hrsi.Subscription)
 
 		if err != nil {
 			klog.Error("failed to create a helmrelease CR deployable, err: ", err)
@@ -560,8 +626,21 @@ func (hrsi *SubscriberItem) manageHelmCR(indexFile *repo.IndexFile, repoURL stri
 			continue
 		}
 
+		verifications[dpl.Name] = verification
+
+		if verification != nil && verification.Refused {
+			klog.Errorf("Chart %s is refused by the %s chart verification policy of subscription %s/%s", verification.Chart,
+				policy, hrsi.Subscription.Namespace, hrsi.Subscription.Name)
+
+			refused = true
+			doErr = fmt.Errorf("chart %s is refused by the chart verification, %s", verification.Chart, verification.Message)
+
+			continue
+		}
+
 		unit := kubesynchronizer.DplUnit{Dpl: dpl, Gvk: helmGvk}
 		dplUnits = append(dplUnits, unit)
+		versions[dpl.Name] = chartVersions[0].Version
 
 		dplkey := types.NamespacedName{
 			Name:      dpl.Name,
@@ -570,6 +649,15 @@ func (hrsi *SubscriberItem) manageHelmCR(indexFile *repo.IndexFile, repoURL stri
 		pkgMap[dplkey.Name] = true
 	}
 
+	// Keep the deployed releases of a subscription with a refused chart as they are
+	if refused {
+		if err := utils.SetInClusterPackageChartVerification(hrsi.synchronizer.GetLocalClient(), hostkey, verifications); err != nil {
+			klog.Error("failed to record the chart verification results, err: ", err)
+		}
+
+		return doErr
+	}
+
 	if len(dplUnits) > 0 || (len(dplUnits) == 0 && doErr == nil) {
 		if len(dplUnits) == 0 {
 			klog.Warningf("The dplUnits length is 0, this might lead to deregistration for subscription %s/%s",
@@ -579,8 +667,25 @@ func (hrsi *SubscriberItem) manageHelmCR(indexFile *repo.IndexFile, repoURL stri
 		if err := dplpro.Units(hrsi.Subscription, hrsi.synchronizer, hostkey, syncsource, pkgMap, dplUnits); err != nil {
 			klog.Warningf("failed to put helm deployables to cache (will retry), err: %v", err)
 			doErr = err
+		} else if err := utils.SetInClusterPackageChartVersions(hrsi.synchronizer.GetLocalClient(), hostkey, versions); err != nil {
+			klog.Error("failed to record the chart versions, err: ", err)
 		}
 	}
 
+	if err := utils.SetInClusterPackageChartVerification(hrsi.synchronizer.GetLocalClient(), hostkey, verifications); err != nil {
+		klog.Error("failed to record the chart verification results, err: ", err)
+	}
+
+	// The archives of charts that are no longer deployed are not needed by the HelmReleases anymore
+	if doErr == nil {
+		removeChartArchives(hrsi.chartsDir(), archives)
+	}
+
 	return doErr
 }
+
+// chartsDir returns the directory of the verified Helm chart archives of the subscription. The HelmRelease
+// controller runs in the same process and installs the verified archives from this directory.
+func (hrsi *SubscriberItem) chartsDir() string {
+	return filepath.Join(os.TempDir(), "helmrepo-charts", hrsi.Subscription.Namespace, hrsi.Subscription.Name)
+}
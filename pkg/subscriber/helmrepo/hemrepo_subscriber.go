@@ -16,6 +16,7 @@ package helmrepo
 
 import (
 	"errors"
+	"os"
 	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -158,6 +159,12 @@ func (hrs *Subscriber) UnsubscribeItem(key types.NamespacedName) error {
 			klog.Errorf("failed to unsubscribe %v, err: %v", key.String(), err)
 			return err
 		}
+
+		removeChartArchives(subitem.chartsDir(), nil)
+
+		if err := os.RemoveAll(subitem.chartsDir()); err != nil {
+			klog.Error("Failed to remove Helm chart directory ", subitem.chartsDir(), " err:", err)
+		}
 	}
 
 	return nil
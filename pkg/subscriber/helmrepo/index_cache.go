@@ -0,0 +1,281 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmrepo
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// indexCacheFreshness is how long a fetched index is served without asking the Helm repo again. It lets the
+	// subscriptions of a channel that poll at the same time share one request.
+	indexCacheFreshness = 10 * time.Second
+
+	indexCacheHit         = "hit"
+	indexCacheNotModified = "not_modified"
+	indexCacheMiss        = "miss"
+	indexCacheError       = "error"
+)
+
+var (
+	indexCacheTracker = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "helmrepo",
+		Name:      "index_cache_total",
+		Help:      "Count the Helm repo index lookups by result: hit, not_modified, miss or error",
+	}, []string{"result"})
+
+	// helmIndexCache is shared by all the Helm repo subscriptions
+	helmIndexCache = newIndexCache(filepath.Join(os.TempDir(), "helm-index-cache"))
+)
+
+func init() {
+	metrics.Registry.MustRegister(indexCacheTracker)
+}
+
+// indexCache keeps the parsed index files of Helm repos in memory and on disk. Index files are fetched again with
+// conditional requests so that an unchanged index is neither downloaded nor parsed.
+type indexCache struct {
+	dir       string
+	freshness time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*indexCacheEntry
+}
+
+type indexCacheEntry struct {
+	// mu serializes the fetches of the same index
+	mu sync.Mutex
+
+	meta    indexCacheMeta
+	index   *repo.IndexFile
+	fetched time.Time
+}
+
+// indexCacheMeta is saved next to the index file on disk
+type indexCacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Hash         string `json:"hash"`
+}
+
+func newIndexCache(dir string) *indexCache {
+	return &indexCache{dir: dir, freshness: indexCacheFreshness, entries: map[string]*indexCacheEntry{}}
+}
+
+// cacheKey identifies an index by its URL and the credentials used to get it. clientCert is the TLS client
+// certificate presented with the request, if any.
+func cacheKey(req *http.Request, clientCert []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(req.URL.String()+"\n"+req.Header.Get("Authorization")+"\n"+string(clientCert))))
+}
+
+func (c *indexCache) entry(key string) *indexCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &indexCacheEntry{}
+		c.entries[key] = e
+	}
+
+	return e
+}
+
+// get returns the parsed index file of the request and the hash of its content. The returned index file is shared
+// and must not be modified.
+func (c *indexCache) get(client rest.HTTPClient, req *http.Request, clientCert []byte) (*repo.IndexFile, string, error) {
+	key := cacheKey(req, clientCert)
+	e := c.entry(key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.index != nil && time.Since(e.fetched) < c.freshness {
+		klog.V(2).Info("Helm repo index cache hit: ", req.URL.String())
+		indexCacheTracker.WithLabelValues(indexCacheHit).Inc()
+
+		return e.index, e.meta.Hash, nil
+	}
+
+	if e.index == nil {
+		c.load(key, e)
+	}
+
+	if e.index != nil {
+		if e.meta.ETag != "" {
+			req.Header.Set("If-None-Match", e.meta.ETag)
+		}
+
+		if e.meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", e.meta.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		klog.Error(err, "Http request failed: ", req.URL.String())
+		indexCacheTracker.WithLabelValues(indexCacheError).Inc()
+
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && e.index != nil {
+		klog.V(2).Info("Helm repo index not modified: ", req.URL.String())
+		indexCacheTracker.WithLabelValues(indexCacheNotModified).Inc()
+
+		e.fetched = time.Now()
+
+		return e.index, e.meta.Hash, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		indexCacheTracker.WithLabelValues(indexCacheError).Inc()
+
+		return nil, "", fmt.Errorf("failed to get %s, status: %s", req.URL.String(), resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		klog.Error(err, "Unable to read body: ", req.URL.String())
+		indexCacheTracker.WithLabelValues(indexCacheError).Inc()
+
+		return nil, "", err
+	}
+
+	index, err := loadIndex(body)
+	if err != nil {
+		klog.Error(err, "Unable to parse the indexfile: ", req.URL.String())
+		indexCacheTracker.WithLabelValues(indexCacheError).Inc()
+
+		return nil, "", err
+	}
+
+	klog.V(2).Info("Helm repo index cache miss: ", req.URL.String())
+	indexCacheTracker.WithLabelValues(indexCacheMiss).Inc()
+
+	e.index = index
+	e.fetched = time.Now()
+	e.meta = indexCacheMeta{
+		URL:          req.URL.String(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Hash:         hashKey(body),
+	}
+
+	c.save(key, e, body)
+
+	return e.index, e.meta.Hash, nil
+}
+
+// load reads the index file saved by a previous run. A missing or broken file is ignored.
+func (c *indexCache) load(key string, e *indexCacheEntry) {
+	metaBytes, err := ioutil.ReadFile(filepath.Join(c.dir, key+".json")) // #nosec G304 the file name is a hash
+	if err != nil {
+		return
+	}
+
+	meta := indexCacheMeta{}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil || (meta.ETag == "" && meta.LastModified == "") {
+		return
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(c.dir, key+".yaml")) // #nosec G304 the file name is a hash
+	if err != nil || hashKey(body) != meta.Hash {
+		return
+	}
+
+	index, err := loadIndex(body)
+	if err != nil {
+		return
+	}
+
+	klog.V(2).Info("Loaded the cached Helm repo index of ", meta.URL)
+
+	e.meta = meta
+	e.index = index
+}
+
+// save writes the index file to disk to be reused after a restart. Only index files that can be validated with a
+// conditional request are saved.
+func (c *indexCache) save(key string, e *indexCacheEntry, body []byte) {
+	if e.meta.ETag == "" && e.meta.LastModified == "" {
+		return
+	}
+
+	metaBytes, err := json.Marshal(e.meta)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		klog.Warning("Failed to create the Helm repo index cache directory, err: ", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(c.dir, key+".yaml"), body, 0600); err != nil {
+		klog.Warning("Failed to save the Helm repo index, err: ", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(c.dir, key+".json"), metaBytes, 0600); err != nil {
+		klog.Warning("Failed to save the Helm repo index, err: ", err)
+	}
+}
+
+// copyIndexEntries returns an index file with copies of the shared index entries that the subscription may select,
+// so that filtering and deploying them never changes the shared index
+func copyIndexEntries(index *repo.IndexFile, packageName string) *repo.IndexFile {
+	copied := &repo.IndexFile{
+		APIVersion: index.APIVersion,
+		Generated:  index.Generated,
+		Entries:    map[string]repo.ChartVersions{},
+		PublicKeys: index.PublicKeys,
+	}
+
+	for name, chartVersions := range index.Entries {
+		if packageName != "" && name != packageName {
+			continue
+		}
+
+		copiedVersions := make(repo.ChartVersions, 0, len(chartVersions))
+
+		for _, chartVersion := range chartVersions {
+			cv := *chartVersion
+			cv.URLs = append([]string{}, chartVersion.URLs...)
+			copiedVersions = append(copiedVersions, &cv)
+		}
+
+		copied.Entries[name] = copiedVersions
+	}
+
+	return copied
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  nginx-ingress:
+  - name: nginx-ingress
+    version: 1.0.0
+    urls:
+    - local://nginx-ingress-1.0.0.tgz
+  - name: nginx-ingress
+    version: 1.1.0
+    urls:
+    - local://nginx-ingress-1.1.0.tgz
+  redis:
+  - name: redis
+    version: 2.0.0
+    urls:
+    - local://redis-2.0.0.tgz
+`
+
+func newIndexRequest(g *gomega.GomegaWithT, url string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, url+"/index.yaml", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return req
+}
+
+func TestIndexCache(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	var mu sync.Mutex
+
+	index := testIndex
+	etag := `"v1"`
+	downloads := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads++
+
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(index))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "helm-index-cache")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	cache := newIndexCache(dir)
+	client := server.Client()
+
+	hits := testutil.ToFloat64(indexCacheTracker.WithLabelValues(indexCacheHit))
+	notModified := testutil.ToFloat64(indexCacheTracker.WithLabelValues(indexCacheNotModified))
+
+	index1, hash1, err := cache.get(client, newIndexRequest(g, server.URL), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(index1.Entries).To(gomega.HaveLen(2))
+
+	// Subscriptions polling at the same time share the fetched index
+	index2, hash2, err := cache.get(client, newIndexRequest(g, server.URL), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(index2).To(gomega.BeIdenticalTo(index1))
+	g.Expect(hash2).To(gomega.Equal(hash1))
+	g.Expect(testutil.ToFloat64(indexCacheTracker.WithLabelValues(indexCacheHit))).To(gomega.Equal(hits + 1))
+
+	// An unchanged index is not downloaded again
+	cache.freshness = 0
+
+	index2, _, err = cache.get(client, newIndexRequest(g, server.URL), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(index2).To(gomega.BeIdenticalTo(index1))
+	g.Expect(downloads).To(gomega.Equal(1))
+	g.Expect(testutil.ToFloat64(indexCacheTracker.WithLabelValues(indexCacheNotModified))).To(gomega.Equal(notModified + 1))
+
+	// The index saved on disk is used after a restart
+	index2, hash2, err = newIndexCache(dir).get(client, newIndexRequest(g, server.URL), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(index2.Entries).To(gomega.HaveLen(2))
+	g.Expect(hash2).To(gomega.Equal(hash1))
+	g.Expect(downloads).To(gomega.Equal(1))
+
+	// A changed index is downloaded
+	mu.Lock()
+	index = testIndex + "  mysql:\n  - name: mysql\n    version: 3.0.0\n"
+	etag = `"v2"`
+	mu.Unlock()
+
+	index2, hash2, err = cache.get(client, newIndexRequest(g, server.URL), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(index2.Entries).To(gomega.HaveLen(3))
+	g.Expect(hash2).NotTo(gomega.Equal(hash1))
+	g.Expect(downloads).To(gomega.Equal(2))
+
+	// Requests with other credentials are cached separately
+	req := newIndexRequest(g, server.URL)
+	req.SetBasicAuth("user", "password")
+
+	_, _, err = cache.get(client, req, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(downloads).To(gomega.Equal(3))
+
+	// Requests with a TLS client certificate are cached separately
+	_, _, err = cache.get(client, newIndexRequest(g, server.URL), []byte("client certificate"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(downloads).To(gomega.Equal(4))
+}
+
+func TestCopyIndexEntries(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	index, err := loadIndex([]byte(testIndex))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	copied := copyIndexEntries(index, "nginx-ingress")
+	g.Expect(copied.Entries).To(gomega.HaveLen(1))
+	g.Expect(copied.Entries["nginx-ingress"]).To(gomega.HaveLen(2))
+
+	copied.Entries["nginx-ingress"][0].URLs[0] = "https://example.com/nginx-ingress.tgz"
+	copied.Entries["nginx-ingress"] = repo.ChartVersions{}
+
+	g.Expect(index.Entries["nginx-ingress"]).To(gomega.HaveLen(2))
+	g.Expect(index.Entries["nginx-ingress"][0].URLs[0]).To(gomega.HavePrefix("local://"))
+
+	g.Expect(copyIndexEntries(index, "").Entries).To(gomega.HaveLen(2))
+}
@@ -0,0 +1,360 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmrepo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+// verifiedChart is the verification result of a chart archive kept in the charts directory of a subscription
+type verifiedChart struct {
+	result  appv1.ChartVerificationStatus
+	keyring [sha256.Size]byte
+}
+
+var (
+	// verifiedCharts caches the verification results by the path of the verified archive. The path holds the
+	// digest of the archive, so a chart is only downloaded and verified again when its digest changes.
+	verifiedCharts     = map[string]*verifiedChart{}
+	verifiedChartsLock sync.Mutex
+)
+
+// getChartVerificationPolicy returns the chart verification policy of the subscription
+func getChartVerificationPolicy(sub *appv1.Subscription) (string, error) {
+	policy := sub.GetAnnotations()[appv1.AnnotationChartVerification]
+
+	switch policy {
+	case "", appv1.ChartVerificationNone:
+		return appv1.ChartVerificationNone, nil
+	case appv1.ChartVerificationWarn, appv1.ChartVerificationIfSigned, appv1.ChartVerificationRequired:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown chart verification policy %s. It must be one of %s, %s, %s or %s", policy,
+			appv1.ChartVerificationNone, appv1.ChartVerificationWarn, appv1.ChartVerificationIfSigned, appv1.ChartVerificationRequired)
+	}
+}
+
+// isChartRefused returns true if the chart verification policy does not allow deploying a chart with the result
+func isChartRefused(policy string, result *appv1.ChartVerificationStatus) bool {
+	switch policy {
+	case appv1.ChartVerificationIfSigned:
+		return result.Result == appv1.ChartVerificationFailed
+	case appv1.ChartVerificationRequired:
+		return result.Result != appv1.ChartVerified
+	default:
+		return false
+	}
+}
+
+// resolveChartURL returns the absolute URL of a chart archive listed in the index of the Helm repo
+func resolveChartURL(repoURL, chartURL string) (string, error) {
+	if strings.HasPrefix(chartURL, "local://") {
+		return strings.Replace(chartURL, "local://", strings.TrimSuffix(repoURL, "/")+"/", 1), nil
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(chartURL)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// loadKeyring reads the binary or ASCII armored GnuPG public keyring of the channel secret
+func loadKeyring(chnSrt *corev1.Secret) (openpgp.EntityList, error) {
+	if chnSrt == nil || len(chnSrt.Data[appv1.ChannelKeyringData]) == 0 {
+		return nil, fmt.Errorf("the channel secret has no %s to verify Helm charts with", appv1.ChannelKeyringData)
+	}
+
+	data := chnSrt.Data[appv1.ChannelKeyringData]
+
+	if keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err == nil {
+		return keyring, nil
+	}
+
+	keyring, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the keyring of the channel secret, err: %w", err)
+	}
+
+	return keyring, nil
+}
+
+// downloadFromHelmRepo returns the content at the URL. It returns false if there is nothing at the URL.
+func downloadFromHelmRepo(client rest.HTTPClient, chnSrt *corev1.Secret, fileURL string) ([]byte, bool, error) {
+	req, err := newHelmRepoRequest(fileURL, chnSrt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("failed to get %s, status: %s", fileURL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return body, true, nil
+}
+
+// keyringSum returns the hash of the keyring of the channel secret
+func keyringSum(chnSrt *corev1.Secret) [sha256.Size]byte {
+	if chnSrt == nil {
+		return sha256.Sum256(nil)
+	}
+
+	return sha256.Sum256(chnSrt.Data[appv1.ChannelKeyringData])
+}
+
+// getVerifiedChart returns the cached verification result of the archive if it was verified with the same keyring
+// and is still in the charts directory
+func getVerifiedChart(archivePath string, keyring [sha256.Size]byte) *appv1.ChartVerificationStatus {
+	verifiedChartsLock.Lock()
+	cached, ok := verifiedCharts[archivePath]
+	verifiedChartsLock.Unlock()
+
+	if !ok || cached.keyring != keyring {
+		return nil
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		return nil
+	}
+
+	result := cached.result
+
+	return &result
+}
+
+// removeChartArchives removes the verified archives of the charts directory that are not kept, with their cached
+// verification results
+func removeChartArchives(archiveDir string, keep map[string]bool) {
+	keepDirs := make(map[string]bool)
+	for archivePath := range keep {
+		keepDirs[filepath.Dir(archivePath)] = true
+	}
+
+	verifiedChartsLock.Lock()
+	for archivePath := range verifiedCharts {
+		if filepath.Dir(filepath.Dir(archivePath)) == archiveDir && !keep[archivePath] {
+			delete(verifiedCharts, archivePath)
+		}
+	}
+	verifiedChartsLock.Unlock()
+
+	entries, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(archiveDir, entry.Name())
+
+		if keepDirs[dir] {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			klog.Error("Failed to remove Helm chart directory ", dir, " err:", err)
+		}
+	}
+}
+
+// pinChartArchive returns the chart versions with the URL of the verified archive, so the HelmRelease installs
+// the archive that was verified and not the one the Helm repo serves at install time
+func pinChartArchive(chartVersions []*repo.ChartVersion, archivePath string) []*repo.ChartVersion {
+	pinned := *chartVersions[0]
+	pinned.URLs = []string{"file://localhost" + filepath.ToSlash(archivePath)}
+
+	return append([]*repo.ChartVersion{&pinned}, chartVersions[1:]...)
+}
+
+// verifyChart downloads the chart archive and its provenance file and verifies them with the keyring of the
+// channel secret. It returns the path of the verified archive, kept in the archive directory by its digest.
+func verifyChart(client rest.HTTPClient, chnSrt *corev1.Secret, repoURL string, chartVersion *repo.ChartVersion,
+	archiveDir string) (*appv1.ChartVerificationStatus, string) {
+	result := &appv1.ChartVerificationStatus{Chart: chartVersion.Name + "-" + chartVersion.Version}
+
+	failed := func(err error) (*appv1.ChartVerificationStatus, string) {
+		klog.Warningf("Chart %s failed the verification, err: %v", result.Chart, err)
+
+		result.Result = appv1.ChartVerificationFailed
+		result.Message = err.Error()
+
+		return result, ""
+	}
+
+	if len(chartVersion.URLs) == 0 {
+		return failed(errors.New("the chart has no URL"))
+	}
+
+	chartURL, err := resolveChartURL(repoURL, chartVersion.URLs[0])
+	if err != nil {
+		return failed(err)
+	}
+
+	// The provenance file holds the digest of the archive by its file name
+	parsedURL, err := url.Parse(chartURL)
+	if err != nil {
+		return failed(err)
+	}
+
+	archiveName := path.Base(parsedURL.Path)
+	keyring := keyringSum(chnSrt)
+
+	if chartVersion.Digest != "" {
+		if cached := getVerifiedChart(filepath.Join(archiveDir, chartVersion.Digest, archiveName), keyring); cached != nil {
+			klog.V(5).Infof("Chart %s with digest %s is already verified", result.Chart, chartVersion.Digest)
+
+			return cached, filepath.Join(archiveDir, chartVersion.Digest, archiveName)
+		}
+	}
+
+	prov, found, err := downloadFromHelmRepo(client, chnSrt, chartURL+".prov")
+	if err != nil {
+		return failed(err)
+	}
+
+	if !found {
+		klog.Infof("Chart %s has no provenance file", result.Chart)
+
+		result.Result = appv1.ChartUnsigned
+		result.Message = "no provenance file at " + chartURL + ".prov"
+
+		return result, ""
+	}
+
+	keyRing, err := loadKeyring(chnSrt)
+	if err != nil {
+		return failed(err)
+	}
+
+	archive, found, err := downloadFromHelmRepo(client, chnSrt, chartURL)
+	if err != nil {
+		return failed(err)
+	}
+
+	if !found {
+		return failed(fmt.Errorf("no chart archive at %s", chartURL))
+	}
+
+	// The archive is verified next to the charts directory, so the verified file can be moved in place
+	if err := os.MkdirAll(archiveDir, 0700); err != nil {
+		return failed(err)
+	}
+
+	dir, err := ioutil.TempDir(archiveDir, ".verification")
+	if err != nil {
+		return failed(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, archiveName)
+
+	if err := ioutil.WriteFile(archivePath, archive, 0600); err != nil {
+		return failed(err)
+	}
+
+	if err := ioutil.WriteFile(archivePath+".prov", prov, 0600); err != nil {
+		return failed(err)
+	}
+
+	signatory := &provenance.Signatory{KeyRing: keyRing}
+
+	verification, err := signatory.Verify(archivePath, archivePath+".prov")
+	if err != nil {
+		return failed(err)
+	}
+
+	// The verified archive must be the one listed in the index
+	if chartVersion.Digest != "" && verification.FileHash != "sha256:"+chartVersion.Digest {
+		return failed(fmt.Errorf("the chart archive digest %s does not match the digest %s in the index",
+			verification.FileHash, chartVersion.Digest))
+	}
+
+	signers := []string{}
+	for identity := range verification.SignedBy.Identities {
+		signers = append(signers, identity)
+	}
+
+	sort.Strings(signers)
+
+	verifiedDir := filepath.Join(archiveDir, strings.TrimPrefix(verification.FileHash, "sha256:"))
+	verifiedPath := filepath.Join(verifiedDir, archiveName)
+
+	if err := os.RemoveAll(verifiedDir); err != nil {
+		return failed(err)
+	}
+
+	if err := os.Rename(dir, verifiedDir); err != nil {
+		return failed(err)
+	}
+
+	if err := os.Remove(verifiedPath + ".prov"); err != nil {
+		klog.Warning("Failed to remove the provenance file ", verifiedPath+".prov", " err:", err)
+	}
+
+	klog.Infof("Chart %s is verified. Signed by %v", result.Chart, signers)
+
+	result.Result = appv1.ChartVerified
+	result.SignedBy = strings.Join(signers, ", ")
+	result.FileHash = verification.FileHash
+
+	verifiedChartsLock.Lock()
+	verifiedCharts[verifiedPath] = &verifiedChart{result: *result, keyring: keyring}
+	verifiedChartsLock.Unlock()
+
+	return result, verifiedPath
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmrepo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func newTestKeyring(g *gomega.GomegaWithT, name string) (*openpgp.Entity, []byte) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(entity.Serialize(w)).To(gomega.Succeed())
+	g.Expect(w.Close()).To(gomega.Succeed())
+
+	return entity, buf.Bytes()
+}
+
+func TestVerifyChart(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "provenance")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	archivePath, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "signed", Version: "0.1.0"},
+	}, dir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	signer, keyring := newTestKeyring(g, "signer")
+	_, otherKeyring := newTestKeyring(g, "other")
+
+	signature, err := (&provenance.Signatory{Entity: signer}).ClearSign(archivePath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	archive, err := ioutil.ReadFile(archivePath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	digest, err := provenance.DigestFile(archivePath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	files := map[string][]byte{
+		"/charts/signed-0.1.0.tgz":      archive,
+		"/charts/signed-0.1.0.tgz.prov": []byte(signature),
+		"/charts/unsigned-0.1.0.tgz":    archive,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{Data: map[string][]byte{"authHeader": []byte("Bearer token"), appv1.ChannelKeyringData: keyring}}
+	signed := &repo.ChartVersion{
+		Metadata: &chart.Metadata{Name: "signed", Version: "0.1.0"},
+		URLs:     []string{"charts/signed-0.1.0.tgz"},
+		Digest:   digest,
+	}
+
+	chartsDir := filepath.Join(dir, "charts")
+	requests := 0
+
+	result, verified := verifyChart(server.Client(), secret, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerified), result.Message)
+	g.Expect(result.Chart).To(gomega.Equal("signed-0.1.0"))
+	g.Expect(result.SignedBy).To(gomega.ContainSubstring("signer@example.com"))
+	g.Expect(result.FileHash).To(gomega.Equal("sha256:" + digest))
+
+	// The verified archive is kept by its digest and pinned in the chart version
+	g.Expect(verified).To(gomega.Equal(filepath.Join(chartsDir, digest, "signed-0.1.0.tgz")))
+	g.Expect(ioutil.ReadFile(verified)).To(gomega.Equal(archive))
+	g.Expect(pinChartArchive([]*repo.ChartVersion{signed}, verified)[0].URLs).To(gomega.Equal([]string{"file://localhost" + verified}))
+	g.Expect(signed.URLs).To(gomega.Equal([]string{"charts/signed-0.1.0.tgz"}))
+
+	// A chart with the same digest is not downloaded again
+	counting := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		counting.ServeHTTP(w, r)
+	})
+
+	result, cached := verifyChart(server.Client(), secret, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerified))
+	g.Expect(cached).To(gomega.Equal(verified))
+	g.Expect(requests).To(gomega.Equal(0))
+
+	// The archives of charts that are no longer deployed are removed
+	removeChartArchives(chartsDir, map[string]bool{verified: true})
+	g.Expect(verified).To(gomega.BeARegularFile())
+
+	removeChartArchives(chartsDir, nil)
+	g.Expect(verified).NotTo(gomega.BeAnExistingFile())
+
+	result, _ = verifyChart(server.Client(), secret, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerified))
+	g.Expect(requests).To(gomega.Equal(2))
+
+	// A chart without provenance file is unsigned
+	unsigned := &repo.ChartVersion{
+		Metadata: &chart.Metadata{Name: "unsigned", Version: "0.1.0"},
+		URLs:     []string{"local://charts/unsigned-0.1.0.tgz"},
+	}
+
+	result, verified = verifyChart(server.Client(), secret, server.URL, unsigned, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartUnsigned))
+	g.Expect(verified).To(gomega.BeEmpty())
+
+	// A chart signed with a key that is not in the keyring fails
+	result, verified = verifyChart(server.Client(), &corev1.Secret{Data: map[string][]byte{
+		"authHeader": []byte("Bearer token"), appv1.ChannelKeyringData: otherKeyring}}, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerificationFailed))
+	g.Expect(verified).To(gomega.BeEmpty())
+
+	// Without keyring, a signed chart can not be verified
+	result, _ = verifyChart(server.Client(), &corev1.Secret{Data: map[string][]byte{"authHeader": []byte("Bearer token")}},
+		server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerificationFailed))
+	g.Expect(result.Message).To(gomega.ContainSubstring("keyring"))
+
+	// A tampered chart archive fails
+	removeChartArchives(chartsDir, nil)
+
+	files["/charts/signed-0.1.0.tgz"] = append([]byte{}, archive[:len(archive)-1]...)
+
+	result, _ = verifyChart(server.Client(), secret, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerificationFailed))
+	g.Expect(result.Message).To(gomega.ContainSubstring("sha256 sum does not match"))
+
+	// A signed archive that is not the one in the index fails
+	files["/charts/signed-0.1.0.tgz"] = archive
+	signed.Digest = strings.Repeat("0", 64)
+
+	result, _ = verifyChart(server.Client(), secret, server.URL, signed, chartsDir)
+	g.Expect(result.Result).To(gomega.Equal(appv1.ChartVerificationFailed))
+	g.Expect(result.Message).To(gomega.ContainSubstring("does not match the digest"))
+
+	g.Expect(filepath.Base(archivePath)).To(gomega.Equal("signed-0.1.0.tgz"))
+}
+
+func TestChartVerificationPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "default"}}
+
+	policy, err := getChartVerificationPolicy(sub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(policy).To(gomega.Equal(appv1.ChartVerificationNone))
+
+	sub.SetAnnotations(map[string]string{appv1.AnnotationChartVerification: "sometimes"})
+	_, err = getChartVerificationPolicy(sub)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	verified := &appv1.ChartVerificationStatus{Result: appv1.ChartVerified}
+	unsigned := &appv1.ChartVerificationStatus{Result: appv1.ChartUnsigned}
+	failed := &appv1.ChartVerificationStatus{Result: appv1.ChartVerificationFailed}
+
+	for _, policy := range []string{appv1.ChartVerificationWarn, appv1.ChartVerificationIfSigned, appv1.ChartVerificationRequired} {
+		g.Expect(isChartRefused(policy, verified)).To(gomega.BeFalse())
+	}
+
+	g.Expect(isChartRefused(appv1.ChartVerificationWarn, unsigned)).To(gomega.BeFalse())
+	g.Expect(isChartRefused(appv1.ChartVerificationWarn, failed)).To(gomega.BeFalse())
+	g.Expect(isChartRefused(appv1.ChartVerificationIfSigned, unsigned)).To(gomega.BeFalse())
+	g.Expect(isChartRefused(appv1.ChartVerificationIfSigned, failed)).To(gomega.BeTrue())
+	g.Expect(isChartRefused(appv1.ChartVerificationRequired, unsigned)).To(gomega.BeTrue())
+	g.Expect(isChartRefused(appv1.ChartVerificationRequired, failed)).To(gomega.BeTrue())
+}
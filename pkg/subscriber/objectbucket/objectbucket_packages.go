@@ -0,0 +1,400 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectbucket
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog"
+
+	dplv1 "github.com/open-cluster-management/multicloud-operators-deployable/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	kubesynchronizer "github.com/open-cluster-management/multicloud-operators-subscription/pkg/synchronizer/kubernetes"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+	awsutils "github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils/aws"
+)
+
+// maxKustomizeArchiveSize limits the size of the files extracted from a kustomize archive
+const maxKustomizeArchiveSize = 100 << 20
+
+// kustomizationFiles are the file names kustomize looks for in a kustomization directory
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// isChartArchive tells if an object is a packaged Helm chart
+func isChartArchive(key string) bool {
+	key = strings.ToLower(key)
+
+	return strings.HasSuffix(key, ".tgz") || strings.HasSuffix(key, ".tar.gz")
+}
+
+// isKustomizeArchive tells if an object is a zipped kustomize directory
+func isKustomizeArchive(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), ".zip")
+}
+
+// newBucketObject converts the content of a listed object to deployables, or to a chart version for Helm charts.
+// Invalid content gives an object without deployables. An error is only returned when the chart archive can not be
+// saved.
+func (obsi *SubscriberItem) newBucketObject(info awsutils.ObjectInfo, tplb awsutils.DeployableObject) (*bucketObject, error) {
+	obj := &bucketObject{info: info}
+
+	// skip empty body object store
+	if len(tplb.Content) == 0 {
+		return obj, nil
+	}
+
+	switch {
+	case isChartArchive(info.Key):
+		chartVersion, err := loadChartArchive(tplb.Content)
+		if err != nil {
+			klog.Error("Failed to load Helm chart ", obsi.bucket, "/", info.Key, " err:", err)
+
+			return obj, nil
+		}
+
+		chartVersion.Created = info.LastModified
+
+		if err := obsi.saveChartArchive(obj, chartVersion, tplb.Content); err != nil {
+			return nil, err
+		}
+	case isKustomizeArchive(info.Key):
+		dpls, err := kustomizeDeployables(obsi.bucket, info.Key, tplb, obsi.Subscription.Spec.PackageOverrides)
+		if err != nil {
+			klog.Error("Failed to apply kustomization ", obsi.bucket, "/", info.Key, " err:", err)
+
+			return obj, nil
+		}
+
+		obj.dpls = dpls
+	default:
+		obj.dpls = newDeployables(obsi.bucket, info.Key, tplb, utils.ParseKubeResoures(tplb.Content))
+	}
+
+	return obj, nil
+}
+
+// newDeployables converts the resources of an object to deployables. The deployable of an object with a single
+// resource is named after the object key, the deployables of several resources after the key, kind and name.
+func newDeployables(bucket, key string, tplb awsutils.DeployableObject, resources [][]byte) []*dplv1.Deployable {
+	var dpls []*dplv1.Deployable
+
+	for _, resource := range resources {
+		name := generateDplNameFromKey(key)
+
+		if len(resources) > 1 {
+			rsc := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal(resource, rsc); err != nil {
+				klog.Error("Failed to unmashall ", bucket, "/", key, " err:", err)
+
+				continue
+			}
+
+			name += "-" + rsc.GetKind() + "-" + rsc.GetName()
+		}
+
+		if dpl := newDeployable(bucket, name, tplb.GenerateName, tplb.Version, resource); dpl != nil {
+			dpls = append(dpls, dpl)
+		}
+	}
+
+	return dpls
+}
+
+// loadChartArchive returns the chart version of a packaged Helm chart, without URL
+func loadChartArchive(content []byte) (*repo.ChartVersion, error) {
+	chart, err := loader.LoadArchive(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chart.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &repo.ChartVersion{
+		Metadata: chart.Metadata,
+		Digest:   fmt.Sprintf("%x", sha256.Sum256(content)),
+	}, nil
+}
+
+// chartsDir returns the directory of the Helm charts of the bucket. The HelmRelease controller runs in the same
+// process and reads the charts from this directory, without the object store credentials.
+func (obsi *SubscriberItem) chartsDir() string {
+	return filepath.Join(os.TempDir(), "objectbucket-charts", obsi.Subscription.Namespace, obsi.Subscription.Name)
+}
+
+// saveChartArchive writes the chart archive of an object to the charts directory and sets the chart version of the
+// object with the file URL of the archive
+func (obsi *SubscriberItem) saveChartArchive(obj *bucketObject, chartVersion *repo.ChartVersion, content []byte) error {
+	// Object keys are hashed to give a directory per object without escaping the charts directory
+	dir := filepath.Join(obsi.chartsDir(), fmt.Sprintf("%x", sha256.Sum256([]byte(obj.info.Key)))[:16])
+	file := filepath.Join(dir, chartVersion.Name+"-"+chartVersion.Version+".tgz")
+
+	if err := os.RemoveAll(dir); err != nil {
+		klog.Error("Failed to remove Helm chart directory ", dir, " err:", err)
+
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		klog.Error("Failed to create Helm chart directory ", dir, " err:", err)
+
+		return err
+	}
+
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		klog.Error("Failed to save Helm chart ", file, " err:", err)
+
+		return err
+	}
+
+	chartVersion.URLs = []string{"file://localhost" + filepath.ToSlash(file)}
+	obj.chart = chartVersion
+	obj.chartDir = dir
+
+	return nil
+}
+
+// removeChartArchive deletes the saved chart archive of an object
+func removeChartArchive(obj *bucketObject) {
+	if obj.chartDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(obj.chartDir); err != nil {
+		klog.Error("Failed to remove Helm chart directory ", obj.chartDir, " err:", err)
+	}
+}
+
+// getChartIndex returns a Helm repo index of the charts of the listed objects
+func (obsi *SubscriberItem) getChartIndex() *repo.IndexFile {
+	indexFile := repo.NewIndexFile()
+
+	for _, obj := range obsi.objects {
+		if obj.chart == nil {
+			continue
+		}
+
+		// The index is filtered for the subscription, the cached chart versions are not changed
+		chartVersion := *obj.chart
+		chartVersion.URLs = append([]string{}, obj.chart.URLs...)
+
+		indexFile.Entries[chartVersion.Name] = append(indexFile.Entries[chartVersion.Name], &chartVersion)
+	}
+
+	indexFile.SortEntries()
+
+	return indexFile
+}
+
+// subscribeHelmCharts returns the HelmRelease units of the charts in the bucket selected by the subscription
+func (obsi *SubscriberItem) subscribeHelmCharts(indexFile *repo.IndexFile) ([]kubesynchronizer.DplUnit, error) {
+	if len(indexFile.Entries) == 0 {
+		return nil, nil
+	}
+
+	if err := utils.FilterCharts(obsi.Subscription, indexFile); err != nil {
+		return nil, err
+	}
+
+	chartReleases, err := utils.GetChartReleases(obsi.Subscription, indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The charts are read from the charts directory, the channel secret and config map are object store settings
+	channel := obsi.Channel.DeepCopy()
+	channel.Spec.SecretRef = nil
+	channel.Spec.ConfigMapRef = nil
+
+	var units []kubesynchronizer.DplUnit
+
+	for _, chartRelease := range chartReleases {
+		dpl, err := utils.CreateHelmCRDeployable("", chartRelease.PackageName, chartRelease.VersionKey,
+			chartRelease.ChartVersions, obsi.synchronizer.GetLocalClient(), channel, obsi.Subscription)
+		if err != nil {
+			klog.Error("Failed to create a helmrelease CR deployable, err: ", err)
+
+			return nil, err
+		}
+
+		units = append(units, kubesynchronizer.DplUnit{Dpl: dpl, Gvk: helmGvk})
+	}
+
+	return units, nil
+}
+
+// kustomizeDeployables extracts a zipped kustomize directory and returns the deployables of the kustomize build
+// output of its top level kustomizations
+func kustomizeDeployables(bucket, key string, tplb awsutils.DeployableObject, overrides []*appv1.Overrides) ([]*dplv1.Deployable, error) {
+	dir, err := ioutil.TempDir("", "objectbucket-kustomize")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := extractZip(tplb.Content, dir); err != nil {
+		return nil, err
+	}
+
+	kustomizeDirs, err := findKustomizeDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kustomizeDirs) == 0 {
+		return nil, fmt.Errorf("no kustomization in archive %s", key)
+	}
+
+	var resources [][]byte
+
+	for _, kustomizeDir := range kustomizeDirs {
+		relativePath, err := filepath.Rel(dir, kustomizeDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if relativePath == "." {
+			relativePath = ""
+		}
+
+		klog.Info("Applying kustomization ", filepath.ToSlash(filepath.Join(key, relativePath)))
+
+		utils.VerifyAndOverrideKustomize(overrides, filepath.ToSlash(relativePath), kustomizeDir)
+
+		out, err := utils.RunKustomizeBuild(kustomizeDir)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, utils.ParseKubeResoures(out)...)
+	}
+
+	return newDeployables(bucket, key, tplb, resources), nil
+}
+
+// extractZip extracts the regular files of a zip archive in a directory
+func extractZip(content []byte, dir string) error {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+
+	var size int64
+
+	for _, f := range reader.File {
+		file := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return fmt.Errorf("invalid file name %q in archive", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(file, 0750); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Symbolic links could point out of the directory
+		if !f.Mode().IsRegular() {
+			klog.V(1).Info("Skipping archive file ", f.Name)
+
+			continue
+		}
+
+		data, err := readZipFile(f, maxKustomizeArchiveSize-size)
+		if err != nil {
+			return err
+		}
+
+		size += int64(len(data))
+
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readZipFile reads a file of a zip archive up to a limit
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("the archive files exceed %d bytes", int64(maxKustomizeArchiveSize))
+	}
+
+	return data, nil
+}
+
+// findKustomizeDirs returns the directories with a kustomization that are not in another kustomization directory
+func findKustomizeDirs(dir string) ([]string, error) {
+	var kustomizeDirs []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		for _, name := range kustomizationFiles {
+			if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+				kustomizeDirs = append(kustomizeDirs, path)
+
+				// The nested kustomizations are built by the top level kustomization
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(kustomizeDirs)
+
+	return kustomizeDirs, err
+}
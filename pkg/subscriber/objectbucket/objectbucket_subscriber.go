@@ -108,7 +108,16 @@ func (obs *Subscriber) SubscribeItem(subitem *appv1alpha1.SubscriberItem) error
 
 	obssubitem.successful = false
 
-	err := obssubitem.Start()
+	// If manual sync time is updated, sync the bucket immediately
+	previousSyncTime := obssubitem.syncTime
+	obssubitem.syncTime = obssubitem.Subscription.GetAnnotations()[appv1alpha1.AnnotationManualReconcileTime]
+
+	restart := previousSyncTime != obssubitem.syncTime
+	if restart {
+		klog.Infof("Manual reconcile time has changed from %s to %s. restart to reconcile resources", previousSyncTime, obssubitem.syncTime)
+	}
+
+	err := obssubitem.Start(restart)
 
 	return err
 }
@@ -17,10 +17,14 @@ package objectbucket
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ghodss/yaml"
+	"helm.sh/helm/v3/pkg/repo"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -41,6 +45,8 @@ import (
 
 var SubscriptionGVK = schema.GroupVersionKind{Group: "apps.open-cluster-management.io", Kind: "Subscription", Version: "v1"}
 
+var helmGvk = schema.GroupVersionKind{Group: appv1.SchemeGroupVersion.Group, Kind: "HelmRelease", Version: appv1.SchemeGroupVersion.Version}
+
 // SubscriberItem - defines the unit of namespace subscription.
 type SubscriberItem struct {
 	appv1.SubscriberItem
@@ -51,10 +57,26 @@ type SubscriberItem struct {
 	successful   bool
 	syncinterval int
 	synchronizer SyncSource
+	// syncTime is the manual refresh time of the subscription, the bucket is synced immediately when it changes
+	syncTime string
+	// objects are the objects of the last bucket listing by key, they are downloaded again only when they change
+	objects map[string]*bucketObject
+	// objectsSource identifies the channel path, bucket folder, snapshot and package overrides of the objects
+	objectsSource string
+}
+
+// bucketObject is a listed object with the deployables or the Helm chart of its content
+type bucketObject struct {
+	info awsutils.ObjectInfo
+	// dpls are the deployables of the resources of YAML objects and kustomize archives, none for invalid content
+	dpls []*dplv1.Deployable
+	// chart is the chart version of a Helm chart archive, its URL is the archive saved in chartDir
+	chart    *repo.ChartVersion
+	chartDir string
 }
 
 // SubscribeItem subscribes a subscriber item with namespace channel.
-func (obsi *SubscriberItem) Start() error {
+func (obsi *SubscriberItem) Start(restart bool) error {
 	err := obsi.initObjectStore()
 
 	// If the new object store connection status (successful or failed) is different, return for updating the appsub status
@@ -73,7 +95,14 @@ func (obsi *SubscriberItem) Start() error {
 
 	// do nothing if already started
 	if obsi.stopch != nil {
-		return nil
+		if !restart {
+			return nil
+		}
+
+		// restart this goroutine to sync the bucket immediately
+		klog.Info("Restarting SubscriberItem: ", obsi.Subscription.Name)
+		close(obsi.stopch)
+		obsi.stopch = nil
 	}
 
 	obsi.stopch = make(chan struct{})
@@ -98,14 +127,15 @@ func (obsi *SubscriberItem) Start() error {
 			return
 		}
 
-		if !obsi.successful {
-			err := obsi.doSubscription()
+		// The bucket is listed on each cycle, the resources are only synchronized again when objects change
+		err := obsi.doSubscription()
 
-			if err != nil {
-				klog.Error("Object Bucket ", obsi.Subscription.Namespace, "/", obsi.Subscription.Name, "housekeeping failed with error: ", err)
-			} else {
-				obsi.successful = true
-			}
+		if err != nil {
+			klog.Error("Object Bucket ", obsi.Subscription.Namespace, "/", obsi.Subscription.Name, "housekeeping failed with error: ", err)
+
+			obsi.successful = false
+		} else {
+			obsi.successful = true
 		}
 	}, time.Duration(obsi.syncinterval)*time.Second, obsi.stopch)
 
@@ -118,6 +148,10 @@ func (obsi *SubscriberItem) Stop() {
 		close(obsi.stopch)
 		obsi.stopch = nil
 	}
+
+	if err := os.RemoveAll(obsi.chartsDir()); err != nil {
+		klog.Error("Failed to remove Helm chart directory ", obsi.chartsDir(), " err:", err)
+	}
 }
 
 // Compare current object store subscription error  with the new object store initialization error.
@@ -138,8 +172,6 @@ func (obsi *SubscriberItem) CompareOjbectStoreStatus(initObjectStoreErr error) b
 func (obsi *SubscriberItem) initObjectStore() error {
 	var err error
 
-	awshandler := &awsutils.Handler{}
-
 	pathName := obsi.Channel.Spec.Pathname
 
 	if pathName == "" {
@@ -149,14 +181,21 @@ func (obsi *SubscriberItem) initObjectStore() error {
 		return errors.New(errmsg)
 	}
 
-	if strings.HasSuffix(pathName, "/") {
-		last := len(pathName) - 1
-		pathName = pathName[:last]
+	endpoint, bucket, err := awsutils.ParseObjectBucketPath(pathName)
+	if err != nil {
+		klog.Error(err, " in channel ", obsi.Channel.Name)
+
+		return err
 	}
 
-	loc := strings.LastIndex(pathName, "/")
-	endpoint := pathName[:loc]
-	obsi.bucket = pathName[loc+1:]
+	obsi.bucket = bucket
+
+	objectStore, err := awsutils.NewObjectStore(endpoint)
+	if err != nil {
+		klog.Error(err, " in channel ", obsi.Channel.Name)
+
+		return err
+	}
 
 	accessKeyID := ""
 	secretAccessKey := ""
@@ -187,23 +226,31 @@ func (obsi *SubscriberItem) initObjectStore() error {
 				return err
 			}
 		}
+
+		if configurable, ok := objectStore.(awsutils.ConfigurableObjectStore); ok {
+			if err := configurable.SetOptions(obsi.ChannelSecret.Data); err != nil {
+				klog.Error(err, " in the secret of channel ", obsi.Channel.Name)
+
+				return err
+			}
+		}
 	}
 
 	klog.V(1).Info("Trying to connect to object bucket ", endpoint, "|", obsi.bucket)
 
-	if err := awshandler.InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region); err != nil {
+	if err := objectStore.InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region); err != nil {
 		klog.Error(err, "unable initialize object store settings")
 
 		return err
 	}
 	// Check whether the connection is setup successfully
-	if err := awshandler.Exists(obsi.bucket); err != nil {
+	if err := objectStore.Exists(obsi.bucket); err != nil {
 		klog.Error(err, "Unable to access object store bucket ", obsi.bucket, " for channel ", obsi.Channel.Name)
 
 		return err
 	}
 
-	obsi.objectStore = awshandler
+	obsi.objectStore = objectStore
 
 	return nil
 }
@@ -217,8 +264,6 @@ func generateDplNameFromKey(key string) string {
 }
 
 func (obsi *SubscriberItem) doSubscription() error {
-	var dpls []*dplv1.Deployable
-
 	var folderName *string
 
 	annotations := obsi.Subscription.GetAnnotations()
@@ -228,47 +273,25 @@ func (obsi *SubscriberItem) doSubscription() error {
 		folderName = &bucketPath
 	}
 
-	keys, err := obsi.objectStore.List(obsi.bucket, folderName)
-	klog.V(5).Infof("object keys: %v", keys)
-
+	snapshot, err := getBucketSnapshot(obsi.Subscription)
 	if err != nil {
-		klog.Error("Failed to list objects in bucket ", obsi.bucket)
-
 		return err
 	}
 
-	// converting template from obeject store to DPL
-	for _, key := range keys {
-		tplb, err := obsi.objectStore.Get(obsi.bucket, key)
-		if err != nil {
-			klog.Error("Failed to get object ", key, " in bucket ", obsi.bucket)
-
-			return err
-		}
-
-		// skip empty body object store
-		if len(tplb.Content) == 0 {
-			continue
-		}
-
-		dpl := &dplv1.Deployable{}
-		dpl.Name = generateDplNameFromKey(key)
-		dpl.Namespace = obsi.bucket
-		dpl.Spec.Template = &runtime.RawExtension{}
-		dpl.GenerateName = tplb.GenerateName
-		verionAnno := map[string]string{dplv1.AnnotationDeployableVersion: tplb.Version}
-		dpl.SetAnnotations(verionAnno)
-		err = yaml.Unmarshal(tplb.Content, dpl.Spec.Template)
+	changed, err := obsi.updateObjects(folderName, snapshot)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			klog.Error("Failed to unmashall ", obsi.bucket, "/", key, " err:", err)
-			continue
-		}
+	// Nothing to do if the objects did not change since the last successful synchronization
+	if !changed && obsi.successful {
+		klog.V(1).Info("No change in object bucket ", obsi.bucket, " of subscription ", obsi.Subscription.Namespace, "/", obsi.Subscription.Name)
 
-		klog.V(5).Infof("Retived Dpl: %v", dpl)
-		dpls = append(dpls, dpl)
+		return nil
 	}
 
+	dpls := obsi.getDeployables()
+
 	hostkey := types.NamespacedName{Name: obsi.Subscription.Name, Namespace: obsi.Subscription.Namespace}
 	syncsource := objectbucketsyncsource + hostkey.String()
 	// subscribed k8s resource
@@ -305,6 +328,14 @@ func (obsi *SubscriberItem) doSubscription() error {
 		dplUnits = append(dplUnits, unit)
 	}
 
+	// The HelmReleases would be removed without their units
+	helmUnits, err := obsi.subscribeHelmCharts(obsi.getChartIndex())
+	if err != nil {
+		return err
+	}
+
+	dplUnits = append(dplUnits, helmUnits...)
+
 	if err := dplpro.Units(obsi.Subscription, obsi.synchronizer, hostkey, syncsource, pkgMap, dplUnits); err != nil {
 		return err
 	}
@@ -312,6 +343,178 @@ func (obsi *SubscriberItem) doSubscription() error {
 	return doErr
 }
 
+// updateObjects lists the bucket and downloads the new and changed objects. It returns true if objects are added,
+// changed or deleted since the last listing.
+func (obsi *SubscriberItem) updateObjects(folderName *string, snapshot *time.Time) (bool, error) {
+	source := obsi.Channel.Spec.Pathname + "|" + obsi.Subscription.GetAnnotations()[appv1.AnnotationBucketPath]
+
+	// Kustomize archives are built with the package overrides
+	if overrides, err := json.Marshal(obsi.Subscription.Spec.PackageOverrides); err == nil {
+		source += "|" + string(overrides)
+	}
+
+	var versioned awsutils.VersionedObjectStore
+
+	if snapshot != nil {
+		source += "|" + snapshot.Format(time.RFC3339)
+
+		var ok bool
+
+		if versioned, ok = obsi.objectStore.(awsutils.VersionedObjectStore); !ok {
+			return false, errors.New("the object store of channel " + obsi.Channel.Name + " does not support bucket snapshots")
+		}
+	}
+
+	changed := false
+
+	if obsi.objects == nil || obsi.objectsSource != source {
+		for _, obj := range obsi.objects {
+			removeChartArchive(obj)
+		}
+
+		obsi.objects = make(map[string]*bucketObject)
+		obsi.objectsSource = source
+		changed = true
+	}
+
+	var (
+		objects []awsutils.ObjectInfo
+		err     error
+	)
+
+	if versioned != nil {
+		objects, err = versioned.ListObjectsAt(obsi.bucket, folderName, *snapshot)
+	} else {
+		objects, err = obsi.objectStore.ListObjects(obsi.bucket, folderName)
+	}
+
+	if err != nil {
+		klog.Error("Failed to list objects in bucket ", obsi.bucket)
+
+		return false, err
+	}
+
+	listed := make(map[string]bool, len(objects))
+
+	for _, info := range objects {
+		listed[info.Key] = true
+
+		if cached, ok := obsi.objects[info.Key]; ok && !isObjectChanged(cached.info, info) {
+			continue
+		}
+
+		var tplb awsutils.DeployableObject
+
+		if versioned != nil {
+			tplb, err = versioned.GetVersion(obsi.bucket, info.Key, info.VersionID)
+		} else {
+			tplb, err = obsi.objectStore.Get(obsi.bucket, info.Key)
+		}
+
+		if err != nil {
+			klog.Error("Failed to get object ", info.Key, " in bucket ", obsi.bucket)
+
+			return changed, err
+		}
+
+		klog.V(1).Info("Downloaded new or changed object ", info.Key, " in bucket ", obsi.bucket)
+
+		obj, err := obsi.newBucketObject(info, tplb)
+		if err != nil {
+			return changed, err
+		}
+
+		if cached, ok := obsi.objects[info.Key]; ok && cached.chartDir != obj.chartDir {
+			removeChartArchive(cached)
+		}
+
+		obsi.objects[info.Key] = obj
+		changed = true
+	}
+
+	for key := range obsi.objects {
+		if !listed[key] {
+			klog.V(1).Info("Object ", key, " is deleted from bucket ", obsi.bucket)
+
+			removeChartArchive(obsi.objects[key])
+			delete(obsi.objects, key)
+
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// getDeployables returns the deployables of the listed objects ordered by key
+func (obsi *SubscriberItem) getDeployables() []*dplv1.Deployable {
+	keys := make([]string, 0, len(obsi.objects))
+
+	for key := range obsi.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var dpls []*dplv1.Deployable
+
+	for _, key := range keys {
+		dpls = append(dpls, obsi.objects[key].dpls...)
+	}
+
+	return dpls
+}
+
+// isObjectChanged compares the listing information of an object. The ETag of an object that is uploaded again with
+// the same content does not change.
+func isObjectChanged(cached, listed awsutils.ObjectInfo) bool {
+	if cached.VersionID != listed.VersionID {
+		return true
+	}
+
+	if cached.ETag != "" && listed.ETag != "" {
+		return cached.ETag != listed.ETag
+	}
+
+	return !cached.LastModified.Equal(listed.LastModified)
+}
+
+// newDeployable converts a resource template of an object to a deployable. It returns nil for invalid templates.
+func newDeployable(bucket, name, generateName, version string, template []byte) *dplv1.Deployable {
+	dpl := &dplv1.Deployable{}
+	dpl.Name = name
+	dpl.Namespace = bucket
+	dpl.Spec.Template = &runtime.RawExtension{}
+	dpl.GenerateName = generateName
+	verionAnno := map[string]string{dplv1.AnnotationDeployableVersion: version}
+	dpl.SetAnnotations(verionAnno)
+
+	if err := yaml.Unmarshal(template, dpl.Spec.Template); err != nil {
+		klog.Error("Failed to unmashall ", bucket, "/", name, " err:", err)
+
+		return nil
+	}
+
+	klog.V(5).Infof("Retived Dpl: %v", dpl)
+
+	return dpl
+}
+
+// getBucketSnapshot returns the time of the bucket snapshot to deploy, nil for the latest objects
+func getBucketSnapshot(sub *appv1.Subscription) (*time.Time, error) {
+	value := sub.GetAnnotations()[appv1.AnnotationBucketSnapshot]
+	if value == "" {
+		return nil, nil
+	}
+
+	snapshot, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", appv1.AnnotationBucketSnapshot, value, err)
+	}
+
+	return &snapshot, nil
+}
+
 func (obsi *SubscriberItem) doSubscribeDeployable(dpl *dplv1.Deployable,
 	versionMap map[string]utils.VersionRep, pkgMap map[string]bool) (*dplv1.Deployable, *schema.GroupVersionKind, error) {
 	var annotations map[string]string
@@ -15,18 +15,31 @@
 package objectbucket
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
 	dplv1alpha1 "github.com/open-cluster-management/multicloud-operators-deployable/pkg/apis/apps/v1"
+	releasev1 "github.com/open-cluster-management/multicloud-operators-subscription-release/pkg/apis/apps/v1"
 	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	awsutils "github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils/aws"
 )
 
 var c client.Client
@@ -102,3 +115,310 @@ func TestDoSubscribeDeployable(t *testing.T) {
 	_, _, err = obsi.doSubscribeDeployable(dpl, nil, nil)
 	g.Expect(err).To(gomega.HaveOccurred())
 }
+
+// countingObjectStore counts the downloaded objects
+type countingObjectStore struct {
+	awsutils.ObjectStore
+	gets []string
+}
+
+func (s *countingObjectStore) Get(bucket, name string) (awsutils.DeployableObject, error) {
+	s.gets = append(s.gets, name)
+
+	return s.ObjectStore.Get(bucket, name)
+}
+
+func TestUpdateObjects(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "buckets")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	localHandler := awsutils.NewLocalHandler(dir)
+	g.Expect(localHandler.InitObjectStoreConnection("file://"+dir, "", "", "")).To(gomega.Succeed())
+	g.Expect(localHandler.Create("bucket")).To(gomega.Succeed())
+
+	cm := func(name string) []byte {
+		return []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n")
+	}
+
+	g.Expect(localHandler.Put("bucket", awsutils.DeployableObject{Name: "cm1.yaml", Content: cm("cm1")})).To(gomega.Succeed())
+	g.Expect(localHandler.Put("bucket", awsutils.DeployableObject{Name: "folder/cm2.yaml", Content: cm("cm2")})).To(gomega.Succeed())
+	g.Expect(localHandler.Put("bucket", awsutils.DeployableObject{Name: "invalid.yaml", Content: []byte("{")})).To(gomega.Succeed())
+
+	store := &countingObjectStore{ObjectStore: localHandler}
+	obsi := &SubscriberItem{
+		SubscriberItem: appv1alpha1.SubscriberItem{Subscription: helmsub.DeepCopy(), Channel: helmchn.DeepCopy()},
+		bucket:         "bucket",
+		objectStore:    store,
+	}
+
+	// All objects are downloaded the first time
+	changed, err := obsi.updateObjects(nil, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(store.gets).To(gomega.ConsistOf("cm1.yaml", "folder/cm2.yaml", "invalid.yaml"))
+
+	dpls := obsi.getDeployables()
+	g.Expect(dpls).To(gomega.HaveLen(2))
+	g.Expect(dpls[0].Name).To(gomega.Equal("cm1.yaml"))
+	g.Expect(dpls[1].Name).To(gomega.Equal("folder-cm2.yaml"))
+
+	// Nothing is downloaded when nothing changed
+	store.gets = nil
+
+	changed, err = obsi.updateObjects(nil, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(store.gets).To(gomega.BeEmpty())
+
+	// Only the changed object is downloaded
+	modified := time.Now().Add(time.Minute)
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "bucket", "cm1.yaml"), cm("cm1-changed"), 0600)).To(gomega.Succeed())
+	g.Expect(os.Chtimes(filepath.Join(dir, "bucket", "cm1.yaml"), modified, modified)).To(gomega.Succeed())
+
+	changed, err = obsi.updateObjects(nil, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(store.gets).To(gomega.ConsistOf("cm1.yaml"))
+	g.Expect(string(obsi.getDeployables()[0].Spec.Template.Raw)).To(gomega.ContainSubstring("cm1-changed"))
+
+	// Deleted objects are detected
+	store.gets = nil
+
+	g.Expect(localHandler.Delete("bucket", "folder/cm2.yaml")).To(gomega.Succeed())
+
+	changed, err = obsi.updateObjects(nil, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(store.gets).To(gomega.BeEmpty())
+	g.Expect(obsi.getDeployables()).To(gomega.HaveLen(1))
+
+	// The object store does not keep versions
+	snapshot := time.Now()
+
+	_, err = obsi.updateObjects(nil, &snapshot)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestIsObjectChanged(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+	cached := awsutils.ObjectInfo{Key: "cm.yaml", ETag: "1", LastModified: now}
+
+	// An object uploaded again with the same content did not change
+	g.Expect(isObjectChanged(cached, awsutils.ObjectInfo{Key: "cm.yaml", ETag: "1", LastModified: now.Add(time.Minute)})).To(gomega.BeFalse())
+	g.Expect(isObjectChanged(cached, awsutils.ObjectInfo{Key: "cm.yaml", ETag: "2", LastModified: now})).To(gomega.BeTrue())
+	g.Expect(isObjectChanged(cached, awsutils.ObjectInfo{Key: "cm.yaml", ETag: "1", VersionID: "v2", LastModified: now})).To(gomega.BeTrue())
+
+	// The last modified time is compared without ETag
+	cached.ETag = ""
+	g.Expect(isObjectChanged(cached, awsutils.ObjectInfo{Key: "cm.yaml", LastModified: now})).To(gomega.BeFalse())
+	g.Expect(isObjectChanged(cached, awsutils.ObjectInfo{Key: "cm.yaml", LastModified: now.Add(time.Minute)})).To(gomega.BeTrue())
+}
+
+func TestGetBucketSnapshot(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := helmsub.DeepCopy()
+
+	snapshot, err := getBucketSnapshot(sub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(snapshot).To(gomega.BeNil())
+
+	sub.SetAnnotations(map[string]string{appv1alpha1.AnnotationBucketSnapshot: "2021-05-01T10:00:00Z"})
+
+	snapshot, err = getBucketSnapshot(sub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(*snapshot).To(gomega.BeTemporally("==", time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)))
+
+	sub.SetAnnotations(map[string]string{appv1alpha1.AnnotationBucketSnapshot: "yesterday"})
+
+	_, err = getBucketSnapshot(sub)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+// zipArchive returns a zip archive of files by name
+func zipArchive(g *gomega.GomegaWithT, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+
+	for name, content := range files {
+		f, err := writer.Create(name)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		_, err = f.Write([]byte(content))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	g.Expect(writer.Close()).To(gomega.Succeed())
+
+	return buf.Bytes()
+}
+
+// chartArchive returns a packaged Helm chart
+func chartArchive(g *gomega.GomegaWithT, name, version string) []byte {
+	dir, err := ioutil.TempDir("", "chart")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version}}
+
+	file, err := chartutil.Save(ch, dir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	content, err := ioutil.ReadFile(file)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return content
+}
+
+func TestNewBucketObject(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	obsi := &SubscriberItem{
+		SubscriberItem: appv1alpha1.SubscriberItem{Subscription: helmsub.DeepCopy(), Channel: helmchn.DeepCopy()},
+		bucket:         "bucket",
+	}
+
+	defer obsi.Stop()
+
+	cm := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm1\n"
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: secret1\n"
+
+	newObject := func(key string, content []byte) *bucketObject {
+		obj, err := obsi.newBucketObject(awsutils.ObjectInfo{Key: key}, awsutils.DeployableObject{Name: key, Content: content})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		return obj
+	}
+
+	dplNames := func(obj *bucketObject) []string {
+		names := []string{}
+
+		for _, dpl := range obj.dpls {
+			names = append(names, dpl.Name)
+		}
+
+		return names
+	}
+
+	// A single resource keeps the name of the object
+	g.Expect(dplNames(newObject("folder/cm.yaml", []byte(cm)))).To(gomega.Equal([]string{"folder-cm.yaml"}))
+
+	// Each resource of a multi-document object is a deployable
+	obj := newObject("resources.yaml", []byte("---\n"+cm+"---\n"+secret))
+	g.Expect(dplNames(obj)).To(gomega.Equal([]string{"resources.yaml-ConfigMap-cm1", "resources.yaml-Secret-secret1"}))
+	g.Expect(string(obj.dpls[1].Spec.Template.Raw)).To(gomega.ContainSubstring("secret1"))
+
+	g.Expect(newObject("invalid.yaml", []byte("{")).dpls).To(gomega.BeEmpty())
+
+	// Zipped kustomize directories are built
+	obj = newObject("app.zip", zipArchive(g, map[string]string{
+		"app/kustomization.yaml":      "namePrefix: prod-\nresources:\n- cm.yaml\n- secret.yaml\n",
+		"app/cm.yaml":                 cm,
+		"app/secret.yaml":             secret,
+		"app/base/kustomization.yaml": "resources:\n- missing.yaml\n",
+	}))
+	g.Expect(dplNames(obj)).To(gomega.ConsistOf("app.zip-ConfigMap-prod-cm1", "app.zip-Secret-prod-secret1"))
+
+	g.Expect(newObject("notkustomize.zip", zipArchive(g, map[string]string{"cm.yaml": cm})).dpls).To(gomega.BeEmpty())
+	g.Expect(newObject("escape.zip", zipArchive(g, map[string]string{"../kustomization.yaml": "resources: []\n"})).dpls).To(gomega.BeEmpty())
+
+	// Helm charts are saved for the HelmRelease controller
+	obj = newObject("charts/nginx-1.0.0.tgz", chartArchive(g, "nginx", "1.0.0"))
+	g.Expect(obj.dpls).To(gomega.BeEmpty())
+	g.Expect(obj.chart).NotTo(gomega.BeNil())
+	g.Expect(obj.chart.Name).To(gomega.Equal("nginx"))
+	g.Expect(obj.chart.Version).To(gomega.Equal("1.0.0"))
+	g.Expect(obj.chart.Digest).NotTo(gomega.BeEmpty())
+	g.Expect(obj.chart.URLs).To(gomega.HaveLen(1))
+	g.Expect(obj.chart.URLs[0]).To(gomega.HavePrefix("file://localhost/"))
+	g.Expect(filepath.Join(obj.chartDir, "nginx-1.0.0.tgz")).To(gomega.BeARegularFile())
+
+	g.Expect(newObject("broken.tgz", []byte("not a chart")).chart).To(gomega.BeNil())
+
+	obsi.objects = map[string]*bucketObject{"charts/nginx-1.0.0.tgz": obj}
+
+	indexFile := obsi.getChartIndex()
+	g.Expect(indexFile.Entries).To(gomega.HaveKey("nginx"))
+	g.Expect(indexFile.Entries["nginx"][0].URLs).To(gomega.Equal(obj.chart.URLs))
+
+	// The charts are removed with the subscription
+	obsi.Stop()
+	g.Expect(obj.chartDir).NotTo(gomega.BeADirectory())
+}
+
+// fakeSyncSource gives a fake local client to the subscriber item
+type fakeSyncSource struct {
+	SyncSource
+	client client.Client
+}
+
+func (s *fakeSyncSource) GetLocalClient() client.Client {
+	return s.client
+}
+
+func TestSubscribeHelmCharts(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(releasev1.SchemeBuilder.AddToScheme(scheme)).To(gomega.Succeed())
+
+	sub := helmsub.DeepCopy()
+	sub.UID = "7d4e2b1a-0000-0000-0000-000000000000"
+
+	obsi := &SubscriberItem{
+		SubscriberItem: appv1alpha1.SubscriberItem{Subscription: sub, Channel: helmchn.DeepCopy()},
+		bucket:         "bucket",
+		synchronizer:   &fakeSyncSource{client: fake.NewFakeClientWithScheme(scheme)},
+	}
+
+	obsi.Channel.Spec.SecretRef = &corev1.ObjectReference{Name: "bucket-secret"}
+
+	defer obsi.Stop()
+
+	obsi.objects = map[string]*bucketObject{}
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		key := "nginx-" + version + ".tgz"
+
+		obj, err := obsi.newBucketObject(awsutils.ObjectInfo{Key: key}, awsutils.DeployableObject{Name: key, Content: chartArchive(g, "nginx", version)})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		obsi.objects[key] = obj
+	}
+
+	// The latest version of the chart is released from the saved archive
+	units, err := obsi.subscribeHelmCharts(obsi.getChartIndex())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(units).To(gomega.HaveLen(1))
+	g.Expect(units[0].Gvk).To(gomega.Equal(helmGvk))
+
+	helmRelease := &releasev1.HelmRelease{}
+	g.Expect(json.Unmarshal(units[0].Dpl.Spec.Template.Raw, helmRelease)).To(gomega.Succeed())
+	g.Expect(helmRelease.Repo.ChartName).To(gomega.Equal("nginx"))
+	g.Expect(helmRelease.Repo.Version).To(gomega.Equal("1.1.0"))
+	g.Expect(helmRelease.Repo.SecretRef).To(gomega.BeNil())
+	g.Expect(helmRelease.Repo.Source.HelmRepo.Urls).To(gomega.Equal(obsi.objects["nginx-1.1.0.tgz"].chart.URLs))
+
+	// The package filter selects the chart versions
+	obsi.Subscription.Spec.PackageFilter = &appv1alpha1.PackageFilter{Version: "1.0.0"}
+
+	units, err = obsi.subscribeHelmCharts(obsi.getChartIndex())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(units).To(gomega.HaveLen(1))
+	g.Expect(json.Unmarshal(units[0].Dpl.Spec.Template.Raw, helmRelease)).To(gomega.Succeed())
+	g.Expect(helmRelease.Repo.Version).To(gomega.Equal("1.0.0"))
+
+	// No HelmRelease without charts
+	obsi.objects = map[string]*bucketObject{}
+
+	units, err = obsi.subscribeHelmCharts(obsi.getChartIndex())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(units).To(gomega.BeEmpty())
+}
@@ -0,0 +1,249 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"k8s.io/klog"
+)
+
+const (
+	// azureMetaGenerateName is the blob metadata key of the deployable generate name
+	azureMetaGenerateName = "generatename"
+	// azureMetaVersion is the blob metadata key of the deployable version
+	azureMetaVersion = "deployableversion"
+)
+
+var _ ObjectStore = &AzureBlobHandler{}
+
+// AzureBlobHandler handles connections to Azure Blob storage. Buckets are blob containers.
+type AzureBlobHandler struct {
+	serviceURL *azblob.ServiceURL
+}
+
+// InitObjectStoreConnection connect to the Azure storage account. The access key ID is the storage account name and
+// the secret access key is the storage account key. Public containers are accessed anonymously without account key.
+func (h *AzureBlobHandler) InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region string) error {
+	klog.Infof("Preparing Azure Blob settings endpoint: %v", endpoint)
+
+	scheme := "https"
+	if strings.HasPrefix(strings.ToLower(endpoint), SchemeAzureBlobHTTP+"://") {
+		scheme = "http"
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		klog.Error("Failed to parse Azure Blob endpoint. error: ", err)
+
+		return err
+	}
+
+	if u.Host == "" {
+		return errors.New("no storage account host in Azure Blob endpoint " + endpoint)
+	}
+
+	u.Scheme = scheme
+
+	var credential azblob.Credential = azblob.NewAnonymousCredential()
+
+	if secretAccessKey != "" {
+		accountName := accessKeyID
+		if accountName == "" {
+			accountName = strings.Split(u.Host, ".")[0]
+		}
+
+		credential, err = azblob.NewSharedKeyCredential(accountName, secretAccessKey)
+		if err != nil {
+			klog.Error("Failed to create Azure storage account credential. error: ", err)
+
+			return err
+		}
+	}
+
+	serviceURL := azblob.NewServiceURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{}))
+	h.serviceURL = &serviceURL
+
+	klog.V(1).Info("Azure Blob configured ")
+
+	return nil
+}
+
+// Create a bucket.
+func (h *AzureBlobHandler) Create(bucket string) error {
+	_, err := h.serviceURL.NewContainerURL(bucket).Create(context.TODO(), azblob.Metadata{}, azblob.PublicAccessNone)
+	if err != nil {
+		klog.Error("Failed to create container ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Exists Checks whether a bucket exists and is accessible.
+func (h *AzureBlobHandler) Exists(bucket string) error {
+	_, err := h.serviceURL.NewContainerURL(bucket).GetProperties(context.TODO(), azblob.LeaseAccessConditions{})
+	if err != nil {
+		klog.Error("Failed to access container ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// List all objects in bucket.
+func (h *AzureBlobHandler) List(bucket string, folderName *string) ([]string, error) {
+	objects, err := h.ListObjects(bucket, folderName)
+
+	return objectKeys(objects), err
+}
+
+// ListObjects lists all objects in bucket with their ETag and last modified time.
+func (h *AzureBlobHandler) ListObjects(bucket string, folderName *string) ([]ObjectInfo, error) {
+	klog.V(1).Info("List Azure Blob Objects ", bucket)
+
+	options := azblob.ListBlobsSegmentOptions{}
+
+	if prefix := folderPrefix(folderName); prefix != nil {
+		options.Prefix = *prefix
+	}
+
+	containerURL := h.serviceURL.NewContainerURL(bucket)
+
+	var objects []ObjectInfo
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := containerURL.ListBlobsFlatSegment(context.TODO(), marker, options)
+		if err != nil {
+			klog.Infof("Got error retrieving list of blobs. err: %v", err)
+
+			return objects, err
+		}
+
+		for _, blob := range resp.Segment.BlobItems {
+			if strings.HasSuffix(blob.Name, "/") {
+				klog.V(1).Info("Skipping Azure Blob Object: ", blob.Name)
+
+				continue
+			}
+
+			objects = append(objects, ObjectInfo{
+				Key:          blob.Name,
+				ETag:         string(blob.Properties.Etag),
+				LastModified: blob.Properties.LastModified,
+			})
+		}
+
+		marker = resp.NextMarker
+	}
+
+	klog.Infof("List Azure Blob Objects result, keys: %v", objectKeys(objects))
+
+	return objects, nil
+}
+
+// Get get existing object.
+func (h *AzureBlobHandler) Get(bucket, name string) (DeployableObject, error) {
+	blobURL := h.serviceURL.NewContainerURL(bucket).NewBlockBlobURL(name)
+
+	resp, err := blobURL.Download(context.TODO(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false,
+		azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		klog.Error("Failed to send Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		klog.Error("Failed to parse Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	if len(content) == 0 {
+		return DeployableObject{}, nil
+	}
+
+	metadata := resp.NewMetadata()
+
+	return DeployableObject{
+		Name:         name,
+		GenerateName: metadata[azureMetaGenerateName],
+		Version:      metadata[azureMetaVersion],
+		Content:      content,
+	}, nil
+}
+
+// Put create new object.
+func (h *AzureBlobHandler) Put(bucket string, dplObj DeployableObject) error {
+	if dplObj.isEmpty() {
+		klog.V(1).Infof("got an empty deployableObject to put to object store")
+
+		return nil
+	}
+
+	metadata := azblob.Metadata{}
+
+	if dplObj.GenerateName != "" {
+		metadata[azureMetaGenerateName] = dplObj.GenerateName
+	}
+
+	if dplObj.Version != "" {
+		metadata[azureMetaVersion] = dplObj.Version
+	}
+
+	blobURL := h.serviceURL.NewContainerURL(bucket).NewBlockBlobURL(dplObj.Name)
+
+	_, err := azblob.UploadBufferToBlockBlob(context.TODO(), dplObj.Content, blobURL,
+		azblob.UploadToBlockBlobOptions{Metadata: metadata})
+	if err != nil {
+		klog.Error("Failed to send Put request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete delete existing object.
+func (h *AzureBlobHandler) Delete(bucket, name string) error {
+	blobURL := h.serviceURL.NewContainerURL(bucket).NewBlockBlobURL(name)
+
+	_, err := blobURL.Delete(context.TODO(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if err != nil {
+		// Deleting a missing object succeeds like in S3
+		var serr azblob.StorageError
+		if errors.As(err, &serr) && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil
+		}
+
+		klog.Error("Failed to send Delete request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
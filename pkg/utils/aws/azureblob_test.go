@@ -0,0 +1,163 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+type fakeBlob struct {
+	content  []byte
+	metadata http.Header
+}
+
+// fakeAzureBlobServer serves the Azure Blob REST operations used by the AzureBlobHandler from memory, for one
+// storage account with path style URLs like the Azurite emulator
+type fakeAzureBlobServer struct {
+	sync.Mutex
+	containers map[string]map[string]*fakeBlob
+}
+
+func (s *fakeAzureBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	// /<account>/<container>[/<blob>]
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	container := parts[1]
+	blobs, found := s.containers[container]
+
+	if r.URL.Query().Get("restype") == "container" {
+		switch {
+		case r.Method == http.MethodPut && found:
+			s.writeError(w, http.StatusConflict, "ContainerAlreadyExists")
+		case r.Method == http.MethodPut:
+			s.containers[container] = map[string]*fakeBlob{}
+			w.WriteHeader(http.StatusCreated)
+		case !found:
+			s.writeError(w, http.StatusNotFound, "ContainerNotFound")
+		case r.URL.Query().Get("comp") == "list":
+			s.list(w, container, r.URL.Query().Get("prefix"))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+
+		return
+	}
+
+	if !found {
+		s.writeError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	name := parts[2]
+	blob := blobs[name]
+
+	switch r.Method {
+	case http.MethodPut:
+		content, _ := ioutil.ReadAll(r.Body)
+		blobs[name] = &fakeBlob{content: content, metadata: r.Header.Clone()}
+
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		if blob == nil {
+			s.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+
+		for key, values := range blob.metadata {
+			if strings.HasPrefix(strings.ToLower(key), "x-ms-meta-") {
+				w.Header()[key] = values
+			}
+		}
+
+		_, _ = w.Write(blob.content)
+	case http.MethodDelete:
+		if blob == nil {
+			s.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+
+		delete(blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeAzureBlobServer) list(w http.ResponseWriter, container, prefix string) {
+	type blob struct {
+		Name string `xml:"Name"`
+	}
+
+	result := struct {
+		XMLName       xml.Name `xml:"EnumerationResults"`
+		ContainerName string   `xml:"ContainerName,attr"`
+		Blobs         []blob   `xml:"Blobs>Blob"`
+		NextMarker    string   `xml:"NextMarker"`
+	}{ContainerName: container}
+
+	for name := range s.containers[container] {
+		if strings.HasPrefix(name, prefix) {
+			result.Blobs = append(result.Blobs, blob{Name: name})
+		}
+	}
+
+	sort.Slice(result.Blobs, func(i, j int) bool { return result.Blobs[i].Name < result.Blobs[j].Name })
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func (s *fakeAzureBlobServer) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+}
+
+func TestAzureBlobObjectStore(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// Set up a fake Azure Blob server
+	ts := httptest.NewServer(&fakeAzureBlobServer{containers: map[string]map[string]*fakeBlob{}})
+
+	defer ts.Close()
+
+	azureHandler := &AzureBlobHandler{}
+
+	accountKey := base64.StdEncoding.EncodeToString([]byte("randomkey"))
+	endpoint := SchemeAzureBlobHTTP + "://" + strings.TrimPrefix(ts.URL, "http://") + "/devstoreaccount1"
+
+	g.Expect(azureHandler.InitObjectStoreConnection(endpoint, "devstoreaccount1", accountKey, "")).To(gomega.Succeed())
+
+	testObjectStore(g, azureHandler, true)
+
+	// The account key is base64 encoded
+	g.Expect(azureHandler.InitObjectStoreConnection(endpoint, "devstoreaccount1", "not base64!", "")).NotTo(gomega.Succeed())
+}
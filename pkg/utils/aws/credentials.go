@@ -0,0 +1,231 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"k8s.io/klog"
+)
+
+const (
+	// SecretMapKeyRoleARN is the key of the IAM role to assume in the secret.
+	SecretMapKeyRoleARN = "RoleARN"
+	// SecretMapKeyExternalID is the key of the external ID of the role in the secret.
+	SecretMapKeyExternalID = "ExternalID"
+	// SecretMapKeyRoleSessionName is the key of the session name of the assumed role in the secret.
+	SecretMapKeyRoleSessionName = "RoleSessionName"
+	// SecretMapKeyWebIdentityToken is the key of the web identity token in the secret, like a ServiceAccount token.
+	SecretMapKeyWebIdentityToken = "WebIdentityToken"
+	// SecretMapKeyWebIdentityTokenFile is the key of a web identity token file in the secret, it's rejected as the
+	// files are read in the operator pod.
+	SecretMapKeyWebIdentityTokenFile = "WebIdentityTokenFile"
+	// SecretMapKeyServerSideEncryption is the key of the server-side encryption of the written objects in the
+	// secret, AES256 or aws:kms.
+	SecretMapKeyServerSideEncryption = "ServerSideEncryption"
+	// SecretMapKeySSEKMSKeyID is the key of the KMS key of the written objects in the secret.
+	SecretMapKeySSEKMSKeyID = "SSEKMSKeyID"
+
+	// defaultRoleSessionName is the session name of the assumed roles without session name in the secret
+	defaultRoleSessionName = "multicloud-operators-subscription"
+	// defaultSTSRegion is the STS region of AWS S3 channels without region
+	defaultSTSRegion = "us-east-1"
+	// credentialsExpiryWindow is the time before the expiry of the temporary credentials when they are refreshed
+	credentialsExpiryWindow = 5 * time.Minute
+)
+
+// ambientCredentials tells if the S3 channels without credentials in the channel secret can use the default credential
+// chain of the operator pod
+var ambientCredentials int32
+
+// EnableAmbientCredentials lets the S3 channels without credentials in the channel secret use the default credential
+// chain of the operator pod, like its web identity or the EC2 instance profile, and assume roles with it. It's
+// disabled by default since the channels then access the buckets with the identity of the operator.
+func EnableAmbientCredentials(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	atomic.StoreInt32(&ambientCredentials, v)
+}
+
+func isAmbientCredentialsEnabled() bool {
+	return atomic.LoadInt32(&ambientCredentials) == 1
+}
+
+// ConfigurableObjectStore is an object store with settings in the channel secret besides the credentials.
+type ConfigurableObjectStore interface {
+	ObjectStore
+	// SetOptions reads the settings from the channel secret data. It is called before InitObjectStoreConnection.
+	SetOptions(secretData map[string][]byte) error
+}
+
+var _ ConfigurableObjectStore = &Handler{}
+
+// S3Options are the settings of S3 object bucket channels besides the access key.
+type S3Options struct {
+	// RoleARN is the IAM role assumed with the access key, the web identity token or the default credentials
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	// WebIdentityToken is the OIDC token exchanged for the credentials of the role
+	WebIdentityToken string
+	// ServerSideEncryption and SSEKMSKeyID are the server-side encryption settings of the written objects
+	ServerSideEncryption types.ServerSideEncryption
+	SSEKMSKeyID          string
+}
+
+// SetOptions reads the role and server-side encryption settings from the channel secret data.
+func (h *Handler) SetOptions(secretData map[string][]byte) error {
+	value := func(key string) string {
+		return strings.TrimSpace(string(secretData[key]))
+	}
+
+	options := S3Options{
+		RoleARN:              value(SecretMapKeyRoleARN),
+		ExternalID:           value(SecretMapKeyExternalID),
+		RoleSessionName:      value(SecretMapKeyRoleSessionName),
+		WebIdentityToken:     value(SecretMapKeyWebIdentityToken),
+		ServerSideEncryption: types.ServerSideEncryption(value(SecretMapKeyServerSideEncryption)),
+		SSEKMSKeyID:          value(SecretMapKeySSEKMSKeyID),
+	}
+
+	if value(SecretMapKeyWebIdentityTokenFile) != "" {
+		return fmt.Errorf("%s is not supported, set the token in %s of the channel secret", SecretMapKeyWebIdentityTokenFile,
+			SecretMapKeyWebIdentityToken)
+	}
+
+	if options.WebIdentityToken != "" && options.RoleARN == "" {
+		return fmt.Errorf("%s requires %s in the channel secret", SecretMapKeyWebIdentityToken, SecretMapKeyRoleARN)
+	}
+
+	// A KMS key implies KMS encryption
+	if options.SSEKMSKeyID != "" && options.ServerSideEncryption == "" {
+		options.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+	}
+
+	switch options.ServerSideEncryption {
+	case "", types.ServerSideEncryptionAes256:
+		if options.SSEKMSKeyID != "" {
+			return fmt.Errorf("%s requires the %s server-side encryption", SecretMapKeySSEKMSKeyID, types.ServerSideEncryptionAwsKms)
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q, expecting %s or %s", options.ServerSideEncryption,
+			types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms)
+	}
+
+	h.options = options
+
+	return nil
+}
+
+// identityToken is the web identity token of the channel secret
+type identityToken string
+
+func (t identityToken) GetIdentityToken() ([]byte, error) {
+	return []byte(t), nil
+}
+
+// credentialsProvider returns the credentials of the S3 client. The access key of the channel secret is used when it
+// is set. Without access key and web identity token, the default credential chain of the operator pod is used if
+// EnableAmbientCredentials enabled it, otherwise the empty access key. A role is assumed on top of these credentials,
+// or with the web identity token. Temporary credentials are refreshed before they expire.
+func (h *Handler) credentialsProvider(cfg aws.Config, accessKeyID, secretAccessKey, region string) (aws.CredentialsProvider, error) {
+	provider := aws.CredentialsProvider(credentialProvider{
+		Value: aws.Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+		},
+	})
+
+	if accessKeyID == "" && secretAccessKey == "" && h.options.WebIdentityToken == "" {
+		if isAmbientCredentialsEnabled() {
+			klog.V(1).Info("No access key in the channel secret, using the default credential chain")
+
+			provider = cfg.Credentials
+		} else if h.options.RoleARN != "" {
+			return nil, errors.New("assuming a role requires an access key or a web identity token in the channel secret, " +
+				"the default credentials of the operator are not enabled")
+		}
+	}
+
+	if h.options.RoleARN == "" {
+		return provider, nil
+	}
+
+	if region == "" {
+		region = defaultSTSRegion
+	}
+
+	sessionName := h.options.RoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	if h.options.WebIdentityToken != "" {
+		klog.V(1).Info("Assuming role ", h.options.RoleARN, " with the web identity token of the channel secret")
+
+		// The web identity token is exchanged without credentials
+		client := sts.NewFromConfig(cfg, func(o *sts.Options) {
+			o.Region = region
+			o.Credentials = aws.AnonymousCredentials{}
+		})
+
+		provider = stscreds.NewWebIdentityRoleProvider(client, h.options.RoleARN,
+			identityToken(h.options.WebIdentityToken), func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = sessionName
+			})
+	} else {
+		klog.V(1).Info("Assuming role ", h.options.RoleARN)
+
+		client := sts.NewFromConfig(cfg, func(o *sts.Options) {
+			o.Region = region
+			o.Credentials = provider
+		})
+
+		provider = stscreds.NewAssumeRoleProvider(client, h.options.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+
+			if h.options.ExternalID != "" {
+				o.ExternalID = aws.String(h.options.ExternalID)
+			}
+		})
+	}
+
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	}), nil
+}
+
+// Retrieve follow the Provider interface.
+func (p credentialProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	awscred := aws.Credentials{
+		SecretAccessKey: p.Value.SecretAccessKey,
+		AccessKeyID:     p.Value.AccessKeyID,
+	}
+
+	return awscred, nil
+}
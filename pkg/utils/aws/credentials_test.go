@@ -0,0 +1,206 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/onsi/gomega"
+)
+
+// fakeSTSServer answers STS role requests with temporary credentials and records the S3 put requests
+type fakeSTSServer struct {
+	sync.Mutex
+	// expiration is the lifetime of the temporary credentials
+	expiration time.Duration
+	stsForms   []map[string]string
+	puts       []http.Header
+}
+
+func (s *fakeSTSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r.Method == http.MethodPut {
+		s.puts = append(s.puts, r.Header.Clone())
+
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	form := map[string]string{}
+	for key := range r.PostForm {
+		form[key] = r.PostForm.Get(key)
+	}
+
+	s.stsForms = append(s.stsForms, form)
+
+	action := form["Action"]
+	expiration := time.Now().Add(s.expiration).UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>`+
+		`<AccessKeyId>ROLEKEY</AccessKeyId><SecretAccessKey>ROLESECRET</SecretAccessKey><SessionToken>TOKEN%[2]d</SessionToken>`+
+		`<Expiration>%[3]s</Expiration></Credentials></%[1]sResult></%[1]sResponse>`, action, len(s.stsForms), expiration)
+}
+
+func TestSetOptions(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	h := &Handler{}
+
+	g.Expect(h.SetOptions(map[string][]byte{
+		SecretMapKeyRoleARN:     []byte("arn:aws:iam::123456789012:role/channel\n"),
+		SecretMapKeyExternalID:  []byte("external"),
+		SecretMapKeySSEKMSKeyID: []byte("key"),
+	})).To(gomega.Succeed())
+	g.Expect(h.options.RoleARN).To(gomega.Equal("arn:aws:iam::123456789012:role/channel"))
+	g.Expect(h.options.ExternalID).To(gomega.Equal("external"))
+	g.Expect(h.options.ServerSideEncryption).To(gomega.Equal(types.ServerSideEncryptionAwsKms))
+
+	g.Expect(h.SetOptions(map[string][]byte{SecretMapKeyServerSideEncryption: []byte("AES256")})).To(gomega.Succeed())
+	g.Expect(h.options.ServerSideEncryption).To(gomega.Equal(types.ServerSideEncryptionAes256))
+
+	g.Expect(h.SetOptions(map[string][]byte{SecretMapKeyServerSideEncryption: []byte("rot13")})).NotTo(gomega.Succeed())
+	g.Expect(h.SetOptions(map[string][]byte{
+		SecretMapKeyServerSideEncryption: []byte("AES256"),
+		SecretMapKeySSEKMSKeyID:          []byte("key"),
+	})).NotTo(gomega.Succeed())
+	g.Expect(h.SetOptions(map[string][]byte{SecretMapKeyWebIdentityToken: []byte("token")})).NotTo(gomega.Succeed())
+
+	// The files of the operator pod are not read for the channels
+	g.Expect(h.SetOptions(map[string][]byte{
+		SecretMapKeyRoleARN:              []byte("arn:aws:iam::123456789012:role/channel"),
+		SecretMapKeyWebIdentityTokenFile: []byte("/var/run/secrets/eks.amazonaws.com/serviceaccount/token"),
+	})).NotTo(gomega.Succeed())
+}
+
+func TestAmbientCredentials(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	server := &fakeSTSServer{expiration: time.Hour}
+	ts := httptest.NewServer(server)
+
+	defer ts.Close()
+
+	h := &Handler{}
+	g.Expect(h.SetOptions(map[string][]byte{SecretMapKeyRoleARN: []byte("arn:aws:iam::123456789012:role/channel")})).To(gomega.Succeed())
+
+	// The role isn't assumed with the identity of the operator by default
+	g.Expect(h.InitObjectStoreConnection(ts.URL, "", "", "minio")).NotTo(gomega.Succeed())
+
+	EnableAmbientCredentials(true)
+	defer EnableAmbientCredentials(false)
+
+	g.Expect(h.InitObjectStoreConnection(ts.URL, "", "", "minio")).To(gomega.Succeed())
+
+	// Without role, the channels without access key keep the empty access key by default
+	EnableAmbientCredentials(false)
+
+	h = &Handler{}
+
+	provider, err := h.credentialsProvider(aws.Config{Credentials: credentialProvider{Value: aws.Credentials{AccessKeyID: "OPERATOR"}}},
+		"", "", "minio")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	creds, err := provider.Retrieve(context.TODO())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(creds.AccessKeyID).To(gomega.BeEmpty())
+}
+
+func TestAssumeRole(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	server := &fakeSTSServer{expiration: time.Hour}
+	ts := httptest.NewServer(server)
+
+	defer ts.Close()
+
+	h := &Handler{}
+	g.Expect(h.SetOptions(map[string][]byte{
+		SecretMapKeyRoleARN:              []byte("arn:aws:iam::123456789012:role/channel"),
+		SecretMapKeyExternalID:           []byte("external"),
+		SecretMapKeyServerSideEncryption: []byte("aws:kms"),
+		SecretMapKeySSEKMSKeyID:          []byte("key"),
+	})).To(gomega.Succeed())
+	g.Expect(h.InitObjectStoreConnection(ts.URL, "randomid", "randomkey", "minio")).To(gomega.Succeed())
+
+	dplObj := DeployableObject{Name: "cm.yaml", Content: []byte("apiVersion: v1\nkind: ConfigMap\n")}
+
+	g.Expect(h.Put("bucket", dplObj)).To(gomega.Succeed())
+	g.Expect(h.Put("bucket", dplObj)).To(gomega.Succeed())
+
+	// The role is assumed once with the access key and the external ID
+	g.Expect(server.stsForms).To(gomega.HaveLen(1))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("Action", "AssumeRole"))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("RoleArn", "arn:aws:iam::123456789012:role/channel"))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("ExternalId", "external"))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("RoleSessionName", defaultRoleSessionName))
+
+	// The objects are written with the role credentials and encrypted
+	g.Expect(server.puts).To(gomega.HaveLen(2))
+	g.Expect(server.puts[0].Get("Authorization")).To(gomega.ContainSubstring("Credential=ROLEKEY/"))
+	g.Expect(server.puts[0].Get("X-Amz-Security-Token")).To(gomega.Equal("TOKEN1"))
+	g.Expect(server.puts[0].Get("X-Amz-Server-Side-Encryption")).To(gomega.Equal("aws:kms"))
+	g.Expect(server.puts[0].Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")).To(gomega.Equal("key"))
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// The credentials are in the expiry window, they are refreshed for each request
+	server := &fakeSTSServer{expiration: credentialsExpiryWindow / 2}
+	ts := httptest.NewServer(server)
+
+	defer ts.Close()
+
+	h := &Handler{}
+	g.Expect(h.SetOptions(map[string][]byte{
+		SecretMapKeyRoleARN:          []byte("arn:aws:iam::123456789012:role/channel"),
+		SecretMapKeyWebIdentityToken: []byte("serviceaccounttoken"),
+		SecretMapKeyRoleSessionName:  []byte("cluster1"),
+	})).To(gomega.Succeed())
+	g.Expect(h.InitObjectStoreConnection(ts.URL, "", "", "minio")).To(gomega.Succeed())
+
+	dplObj := DeployableObject{Name: "cm.yaml", Content: []byte("apiVersion: v1\nkind: ConfigMap\n")}
+
+	g.Expect(h.Put("bucket", dplObj)).To(gomega.Succeed())
+	g.Expect(h.Put("bucket", dplObj)).To(gomega.Succeed())
+
+	g.Expect(server.stsForms).To(gomega.HaveLen(2))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("Action", "AssumeRoleWithWebIdentity"))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("WebIdentityToken", "serviceaccounttoken"))
+	g.Expect(server.stsForms[0]).To(gomega.HaveKeyWithValue("RoleSessionName", "cluster1"))
+
+	g.Expect(server.puts).To(gomega.HaveLen(2))
+	g.Expect(server.puts[0].Get("X-Amz-Security-Token")).To(gomega.Equal("TOKEN1"))
+	g.Expect(server.puts[1].Get("X-Amz-Security-Token")).To(gomega.Equal("TOKEN2"))
+	g.Expect(server.puts[0].Get("X-Amz-Server-Side-Encryption")).To(gomega.BeEmpty())
+}
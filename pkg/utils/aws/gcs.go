@@ -0,0 +1,268 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"k8s.io/klog"
+)
+
+const (
+	// gcsMetaGenerateName is the object metadata key of the deployable generate name
+	gcsMetaGenerateName = "generatename"
+	// gcsMetaVersion is the object metadata key of the deployable version
+	gcsMetaVersion = "deployableversion"
+)
+
+var _ ObjectStore = &GCSHandler{}
+
+// GCSHandler handles connections to Google Cloud Storage.
+type GCSHandler struct {
+	*storage.Client
+	projectID string
+	// httpClient replaces the authenticated HTTP client, for example to connect to a fake server
+	httpClient *http.Client
+}
+
+// InitObjectStoreConnection connect to Google Cloud Storage. The secret access key is the JSON key of a service
+// account. The Google application default credentials are used without key. The access key ID is the project of
+// the buckets that are created, the project of the service account by default.
+func (h *GCSHandler) InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region string) error {
+	klog.Infof("Preparing GCS settings endpoint: %v", endpoint)
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		klog.Error("Failed to parse GCS endpoint. error: ", err)
+
+		return err
+	}
+
+	var opts []option.ClientOption
+
+	// gs:// connects to storage.googleapis.com, gs://host connects to another server with the GCS JSON API
+	if u.Host != "" {
+		opts = append(opts, option.WithEndpoint("https://"+u.Host+strings.TrimSuffix(u.Path, "/")+"/storage/v1/"))
+	}
+
+	h.projectID = accessKeyID
+
+	switch {
+	case h.httpClient != nil:
+		opts = append(opts, option.WithHTTPClient(h.httpClient))
+	case secretAccessKey != "":
+		opts = append(opts, option.WithCredentialsJSON([]byte(secretAccessKey)))
+
+		if h.projectID == "" {
+			key := struct {
+				ProjectID string `json:"project_id"`
+			}{}
+
+			if err := json.Unmarshal([]byte(secretAccessKey), &key); err != nil {
+				klog.Error("Failed to parse the GCS service account key. error: ", err)
+
+				return err
+			}
+
+			h.projectID = key.ProjectID
+		}
+	}
+
+	h.Client, err = storage.NewClient(context.TODO(), opts...)
+	if err != nil {
+		klog.Error("Failed to connect to GCS. error: ", err)
+
+		return err
+	}
+
+	klog.V(1).Info("GCS configured ")
+
+	return nil
+}
+
+// Create a bucket.
+func (h *GCSHandler) Create(bucket string) error {
+	if h.projectID == "" {
+		return errors.New("no project to create GCS bucket " + bucket)
+	}
+
+	if err := h.Client.Bucket(bucket).Create(context.TODO(), h.projectID, nil); err != nil {
+		klog.Error("Failed to create bucket ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Exists Checks whether a bucket exists and is accessible.
+func (h *GCSHandler) Exists(bucket string) error {
+	if _, err := h.Client.Bucket(bucket).Attrs(context.TODO()); err != nil {
+		klog.Error("Failed to access bucket ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// List all objects in bucket.
+func (h *GCSHandler) List(bucket string, folderName *string) ([]string, error) {
+	objects, err := h.ListObjects(bucket, folderName)
+
+	return objectKeys(objects), err
+}
+
+// ListObjects lists all objects in bucket with their ETag, generation and last modified time.
+func (h *GCSHandler) ListObjects(bucket string, folderName *string) ([]ObjectInfo, error) {
+	klog.V(1).Info("List GCS Objects ", bucket)
+
+	query := &storage.Query{}
+
+	if prefix := folderPrefix(folderName); prefix != nil {
+		query.Prefix = *prefix
+	}
+
+	var objects []ObjectInfo
+
+	it := h.Client.Bucket(bucket).Objects(context.TODO(), query)
+
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+
+		if err != nil {
+			klog.Infof("Got error retrieving list of objects. err: %v", err)
+
+			return objects, err
+		}
+
+		if strings.HasSuffix(attrs.Name, "/") {
+			klog.V(1).Info("Skipping GCS Object: ", attrs.Name)
+
+			continue
+		}
+
+		objects = append(objects, ObjectInfo{
+			Key:          attrs.Name,
+			ETag:         attrs.Etag,
+			VersionID:    strconv.FormatInt(attrs.Generation, 10),
+			LastModified: attrs.Updated,
+		})
+	}
+
+	klog.Infof("List GCS Objects result, keys: %v", objectKeys(objects))
+
+	return objects, nil
+}
+
+// Get get existing object.
+func (h *GCSHandler) Get(bucket, name string) (DeployableObject, error) {
+	obj := h.Client.Bucket(bucket).Object(name)
+
+	attrs, err := obj.Attrs(context.TODO())
+	if err != nil {
+		klog.Error("Failed to send Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	reader, err := obj.NewReader(context.TODO())
+	if err != nil {
+		klog.Error("Failed to send Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		klog.Error("Failed to parse Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	if len(content) == 0 {
+		return DeployableObject{}, nil
+	}
+
+	return DeployableObject{
+		Name:         name,
+		GenerateName: attrs.Metadata[gcsMetaGenerateName],
+		Version:      attrs.Metadata[gcsMetaVersion],
+		Content:      content,
+	}, nil
+}
+
+// Put create new object.
+func (h *GCSHandler) Put(bucket string, dplObj DeployableObject) error {
+	if dplObj.isEmpty() {
+		klog.V(1).Infof("got an empty deployableObject to put to object store")
+
+		return nil
+	}
+
+	writer := h.Client.Bucket(bucket).Object(dplObj.Name).NewWriter(context.TODO())
+	writer.Metadata = map[string]string{}
+
+	if dplObj.GenerateName != "" {
+		writer.Metadata[gcsMetaGenerateName] = dplObj.GenerateName
+	}
+
+	if dplObj.Version != "" {
+		writer.Metadata[gcsMetaVersion] = dplObj.Version
+	}
+
+	if _, err := writer.Write(dplObj.Content); err != nil {
+		klog.Error("Failed to send Put request. error: ", err)
+
+		_ = writer.Close()
+
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		klog.Error("Failed to send Put request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete delete existing object.
+func (h *GCSHandler) Delete(bucket, name string) error {
+	err := h.Client.Bucket(bucket).Object(name).Delete(context.TODO())
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		klog.Error("Failed to send Delete request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/onsi/gomega"
+)
+
+func TestGCSObjectStore(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// Set up a fake GCS server
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer server.Stop()
+
+	gcsHandler := &GCSHandler{httpClient: server.HTTPClient()}
+
+	g.Expect(gcsHandler.InitObjectStoreConnection("gs://", "test-project", "", "")).To(gomega.Succeed())
+
+	testObjectStore(g, gcsHandler, true)
+}
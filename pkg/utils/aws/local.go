@@ -0,0 +1,272 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+var _ ObjectStore = &LocalHandler{}
+
+// LocalHandler handles buckets in the local filesystem, for air-gapped clusters and tests. Buckets are the
+// directories of the endpoint directory and object names are file paths relative to the bucket directory.
+// Object metadata, like the deployable generate name and version, is not stored.
+type LocalHandler struct {
+	// baseDir is the directory the endpoint directories have to be in
+	baseDir string
+	root    string
+}
+
+// NewLocalHandler returns a local object store handler of the endpoint directories in baseDir
+func NewLocalHandler(baseDir string) *LocalHandler {
+	return &LocalHandler{baseDir: baseDir}
+}
+
+// InitObjectStoreConnection sets the directory of the buckets, file:///<directory>, the directory has to be in the
+// base directory of the handler. The credentials are not used.
+func (h *LocalHandler) InitObjectStoreConnection(endpoint, accessKeyID, secretAccessKey, region string) error {
+	klog.Infof("Preparing local object store settings endpoint: %v", endpoint)
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		klog.Error("Failed to parse local object store endpoint. error: ", err)
+
+		return err
+	}
+
+	if u.Host != "" && u.Host != "localhost" {
+		return errors.New("local object store endpoint " + endpoint + " is not on the local host")
+	}
+
+	if u.Path == "" {
+		return errors.New("no directory in local object store endpoint " + endpoint)
+	}
+
+	if h.baseDir == "" {
+		return errors.New("local object store endpoint " + endpoint + " is not allowed, the local object store is not enabled")
+	}
+
+	base := filepath.Clean(h.baseDir)
+	root := filepath.Clean(filepath.FromSlash(u.Path))
+
+	if root != base && !strings.HasPrefix(root, base+string(filepath.Separator)) {
+		return errors.New("local object store endpoint " + endpoint + " is not in the allowed directory " + base)
+	}
+
+	h.root = root
+
+	klog.V(1).Info("Local object store configured ")
+
+	return nil
+}
+
+// bucketPath returns the directory of the bucket
+func (h *LocalHandler) bucketPath(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+
+	return filepath.Join(h.root, bucket), nil
+}
+
+// objectPath returns the file of the object in the bucket
+func (h *LocalHandler) objectPath(bucket, name string) (string, error) {
+	dir, err := h.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+
+	return file, nil
+}
+
+// Create a bucket.
+func (h *LocalHandler) Create(bucket string) error {
+	dir, err := h.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		klog.Error("Failed to create bucket ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Exists Checks whether a bucket exists and is accessible.
+func (h *LocalHandler) Exists(bucket string) error {
+	dir, err := h.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		klog.Error("Failed to access bucket ", bucket, ". error: ", err)
+
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("bucket %s is not a directory", dir)
+	}
+
+	return nil
+}
+
+// List all objects in bucket.
+func (h *LocalHandler) List(bucket string, folderName *string) ([]string, error) {
+	objects, err := h.ListObjects(bucket, folderName)
+
+	return objectKeys(objects), err
+}
+
+// ListObjects lists all objects in bucket. The ETag is made of the file size and modification time.
+func (h *LocalHandler) ListObjects(bucket string, folderName *string) ([]ObjectInfo, error) {
+	klog.V(1).Info("List local Objects ", bucket)
+
+	dir, err := h.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Exists(bucket); err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if folder := folderPrefix(folderName); folder != nil {
+		prefix = *folder
+	}
+
+	var objects []ObjectInfo
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				ETag:         fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano()),
+				LastModified: info.ModTime(),
+			})
+		}
+
+		return nil
+	})
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	klog.Infof("List local Objects result, keys: %v, err: %v", objectKeys(objects), err)
+
+	return objects, err
+}
+
+// Get get existing object.
+func (h *LocalHandler) Get(bucket, name string) (DeployableObject, error) {
+	file, err := h.objectPath(bucket, name)
+	if err != nil {
+		return DeployableObject{}, err
+	}
+
+	content, err := ioutil.ReadFile(file) // #nosec G304 the file is in the bucket directory
+	if err != nil {
+		klog.Error("Failed to send Get request. error: ", err)
+
+		return DeployableObject{}, err
+	}
+
+	if len(content) == 0 {
+		return DeployableObject{}, nil
+	}
+
+	return DeployableObject{Name: name, Content: content}, nil
+}
+
+// Put create new object.
+func (h *LocalHandler) Put(bucket string, dplObj DeployableObject) error {
+	if dplObj.isEmpty() {
+		klog.V(1).Infof("got an empty deployableObject to put to object store")
+
+		return nil
+	}
+
+	if err := h.Exists(bucket); err != nil {
+		return err
+	}
+
+	file, err := h.objectPath(bucket, dplObj.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		klog.Error("Failed to send Put request. error: ", err)
+
+		return err
+	}
+
+	if err := ioutil.WriteFile(file, dplObj.Content, 0600); err != nil {
+		klog.Error("Failed to send Put request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete delete existing object.
+func (h *LocalHandler) Delete(bucket, name string) error {
+	file, err := h.objectPath(bucket, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		klog.Error("Failed to send Delete request. error: ", err)
+
+		return err
+	}
+
+	return nil
+}
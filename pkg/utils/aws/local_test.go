@@ -0,0 +1,55 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+func TestLocalObjectStore(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "buckets")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	localHandler := NewLocalHandler(dir)
+
+	g.Expect(localHandler.InitObjectStoreConnection("file://"+dir, "", "", "")).To(gomega.Succeed())
+
+	testObjectStore(g, localHandler, false)
+
+	// Objects are in the bucket directory
+	g.Expect(localHandler.Put("test", DeployableObject{Name: "../escape", Content: []byte("data")})).NotTo(gomega.Succeed())
+	_, err = localHandler.Get("test", "../../etc/passwd")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(localHandler.Exists("..")).NotTo(gomega.Succeed())
+
+	g.Expect(localHandler.InitObjectStoreConnection("file://remote-host/buckets", "", "", "")).NotTo(gomega.Succeed())
+
+	// The endpoint directory is in the base directory
+	g.Expect(localHandler.InitObjectStoreConnection("file://"+dir+"/buckets", "", "", "")).To(gomega.Succeed())
+	g.Expect(localHandler.InitObjectStoreConnection("file://"+dir+"/../etc", "", "", "")).NotTo(gomega.Succeed())
+	g.Expect(localHandler.InitObjectStoreConnection("file:///etc", "", "", "")).NotTo(gomega.Succeed())
+	g.Expect(localHandler.InitObjectStoreConnection("file://"+dir+"-other", "", "", "")).NotTo(gomega.Succeed())
+
+	// The local object store is disabled without a base directory
+	g.Expect((&LocalHandler{}).InitObjectStoreConnection("file://"+dir, "", "", "")).NotTo(gomega.Succeed())
+}
@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -32,12 +34,21 @@ type ObjectStore interface {
 	Exists(bucket string) error
 	Create(bucket string) error
 	List(bucket string, folderName *string) ([]string, error)
+	ListObjects(bucket string, folderName *string) ([]ObjectInfo, error)
 	Put(bucket string, dplObj DeployableObject) error
 	Delete(bucket, name string) error
 	Get(bucket, name string) (DeployableObject, error)
 }
 
-var _ ObjectStore = &Handler{}
+// VersionedObjectStore is an object store that keeps the previous versions of the objects.
+type VersionedObjectStore interface {
+	ObjectStore
+	// ListObjectsAt lists the object versions that were the latest ones at the time, as a snapshot of the bucket
+	ListObjectsAt(bucket string, folderName *string, at time.Time) ([]ObjectInfo, error)
+	GetVersion(bucket, name, versionID string) (DeployableObject, error)
+}
+
+var _ VersionedObjectStore = &Handler{}
 
 const (
 	// SecretMapKeyAccessKeyID is key of accesskeyid in secret.
@@ -59,6 +70,7 @@ const (
 // Handler handles connections to aws.
 type Handler struct {
 	*s3.Client
+	options S3Options
 }
 
 // credentialProvider provides credetials for mcm hub deployable.
@@ -66,14 +78,23 @@ type credentialProvider struct {
 	Value aws.Credentials
 }
 
-// Retrieve follow the Provider interface.
-func (p credentialProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
-	awscred := aws.Credentials{
-		SecretAccessKey: p.Value.SecretAccessKey,
-		AccessKeyID:     p.Value.AccessKeyID,
+// ObjectInfo is the object information returned by the list calls, which changes when the object changes.
+type ObjectInfo struct {
+	Key          string
+	ETag         string
+	VersionID    string
+	LastModified time.Time
+}
+
+// objectKeys returns the keys of the listed objects
+func objectKeys(objects []ObjectInfo) []string {
+	var keys []string
+
+	for _, object := range objects {
+		keys = append(keys, object.Key)
 	}
 
-	return awscred, nil
+	return keys
 }
 
 type DeployableObject struct {
@@ -135,11 +156,11 @@ func (h *Handler) InitObjectStoreConnection(endpoint, accessKeyID, secretAccessK
 		return err
 	}
 
-	objCredential := credentialProvider{
-		Value: aws.Credentials{
-			AccessKeyID:     accessKeyID,
-			SecretAccessKey: secretAccessKey,
-		},
+	objCredential, err := h.credentialsProvider(cfg, accessKeyID, secretAccessKey, objectRegion)
+	if err != nil {
+		klog.Error("Failed to get the object store credentials. error: ", err)
+
+		return err
 	}
 
 	h.Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
@@ -191,27 +212,39 @@ func (h *Handler) Exists(bucket string) error {
 
 // List all objects in bucket.
 func (h *Handler) List(bucket string, folderName *string) ([]string, error) {
-	klog.V(1).Info("List S3 Objects ", bucket)
+	objects, err := h.ListObjects(bucket, folderName)
 
-	if folderName != nil {
-		tmpFolderName := *folderName
-		if len(tmpFolderName) > 0 && tmpFolderName[len(tmpFolderName)-1:] != "/" {
-			tmpFolderName += "/"
-		}
+	return objectKeys(objects), err
+}
 
-		folderName = &tmpFolderName
+// folderPrefix returns the key prefix of the objects in the folder
+func folderPrefix(folderName *string) *string {
+	if folderName == nil {
+		return nil
 	}
 
+	prefix := *folderName
+	if len(prefix) > 0 && prefix[len(prefix)-1:] != "/" {
+		prefix += "/"
+	}
+
+	return &prefix
+}
+
+// ListObjects lists all objects in bucket with their ETag and last modified time.
+func (h *Handler) ListObjects(bucket string, folderName *string) ([]ObjectInfo, error) {
+	klog.V(1).Info("List S3 Objects ", bucket)
+
 	params := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: folderName,
+		Prefix: folderPrefix(folderName),
 	}
 
 	paginator := s3.NewListObjectsV2Paginator(h.Client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
 		o.Limit = 1000
 	})
 
-	var keys []string
+	var objects []ObjectInfo
 
 	var objErr error
 
@@ -229,7 +262,11 @@ func (h *Handler) List(bucket string, folderName *string) ([]string, error) {
 		for _, value := range output.Contents {
 			key := *value.Key
 			if len(key) > 0 && key[len(key)-1:] != "/" {
-				keys = append(keys, *value.Key)
+				objects = append(objects, ObjectInfo{
+					Key:          key,
+					ETag:         aws.ToString(value.ETag),
+					LastModified: aws.ToTime(value.LastModified),
+				})
 			} else {
 				klog.V(1).Info("Skipping S3 Object: ", key)
 			}
@@ -237,25 +274,120 @@ func (h *Handler) List(bucket string, folderName *string) ([]string, error) {
 		pageNum++
 	}
 
-	klog.Infof("List S3 Objects result, page Num: %v, keys: %v, err: %v ", pageNum, keys, objErr)
+	klog.Infof("List S3 Objects result, page Num: %v, keys: %v, err: %v ", pageNum, objectKeys(objects), objErr)
+
+	return objects, objErr
+}
+
+// ListObjectsAt lists the object versions that were the latest ones at the time. The bucket must have versioning
+// enabled. Objects that were deleted at the time are not listed.
+func (h *Handler) ListObjectsAt(bucket string, folderName *string, at time.Time) ([]ObjectInfo, error) {
+	klog.V(1).Info("List S3 Object versions ", bucket, " at ", at)
+
+	params := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: folderPrefix(folderName),
+	}
+
+	// the latest version or delete marker of each key at the time
+	latest := map[string]ObjectInfo{}
+	deleted := map[string]bool{}
+
+	isLatest := func(key string, lastModified time.Time) bool {
+		if lastModified.After(at) {
+			return false
+		}
+
+		current, ok := latest[key]
+
+		return !ok || lastModified.After(current.LastModified)
+	}
+
+	for {
+		output, err := h.Client.ListObjectVersions(context.TODO(), params)
+		if err != nil {
+			klog.Infof("Got error retrieving list of object versions. err: %v", err)
+
+			return nil, err
+		}
+
+		for _, value := range output.Versions {
+			key := aws.ToString(value.Key)
+			if key == "" || strings.HasSuffix(key, "/") || !isLatest(key, aws.ToTime(value.LastModified)) {
+				continue
+			}
+
+			latest[key] = ObjectInfo{
+				Key:          key,
+				ETag:         aws.ToString(value.ETag),
+				VersionID:    aws.ToString(value.VersionId),
+				LastModified: aws.ToTime(value.LastModified),
+			}
+			deleted[key] = false
+		}
+
+		for _, value := range output.DeleteMarkers {
+			key := aws.ToString(value.Key)
+			if key == "" || !isLatest(key, aws.ToTime(value.LastModified)) {
+				continue
+			}
+
+			latest[key] = ObjectInfo{Key: key, VersionID: aws.ToString(value.VersionId), LastModified: aws.ToTime(value.LastModified)}
+			deleted[key] = true
+		}
+
+		if !output.IsTruncated {
+			break
+		}
+
+		params.KeyMarker = output.NextKeyMarker
+		params.VersionIdMarker = output.NextVersionIdMarker
+	}
+
+	var objects []ObjectInfo
+
+	for key, object := range latest {
+		if !deleted[key] {
+			objects = append(objects, object)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	klog.Infof("List S3 Object versions result at %v, keys: %v", at, objectKeys(objects))
 
-	return keys, objErr
+	return objects, nil
 }
 
 // Get get existing object.
 func (h *Handler) Get(bucket, name string) (DeployableObject, error) {
-	dplObj := DeployableObject{}
-
-	resp, err := h.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+	return h.getObject(&s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &name,
 	})
+}
+
+// GetVersion gets a version of an object.
+func (h *Handler) GetVersion(bucket, name, versionID string) (DeployableObject, error) {
+	return h.getObject(&s3.GetObjectInput{
+		Bucket:    &bucket,
+		Key:       &name,
+		VersionId: &versionID,
+	})
+}
+
+func (h *Handler) getObject(input *s3.GetObjectInput) (DeployableObject, error) {
+	dplObj := DeployableObject{}
+
+	resp, err := h.Client.GetObject(context.TODO(), input)
 	if err != nil {
 		klog.Error("Failed to send Get request. error: ", err)
 
 		return dplObj, err
 	}
 
+	defer resp.Body.Close()
+
 	generateName := resp.Metadata[DployableMateGenerateNameKey]
 	version := resp.Metadata[DeployableMetaVersionKey]
 	body, err := ioutil.ReadAll(resp.Body)
@@ -270,7 +402,7 @@ func (h *Handler) Get(bucket, name string) (DeployableObject, error) {
 		return DeployableObject{}, nil
 	}
 
-	dplObj.Name = name
+	dplObj.Name = *input.Key
 	dplObj.GenerateName = generateName
 	dplObj.Content = body
 	dplObj.Version = version
@@ -288,11 +420,18 @@ func (h *Handler) Put(bucket string, dplObj DeployableObject) error {
 		return nil
 	}
 
-	resp, err := h.Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &dplObj.Name,
-		Body:   bytes.NewReader(dplObj.Content),
-	})
+	input := &s3.PutObjectInput{
+		Bucket:               &bucket,
+		Key:                  &dplObj.Name,
+		Body:                 bytes.NewReader(dplObj.Content),
+		ServerSideEncryption: h.options.ServerSideEncryption,
+	}
+
+	if h.options.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = &h.options.SSEKMSKeyID
+	}
+
+	resp, err := h.Client.PutObject(context.TODO(), input)
 	if err != nil {
 		klog.Error("Failed to send Put request. error: ", err)
 
@@ -15,9 +15,14 @@
 package aws
 
 import (
+	"context"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/onsi/gomega"
@@ -110,3 +115,68 @@ func TestObjectstore(t *testing.T) {
 	_, err = awshandler.Get("test", "testObj")
 	g.Expect(err).To(gomega.HaveOccurred())
 }
+
+func TestObjectstoreVersions(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	start := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	timeSource := gofakes3.FixedTimeSource(start)
+
+	// Set up a fake S3 server with object versioning
+	backend := s3mem.New(s3mem.WithTimeSource(timeSource))
+	faker := gofakes3.New(backend, gofakes3.WithTimeSource(timeSource), gofakes3.WithTimeSkewLimit(0))
+	ts := httptest.NewServer(faker.Server())
+
+	defer ts.Close()
+
+	awshandler := &Handler{}
+
+	g.Expect(awshandler.InitObjectStoreConnection(ts.URL, "randomid", "randomkey", "minio")).To(gomega.Succeed())
+	g.Expect(awshandler.Create("test")).To(gomega.Succeed())
+
+	_, err := awshandler.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String("test"),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(awshandler.Put("test", DeployableObject{Name: "cm.yaml", Content: []byte("v1")})).To(gomega.Succeed())
+
+	timeSource.Advance(time.Hour)
+	g.Expect(awshandler.Put("test", DeployableObject{Name: "cm.yaml", Content: []byte("v2")})).To(gomega.Succeed())
+	g.Expect(awshandler.Put("test", DeployableObject{Name: "secret.yaml", Content: []byte("v1")})).To(gomega.Succeed())
+
+	// The latest objects have an ETag and a last modified time
+	objects, err := awshandler.ListObjects("test", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objectKeys(objects)).To(gomega.Equal([]string{"cm.yaml", "secret.yaml"}))
+	g.Expect(objects[0].ETag).NotTo(gomega.BeEmpty())
+	g.Expect(objects[0].LastModified).To(gomega.BeTemporally("==", start.Add(time.Hour)))
+
+	timeSource.Advance(time.Hour)
+	g.Expect(awshandler.Delete("test", "cm.yaml")).To(gomega.Succeed())
+
+	// The first snapshot has the first version of cm.yaml
+	objects, err = awshandler.ListObjectsAt("test", nil, start.Add(30*time.Minute))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objects).To(gomega.HaveLen(1))
+	g.Expect(objects[0].Key).To(gomega.Equal("cm.yaml"))
+
+	obj, err := awshandler.GetVersion("test", "cm.yaml", objects[0].VersionID)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(obj.Content)).To(gomega.Equal("v1"))
+
+	// The second snapshot has both objects
+	objects, err = awshandler.ListObjectsAt("test", nil, start.Add(90*time.Minute))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objectKeys(objects)).To(gomega.Equal([]string{"cm.yaml", "secret.yaml"}))
+
+	obj, err = awshandler.GetVersion("test", "cm.yaml", objects[0].VersionID)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(obj.Content)).To(gomega.Equal("v2"))
+
+	// cm.yaml is deleted in the last snapshot
+	objects, err = awshandler.ListObjectsAt("test", nil, start.Add(3*time.Hour))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objectKeys(objects)).To(gomega.Equal([]string{"secret.yaml"}))
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// SchemeAzureBlob is the channel URL scheme of Azure Blob storage, azblob://<account>.blob.core.windows.net/<container>
+	SchemeAzureBlob = "azblob"
+	// SchemeAzureBlobHTTP is the channel URL scheme of Azure Blob storage emulators without TLS, like Azurite
+	SchemeAzureBlobHTTP = "azblob+http"
+	// SchemeGCS is the channel URL scheme of Google Cloud Storage, gs://<bucket>
+	SchemeGCS = "gs"
+	// SchemeLocal is the channel URL scheme of buckets in the local filesystem, file:///<directory>/<bucket>,
+	// it's only supported when enabled by EnableLocalObjectStore
+	SchemeLocal = "file"
+	// SchemeS3 is the channel URL scheme of AWS S3
+	SchemeS3 = "s3"
+)
+
+// ObjectStoreFactory returns a new object store client that is not connected yet
+type ObjectStoreFactory func() ObjectStore
+
+var (
+	objectStoreFactoriesLock sync.RWMutex
+
+	// objectStoreFactories are the object store backends by channel URL scheme. S3 compatible object stores,
+	// like AWS S3 and MinIO, have http or https URLs.
+	objectStoreFactories = map[string]ObjectStoreFactory{
+		"":                  func() ObjectStore { return &Handler{} },
+		"http":              func() ObjectStore { return &Handler{} },
+		"https":             func() ObjectStore { return &Handler{} },
+		SchemeS3:            func() ObjectStore { return &Handler{} },
+		SchemeAzureBlob:     func() ObjectStore { return &AzureBlobHandler{} },
+		SchemeAzureBlobHTTP: func() ObjectStore { return &AzureBlobHandler{} },
+		SchemeGCS:           func() ObjectStore { return &GCSHandler{} },
+	}
+)
+
+// RegisterObjectStore registers the object store backend of a channel URL scheme, replacing the existing one
+func RegisterObjectStore(scheme string, factory ObjectStoreFactory) {
+	objectStoreFactoriesLock.Lock()
+	defer objectStoreFactoriesLock.Unlock()
+
+	objectStoreFactories[strings.ToLower(scheme)] = factory
+}
+
+// EnableLocalObjectStore registers the local filesystem backend, the buckets of the file:// channels have to be in
+// the baseDir directory
+func EnableLocalObjectStore(baseDir string) {
+	RegisterObjectStore(SchemeLocal, func() ObjectStore { return NewLocalHandler(baseDir) })
+}
+
+// NewObjectStore returns a new object store client of the backend registered for the scheme of the endpoint
+func NewObjectStore(endpoint string) (ObjectStore, error) {
+	scheme := ""
+	if loc := strings.Index(endpoint, "://"); loc >= 0 {
+		scheme = strings.ToLower(endpoint[:loc])
+	}
+
+	objectStoreFactoriesLock.RLock()
+	defer objectStoreFactoriesLock.RUnlock()
+
+	factory, ok := objectStoreFactories[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported object store scheme %q of %s", scheme, endpoint)
+	}
+
+	return factory(), nil
+}
+
+// ParseObjectBucketPath splits the pathname of an object bucket channel into the object store endpoint and the bucket
+func ParseObjectBucketPath(pathname string) (string, string, error) {
+	pathname = strings.TrimSuffix(pathname, "/")
+
+	loc := strings.LastIndex(pathname, "/")
+	if loc < 0 || loc == len(pathname)-1 {
+		return "", "", errors.New("no bucket in object bucket path " + pathname)
+	}
+
+	endpoint := pathname[:loc]
+	bucket := pathname[loc+1:]
+
+	// The bucket follows the scheme directly, like gs://bucket
+	if strings.HasSuffix(endpoint, ":/") {
+		endpoint += "/"
+	}
+
+	return endpoint, bucket, nil
+}
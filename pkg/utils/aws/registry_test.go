@@ -0,0 +1,147 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+func TestParseObjectBucketPath(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := map[string][2]string{
+		"http://minio:9000/bucket":                              {"http://minio:9000", "bucket"},
+		"https://s3.amazonaws.com/bucket/":                      {"https://s3.amazonaws.com", "bucket"},
+		"azblob://account.blob.core.windows.net/container":      {"azblob://account.blob.core.windows.net", "container"},
+		"azblob+http://127.0.0.1:10000/devstoreaccount1/charts": {"azblob+http://127.0.0.1:10000/devstoreaccount1", "charts"},
+		"gs://bucket":                    {"gs://", "bucket"},
+		"file:///var/lib/buckets/bucket": {"file:///var/lib/buckets", "bucket"},
+	}
+
+	for pathname, expected := range tests {
+		endpoint, bucket, err := ParseObjectBucketPath(pathname)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect([2]string{endpoint, bucket}).To(gomega.Equal(expected), pathname)
+	}
+
+	_, _, err := ParseObjectBucketPath("bucket")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestNewObjectStore(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := map[string]ObjectStore{
+		"http://minio:9000":                      &Handler{},
+		"https://s3.amazonaws.com":               &Handler{},
+		"s3://s3.amazonaws.com":                  &Handler{},
+		"azblob://account.blob.core.windows.net": &AzureBlobHandler{},
+		"AZBLOB+HTTP://127.0.0.1:10000/account":  &AzureBlobHandler{},
+		"gs://":                                  &GCSHandler{},
+	}
+
+	for endpoint, expected := range tests {
+		objectStore, err := NewObjectStore(endpoint)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(objectStore).To(gomega.BeAssignableToTypeOf(expected), endpoint)
+	}
+
+	_, err := NewObjectStore("ftp://example.com")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// The local filesystem backend is enabled by the operator only
+	_, err = NewObjectStore("file:///var/lib/buckets")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	EnableLocalObjectStore("/var/lib/buckets")
+
+	defer func() {
+		objectStoreFactoriesLock.Lock()
+		delete(objectStoreFactories, SchemeLocal)
+		objectStoreFactoriesLock.Unlock()
+	}()
+
+	objectStore, err := NewObjectStore("file:///var/lib/buckets")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objectStore).To(gomega.Equal(NewLocalHandler("/var/lib/buckets")))
+
+	// Other backends can be registered
+	RegisterObjectStore("ftp", func() ObjectStore { return &LocalHandler{} })
+
+	defer func() {
+		objectStoreFactoriesLock.Lock()
+		delete(objectStoreFactories, "ftp")
+		objectStoreFactoriesLock.Unlock()
+	}()
+
+	objectStore, err = NewObjectStore("ftp://example.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objectStore).To(gomega.BeAssignableToTypeOf(&LocalHandler{}))
+}
+
+// testObjectStore runs the object store operations used by the subscriptions against a connected backend
+func testObjectStore(g *gomega.GomegaWithT, objectStore ObjectStore, withMetadata bool) {
+	g.Expect(objectStore.Create("test")).To(gomega.Succeed())
+
+	g.Expect(objectStore.Exists("test")).To(gomega.Succeed())
+	g.Expect(objectStore.Exists("notest")).NotTo(gomega.Succeed())
+
+	keys, err := objectStore.List("test", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(keys).To(gomega.BeEmpty())
+
+	testObj := DeployableObject{
+		Name:         "testObj",
+		GenerateName: "generateTestObj",
+		Version:      "1.1.1",
+		Content:      []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-from-ch-qa\n"),
+	}
+
+	g.Expect(objectStore.Put("test", testObj)).To(gomega.Succeed())
+
+	folderObj := testObj
+	folderObj.Name = "subfolder1/configmap3.yaml"
+	g.Expect(objectStore.Put("test", folderObj)).To(gomega.Succeed())
+
+	keys, err = objectStore.List("test", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(keys).To(gomega.ConsistOf("testObj", "subfolder1/configmap3.yaml"))
+
+	folderName := "subfolder1"
+	keys, err = objectStore.List("test", &folderName)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(keys).To(gomega.ConsistOf("subfolder1/configmap3.yaml"))
+
+	obj, err := objectStore.Get("test", "testObj")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(obj.Name).To(gomega.Equal("testObj"))
+	g.Expect(obj.Content).To(gomega.Equal(testObj.Content))
+
+	if withMetadata {
+		g.Expect(obj.GenerateName).To(gomega.Equal("generateTestObj"))
+		g.Expect(obj.Version).To(gomega.Equal("1.1.1"))
+	}
+
+	_, err = objectStore.Get("test", "testObjDummy")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	g.Expect(objectStore.Delete("test", "testObjDummy")).To(gomega.Succeed())
+	g.Expect(objectStore.Delete("test", "testObj")).To(gomega.Succeed())
+
+	_, err = objectStore.Get("test", "testObj")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
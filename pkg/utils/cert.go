@@ -28,7 +28,17 @@ import (
 	"k8s.io/klog"
 )
 
+// SelfSignedCertValidity is the validity of the certificates generated by GenerateServerCerts
+const SelfSignedCertValidity = 5 * 365 * 24 * time.Hour
+
+// GenerateServerCerts generates a self-signed certificate and its key in the tls.crt and tls.key files of the dir
 func GenerateServerCerts(dir string) error {
+	return GenerateServerCertsWithValidity(dir, SelfSignedCertValidity)
+}
+
+// GenerateServerCertsWithValidity generates a self-signed certificate valid for the duration and its key in the
+// tls.crt and tls.key files of the dir
+func GenerateServerCertsWithValidity(dir string, validity time.Duration) error {
 	var err error
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 
@@ -38,7 +48,7 @@ func GenerateServerCerts(dir string) error {
 	}
 
 	notBefore := time.Now()
-	notAfter := notBefore.AddDate(5, 0, 0)
+	notAfter := notBefore.Add(validity)
 
 	ca := x509.Certificate{
 		SerialNumber: big.NewInt(2019),
@@ -0,0 +1,95 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ClientCert is the PEM encoded TLS client certificate presented to the channel server
+	ClientCert = "clientCert"
+	// ClientKey is the PEM encoded private key of the TLS client certificate
+	ClientKey = "clientKey"
+)
+
+// GetClientCertificateData returns the PEM encoded TLS client certificate and key of the channel secret. The
+// clientCert and clientKey keys take precedence over the tls.crt and tls.key keys of a kubernetes.io/tls secret.
+// It returns nil if the secret has no client certificate.
+func GetClientCertificateData(secret *corev1.Secret) ([]byte, []byte, error) {
+	if secret == nil {
+		return nil, nil, nil
+	}
+
+	certKey, keyKey := ClientCert, ClientKey
+
+	if len(bytes.TrimSpace(secret.Data[ClientCert])) == 0 && len(bytes.TrimSpace(secret.Data[ClientKey])) == 0 {
+		certKey, keyKey = corev1.TLSCertKey, corev1.TLSPrivateKeyKey
+	}
+
+	cert := bytes.TrimSpace(secret.Data[certKey])
+	key := bytes.TrimSpace(secret.Data[keyKey])
+
+	if len(cert) == 0 && len(key) == 0 {
+		return nil, nil, nil
+	}
+
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, nil, fmt.Errorf("%s and %s need to be specified together in the channel secret", certKey, keyKey)
+	}
+
+	return cert, key, nil
+}
+
+// HasClientCertificate returns true if the channel secret has a TLS client certificate
+func HasClientCertificate(secret *corev1.Secret) bool {
+	cert, _, err := GetClientCertificateData(secret)
+
+	return err == nil && len(cert) > 0
+}
+
+// LoadClientCertificate parses the PEM encoded TLS client certificate and key. It returns nil if both are empty.
+func LoadClientCertificate(cert, key []byte) (*tls.Certificate, error) {
+	if len(cert) == 0 && len(key) == 0 {
+		return nil, nil
+	}
+
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, errors.New("the TLS client certificate and key need to be specified together")
+	}
+
+	clientCert, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS client certificate, err: %w", err)
+	}
+
+	return &clientCert, nil
+}
+
+// GetClientCertificate returns the parsed TLS client certificate of the channel secret. It returns nil if the secret
+// has no client certificate.
+func GetClientCertificate(secret *corev1.Secret) (*tls.Certificate, error) {
+	cert, key, err := GetClientCertificateData(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadClientCertificate(cert, key)
+}
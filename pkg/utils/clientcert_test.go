@@ -0,0 +1,135 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"gopkg.in/src-d/go-git.v4"
+	gitclient "gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newTestClientCertificate(g *gomega.GomegaWithT, cn string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestGetClientCertificate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cert, key := newTestClientCertificate(g, "channel")
+	tlsCert, tlsKey := newTestClientCertificate(g, "tls")
+
+	clientCert, err := GetClientCertificate(nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(clientCert).To(gomega.BeNil())
+
+	clientCert, err = GetClientCertificate(&corev1.Secret{Data: map[string][]byte{UserID: []byte("user")}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(clientCert).To(gomega.BeNil())
+
+	// clientCert and clientKey take precedence over tls.crt and tls.key
+	secret := &corev1.Secret{Data: map[string][]byte{
+		ClientCert:              cert,
+		ClientKey:               key,
+		corev1.TLSCertKey:       tlsCert,
+		corev1.TLSPrivateKeyKey: tlsKey,
+	}}
+
+	certData, _, err := GetClientCertificateData(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(certData).To(gomega.Equal(cert[:len(cert)-1]))
+
+	clientCert, err = GetClientCertificate(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(clientCert.Certificate).To(gomega.HaveLen(1))
+
+	delete(secret.Data, ClientCert)
+	delete(secret.Data, ClientKey)
+
+	certData, _, err = GetClientCertificateData(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(certData).To(gomega.Equal(tlsCert[:len(tlsCert)-1]))
+
+	// The certificate and the key go together
+	_, _, err = GetClientCertificateData(&corev1.Secret{Data: map[string][]byte{ClientCert: cert}})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = LoadClientCertificate(cert, tlsKey)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// A client certificate is enough to authenticate to a Git server
+	_, _, _, _, err = ParseChannelSecret(&corev1.Secret{Data: map[string][]byte{ClientCert: cert, ClientKey: key}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, _, _, _, err = ParseChannelSecret(&corev1.Secret{Data: map[string][]byte{}})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestGetHTTPOptionsClientCertificate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cert, key := newTestClientCertificate(g, "channel")
+
+	// without custom TLS settings the default transport and the credentials are used
+	options := &git.CloneOptions{}
+	g.Expect(getHTTPOptions(options, &GitCloneOption{User: "user", Password: "token"})).To(gomega.Succeed())
+	g.Expect(options.Auth).To(gomega.Equal(&githttp.BasicAuth{Username: "user", Password: "token"}))
+
+	// the client certificate goes with the clone options only
+	options = &git.CloneOptions{}
+	g.Expect(getHTTPOptions(options, &GitCloneOption{User: "user", Password: "token", ClientCert: cert, ClientKey: key})).To(gomega.Succeed())
+
+	auth, ok := options.Auth.(*gitClientAuth)
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(auth.auth).To(gomega.Equal(&githttp.BasicAuth{Username: "user", Password: "token"}))
+	g.Expect(auth.client.Transport.(*http.Transport).TLSClientConfig.Certificates).To(gomega.HaveLen(1))
+
+	other := &git.CloneOptions{}
+	g.Expect(getHTTPOptions(other, &GitCloneOption{})).To(gomega.Succeed())
+	g.Expect(other.Auth).To(gomega.BeNil())
+
+	// the process wide transport is the dispatcher for all the clones
+	g.Expect(gitclient.Protocols["https"]).To(gomega.Equal(gitHTTPTransport{}))
+}
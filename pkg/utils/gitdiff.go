@@ -0,0 +1,96 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"k8s.io/klog"
+)
+
+// GetGitTreeHashes returns the object hashes of all files in the tree of the given commit of a cloned repo, keyed by
+// the file path relative to the repo root. Submodules are recorded with the hash of the commit they point to.
+// The hashes of two commits can be compared with GetChangedGitFiles even if the clone is too shallow to contain both.
+func GetGitTreeHashes(repoDir, commitID string) (map[string]string, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, errors.New("failed to open git repo " + repoDir + ", err: " + err.Error())
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(strings.TrimSpace(commitID)))
+	if err != nil {
+		return nil, errors.New("failed to get git commit " + commitID + ", err: " + err.Error())
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.New("failed to get the tree of git commit " + commitID + ", err: " + err.Error())
+	}
+
+	hashes := make(map[string]string)
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.New("failed to walk the tree of git commit " + commitID + ", err: " + err.Error())
+		}
+
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+
+		hashes[name] = entry.Hash.String()
+	}
+
+	klog.V(4).Infof("Found %d files in git commit %s", len(hashes), commitID)
+
+	return hashes, nil
+}
+
+// GetChangedGitFiles returns the sorted paths that were added, modified or deleted between two sets of tree hashes
+// returned by GetGitTreeHashes.
+func GetChangedGitFiles(prevHashes, hashes map[string]string) []string {
+	changed := []string{}
+
+	for path, hash := range hashes {
+		if prevHashes[path] != hash {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range prevHashes {
+		if _, ok := hashes[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func commitTestFiles(g *gomega.GomegaWithT, repo *git.Repository, dir string, files map[string]string) string {
+	wt, err := repo.Worktree()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if content == "" {
+			g.Expect(os.Remove(path)).To(gomega.Succeed())
+		} else {
+			g.Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(gomega.Succeed())
+			g.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+		}
+
+		_, err = wt.Add(name)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	hash, err := wt.Commit("test", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return hash.String()
+}
+
+func TestGetChangedGitFiles(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "gitdiff")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	commit1 := commitTestFiles(g, repo, dir, map[string]string{
+		"a.yaml":     "a: 1\n",
+		"b.yaml":     "b: 1\n",
+		"dir/c.yaml": "c: 1\n",
+	})
+
+	commit2 := commitTestFiles(g, repo, dir, map[string]string{
+		"a.yaml":     "a: 2\n",
+		"dir/c.yaml": "",
+		"dir/d.yaml": "d: 1\n",
+	})
+
+	hashes1, err := GetGitTreeHashes(dir, commit1)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(hashes1).To(gomega.HaveLen(3))
+
+	hashes2, err := GetGitTreeHashes(dir, commit2)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(hashes2).To(gomega.HaveLen(3))
+
+	g.Expect(GetChangedGitFiles(hashes1, hashes2)).To(gomega.Equal([]string{"a.yaml", "dir/c.yaml", "dir/d.yaml"}))
+	g.Expect(GetChangedGitFiles(hashes2, hashes2)).To(gomega.BeEmpty())
+
+	_, err = GetGitTreeHashes(dir, "0000000000000000000000000000000000000000")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestRunKustomizeBuildWithDeps(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	kustomizeDir := "../../test/github/kustomize/overlays/staging"
+
+	expected, err := RunKustomizeBuild(kustomizeDir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	out, deps, err := RunKustomizeBuildWithDeps(kustomizeDir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(out).To(gomega.Equal(expected))
+
+	base, err := filepath.Abs("../../test/github/kustomize/base")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deps).To(gomega.ContainElement(filepath.Join(base, "kustomization.yaml")))
+}
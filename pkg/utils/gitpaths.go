@@ -0,0 +1,272 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	gitignore "github.com/sabhiram/go-gitignore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+// GitPath is an entry of the git-paths subscription annotation. Path is a directory, a file or a glob pattern
+// relative to the Git repo root. Include and exclude are .kubernetesignore style patterns relative to each
+// matched path.
+type GitPath struct {
+	Path    string   `json:"path"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// UnmarshalJSON accepts a plain path string as well as a path object
+func (p *GitPath) UnmarshalJSON(b []byte) error {
+	var path string
+
+	if err := json.Unmarshal(b, &path); err == nil {
+		*p = GitPath{Path: path}
+		return nil
+	}
+
+	type gitPath GitPath
+
+	return json.Unmarshal(b, (*gitPath)(p))
+}
+
+// GitResourcePath is a resolved Git path that resources are sorted from
+type GitResourcePath struct {
+	// Dir is the absolute path of the matched directory or file
+	Dir     string
+	include *gitignore.GitIgnore
+	exclude *gitignore.GitIgnore
+}
+
+// GetGitPaths returns the Git paths of the subscription. The git-paths annotation takes precedence over the
+// single path in the github-path or git-path annotation or in the filterRef ConfigMap.
+func GetGitPaths(sub *appv1.Subscription, subConfigMap *corev1.ConfigMap) ([]GitPath, error) {
+	annotations := sub.GetAnnotations()
+
+	if annotations[appv1.AnnotationGitPaths] != "" {
+		paths := []GitPath{}
+
+		if err := yaml.Unmarshal([]byte(annotations[appv1.AnnotationGitPaths]), &paths); err != nil {
+			return nil, fmt.Errorf("failed to parse the %s annotation, err: %w", appv1.AnnotationGitPaths, err)
+		}
+
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("the %s annotation has no path", appv1.AnnotationGitPaths)
+		}
+
+		return paths, nil
+	}
+
+	path := ""
+
+	if annotations[appv1.AnnotationGithubPath] != "" {
+		path = annotations[appv1.AnnotationGithubPath]
+	} else if annotations[appv1.AnnotationGitPath] != "" {
+		path = annotations[appv1.AnnotationGitPath]
+	} else if subConfigMap != nil {
+		path = subConfigMap.Data["path"]
+	}
+
+	return []GitPath{{Path: path}}, nil
+}
+
+// ResolveGitPaths expands the glob patterns of the Git paths in the cloned repo. The resolved paths keep the order
+// of the Git paths, glob matches are sorted by name and a path matched more than once is kept at its first position.
+func ResolveGitPaths(repoRoot string, paths []GitPath) ([]GitResourcePath, error) {
+	resolved := []GitResourcePath{}
+	seen := map[string]bool{}
+
+	for _, path := range paths {
+		pattern := filepath.Join(repoRoot, path.Path)
+
+		if rel, err := filepath.Rel(repoRoot, pattern); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("path %s is outside of the Git repo", path.Path)
+		}
+
+		matches := []string{pattern}
+
+		if !strings.ContainsAny(path.Path, "*?[") {
+			// a path that does not exist fails sorting the resources, like a single path does
+			if _, err := os.Lstat(pattern); err == nil && !isInDir(repoRoot, pattern) {
+				return nil, fmt.Errorf("path %s is outside of the Git repo", path.Path)
+			}
+		} else {
+			var err error
+
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path glob %s, err: %w", path.Path, err)
+			}
+
+			if len(matches) == 0 {
+				klog.Warningf("Path glob %s does not match anything in the Git repo", path.Path)
+			}
+		}
+
+		include, err := gitignore.CompileIgnoreLines(path.Include...)
+		if err != nil {
+			return nil, err
+		}
+
+		exclude, err := gitignore.CompileIgnoreLines(path.Exclude...)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(path.Include) == 0 {
+			include = nil
+		}
+
+		for _, match := range matches {
+			if seen[match] || strings.HasPrefix(match+"/", filepath.Join(repoRoot, ".git")+"/") {
+				continue
+			}
+
+			if match != pattern && !isInDir(repoRoot, match) {
+				klog.Warningf("Ignoring %s matched by path glob %s. It is outside of the Git repo", match, path.Path)
+				continue
+			}
+
+			seen[match] = true
+
+			resolved = append(resolved, GitResourcePath{Dir: match, include: include, exclude: exclude})
+		}
+	}
+
+	return resolved, nil
+}
+
+// skips returns true if the include and exclude patterns of the resource path filter out the file or directory.
+// Include patterns select resource files and chart, kustomize, Jsonnet or CUE directories. Files in those
+// directories are selected together with the directory.
+func (p GitResourcePath) skips(curPath string) bool {
+	if p.matches(p.exclude, curPath) {
+		return true
+	}
+
+	if p.include == nil {
+		return false
+	}
+
+	if info, err := os.Stat(curPath); err == nil && info.IsDir() {
+		return isPackageDir(curPath) && !p.matches(p.include, curPath)
+	}
+
+	packageDir := ""
+
+	for dir := filepath.Dir(curPath); strings.HasPrefix(dir, p.Dir+"/"); dir = filepath.Dir(dir) {
+		if isPackageDir(dir) {
+			packageDir = dir
+		}
+	}
+
+	if packageDir != "" {
+		return !p.matches(p.include, packageDir)
+	}
+
+	return !p.matches(p.include, curPath)
+}
+
+// matches returns true if the patterns match the path relative to the resource path
+func (p GitResourcePath) matches(patterns *gitignore.GitIgnore, curPath string) bool {
+	rel, err := filepath.Rel(p.Dir, curPath)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	if patterns.MatchesPath(rel) {
+		return true
+	}
+
+	// a directory pattern like "dir/" matches the directory itself
+	info, err := os.Stat(curPath)
+
+	return err == nil && info.IsDir() && patterns.MatchesPath(rel+"/")
+}
+
+// isPackageDir returns true if the directory is deployed as a whole, like a helm chart or a kustomization
+func isPackageDir(dir string) bool {
+	return isChartOrKustomizeDir(dir) || GetManifestEntrypoint(dir) != ""
+}
+
+// SortResourcesInPaths sorts the resources of every resolved Git path like SortResources does and merges them into
+// a single set. A resource file found in more than one path is kept in the order of the first path it is found in.
+func SortResourcesInPaths(repoRoot string, paths []GitResourcePath, skips ...SkipFunc) (map[string]string, map[string]string,
+	[]string, []string, []string, error) {
+	chartDirs := make(map[string]string)
+	kustomizeDirs := make(map[string]string)
+	crdsAndNamespaceFiles := []string{}
+	rbacFiles := []string{}
+	otherFiles := []string{}
+
+	seen := map[string]bool{}
+
+	appendNew := func(files, found []string) []string {
+		for _, file := range found {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+
+		return files
+	}
+
+	for _, path := range paths {
+		path := path
+
+		skip := func(resourcePath, curPath string) bool {
+			for _, s := range skips {
+				if s(resourcePath, curPath) {
+					return true
+				}
+			}
+
+			return path.skips(curPath)
+		}
+
+		pathChartDirs, pathKustomizeDirs, pathCrdsAndNamespaceFiles, pathRbacFiles, pathOtherFiles, err :=
+			SortResources(repoRoot, path.Dir, skip)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+
+		for dir := range pathChartDirs {
+			chartDirs[dir] = dir
+		}
+
+		for dir := range pathKustomizeDirs {
+			kustomizeDirs[dir] = dir
+		}
+
+		crdsAndNamespaceFiles = appendNew(crdsAndNamespaceFiles, pathCrdsAndNamespaceFiles)
+		rbacFiles = appendNew(rbacFiles, pathRbacFiles)
+		otherFiles = appendNew(otherFiles, pathOtherFiles)
+	}
+
+	return chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func testConfigMap(name string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"
+}
+
+func TestGetGitPaths(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1.Subscription{}
+	cm := &corev1.ConfigMap{Data: map[string]string{"path": "cm-path"}}
+
+	paths, err := GetGitPaths(sub, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(paths).To(gomega.Equal([]GitPath{{Path: ""}}))
+
+	paths, err = GetGitPaths(sub, cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(paths).To(gomega.Equal([]GitPath{{Path: "cm-path"}}))
+
+	sub.SetAnnotations(map[string]string{appv1.AnnotationGitPath: "git-path"})
+	paths, err = GetGitPaths(sub, cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(paths).To(gomega.Equal([]GitPath{{Path: "git-path"}}))
+
+	sub.SetAnnotations(map[string]string{
+		appv1.AnnotationGitPath:  "git-path",
+		appv1.AnnotationGitPaths: `["base", {"path": "config/*", "exclude": ["secret*"]}]`,
+	})
+	paths, err = GetGitPaths(sub, cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(paths).To(gomega.Equal([]GitPath{{Path: "base"}, {Path: "config/*", Exclude: []string{"secret*"}}}))
+
+	// YAML is accepted too
+	sub.SetAnnotations(map[string]string{appv1.AnnotationGitPaths: "- base\n- path: dashboards\n  include:\n  - '*.yaml'\n"})
+	paths, err = GetGitPaths(sub, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(paths).To(gomega.Equal([]GitPath{{Path: "base"}, {Path: "dashboards", Include: []string{"*.yaml"}}}))
+
+	sub.SetAnnotations(map[string]string{appv1.AnnotationGitPaths: "[]"})
+	_, err = GetGitPaths(sub, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	sub.SetAnnotations(map[string]string{appv1.AnnotationGitPaths: "{"})
+	_, err = GetGitPaths(sub, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestSortResourcesInPaths(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	repoRoot, err := ioutil.TempDir("", "gitpaths")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(repoRoot)
+
+	writeTestFiles(g, repoRoot, map[string]string{
+		"base/namespace.yaml":                     "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: app\n",
+		"base/config.yaml":                        testConfigMap("base"),
+		"config/prod/config.yaml":                 testConfigMap("prod"),
+		"config/prod/secret-config.yaml":          testConfigMap("prod-secret"),
+		"config/stage/config.yaml":                testConfigMap("stage"),
+		"dashboards/dashboard.yaml":               testConfigMap("dashboard"),
+		"dashboards/notes.yml":                    testConfigMap("notes"),
+		"dashboards/chart/Chart.yaml":             "apiVersion: v2\nname: chart\nversion: 0.1.0\n",
+		"dashboards/chart/templates/a.yaml":       testConfigMap("chart"),
+		"dashboards/kustomize/kustomization.yaml": "resources:\n- cm.yaml\n",
+		"dashboards/kustomize/cm.yaml":            testConfigMap("kustomize"),
+		"other/config.yaml":                       testConfigMap("other"),
+	})
+
+	paths := []GitPath{
+		{Path: "config/*", Exclude: []string{"secret*"}},
+		{Path: "base"},
+		{Path: "dashboards", Include: []string{"*.yaml", "kustomize/"}},
+		{Path: "config/prod"},
+		{Path: "nothing/*"},
+	}
+
+	resourcePaths, err := ResolveGitPaths(repoRoot, paths)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	dirs := []string{}
+	for _, resourcePath := range resourcePaths {
+		dirs = append(dirs, resourcePath.Dir)
+	}
+
+	g.Expect(dirs).To(gomega.Equal([]string{
+		filepath.Join(repoRoot, "config", "prod"),
+		filepath.Join(repoRoot, "config", "stage"),
+		filepath.Join(repoRoot, "base"),
+		filepath.Join(repoRoot, "dashboards"),
+	}))
+
+	chartDirs, kustomizeDirs, crdsAndNamespaceFiles, rbacFiles, otherFiles, err := SortResourcesInPaths(repoRoot, resourcePaths)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(chartDirs).To(gomega.BeEmpty())
+	g.Expect(kustomizeDirs).To(gomega.Equal(map[string]string{
+		filepath.Join(repoRoot, "dashboards", "kustomize") + "/": filepath.Join(repoRoot, "dashboards", "kustomize") + "/",
+	}))
+	g.Expect(crdsAndNamespaceFiles).To(gomega.Equal([]string{filepath.Join(repoRoot, "base", "namespace.yaml")}))
+	g.Expect(rbacFiles).To(gomega.BeEmpty())
+	g.Expect(otherFiles).To(gomega.Equal([]string{
+		filepath.Join(repoRoot, "config", "prod", "config.yaml"),
+		filepath.Join(repoRoot, "config", "stage", "config.yaml"),
+		filepath.Join(repoRoot, "base", "config.yaml"),
+		filepath.Join(repoRoot, "dashboards", "dashboard.yaml"),
+	}))
+
+	// Paths outside of the repo are rejected
+	_, err = ResolveGitPaths(repoRoot, []GitPath{{Path: "base"}, {Path: "../*"}})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// A missing path fails like a single missing path does
+	resourcePaths, err = ResolveGitPaths(repoRoot, []GitPath{{Path: "missing"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, _, _, _, _, err = SortResourcesInPaths(repoRoot, resourcePaths)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -27,13 +27,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
@@ -48,6 +46,7 @@ import (
 	gitignore "github.com/sabhiram/go-gitignore"
 
 	"github.com/ghodss/yaml"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	gitclient "gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -90,6 +89,12 @@ type GitCloneOption struct {
 	InsecureSkipVerify bool
 	CaCerts            string
 	CloneDepth         int
+	KnownHosts         []byte
+	HostKeyPolicy      string
+	HostKeyStore       HostKeyStore
+	TokenSource        GitTokenSource
+	ClientCert         []byte
+	ClientKey          []byte
 }
 
 // ParseKubeResoures parses a YAML content and returns kube resources in byte array from the file
@@ -187,7 +192,12 @@ func CloneGitRepo(cloneOptions *GitCloneOption) (commitID string, err error) {
 	if strings.HasPrefix(cloneOptions.RepoURL, "http") {
 		klog.Info("Connecting to Git server via HTTP")
 
-		err := getHTTPOptions(options, cloneOptions.User, cloneOptions.Password, cloneOptions.CaCerts, cloneOptions.InsecureSkipVerify)
+		if err := cloneOptions.RefreshToken(); err != nil {
+			klog.Error(err, " failed to get the Git access token")
+			return "", err
+		}
+
+		err := getHTTPOptions(options, cloneOptions)
 
 		if err != nil {
 			klog.Error(err, "failed to prepare HTTP clone options")
@@ -196,17 +206,7 @@ func CloneGitRepo(cloneOptions *GitCloneOption) (commitID string, err error) {
 	} else {
 		klog.Info("Connecting to Git server via SSH")
 
-		knownhostsfile := filepath.Join(cloneOptions.DestDir, "known_hosts")
-
-		if !cloneOptions.InsecureSkipVerify {
-			err := getKnownHostFromURL(cloneOptions.RepoURL, knownhostsfile)
-
-			if err != nil {
-				return "", err
-			}
-		}
-
-		err = getSSHOptions(options, cloneOptions.SSHKey, cloneOptions.Passphrase, knownhostsfile, cloneOptions.InsecureSkipVerify)
+		err = getSSHOptions(options, cloneOptions)
 		if err != nil {
 			klog.Error(err, " failed to prepare SSH clone options")
 			return "", err
@@ -289,124 +289,128 @@ func CloneGitRepo(cloneOptions *GitCloneOption) (commitID string, err error) {
 	return commit.ID().String(), nil
 }
 
-func getKnownHostFromURL(sshURL string, filepath string) error {
-	sshhostname := ""
-	sshhostport := ""
+func getSSHOptions(options *git.CloneOptions, cloneOptions *GitCloneOption) error {
+	publicKey := &gitssh.PublicKeys{}
+	publicKey.User = "git"
+
+	if len(cloneOptions.Passphrase) > 0 {
+		klog.Info("Parsing SSH private key with passphrase")
 
-	if strings.HasPrefix(sshURL, "ssh:") {
-		u, err := url.Parse(sshURL)
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(cloneOptions.SSHKey, cloneOptions.Passphrase)
 
 		if err != nil {
-			klog.Error("failed toparse SSH URL: ", err)
+			klog.Error("failed to parse SSH key", err.Error())
 			return err
 		}
 
-		sshhostname = strings.Split(u.Host, ":")[0]
-
-		sshhostport = u.Host
-	} else if strings.HasPrefix(sshURL, "git@") {
-		sshhostname = strings.Split(strings.SplitAfter(sshURL, "@")[1], ":")[0]
+		publicKey.Signer = signer
+	} else {
+		signer, err := ssh.ParsePrivateKey(cloneOptions.SSHKey)
+		if err != nil {
+			klog.Error("failed to parse SSH key", err.Error())
+			return err
+		}
+		publicKey.Signer = signer
 	}
 
-	klog.Info("Getting public SSH host key for " + sshhostname)
+	if cloneOptions.InsecureSkipVerify {
+		klog.Info("Insecure ignore SSH host key")
+
+		publicKey.HostKeyCallback = ssh.InsecureIgnoreHostKey() // #nosec G106 this is optional and used only if users specify it in channel configuration
+		options.Auth = publicKey
 
-	cmd := exec.Command("ssh-keyscan", sshhostname) // #nosec G204 the variable is generated within this function.
-	stdout, err := cmd.Output()
+		return nil
+	}
 
+	_, hostport, err := getSSHHostFromURL(cloneOptions.RepoURL)
 	if err != nil {
-		klog.Error("failed to get public SSH host key: ", err)
+		return err
+	}
 
-		if sshhostport != "" && (sshhostport != sshhostname) {
-			klog.Info("Getting public SSH host key for " + sshhostport)
+	klog.Infof("Using SSH known host keys with %s policy", cloneOptions.HostKeyPolicy)
 
-			cmd2 := exec.Command("ssh-keyscan", sshhostport) // #nosec G204 the variable is generated within this function.
-			stdout2, err2 := cmd2.Output()
+	knownHosts, err := withAcceptedHostKeys(cloneOptions.KnownHosts, cloneOptions.HostKeyPolicy, cloneOptions.HostKeyStore)
+	if err != nil {
+		return err
+	}
 
-			if err2 != nil {
-				klog.Error("failed to get public SSH host key: ", err2)
-				return err2
-			}
+	// The known_hosts bundle is written next to the clone directory because the clone directory is emptied before cloning.
+	known, err := loadKnownHosts(knownHosts, filepath.Clean(cloneOptions.DestDir)+"_known_hosts")
+	if err != nil {
+		return err
+	}
 
-			stdout = stdout2
-		} else {
-			return err
-		}
+	callback, err := newHostKeyCallback(known, cloneOptions.HostKeyPolicy, cloneOptions.HostKeyStore)
+	if err != nil {
+		return err
 	}
 
-	klog.Info("SSH host key: " + string(stdout))
+	publicKey.HostKeyCallback = callback
 
-	if err := ioutil.WriteFile(filepath, stdout, 0600); err != nil {
-		klog.Error("failed to write known_hosts file: ", err)
-		return err
+	options.Auth = &sshPublicKeys{
+		PublicKeys:        publicKey,
+		hostKeyAlgorithms: knownHostKeyAlgorithms(known, hostport),
 	}
 
 	return nil
 }
 
-func getSSHOptions(options *git.CloneOptions, sshKey, passphrase []byte, knownhostsfile string, insecureSkipVerify bool) error {
-	publicKey := &gitssh.PublicKeys{}
-	publicKey.User = "git"
-
-	if len(passphrase) > 0 {
-		klog.Info("Parsing SSH private key with passphrase")
-
-		signer, err := ssh.ParsePrivateKeyWithPassphrase(sshKey, passphrase)
+func getHTTPOptions(options *git.CloneOptions, cloneOptions *GitCloneOption) error {
+	var auth transport.AuthMethod
 
-		if err != nil {
-			klog.Error("failed to parse SSH key", err.Error())
-			return err
+	if cloneOptions.User != "" && cloneOptions.Password != "" {
+		auth = &githttp.BasicAuth{
+			Username: cloneOptions.User,
+			Password: cloneOptions.Password,
 		}
+	}
 
-		publicKey.Signer = signer
-	} else {
-		signer, err := ssh.ParsePrivateKey(sshKey)
-		if err != nil {
-			klog.Error("failed to parse SSH key", err.Error())
-			return err
-		}
-		publicKey.Signer = signer
+	options.Auth = auth
+
+	rt, err := NewGitTransport(cloneOptions)
+	if err != nil {
+		return err
 	}
 
-	if insecureSkipVerify {
-		klog.Info("Insecure ignore SSH host key")
+	if rt != nil {
+		// the client is passed to the transport of this clone only, the TLS
+		// settings of a channel are never shared with the other clones
+		options.Auth = &gitClientAuth{
+			client: &http.Client{
+				/* #nosec G402 */
+				Transport: rt,
 
-		publicKey.HostKeyCallback = ssh.InsecureIgnoreHostKey() // #nosec G106 this is optional and used only if users specify it in channel configuration
-	} else {
-		klog.Info("Using SSH known host keys")
-		callback, err := knownhosts.New(knownhostsfile)
+				// 15 second timeout
+				Timeout: 15 * time.Second,
 
-		if err != nil {
-			klog.Error("failed to get knownhosts ", err)
-			return err
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+			auth: auth,
 		}
-
-		publicKey.HostKeyCallback = callback
 	}
 
-	options.Auth = publicKey
-
 	return nil
 }
 
-func getHTTPOptions(options *git.CloneOptions, user, password, caCerts string, insecureSkipVerify bool) error {
-	if user != "" && password != "" {
-		options.Auth = &githttp.BasicAuth{
-			Username: user,
-			Password: password,
-		}
-	}
-
-	installProtocol := false
+// NewGitTransport returns the HTTP transport with the CA certificates, the TLS
+// client certificate and the insecureSkipVerify setting of a channel, it's
+// nil if the channel needs no custom TLS settings
+func NewGitTransport(cloneOptions *GitCloneOption) (http.RoundTripper, error) {
+	customTLS := false
 
 	clientConfig := &tls.Config{MinVersion: tls.VersionTLS12}
 
+	caCerts := cloneOptions.CaCerts
+
 	// skip TLS certificate verification for Git servers with custom or self-signed certs
-	if insecureSkipVerify {
+	if cloneOptions.InsecureSkipVerify {
 		klog.Info("insecureSkipVerify = true, skipping Git server's certificate verification.")
 
 		clientConfig.InsecureSkipVerify = true
 
-		installProtocol = true
+		customTLS = true
 	} else if !strings.EqualFold(caCerts, "") {
 		klog.Info("Adding Git server's CA certificate to trust certificate pool")
 
@@ -427,7 +431,7 @@ func getHTTPOptions(options *git.CloneOptions, user, password, caCerts string, i
 		for _, cert := range certChain.Certificate {
 			x509Cert, err := x509.ParseCertificate(cert)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			klog.Info("Adding certificate -->" + x509Cert.Subject.String())
 			certPool.AddCert(x509Cert)
@@ -435,55 +439,105 @@ func getHTTPOptions(options *git.CloneOptions, user, password, caCerts string, i
 
 		clientConfig.RootCAs = certPool
 
-		installProtocol = true
+		customTLS = true
 	}
 
-	if installProtocol {
-		klog.Info("HTTP_PROXY = " + os.Getenv("HTTP_PROXY"))
-		klog.Info("HTTPS_PROXY = " + os.Getenv("HTTPS_PROXY"))
+	// present the TLS client certificate to Git servers that require mutual TLS
+	clientCert, err := LoadClientCertificate(cloneOptions.ClientCert, cloneOptions.ClientKey)
+	if err != nil {
+		return nil, err
+	}
 
-		transportConfig := &http.Transport{
-			/* #nosec G402 */
-			TLSClientConfig: clientConfig,
-		}
+	if clientCert != nil {
+		klog.Info("Adding TLS client certificate for the Git server")
 
-		proxyURLEnv := ""
+		clientConfig.Certificates = []tls.Certificate{*clientCert}
 
-		if os.Getenv("HTTPS_PROXY") != "" {
-			proxyURLEnv = os.Getenv("HTTPS_PROXY")
-		} else if os.Getenv("HTTP_PROXY") != "" {
-			proxyURLEnv = os.Getenv("HTTP_PROXY")
-		}
+		customTLS = true
+	}
 
-		if proxyURLEnv != "" {
-			proxyURL, err := url.Parse(proxyURLEnv)
+	if !customTLS {
+		return nil, nil
+	}
 
-			if err != nil {
-				klog.Error(err.Error())
-				return err
-			}
+	klog.Info("HTTP_PROXY = " + os.Getenv("HTTP_PROXY"))
+	klog.Info("HTTPS_PROXY = " + os.Getenv("HTTPS_PROXY"))
 
-			transportConfig.Proxy = http.ProxyURL(proxyURL)
+	transportConfig := &http.Transport{
+		/* #nosec G402 */
+		TLSClientConfig: clientConfig,
+	}
 
-			klog.Info("setting HTTP transport proxy to " + proxyURLEnv)
-		}
+	proxyURLEnv := ""
 
-		customClient := &http.Client{
-			/* #nosec G402 */
-			Transport: transportConfig,
+	if os.Getenv("HTTPS_PROXY") != "" {
+		proxyURLEnv = os.Getenv("HTTPS_PROXY")
+	} else if os.Getenv("HTTP_PROXY") != "" {
+		proxyURLEnv = os.Getenv("HTTP_PROXY")
+	}
 
-			// 15 second timeout
-			Timeout: 15 * time.Second,
+	if proxyURLEnv != "" {
+		proxyURL, err := url.Parse(proxyURLEnv)
 
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
+		if err != nil {
+			klog.Error(err.Error())
+			return nil, err
 		}
 
-		gitclient.InstallProtocol("https", githttp.NewClient(customClient))
+		transportConfig.Proxy = http.ProxyURL(proxyURL)
+
+		klog.Info("setting HTTP transport proxy to " + proxyURLEnv)
 	}
 
-	return nil
+	return transportConfig, nil
+}
+
+func init() {
+	// go-git looks the transports up by protocol in a process wide registry,
+	// the dispatcher is installed once and picks the HTTP client of each clone
+	gitclient.InstallProtocol("https", gitHTTPTransport{})
+}
+
+// gitClientAuth carries the HTTP client of a clone along with its credentials
+type gitClientAuth struct {
+	client *http.Client
+	auth   transport.AuthMethod
+}
+
+func (a *gitClientAuth) Name() string {
+	if a.auth == nil {
+		return "http-client"
+	}
+
+	return a.auth.Name()
+}
+
+func (a *gitClientAuth) String() string {
+	if a.auth == nil {
+		return "http-client"
+	}
+
+	return a.auth.String()
+}
+
+// gitHTTPTransport uses the HTTP client of gitClientAuth for the clone, the
+// default client is used otherwise
+type gitHTTPTransport struct{}
+
+func (gitHTTPTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	if a, ok := auth.(*gitClientAuth); ok {
+		return githttp.NewClient(a.client).NewUploadPackSession(ep, a.auth)
+	}
+
+	return githttp.DefaultClient.NewUploadPackSession(ep, auth)
+}
+
+func (gitHTTPTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	if a, ok := auth.(*gitClientAuth); ok {
+		return githttp.NewClient(a.client).NewReceivePackSession(ep, a.auth)
+	}
+
+	return githttp.DefaultClient.NewReceivePackSession(ep, auth)
 }
 
 // GetSubscriptionBranch returns GitHub repo branch for a given subscription
@@ -519,28 +573,33 @@ func GetChannelSecret(client client.Client, chn *chnv1.Channel) (string, string,
 	sshKey := []byte("")
 	passphrase := []byte("")
 
-	if chn.Spec.SecretRef != nil {
-		secret := &corev1.Secret{}
-		secns := chn.Spec.SecretRef.Namespace
+	secret, err := GetChannelSecretObject(client, chn)
+	if err != nil || secret == nil {
+		return username, accessToken, sshKey, passphrase, err
+	}
 
-		if secns == "" {
-			secns = chn.Namespace
-		}
+	return ParseChannelSecret(secret)
+}
 
-		err := client.Get(context.TODO(), types.NamespacedName{Name: chn.Spec.SecretRef.Name, Namespace: secns}, secret)
-		if err != nil {
-			klog.Error(err, "Unable to get secret from local cluster.")
-			return username, accessToken, sshKey, passphrase, err
-		}
+// GetChannelSecretObject returns the secret of the channel, nil if the channel has no secret
+func GetChannelSecretObject(client client.Client, chn *chnv1.Channel) (*corev1.Secret, error) {
+	if chn.Spec.SecretRef == nil {
+		return nil, nil
+	}
 
-		username, accessToken, sshKey, passphrase, err = ParseChannelSecret(secret)
+	secret := &corev1.Secret{}
+	secns := chn.Spec.SecretRef.Namespace
 
-		if err != nil {
-			return username, accessToken, sshKey, passphrase, err
-		}
+	if secns == "" {
+		secns = chn.Namespace
 	}
 
-	return username, accessToken, sshKey, passphrase, nil
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: chn.Spec.SecretRef.Name, Namespace: secns}, secret); err != nil {
+		klog.Error(err, "Unable to get secret from local cluster.")
+		return nil, err
+	}
+
+	return secret, nil
 }
 
 // GetDataFromChannelConfigMap returns username and password for channel
@@ -568,6 +627,18 @@ func ParseChannelSecret(secret *corev1.Secret) (string, string, []byte, []byte,
 	accessToken := ""
 	sshKey := []byte("")
 	passphrase := []byte("")
+
+	// GitHub App and OAuth client credentials are exchanged for a short-lived access token by the
+	// token source of the clone options when the repository is cloned, they are only validated here
+	if IsGitTokenSecret(secret) {
+		if _, err := GetGitTokenSource(secret, "", false); err != nil {
+			klog.Error(err, "Failed to get the Git token source from the secret.")
+			return username, accessToken, sshKey, passphrase, err
+		}
+
+		return username, accessToken, sshKey, passphrase, nil
+	}
+
 	err := yaml.Unmarshal(secret.Data[UserID], &username)
 
 	if err != nil {
@@ -585,7 +656,8 @@ func ParseChannelSecret(secret *corev1.Secret) (string, string, []byte, []byte,
 	sshKey = bytes.TrimSpace(secret.Data[SSHKey])
 	passphrase = bytes.TrimSpace(secret.Data[Passphrase])
 
-	if len(sshKey) == 0 {
+	// A TLS client certificate authenticates to the Git server on its own
+	if len(sshKey) == 0 && !HasClientCertificate(secret) {
 		if username == "" || accessToken == "" {
 			klog.Error(err, "sshKey (and optionally passphrase) or user and accressToken need to be specified in the channel secret")
 			return username, accessToken, sshKey, passphrase,
@@ -630,6 +702,7 @@ func SortResources(repoRoot, resourcePath string, skips ...SkipFunc) (map[string
 
 	currentChartDir := "NONE"
 	currentKustomizeDir := "NONE"
+	currentRenderDir := "NONE"
 
 	kubeIgnore := GetKubeIgnore(resourcePath)
 
@@ -673,10 +746,19 @@ func SortResources(repoRoot, resourcePath string, skips ...SkipFunc) (map[string
 							currentKustomizeDir = path + "/"
 							kustomizeDirs[path+"/"] = path + "/"
 						}
+					} else if GetManifestEntrypoint(path) != "" {
+						// Only the resource files rendered by RenderManifestSources are processed in Jsonnet
+						// and CUE source directories. Libraries and nested directories are left alone.
+						if !strings.HasPrefix(path, currentChartDir) && !strings.HasPrefix(path, currentKustomizeDir) &&
+							!strings.HasPrefix(path, currentRenderDir) {
+							klog.V(4).Info("Found Jsonnet or CUE entrypoint in ", path)
+							currentRenderDir = path + "/"
+						}
 					}
 				} else if !strings.HasPrefix(path, currentChartDir) &&
 					!strings.HasPrefix(path, repoRoot+"/.git") &&
-					!strings.HasPrefix(path, currentKustomizeDir) {
+					!strings.HasPrefix(path, currentKustomizeDir) &&
+					(!strings.HasPrefix(path, currentRenderDir) || (IsRenderedManifest(path) && filepath.Dir(path)+"/" == currentRenderDir)) {
 					// Do not process kubernetes YAML files under helm chart or kustomization directory
 					// If there are nested kustomizations or any other folder structures containing kube
 					// resources under a kustomization, subscription should not process them and let kustomize
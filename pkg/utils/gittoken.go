@@ -0,0 +1,419 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// GitHubAppID is the key of the GitHub App ID in secret
+	GitHubAppID = "githubAppID"
+	// GitHubAppInstallationID is the key of the GitHub App installation ID in secret
+	GitHubAppInstallationID = "githubAppInstallationID"
+	// GitHubAppPrivateKey is the key of the GitHub App PEM encoded private key in secret
+	GitHubAppPrivateKey = "githubAppPrivateKey"
+	// GitHubAPIURL is the key of the GitHub API URL in secret, for GitHub Enterprise
+	GitHubAPIURL = "githubAPIURL"
+	// OAuthClientID is the key of the OAuth client ID in secret
+	OAuthClientID = "clientID"
+	// OAuthClientSecret is the key of the OAuth client secret in secret
+	OAuthClientSecret = "clientSecret"
+	// OAuthTokenURL is the key of the OAuth token endpoint in secret
+	OAuthTokenURL = "tokenURL"
+	// OAuthScopes is the key of the space separated OAuth scopes in secret
+	OAuthScopes = "scopes"
+
+	defaultGitHubAPIURL = "https://api.github.com"
+	// gitHubAppTokenUser is the user name for Git HTTPS authentication with a GitHub App installation token
+	gitHubAppTokenUser = "x-access-token"
+	// oauthTokenUser is the default user name for Git HTTPS authentication with an OAuth token
+	oauthTokenUser = "oauth2"
+	// tokenRefreshMargin is how long before expiry a cached token is refreshed
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// GitToken is a short-lived credential for Git HTTPS authentication
+type GitToken struct {
+	User     string
+	Password string
+	Expiry   time.Time
+}
+
+func (t *GitToken) valid(now time.Time) bool {
+	return t != nil && t.Password != "" && (t.Expiry.IsZero() || now.Add(tokenRefreshMargin).Before(t.Expiry))
+}
+
+// GitTokenSource mints short-lived tokens for Git HTTPS authentication
+type GitTokenSource interface {
+	// Token returns a cached token or mints a new one if the cached token is about to expire
+	Token() (*GitToken, error)
+}
+
+type mintFunc func() (*GitToken, error)
+
+// cachedTokenSource caches the minted tokens by credentials so that all subscriptions sharing
+// the same channel secret share the same token
+type cachedTokenSource struct {
+	key  string
+	mint mintFunc
+}
+
+// tokenCacheEntry is locked while its token is minted so that a slow token endpoint
+// only blocks the subscriptions sharing the same credentials
+type tokenCacheEntry struct {
+	lock sync.Mutex
+	tok  *GitToken
+}
+
+var (
+	gitTokenCache     = map[string]*tokenCacheEntry{}
+	gitTokenCacheLock sync.Mutex
+	gitTokenNow       = time.Now
+)
+
+func getTokenCacheEntry(key string) *tokenCacheEntry {
+	gitTokenCacheLock.Lock()
+	defer gitTokenCacheLock.Unlock()
+
+	entry, ok := gitTokenCache[key]
+	if !ok {
+		entry = &tokenCacheEntry{}
+		gitTokenCache[key] = entry
+	}
+
+	return entry
+}
+
+func (c *cachedTokenSource) Token() (*GitToken, error) {
+	entry := getTokenCacheEntry(c.key)
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if entry.tok.valid(gitTokenNow()) {
+		return entry.tok, nil
+	}
+
+	tok, err := c.mint()
+	if err != nil {
+		entry.tok = nil
+		return nil, err
+	}
+
+	klog.Infof("Minted a new Git access token, expires at %v", tok.Expiry)
+
+	entry.tok = tok
+
+	return tok, nil
+}
+
+func tokenCacheKey(parts ...string) string {
+	h := sha256.New()
+
+	for _, p := range parts {
+		_, _ = h.Write([]byte(p))
+		_, _ = h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func secretValue(secret *corev1.Secret, key string) string {
+	return strings.TrimSpace(string(secret.Data[key]))
+}
+
+// IsGitTokenSecret returns true if the channel secret has GitHub App or OAuth client credentials
+func IsGitTokenSecret(secret *corev1.Secret) bool {
+	if secret == nil {
+		return false
+	}
+
+	return secretValue(secret, GitHubAppID) != "" || secretValue(secret, OAuthClientID) != ""
+}
+
+// GetGitTokenSource returns a token source for the GitHub App or OAuth client credentials in the channel secret.
+// It returns nil if the secret has neither. The token endpoint is called with the CA certificates and the
+// insecureSkipVerify setting of the channel.
+func GetGitTokenSource(secret *corev1.Secret, caCerts string, insecureSkipVerify bool) (GitTokenSource, error) {
+	if !IsGitTokenSecret(secret) {
+		return nil, nil
+	}
+
+	httpClient, err := newTokenHTTPClient(caCerts, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if secretValue(secret, GitHubAppID) != "" {
+		return newGitHubAppTokenSource(secret, httpClient)
+	}
+
+	return newOAuthTokenSource(secret, httpClient)
+}
+
+func newTokenHTTPClient(caCerts string, insecureSkipVerify bool) (*http.Client, error) {
+	rt, err := NewGitTransport(&GitCloneOption{CaCerts: caCerts, InsecureSkipVerify: insecureSkipVerify})
+	if err != nil {
+		return nil, err
+	}
+
+	if rt == nil {
+		rt = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+
+	return &http.Client{Transport: rt, Timeout: 15 * time.Second}, nil
+}
+
+func newGitHubAppTokenSource(secret *corev1.Secret, httpClient *http.Client) (GitTokenSource, error) {
+	appID := secretValue(secret, GitHubAppID)
+	installationID := secretValue(secret, GitHubAppInstallationID)
+	apiURL := strings.TrimSuffix(secretValue(secret, GitHubAPIURL), "/")
+	pemKey := secret.Data[GitHubAppPrivateKey]
+
+	if installationID == "" || len(pemKey) == 0 {
+		return nil, errors.New(GitHubAppInstallationID + " and " + GitHubAppPrivateKey + " need to be specified with " +
+			GitHubAppID + " in the channel secret")
+	}
+
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+
+	key, err := parseRSAPrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	mint := func() (*GitToken, error) {
+		return mintGitHubAppToken(httpClient, apiURL, appID, installationID, key)
+	}
+
+	return &cachedTokenSource{key: tokenCacheKey("githubapp", apiURL, appID, installationID, string(pemKey)), mint: mint}, nil
+}
+
+func newOAuthTokenSource(secret *corev1.Secret, httpClient *http.Client) (GitTokenSource, error) {
+	clientID := secretValue(secret, OAuthClientID)
+	clientSecret := secretValue(secret, OAuthClientSecret)
+	tokenURL := secretValue(secret, OAuthTokenURL)
+	scopes := secretValue(secret, OAuthScopes)
+	user := secretValue(secret, UserID)
+
+	if clientSecret == "" || tokenURL == "" {
+		return nil, errors.New(OAuthClientSecret + " and " + OAuthTokenURL + " need to be specified with " +
+			OAuthClientID + " in the channel secret")
+	}
+
+	if user == "" {
+		user = oauthTokenUser
+	}
+
+	mint := func() (*GitToken, error) {
+		return mintOAuthToken(httpClient, tokenURL, clientID, clientSecret, scopes, user)
+	}
+
+	return &cachedTokenSource{key: tokenCacheKey("oauth", tokenURL, clientID, clientSecret, scopes, user), mint: mint}, nil
+}
+
+func parseRSAPrivateKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("failed to decode the GitHub App private key PEM")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the GitHub App private key, err: %v", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the GitHub App private key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+// gitHubAppJWT returns the JWT used to authenticate as the GitHub App
+func gitHubAppJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+	// backdate the issue time to allow for clock drift
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
+
+func mintGitHubAppToken(httpClient *http.Client, apiURL, appID, installationID string, key *rsa.PrivateKey) (*GitToken, error) {
+	jwt, err := gitHubAppJWT(appID, key, gitTokenNow())
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/app/installations/"+installationID+"/access_tokens", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	body, err := doTokenRequest(httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub App installation token, err: %v", err)
+	}
+
+	resp := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App installation token, err: %v", err)
+	}
+
+	if resp.Token == "" {
+		return nil, errors.New("no token in the GitHub App installation token response")
+	}
+
+	return &GitToken{User: gitHubAppTokenUser, Password: resp.Token, Expiry: resp.ExpiresAt}, nil
+}
+
+func mintOAuthToken(httpClient *http.Client, tokenURL, clientID, clientSecret, scopes, user string) (*GitToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	if scopes != "" {
+		form.Set("scope", scopes)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	now := gitTokenNow()
+
+	body, err := doTokenRequest(httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth token, err: %v", err)
+	}
+
+	resp := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}{}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse OAuth token, err: %v", err)
+	}
+
+	if resp.AccessToken == "" {
+		return nil, errors.New("no access_token in the OAuth token response")
+	}
+
+	tok := &GitToken{User: user, Password: resp.AccessToken}
+
+	if resp.ExpiresIn != "" {
+		expiresIn, err := strconv.ParseInt(resp.ExpiresIn.String(), 10, 64)
+		if err == nil && expiresIn > 0 {
+			tok.Expiry = now.Add(time.Duration(expiresIn) * time.Second)
+		}
+	}
+
+	return tok, nil
+}
+
+func doTokenRequest(httpClient *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// RefreshToken sets the user and password of the clone options from the token source, if any
+func (o *GitCloneOption) RefreshToken() error {
+	if o.TokenSource == nil {
+		return nil
+	}
+
+	tok, err := o.TokenSource.Token()
+	if err != nil {
+		return err
+	}
+
+	o.User = tok.User
+	o.Password = tok.Password
+
+	return nil
+}
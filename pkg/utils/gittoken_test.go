@@ -0,0 +1,223 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGitHubAppTokenSource(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	minted := 0
+
+	// fake GitHub API installation token endpoint
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(gomega.Equal(http.MethodPost))
+		g.Expect(r.URL.Path).To(gomega.Equal("/app/installations/42/access_tokens"))
+
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		g.Expect(parts).To(gomega.HaveLen(3))
+
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		g.Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig)).To(gomega.Succeed())
+
+		claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(string(claims)).To(gomega.ContainSubstring(`"iss":"1234"`))
+
+		minted++
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      fmt.Sprintf("ghs_token%d", minted),
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	}))
+	defer ts.Close()
+
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			GitHubAppID:             []byte("1234"),
+			GitHubAppInstallationID: []byte("42"),
+			GitHubAppPrivateKey:     pemKey,
+			GitHubAPIURL:            []byte(ts.URL),
+		},
+	}
+
+	// parsing the secret doesn't mint a token
+	user, token, _, _, err := ParseChannelSecret(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(user).To(gomega.BeEmpty())
+	g.Expect(token).To(gomega.BeEmpty())
+	g.Expect(minted).To(gomega.Equal(0))
+
+	tokenSource, err := GetGitTokenSource(secret, "", false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	tok, err := tokenSource.Token()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(tok.User).To(gomega.Equal("x-access-token"))
+	g.Expect(tok.Password).To(gomega.Equal("ghs_token1"))
+
+	// the token is cached
+	tok, err = tokenSource.Token()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(tok.Password).To(gomega.Equal("ghs_token1"))
+	g.Expect(minted).To(gomega.Equal(1))
+
+	// the token is refreshed before it expires
+	defer func() { gitTokenNow = time.Now }()
+
+	gitTokenNow = func() time.Time { return time.Now().Add(56 * time.Minute) }
+
+	cloneOptions := &GitCloneOption{TokenSource: tokenSource}
+	g.Expect(cloneOptions.RefreshToken()).To(gomega.Succeed())
+	g.Expect(cloneOptions.User).To(gomega.Equal("x-access-token"))
+	g.Expect(cloneOptions.Password).To(gomega.Equal("ghs_token2"))
+	g.Expect(minted).To(gomega.Equal(2))
+
+	// missing installation ID
+	delete(secret.Data, GitHubAppInstallationID)
+
+	_, _, _, _, err = ParseChannelSecret(secret)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestOAuthTokenSource(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// fake OAuth client credentials token endpoint with a self-signed certificate
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "my-client" || secret != "my-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		g.Expect(r.ParseForm()).To(gomega.Succeed())
+		g.Expect(r.PostForm.Get("grant_type")).To(gomega.Equal("client_credentials"))
+		g.Expect(r.PostForm.Get("scope")).To(gomega.Equal("read_repository"))
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "oauth-token",
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer ts.Close()
+
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			OAuthClientID:     []byte("my-client"),
+			OAuthClientSecret: []byte("my-secret"),
+			OAuthTokenURL:     []byte(ts.URL),
+			OAuthScopes:       []byte("read_repository"),
+		},
+	}
+
+	_, _, _, _, err := ParseChannelSecret(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the token endpoint certificate is not trusted without the channel CA
+	tokenSource, err := GetGitTokenSource(secret, "", false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, err = tokenSource.Token()
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	tokenSource, err = GetGitTokenSource(secret, caCert, false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	tok, err := tokenSource.Token()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(tok.User).To(gomega.Equal("oauth2"))
+	g.Expect(tok.Password).To(gomega.Equal("oauth-token"))
+
+	secret.Data[OAuthClientSecret] = []byte("wrong-secret")
+
+	tokenSource, err = GetGitTokenSource(secret, "", true)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, err = tokenSource.Token()
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// the token endpoint is required
+	delete(secret.Data, OAuthTokenURL)
+
+	_, _, _, _, err = ParseChannelSecret(secret)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestCachedTokenSourceLock(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	// the token endpoint of the first credentials hangs
+	slow := &cachedTokenSource{key: tokenCacheKey("test", "slow"), mint: func() (*GitToken, error) {
+		<-release
+		return &GitToken{User: "slow", Password: "slow-token"}, nil
+	}}
+
+	fast := &cachedTokenSource{key: tokenCacheKey("test", "fast"), mint: func() (*GitToken, error) {
+		return &GitToken{User: "fast", Password: "fast-token"}, nil
+	}}
+
+	go func() { _, _ = slow.Token() }()
+
+	// the other credentials are not blocked
+	done := make(chan *GitToken)
+
+	go func() {
+		tok, _ := fast.Token()
+		done <- tok
+	}()
+
+	select {
+	case tok := <-done:
+		g.Expect(tok.Password).To(gomega.Equal("fast-token"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("minting a token is blocked by the token endpoint of other credentials")
+	}
+}
@@ -0,0 +1,579 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	// HelmDependencyPassword is the password of the Helm repos that the chart dependencies are downloaded from
+	HelmDependencyPassword = "password"
+)
+
+// HelmDependencyCacheDir is the directory where the dependency archives are cached between reconciles. The archives
+// are written with a rename so that concurrent builds never read a partial archive.
+var HelmDependencyCacheDir = filepath.Join(os.TempDir(), "helm-dependency-cache")
+
+// HelmDependencyCredentials are the credentials of the Helm repos that the chart dependencies are downloaded from
+type HelmDependencyCredentials struct {
+	// Host limits the credentials to the Helm repos on the host. The credentials apply to all Helm repos if it is empty.
+	Host       string
+	Username   string
+	Password   string
+	ClientCert []byte
+	ClientKey  []byte
+}
+
+// NewHelmDependencyCredentials returns the Helm repo credentials of the secret. The password is read from the password
+// key or the accessToken key. It returns nil if the secret has no credentials.
+func NewHelmDependencyCredentials(secret *corev1.Secret, host string) (*HelmDependencyCredentials, error) {
+	if secret == nil {
+		return nil, nil
+	}
+
+	clientCert, clientKey, err := GetClientCertificateData(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	password := secretValue(secret, HelmDependencyPassword)
+	if password == "" {
+		password = secretValue(secret, AccessToken)
+	}
+
+	creds := &HelmDependencyCredentials{
+		Host:       host,
+		Username:   secretValue(secret, UserID),
+		Password:   password,
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+	}
+
+	if creds.Username == "" && creds.Password == "" && len(creds.ClientCert) == 0 {
+		return nil, nil
+	}
+
+	return creds, nil
+}
+
+// GetHelmDependencyCredentials returns the credentials of the Helm repos that the chart dependencies of the subscription
+// are downloaded from. The credentials of the secret named by the helm-dependency-secret annotation of the subscription
+// apply to all Helm repos and take precedence over the channel secret, whose credentials only apply to the Helm repos
+// on the channel host. The channel secret is fetched if chnSecret is nil.
+func GetHelmDependencyCredentials(clt client.Client, chn *chnv1.Channel, chnSecret *corev1.Secret,
+	sub *appv1.Subscription) ([]*HelmDependencyCredentials, error) {
+	credentials := []*HelmDependencyCredentials{}
+
+	if secretName := sub.GetAnnotations()[appv1.AnnotationHelmDependencySecret]; secretName != "" {
+		secret := &corev1.Secret{}
+
+		if err := clt.Get(context.TODO(), types.NamespacedName{Name: secretName, Namespace: sub.Namespace}, secret); err != nil {
+			klog.Error(err, " Unable to get the Helm dependency secret ", secretName)
+			return nil, err
+		}
+
+		creds, err := NewHelmDependencyCredentials(secret, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if creds != nil {
+			credentials = append(credentials, creds)
+		}
+	}
+
+	if chn == nil {
+		return credentials, nil
+	}
+
+	if chnSecret == nil {
+		var err error
+
+		// The channel secret is optional for the dependencies, the clone of the channel reports a missing secret
+		if chnSecret, err = GetChannelSecretObject(clt, chn); err != nil {
+			klog.Warning("Unable to get the channel secret for the Helm dependencies, err: ", err)
+			return credentials, nil
+		}
+	}
+
+	chnURL, err := url.Parse(chn.Spec.Pathname)
+	if err != nil || chnURL.Host == "" {
+		return credentials, nil
+	}
+
+	creds, err := NewHelmDependencyCredentials(chnSecret, chnURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds != nil {
+		credentials = append(credentials, creds)
+	}
+
+	return credentials, nil
+}
+
+// credentialsForRepo returns the first credentials that apply to the Helm repo
+func credentialsForRepo(credentials []*HelmDependencyCredentials, repoURL string) *HelmDependencyCredentials {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil
+	}
+
+	for _, creds := range credentials {
+		if creds != nil && (creds.Host == "" || strings.EqualFold(creds.Host, u.Host)) {
+			return creds
+		}
+	}
+
+	return nil
+}
+
+// repoCacheKey identifies the Helm repo and the credentials that the cached dependency archives were downloaded with,
+// so that an archive downloaded with the credentials of one subscription is not handed out to another
+func repoCacheKey(repoURL string, creds *HelmDependencyCredentials) string {
+	h := sha256.New()
+
+	_, _ = h.Write([]byte(strings.TrimSuffix(repoURL, "/")))
+
+	if creds != nil {
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(creds.Username))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(creds.Password))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(creds.ClientCert)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isHelmRepoURL returns true if the dependency is downloaded from a Helm repo
+func isHelmRepoURL(repository string) bool {
+	return strings.HasPrefix(repository, "http://") || strings.HasPrefix(repository, "https://")
+}
+
+// BuildHelmDependencies runs the equivalent of helm dependency build for the chart directories. Charts whose charts
+// folder already satisfies the dependencies are left untouched. The dependency archives are cached in
+// HelmDependencyCacheDir and reused as long as the Chart.lock file of the chart pins them.
+//
+// The HelmRelease controller clones the Git repo again and does not build the dependencies, so the charts whose
+// dependencies are built are packaged with them into archiveDir. It returns the archive of each of these charts keyed
+// by its chart directory. The charts are not packaged if archiveDir is empty.
+func BuildHelmDependencies(chartDirs map[string]string, credentials []*HelmDependencyCredentials,
+	archiveDir string) (map[string]string, error) {
+	archives := map[string]string{}
+
+	for key := range chartDirs {
+		chartDir := strings.TrimSuffix(key, "/")
+
+		built, err := buildChartDependencies(chartDir, credentials)
+		if err != nil {
+			klog.Error("Failed to build the dependencies of the Helm chart ", chartDir, ", err: ", err)
+			return nil, fmt.Errorf("failed to build the dependencies of the Helm chart %s: %w", filepath.Base(chartDir), err)
+		}
+
+		if !built || archiveDir == "" {
+			continue
+		}
+
+		archive, err := packageChart(chartDir, archiveDir)
+		if err != nil {
+			klog.Error("Failed to package the Helm chart ", chartDir, ", err: ", err)
+			return nil, fmt.Errorf("failed to package the Helm chart %s: %w", filepath.Base(chartDir), err)
+		}
+
+		archives[key] = archive
+	}
+
+	return archives, nil
+}
+
+// buildChartDependencies downloads the dependencies of the chart into its charts folder. It returns true if the
+// dependencies were not already in the charts folder.
+func buildChartDependencies(chartDir string, credentials []*HelmDependencyCredentials) (bool, error) {
+	ch, err := loader.LoadDir(chartDir)
+	if err != nil {
+		return false, err
+	}
+
+	if len(ch.Metadata.Dependencies) == 0 || dependenciesSatisfied(ch) {
+		return false, nil
+	}
+
+	if restoreCachedDependencies(chartDir, ch, credentials) {
+		klog.Info("Restored the dependencies of the Helm chart ", chartDir, " from the cache")
+		return true, nil
+	}
+
+	// Every build gets its own work directory for the repositories file and the index files, so that builds of
+	// different subscriptions do not wait on each other
+	workDir, err := ioutil.TempDir("", "helm-dependency")
+	if err != nil {
+		return false, err
+	}
+
+	defer os.RemoveAll(workDir)
+
+	repoFile, err := dependencyRepoFile(workDir, ch.Metadata.Dependencies, credentials)
+	if err != nil {
+		return false, err
+	}
+
+	repoConfig := filepath.Join(workDir, "repositories.yaml")
+	if err := repoFile.WriteFile(repoConfig, 0600); err != nil {
+		return false, err
+	}
+
+	getters := getter.All(cli.New())
+	repoCache := filepath.Join(workDir, "repository")
+
+	// The dependency manager of helm 3.5 downloads the index files to the default helm cache regardless of its
+	// RepositoryCache, so the index files are downloaded here and the manager skips the update
+	for _, entry := range repoFile.Repositories {
+		chartRepo, err := repo.NewChartRepository(entry, getters)
+		if err != nil {
+			return false, err
+		}
+
+		chartRepo.CachePath = repoCache
+
+		if _, err := chartRepo.DownloadIndexFile(); err != nil {
+			return false, fmt.Errorf("failed to download the index file of the Helm repo %s: %w", entry.URL, err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	man := &downloader.Manager{
+		Out:              out,
+		ChartPath:        chartDir,
+		SkipUpdate:       true,
+		Getters:          getters,
+		RepositoryConfig: repoConfig,
+		RepositoryCache:  repoCache,
+	}
+
+	err = man.Build()
+
+	klog.V(4).Info("Helm dependency build of ", chartDir, ":\n", out.String())
+
+	if err != nil {
+		return false, err
+	}
+
+	cacheDependencies(chartDir, credentials)
+
+	return true, nil
+}
+
+// packageChart saves the chart with its dependencies as an archive in the directory and returns the archive path
+func packageChart(chartDir, archiveDir string) (string, error) {
+	ch, err := loader.LoadDir(chartDir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(archiveDir, 0750); err != nil {
+		return "", err
+	}
+
+	// The chart is saved to a temporary directory first because the HelmRelease controller may be reading the
+	// archive of the previous commit
+	tmpDir, err := ioutil.TempDir(archiveDir, ".package")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	tmp, err := chartutil.Save(ch, tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	archive := filepath.Join(archiveDir, filepath.Base(tmp))
+
+	return archive, os.Rename(tmp, archive)
+}
+
+// dependencyRepoFile returns the Helm repositories file with an entry for each Helm repo of the dependencies. The TLS
+// client certificates of the credentials are written to the work directory.
+func dependencyRepoFile(workDir string, deps []*chart.Dependency, credentials []*HelmDependencyCredentials) (*repo.File, error) {
+	repoFile := repo.NewFile()
+
+	for _, dep := range deps {
+		repoURL := strings.TrimSuffix(dep.Repository, "/")
+		// The entry is named after a hash of the URL to get a stable index file name in the repository cache
+		name := "dependency-" + repoCacheKey(repoURL, nil)[:16]
+
+		if !isHelmRepoURL(repoURL) || repoFile.Has(name) {
+			continue
+		}
+
+		entry := &repo.Entry{Name: name, URL: repoURL}
+
+		if creds := credentialsForRepo(credentials, repoURL); creds != nil {
+			entry.Username = creds.Username
+			entry.Password = creds.Password
+
+			if len(creds.ClientCert) > 0 {
+				entry.CertFile = filepath.Join(workDir, name+".crt")
+				entry.KeyFile = filepath.Join(workDir, name+".key")
+
+				if err := ioutil.WriteFile(entry.CertFile, creds.ClientCert, 0600); err != nil {
+					return nil, err
+				}
+
+				if err := ioutil.WriteFile(entry.KeyFile, creds.ClientKey, 0600); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		repoFile.Update(entry)
+	}
+
+	return repoFile, nil
+}
+
+// dependenciesSatisfied returns true if the charts folder of the chart has a chart for every dependency
+func dependenciesSatisfied(ch *chart.Chart) bool {
+	for _, dep := range ch.Metadata.Dependencies {
+		if !hasSubchart(ch.Dependencies(), dep.Name, dep.Version) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasSubchart(subcharts []*chart.Chart, name, version string) bool {
+	for _, subchart := range subcharts {
+		if subchart.Metadata == nil || subchart.Metadata.Name != name {
+			continue
+		}
+
+		if version == "" {
+			return true
+		}
+
+		constraint, err := semver.NewConstraint(version)
+		if err != nil {
+			return false
+		}
+
+		v, err := semver.NewVersion(subchart.Metadata.Version)
+		if err == nil && constraint.Check(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cachedArchivePath returns the path of the cached archive of the dependency
+func cachedArchivePath(dep *chart.Dependency, credentials []*HelmDependencyCredentials) string {
+	repoURL := strings.TrimSuffix(dep.Repository, "/")
+
+	return filepath.Join(HelmDependencyCacheDir, "archives", repoCacheKey(repoURL, credentialsForRepo(credentials, repoURL)),
+		dep.Name+"-"+dep.Version+".tgz")
+}
+
+// restoreCachedDependencies copies the cached archives of the dependencies pinned by the Chart.lock file to the charts
+// folder. It returns false without changing the chart if an archive is not cached.
+func restoreCachedDependencies(chartDir string, ch *chart.Chart, credentials []*HelmDependencyCredentials) bool {
+	if ch.Lock == nil {
+		return false
+	}
+
+	// The lock file has to pin every dependency of Chart.yaml, otherwise it is out of sync and helm fails the build
+	for _, dep := range ch.Metadata.Dependencies {
+		if !isLocked(ch.Lock, dep) {
+			return false
+		}
+	}
+
+	archives := map[string]string{}
+
+	for _, dep := range ch.Lock.Dependencies {
+		if !isHelmRepoURL(dep.Repository) {
+			return false
+		}
+
+		archive := cachedArchivePath(dep, credentials)
+		if _, err := os.Stat(archive); err != nil {
+			return false
+		}
+
+		archives[dep.Name] = archive
+	}
+
+	chartsDir := filepath.Join(chartDir, "charts")
+	if err := os.MkdirAll(chartsDir, 0755); err != nil {
+		return false
+	}
+
+	// Remove the archives of other versions of the dependencies
+	for path, metadata := range chartArchives(chartsDir) {
+		if _, ok := archives[metadata.Name]; ok {
+			_ = os.Remove(path)
+		}
+	}
+
+	for name, archive := range archives {
+		if err := copyFile(archive, filepath.Join(chartsDir, filepath.Base(archive))); err != nil {
+			klog.Warning("Failed to restore the cached dependency ", name, ", err: ", err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLocked(lock *chart.Lock, dep *chart.Dependency) bool {
+	for _, locked := range lock.Dependencies {
+		if locked.Name != dep.Name || strings.TrimSuffix(locked.Repository, "/") != strings.TrimSuffix(dep.Repository, "/") {
+			continue
+		}
+
+		if dep.Version == "" {
+			return true
+		}
+
+		constraint, err := semver.NewConstraint(dep.Version)
+		if err != nil {
+			return false
+		}
+
+		v, err := semver.NewVersion(locked.Version)
+		if err == nil && constraint.Check(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheDependencies copies the dependency archives downloaded from Helm repos to the cache
+func cacheDependencies(chartDir string, credentials []*HelmDependencyCredentials) {
+	ch, err := loader.LoadDir(chartDir)
+	if err != nil || ch.Lock == nil {
+		return
+	}
+
+	for path, metadata := range chartArchives(filepath.Join(chartDir, "charts")) {
+		for _, dep := range ch.Lock.Dependencies {
+			if dep.Name != metadata.Name || dep.Version != metadata.Version || !isHelmRepoURL(dep.Repository) {
+				continue
+			}
+
+			archive := cachedArchivePath(dep, credentials)
+
+			if err := os.MkdirAll(filepath.Dir(archive), 0700); err != nil {
+				klog.Warning("Failed to create the Helm dependency cache, err: ", err)
+				return
+			}
+
+			if err := copyFile(path, archive); err != nil {
+				klog.Warning("Failed to cache the dependency ", dep.Name, ", err: ", err)
+			}
+		}
+	}
+}
+
+// chartArchives returns the chart metadata of the chart archives in the folder keyed by the archive path
+func chartArchives(dir string) map[string]*chart.Metadata {
+	archives := map[string]*chart.Metadata{}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return archives
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".tgz") {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+
+		ch, err := loader.LoadFile(path)
+		if err != nil {
+			continue
+		}
+
+		archives[path] = ch.Metadata
+	}
+
+	return archives
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	// Concurrent builds may cache the same archive, each of them writes its own temporary file
+	out, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+
+	tmp := out.Name()
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBuildHelmDependencies(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "helmdeps")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	cacheDir := HelmDependencyCacheDir
+	HelmDependencyCacheDir = filepath.Join(dir, "cache")
+
+	defer func() { HelmDependencyCacheDir = cacheDir }()
+
+	archivePath, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "dep", Version: "0.1.0"},
+	}, dir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	archive, err := ioutil.ReadFile(archivePath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	digest, err := provenance.DigestFile(archivePath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	var index []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/index.yaml":
+			_, _ = w.Write(index)
+		case "/dep-0.1.0.tgz":
+			_, _ = w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	indexFile := repo.NewIndexFile()
+	g.Expect(indexFile.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "dep", Version: "0.1.0"},
+		"dep-0.1.0.tgz", server.URL, digest)).To(gomega.Succeed())
+
+	index, err = yaml.Marshal(indexFile)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	newChartDir := func(name string) string {
+		chartDir := filepath.Join(dir, name)
+
+		g.Expect(os.MkdirAll(chartDir, 0755)).To(gomega.Succeed())
+		g.Expect(chartutil.SaveChartfile(filepath.Join(chartDir, "Chart.yaml"), &chart.Metadata{
+			APIVersion:   chart.APIVersionV2,
+			Name:         name,
+			Version:      "1.0.0",
+			Dependencies: []*chart.Dependency{{Name: "dep", Version: "~0.1.0", Repository: server.URL}},
+		})).To(gomega.Succeed())
+
+		return chartDir
+	}
+
+	secret := &corev1.Secret{Data: map[string][]byte{UserID: []byte("admin"), HelmDependencyPassword: []byte("secret")}}
+
+	creds, err := NewHelmDependencyCredentials(secret, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	archiveDir := filepath.Join(dir, "archives")
+
+	// Without credentials the dependency can not be downloaded
+	chartDir := newChartDir("app")
+	_, err = BuildHelmDependencies(map[string]string{chartDir + "/": chartDir + "/"}, nil, archiveDir)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	archives, err := BuildHelmDependencies(map[string]string{chartDir + "/": chartDir + "/"},
+		[]*HelmDependencyCredentials{creds}, archiveDir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(filepath.Join(chartDir, "charts", "dep-0.1.0.tgz")).To(gomega.BeAnExistingFile())
+	g.Expect(filepath.Join(chartDir, "Chart.lock")).To(gomega.BeAnExistingFile())
+
+	// The chart is packaged with its dependency for the HelmRelease controller
+	g.Expect(archives).To(gomega.HaveKeyWithValue(chartDir+"/", filepath.Join(archiveDir, "app-1.0.0.tgz")))
+
+	packaged, err := loader.LoadFile(archives[chartDir+"/"])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(packaged.Dependencies()).To(gomega.HaveLen(1))
+
+	lock, err := ioutil.ReadFile(filepath.Join(chartDir, "Chart.lock"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// A chart with a lock file gets the dependency from the cache without contacting the Helm repo
+	server.Close()
+
+	otherDir := newChartDir("other")
+	g.Expect(ioutil.WriteFile(filepath.Join(otherDir, "Chart.lock"), lock, 0600)).To(gomega.Succeed())
+
+	_, err = BuildHelmDependencies(map[string]string{otherDir: otherDir}, []*HelmDependencyCredentials{creds}, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(filepath.Join(otherDir, "charts", "dep-0.1.0.tgz")).To(gomega.BeAnExistingFile())
+
+	// The cached archive is not handed out for other credentials
+	g.Expect(os.RemoveAll(filepath.Join(otherDir, "charts"))).To(gomega.Succeed())
+	_, err = BuildHelmDependencies(map[string]string{otherDir: otherDir}, nil, "")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// A chart that already has its dependencies is left untouched and is not packaged
+	archives, err = BuildHelmDependencies(map[string]string{chartDir: chartDir}, nil, archiveDir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(archives).To(gomega.BeEmpty())
+}
+
+func TestHelmDependencyCredentials(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	subCreds, err := NewHelmDependencyCredentials(&corev1.Secret{Data: map[string][]byte{
+		UserID: []byte("sub"), AccessToken: []byte("token")}}, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(subCreds.Password).To(gomega.Equal("token"))
+
+	chnCreds, err := NewHelmDependencyCredentials(&corev1.Secret{Data: map[string][]byte{
+		UserID: []byte("chn"), AccessToken: []byte("token")}}, "github.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// A secret without credentials, like an SSH key secret, has no Helm repo credentials
+	noCreds, err := NewHelmDependencyCredentials(&corev1.Secret{Data: map[string][]byte{SSHKey: []byte("key")}}, "github.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(noCreds).To(gomega.BeNil())
+
+	g.Expect(credentialsForRepo([]*HelmDependencyCredentials{chnCreds}, "https://github.com/charts")).To(gomega.Equal(chnCreds))
+	g.Expect(credentialsForRepo([]*HelmDependencyCredentials{chnCreds}, "https://charts.example.com")).To(gomega.BeNil())
+	g.Expect(credentialsForRepo([]*HelmDependencyCredentials{subCreds, chnCreds}, "https://github.com/charts")).To(gomega.Equal(subCreds))
+}
@@ -21,6 +21,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/blang/semver"
@@ -110,7 +112,16 @@ func CreateOrUpdateHelmChart(
 
 	var source *releasev1.Source
 
-	if IsGitChannel(string(channel.Spec.Type)) {
+	// Charts of Git repos that are packaged with their dependencies are installed from the local archive, the
+	// HelmRelease controller runs in the same process
+	if IsGitChannel(string(channel.Spec.Type)) && strings.HasPrefix(chartVersions[0].URLs[0], "file://") {
+		source = &releasev1.Source{
+			SourceType: releasev1.HelmRepoSourceType,
+			HelmRepo: &releasev1.HelmRepo{
+				Urls: []string{chartVersions[0].URLs[0]},
+			},
+		}
+	} else if IsGitChannel(string(channel.Spec.Type)) {
 		source = &releasev1.Source{
 			SourceType: releasev1.GitSourceType,
 			Git: &releasev1.Git{
@@ -269,14 +280,27 @@ func getShortSubUID(subUID string) string {
 }
 
 func PkgToReleaseCRName(sub *appv1.Subscription, packageName string) (string, error) {
+	return PkgVersionToReleaseCRName(sub, packageName, "")
+}
+
+// PkgVersionToReleaseCRName returns the HelmRelease name of a version of the package. The version key is empty unless
+// the subscription deploys several versions of the package side by side.
+func PkgVersionToReleaseCRName(sub *appv1.Subscription, packageName, versionKey string) (string, error) {
 	releaseCRName := GetPackageAlias(sub, packageName)
 	if releaseCRName == "" {
 		releaseCRName = packageName
+
+		if versionKey != "" {
+			releaseCRName += "-" + versionKey
+		}
+
 		subUID := string(sub.UID)
 
 		if subUID != "" {
 			releaseCRName += "-" + getShortSubUID(subUID)
 		}
+	} else if versionKey != "" {
+		releaseCRName += "-" + versionKey
 	}
 
 	releaseCRName, err := GetReleaseName(releaseCRName)
@@ -290,11 +314,12 @@ func PkgToReleaseCRName(sub *appv1.Subscription, packageName string) (string, er
 func CreateHelmCRDeployable(
 	repoURL string,
 	packageName string,
+	versionKey string,
 	chartVersions repo.ChartVersions,
 	client client.Client,
 	channel *chnv1.Channel,
 	sub *appv1.Subscription) (*dplv1.Deployable, error) {
-	releaseCRName, err := PkgToReleaseCRName(sub, packageName)
+	releaseCRName, err := PkgVersionToReleaseCRName(sub, packageName, versionKey)
 	if err != nil {
 		return nil, err
 	}
@@ -350,6 +375,10 @@ func CreateHelmCRDeployable(
 
 	dpl := &dplv1.Deployable{}
 	dpl.Name = sub.Name + "-" + getShortSubUID(string(sub.UID)) + "-" + packageName
+
+	if versionKey != "" {
+		dpl.Name += "-" + versionKey
+	}
 	dpl.Namespace = sub.Namespace
 
 	dpl.Spec.Template = &runtime.RawExtension{}
@@ -395,14 +424,11 @@ func getOverrides(packageName string, sub *appv1.Subscription) dplv1.Overrides {
 //FilterCharts filters the indexFile by name, version, digest
 func FilterCharts(sub *appv1.Subscription, indexFile *repo.IndexFile) error {
 	//Removes all entries from the indexFile with non matching name
-	err := removeNoMatchingName(sub, indexFile)
-	if err != nil {
-		klog.Warning(err)
-	}
+	removeNoMatchingName(sub, indexFile)
 	//Removes non matching version, digest
 	filterOnVersion(sub, indexFile)
-	//Keep only the lastest version if multiple remains after filtering.
-	err = takeLatestVersion(indexFile)
+	//Keep only the lastest version of each version range if multiple remains after filtering.
+	err := takeLatestVersion(sub, indexFile)
 	if err != nil {
 		klog.Error("Failed to filter on version with error: ", err)
 		return err
@@ -412,11 +438,29 @@ func FilterCharts(sub *appv1.Subscription, indexFile *repo.IndexFile) error {
 }
 
 //takeLatestVersion if the indexFile contains multiple versions for a given chart, then
-//only the latest is kept.
-func takeLatestVersion(indexFile *repo.IndexFile) (err error) {
+//only the latest is kept. When the subscription has several version ranges for the chart,
+//the latest version of each range is kept.
+func takeLatestVersion(sub *appv1.Subscription, indexFile *repo.IndexFile) (err error) {
 	indexFile.SortEntries()
 
-	for k := range indexFile.Entries {
+	for k, chartVersions := range indexFile.Entries {
+		versionRanges := getVersionRanges(sub, k)
+
+		if len(versionRanges) > 1 {
+			latestVersions := repo.ChartVersions{}
+
+			for _, versionRange := range versionRanges {
+				chartVersion := latestVersionInRange(chartVersions, versionRange)
+				if chartVersion != nil && !containsChartVersion(latestVersions, chartVersion) {
+					latestVersions = append(latestVersions, chartVersion)
+				}
+			}
+
+			indexFile.Entries[k] = latestVersions
+
+			continue
+		}
+
 		//Get return the latest version when version is empty but
 		//there is a bug in the masterminds semver used by helm
 		// "*" constraint is not working properly
@@ -433,6 +477,57 @@ func takeLatestVersion(indexFile *repo.IndexFile) (err error) {
 	return nil
 }
 
+//latestVersionInRange returns the latest of the chart versions sorted from the latest that matches the version range
+func latestVersionInRange(chartVersions repo.ChartVersions, versionRange string) *repo.ChartVersion {
+	filterVersion, err := semver.ParseRange(versionRange)
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+
+	for _, chartVersion := range chartVersions {
+		version, err := semver.Parse(chartVersion.Version)
+		if err != nil {
+			continue
+		}
+
+		if filterVersion(version) {
+			return chartVersion
+		}
+	}
+
+	return nil
+}
+
+func containsChartVersion(chartVersions repo.ChartVersions, chartVersion *repo.ChartVersion) bool {
+	for _, cv := range chartVersions {
+		if cv.Version == chartVersion.Version {
+			return true
+		}
+	}
+
+	return false
+}
+
+//getVersionRanges returns the version ranges of the package in the subscription package filter
+func getVersionRanges(sub *appv1.Subscription, packageName string) []string {
+	if sub == nil || sub.Spec.PackageFilter == nil {
+		return nil
+	}
+
+	for _, pkg := range sub.Spec.PackageFilter.Packages {
+		if pkg.Name == packageName && len(pkg.Versions) > 0 {
+			return pkg.Versions
+		}
+	}
+
+	if sub.Spec.PackageFilter.Version != "" {
+		return []string{sub.Spec.PackageFilter.Version}
+	}
+
+	return nil
+}
+
 //checkDigest Checks if the digest matches
 func checkDigest(sub *appv1.Subscription, chartVersion *repo.ChartVersion) bool {
 	if sub != nil {
@@ -450,26 +545,32 @@ func checkDigest(sub *appv1.Subscription, chartVersion *repo.ChartVersion) bool
 	return true
 }
 
-//removeNoMatchingName Deletes entries that the name doesn't match the name provided in the subscription
-func removeNoMatchingName(sub *appv1.Subscription, indexFile *repo.IndexFile) error {
+//removeNoMatchingName Deletes entries that the name doesn't match the name provided in the subscription, or
+//the names of the packages of the package filter. All entries are kept if the subscription has neither, so that charts
+//are selected by the package filter only.
+func removeNoMatchingName(sub *appv1.Subscription, indexFile *repo.IndexFile) {
+	names := make(map[string]bool)
+
 	if sub.Spec.Package != "" {
-		keys := make([]string, 0)
-		for k := range indexFile.Entries {
-			keys = append(keys, k)
+		names[sub.Spec.Package] = true
+	} else if sub.Spec.PackageFilter != nil {
+		for _, pkg := range sub.Spec.PackageFilter.Packages {
+			names[pkg.Name] = true
 		}
+	}
 
-		for _, k := range keys {
-			if k != sub.Spec.Package {
-				delete(indexFile.Entries, k)
-			}
+	if len(names) == 0 {
+		klog.V(4).Infof("subscription %s/%s has no package name, selecting the charts by the package filter", sub.Namespace, sub.Name)
+		return
+	}
+
+	for k := range indexFile.Entries {
+		if !names[k] {
+			delete(indexFile.Entries, k)
 		}
-	} else {
-		return fmt.Errorf("subsciption.spec.package is missing for subscription: %s/%s", sub.Namespace, sub.Name)
 	}
 
 	klog.V(4).Info("After name matching:", indexFile)
-
-	return nil
 }
 
 //filterOnVersion filters the indexFile with the version, and Digest provided in the subscription
@@ -510,32 +611,137 @@ func checkKeywords(sub *appv1.Subscription, chartVersion *repo.ChartVersion) boo
 	return KeywordsChecker(labelSelector, chartVersion.Keywords)
 }
 
-//checkVersion checks if the version matches
+//checkVersion checks if the version matches one of the version ranges of the chart
 func checkVersion(sub *appv1.Subscription, chartVersion *repo.ChartVersion) bool {
-	if sub.Spec.PackageFilter != nil {
-		if sub.Spec.PackageFilter.Version != "" {
-			version := chartVersion.Version
-			versionVersion, err := semver.Parse(version)
+	versionRanges := getVersionRanges(sub, chartVersion.Name)
 
-			if err != nil {
-				klog.Error(err)
-				return false
+	if len(versionRanges) == 0 {
+		klog.V(4).Info("Version check passed for:", chartVersion)
+
+		return true
+	}
+
+	versionVersion, err := semver.Parse(chartVersion.Version)
+
+	if err != nil {
+		klog.Error(err)
+		return false
+	}
+
+	for _, versionRange := range versionRanges {
+		filterVersion, err := semver.ParseRange(versionRange)
+
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+
+		if filterVersion(versionVersion) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ChartVersionInRange returns true if the chart version is in the version range of a package filter
+func ChartVersionInRange(version, versionRange string) bool {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return version == versionRange
+	}
+
+	inRange, err := semver.ParseRange(versionRange)
+	if err != nil {
+		return version == versionRange
+	}
+
+	return inRange(v)
+}
+
+//ChartRelease is a version of a Helm chart selected by a subscription. Each chart release is deployed by its own
+//HelmRelease.
+type ChartRelease struct {
+	// PackageName is the name of the chart
+	PackageName string
+	// VersionKey tells apart the versions of a chart deployed side by side. It is empty when the subscription deploys
+	// one version of the chart.
+	VersionKey    string
+	ChartVersions repo.ChartVersions
+}
+
+//Name returns the package name of the chart release
+func (r ChartRelease) Name() string {
+	if r.VersionKey == "" {
+		return r.PackageName
+	}
+
+	return r.PackageName + "-" + r.VersionKey
+}
+
+var nonVersionKeyChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+//versionRangeKey returns the version key of the chart release of a version range, such as 1-x for 1.x
+func versionRangeKey(versionRange string) string {
+	return strings.Trim(nonVersionKeyChars.ReplaceAllString(strings.ToLower(versionRange), "-"), "-")
+}
+
+//GetChartReleases returns the chart releases of the filtered index file sorted by name
+func GetChartReleases(sub *appv1.Subscription, indexFile *repo.IndexFile) ([]ChartRelease, error) {
+	packageNames := make([]string, 0, len(indexFile.Entries))
+
+	for packageName := range indexFile.Entries {
+		packageNames = append(packageNames, packageName)
+	}
+
+	sort.Strings(packageNames)
+
+	chartReleases := []ChartRelease{}
+
+	for _, packageName := range packageNames {
+		chartVersions := indexFile.Entries[packageName]
+		if len(chartVersions) == 0 {
+			continue
+		}
+
+		versionRanges := getVersionRanges(sub, packageName)
+
+		if len(versionRanges) <= 1 {
+			chartReleases = append(chartReleases, ChartRelease{PackageName: packageName, ChartVersions: chartVersions})
+
+			continue
+		}
+
+		versionKeys := make(map[string]string)
+
+		for _, versionRange := range versionRanges {
+			versionKey := versionRangeKey(versionRange)
+
+			if versionKey == "" {
+				return nil, fmt.Errorf("the version range %q of chart %s can not name a HelmRelease", versionRange, packageName)
 			}
 
-			filterVersion, err := semver.ParseRange(sub.Spec.PackageFilter.Version)
+			if other, ok := versionKeys[versionKey]; ok {
+				return nil, fmt.Errorf("the version ranges %q and %q of chart %s give the same HelmRelease name", other, versionRange, packageName)
+			}
 
-			if err != nil {
-				klog.Error(err)
-				return false
+			versionKeys[versionKey] = versionRange
+
+			chartVersion := latestVersionInRange(chartVersions, versionRange)
+			if chartVersion == nil {
+				klog.Infof("No version of chart %s matches the version range %s", packageName, versionRange)
+				continue
 			}
 
-			return filterVersion(versionVersion)
+			chartReleases = append(chartReleases, ChartRelease{
+				PackageName:   packageName,
+				VersionKey:    versionKey,
+				ChartVersions: repo.ChartVersions{chartVersion},
+			})
 		}
 	}
 
-	klog.V(4).Info("Version check passed for:", chartVersion)
-
-	return true
+	return chartReleases, nil
 }
 
 //DeleteHelmReleaseCRD deletes the HelmRelease CRD
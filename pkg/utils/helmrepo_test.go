@@ -315,14 +315,14 @@ func TestCreateHelmCRDeployable(t *testing.T) {
 
 	githubsub.UID = "dummyuid"
 
-	dpl, err := CreateHelmCRDeployable("../..", "chart1", indexFile.Entries["chart1"], c, githubchn, githubsub)
+	dpl, err := CreateHelmCRDeployable("../..", "chart1", "", indexFile.Entries["chart1"], c, githubchn, githubsub)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(dpl).NotTo(gomega.BeNil())
 
 	dplName1 := dpl.Name
 
 	githubchn.Spec.Type = chnv1.ChannelTypeHelmRepo
-	dpl, err = CreateHelmCRDeployable("../..", "chart1", indexFile.Entries["chart1"], c, githubchn, githubsub)
+	dpl, err = CreateHelmCRDeployable("../..", "chart1", "", indexFile.Entries["chart1"], c, githubchn, githubsub)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(dpl).NotTo(gomega.BeNil())
 
@@ -338,7 +338,7 @@ func TestCreateHelmCRDeployable(t *testing.T) {
 
 	time.Sleep(3 * time.Second)
 
-	dpl, err = CreateHelmCRDeployable("../..", "chart1", indexFile.Entries["chart1"], c, githubchn, githubsub)
+	dpl, err = CreateHelmCRDeployable("../..", "chart1", "", indexFile.Entries["chart1"], c, githubchn, githubsub)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(dpl).NotTo(gomega.BeNil())
 
@@ -390,3 +390,127 @@ func TestIsURL(t *testing.T) {
 	g.Expect(IsURL("https://charts.helm.sh/stable/packages/nginx-ingress-1.40.1.tgz")).To(gomega.BeTrue())
 	g.Expect(IsURL("nginx-ingress-1.40.1.tgz")).To(gomega.BeFalse())
 }
+
+const testMultiChartIndex = `apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 2.1.0
+    keywords: [web]
+  - name: nginx
+    version: 2.0.0
+    keywords: [web]
+  - name: nginx
+    version: 1.9.0
+    keywords: [web]
+  - name: nginx
+    version: 1.8.0
+    keywords: [web]
+  redis:
+  - name: redis
+    version: 3.0.0
+    keywords: [database]
+  - name: redis
+    version: 2.0.0
+    keywords: [database]
+  httpd:
+  - name: httpd
+    version: 1.0.0
+    keywords: [web]
+`
+
+func testMultiChartIndexFile(g *gomega.GomegaWithT) *repo.IndexFile {
+	indexFile := &repo.IndexFile{}
+	g.Expect(yaml.Unmarshal([]byte(testMultiChartIndex), indexFile)).To(gomega.Succeed())
+
+	return indexFile
+}
+
+func chartVersionsOf(indexFile *repo.IndexFile) map[string][]string {
+	versions := map[string][]string{}
+
+	for name, chartVersions := range indexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			versions[name] = append(versions[name], chartVersion.Version)
+		}
+	}
+
+	return versions
+}
+
+func TestFilterChartsMultiplePackages(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "multi", Namespace: "default", UID: "abcdef"},
+		Spec:       appv1.SubscriptionSpec{Package: "nginx"},
+	}
+
+	// The package name selects one chart
+	indexFile := testMultiChartIndexFile(g)
+	g.Expect(FilterCharts(sub, indexFile)).To(gomega.Succeed())
+	g.Expect(chartVersionsOf(indexFile)).To(gomega.Equal(map[string][]string{"nginx": {"2.1.0"}}))
+
+	// Without package name, the charts are selected by keyword
+	sub.Spec.Package = ""
+	sub.Spec.PackageFilter = &appv1.PackageFilter{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"web": "true"}},
+	}
+
+	indexFile = testMultiChartIndexFile(g)
+	g.Expect(FilterCharts(sub, indexFile)).To(gomega.Succeed())
+	g.Expect(chartVersionsOf(indexFile)).To(gomega.Equal(map[string][]string{"nginx": {"2.1.0"}, "httpd": {"1.0.0"}}))
+
+	// or by a list of names, each with its own version ranges
+	sub.Spec.PackageFilter = &appv1.PackageFilter{
+		Version: "2.x",
+		Packages: []appv1.PackageVersions{
+			{Name: "nginx", Versions: []string{"1.x", ">=2.0.0 <2.1.0"}},
+			{Name: "redis"},
+		},
+	}
+
+	indexFile = testMultiChartIndexFile(g)
+	g.Expect(FilterCharts(sub, indexFile)).To(gomega.Succeed())
+	g.Expect(chartVersionsOf(indexFile)).To(gomega.Equal(map[string][]string{"nginx": {"1.9.0", "2.0.0"}, "redis": {"2.0.0"}}))
+
+	chartReleases, err := GetChartReleases(sub, indexFile)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(chartReleases).To(gomega.HaveLen(3))
+
+	names := []string{}
+
+	for _, chartRelease := range chartReleases {
+		g.Expect(chartRelease.ChartVersions).To(gomega.HaveLen(1))
+
+		name, err := PkgVersionToReleaseCRName(sub, chartRelease.PackageName, chartRelease.VersionKey)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		names = append(names, chartRelease.Name()+"="+chartRelease.ChartVersions[0].Version+"="+name)
+	}
+
+	g.Expect(names).To(gomega.Equal([]string{
+		"nginx-1-x=1.9.0=nginx-1-x-abcde",
+		"nginx-2-0-0-2-1-0=2.0.0=nginx-2-0-0-2-1-0-abcde",
+		"redis=2.0.0=redis-abcde",
+	}))
+
+	// The HelmRelease of a single version keeps its name
+	name, err := PkgToReleaseCRName(sub, "redis")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(name).To(gomega.Equal("redis-abcde"))
+
+	sub.Spec.PackageOverrides = []*appv1.Overrides{{PackageName: "nginx", PackageAlias: "web"}}
+	name, err = PkgVersionToReleaseCRName(sub, "nginx", "1-x")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(name).To(gomega.Equal("web-1-x"))
+
+	// Version ranges must give distinct HelmRelease names
+	sub.Spec.PackageFilter.Packages[0].Versions = []string{">=2.0.0", "<=2.0.0"}
+
+	indexFile = testMultiChartIndexFile(g)
+	g.Expect(FilterCharts(sub, indexFile)).To(gomega.Succeed())
+
+	_, err = GetChartReleases(sub, indexFile)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/ghodss/yaml"
@@ -34,8 +35,31 @@ import (
 
 // RunKustomizeBuild runs kustomize build and returns the build output
 func RunKustomizeBuild(kustomizeDir string) ([]byte, error) {
-	fSys := filesys.MakeFsOnDisk()
+	return runKustomizeBuild(filesys.MakeFsOnDisk(), kustomizeDir)
+}
+
+// RunKustomizeBuildWithDeps runs kustomize build and returns the build output along with
+// the sorted absolute paths of all files and directories kustomize accessed during the build
+func RunKustomizeBuildWithDeps(kustomizeDir string) ([]byte, []string, error) {
+	fSys := &recordingFs{FileSystem: filesys.MakeFsOnDisk(), paths: map[string]bool{}}
+
+	out, err := runKustomizeBuild(fSys, kustomizeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deps := make([]string, 0, len(fSys.paths))
+
+	for path := range fSys.paths {
+		deps = append(deps, path)
+	}
 
+	sort.Strings(deps)
+
+	return out, deps, nil
+}
+
+func runKustomizeBuild(fSys filesys.FileSystem, kustomizeDir string) ([]byte, error) {
 	options := &krusty.Options{
 		DoLegacyResourceSort: true,
 		UseKyaml:             true,
@@ -56,6 +80,50 @@ func RunKustomizeBuild(kustomizeDir string) ([]byte, error) {
 	return byteOut, nil
 }
 
+// recordingFs records the paths kustomize reads from the underlying file system
+type recordingFs struct {
+	filesys.FileSystem
+	paths map[string]bool
+}
+
+func (fs *recordingFs) record(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	fs.paths[filepath.Clean(path)] = true
+}
+
+func (fs *recordingFs) Open(path string) (filesys.File, error) {
+	fs.record(path)
+	return fs.FileSystem.Open(path)
+}
+
+func (fs *recordingFs) IsDir(path string) bool {
+	fs.record(path)
+	return fs.FileSystem.IsDir(path)
+}
+
+func (fs *recordingFs) Exists(path string) bool {
+	fs.record(path)
+	return fs.FileSystem.Exists(path)
+}
+
+func (fs *recordingFs) Glob(pattern string) ([]string, error) {
+	fs.record(filepath.Dir(pattern))
+	return fs.FileSystem.Glob(pattern)
+}
+
+func (fs *recordingFs) ReadFile(path string) ([]byte, error) {
+	fs.record(path)
+	return fs.FileSystem.ReadFile(path)
+}
+
+func (fs *recordingFs) Walk(path string, walkFn filepath.WalkFunc) error {
+	fs.record(path)
+	return fs.FileSystem.Walk(path, walkFn)
+}
+
 func CheckPackageOverride(ov *appv1.Overrides) error {
 	if ov.PackageOverrides == nil || len(ov.PackageOverrides) < 1 {
 		return errors.New("no PackageOverride is specified. Skipping to override kustomization")
@@ -0,0 +1,594 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/cuecontext"
+	cueerrors "cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+	"github.com/ghodss/yaml"
+	"github.com/google/go-jsonnet"
+	"k8s.io/klog"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	// JsonnetEntrypoint is the file that marks a directory as a Jsonnet source to render
+	JsonnetEntrypoint = "main.jsonnet"
+	// CueEntrypoint is the file that marks a directory as a CUE source to render
+	CueEntrypoint = "main.cue"
+
+	// renderedManifestInfix is added to the entrypoint file name to name the rendered resource files
+	renderedManifestInfix = ".rendered-"
+	// jsonnetBundlerFile is the jsonnet-bundler file next to the vendor directory of Jsonnet libraries
+	jsonnetBundlerFile = "jsonnetfile.json"
+)
+
+// IsRenderedManifest returns true if the file was rendered from a Jsonnet or CUE entrypoint
+func IsRenderedManifest(path string) bool {
+	base := filepath.Base(path)
+
+	return strings.HasPrefix(base, JsonnetEntrypoint+renderedManifestInfix) ||
+		strings.HasPrefix(base, CueEntrypoint+renderedManifestInfix)
+}
+
+// GetManifestEntrypoint returns the Jsonnet or CUE entrypoint file name found in the directory
+func GetManifestEntrypoint(dir string) string {
+	for _, entrypoint := range []string{JsonnetEntrypoint, CueEntrypoint} {
+		if info, err := os.Stat(filepath.Join(dir, entrypoint)); err == nil && !info.IsDir() {
+			return entrypoint
+		}
+	}
+
+	return ""
+}
+
+func isChartOrKustomizeDir(dir string) bool {
+	for _, name := range []string{"Chart.yaml", "kustomization.yaml", "kustomization.yml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RenderManifestSources renders every Jsonnet and CUE source directory under the resource path into
+// resource files next to its entrypoint so that SortResources picks them up like any other resource file.
+// Directories under helm charts, kustomizations or another source directory are not rendered.
+func RenderManifestSources(repoRoot, resourcePath string, sub *appv1.Subscription) error {
+	kubeIgnore := GetKubeIgnore(resourcePath)
+
+	errMsgs := []string{}
+
+	err := filepath.Walk(resourcePath,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() {
+				return nil
+			}
+
+			if strings.HasPrefix(path, repoRoot+"/.git") {
+				return filepath.SkipDir
+			}
+
+			relativePath := strings.TrimPrefix(path, repoRoot+"/")
+
+			if kubeIgnore.MatchesPath(relativePath) {
+				return nil
+			}
+
+			if isChartOrKustomizeDir(path) {
+				return filepath.SkipDir
+			}
+
+			entrypoint := GetManifestEntrypoint(path)
+			if entrypoint == "" {
+				return nil
+			}
+
+			klog.Info("Rendering ", filepath.Join(path, entrypoint))
+
+			if err := renderManifestSource(repoRoot, path, entrypoint, sub); err != nil {
+				klog.Error("Failed to render ", filepath.Join(relativePath, entrypoint), ", err: ", err)
+				errMsgs = append(errMsgs, fmt.Sprintf("failed to render %s, err: %v", filepath.Join(relativePath, entrypoint), err))
+			}
+
+			return filepath.SkipDir
+		})
+
+	if err != nil {
+		return err
+	}
+
+	if len(errMsgs) > 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+
+	return nil
+}
+
+func renderManifestSource(repoRoot, dir, entrypoint string, sub *appv1.Subscription) error {
+	// Remove what was rendered from a previous commit
+	previous, err := filepath.Glob(filepath.Join(dir, entrypoint+renderedManifestInfix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range previous {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	var out []byte
+
+	if entrypoint == JsonnetEntrypoint {
+		out, err = renderJsonnet(repoRoot, dir, sub)
+	} else {
+		out, err = renderCue(repoRoot, dir, sub)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return err
+	}
+
+	for i, resource := range flattenManifests(doc) {
+		b, err := yaml.Marshal(resource)
+		if err != nil {
+			return err
+		}
+
+		file := filepath.Join(dir, fmt.Sprintf("%s%s%03d.yaml", entrypoint, renderedManifestInfix, i+1))
+
+		if err := ioutil.WriteFile(file, b, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flattenManifests returns the Kubernetes resources in rendered output. The output can be a single resource,
+// a List, an array of resources or an object whose field values are resources, nested to any depth.
+func flattenManifests(doc interface{}) []map[string]interface{} {
+	resources := []map[string]interface{}{}
+
+	switch v := doc.(type) {
+	case []interface{}:
+		for _, item := range v {
+			resources = append(resources, flattenManifests(item)...)
+		}
+	case map[string]interface{}:
+		_, hasAPIVersion := v["apiVersion"]
+		kind, hasKind := v["kind"].(string)
+
+		if hasAPIVersion && hasKind {
+			if items, ok := v["items"].([]interface{}); ok && strings.HasSuffix(kind, "List") {
+				return flattenManifests(items)
+			}
+
+			return append(resources, v)
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			resources = append(resources, flattenManifests(v[key])...)
+		}
+	}
+
+	return resources
+}
+
+// getRenderValues parses a subscription annotation holding a JSON object. String values are returned as they are
+// and any other value as JSON.
+func getRenderValues(sub *appv1.Subscription, annotation string) (strs map[string]string, codes map[string]string, err error) {
+	strs = map[string]string{}
+	codes = map[string]string{}
+
+	if sub == nil || sub.GetAnnotations()[annotation] == "" {
+		return strs, codes, nil
+	}
+
+	values := map[string]interface{}{}
+
+	if err := json.Unmarshal([]byte(sub.GetAnnotations()[annotation]), &values); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s annotation, err: %v", annotation, err)
+	}
+
+	for key, value := range values {
+		if str, ok := value.(string); ok {
+			strs[key] = str
+			continue
+		}
+
+		code, err := json.Marshal(value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[key] = string(code)
+	}
+
+	return strs, codes, nil
+}
+
+// repoImporter imports Jsonnet files from the library paths but never from outside the Git repo
+type repoImporter struct {
+	root     string
+	importer *jsonnet.FileImporter
+}
+
+func (i *repoImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+
+	if !isInDir(i.root, foundAt) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %s is outside of the Git repo", importedPath)
+	}
+
+	return contents, foundAt, nil
+}
+
+// isInDir returns true if the path, with symbolic links resolved, is in the directory
+func isInDir(dir, path string) bool {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	return realPath == realDir || strings.HasPrefix(realPath, realDir+string(filepath.Separator))
+}
+
+// jsonnetLibPaths returns the jsonnet-bundler vendor directories from the repo root down to the source directory.
+// The Jsonnet importer searches the last library path first, so the nearest vendor directory wins.
+func jsonnetLibPaths(repoRoot, dir string) []string {
+	paths := []string{}
+
+	for d := dir; strings.HasPrefix(d+"/", repoRoot+"/"); d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, jsonnetBundlerFile)); err == nil {
+			if info, err := os.Stat(filepath.Join(d, "vendor")); err == nil && info.IsDir() {
+				paths = append([]string{filepath.Join(d, "vendor")}, paths...)
+			}
+		}
+
+		if d == repoRoot {
+			break
+		}
+	}
+
+	return paths
+}
+
+func renderJsonnet(repoRoot, dir string, sub *appv1.Subscription) ([]byte, error) {
+	vm := jsonnet.MakeVM()
+
+	vm.Importer(&repoImporter{root: repoRoot, importer: &jsonnet.FileImporter{JPaths: jsonnetLibPaths(repoRoot, dir)}})
+
+	extStrs, extCodes, err := getRenderValues(sub, appv1.AnnotationJsonnetExtVars)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range extStrs {
+		vm.ExtVar(key, value)
+	}
+
+	for key, value := range extCodes {
+		vm.ExtCode(key, value)
+	}
+
+	tlaStrs, tlaCodes, err := getRenderValues(sub, appv1.AnnotationJsonnetTLAs)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range tlaStrs {
+		vm.TLAVar(key, value)
+	}
+
+	for key, value := range tlaCodes {
+		vm.TLACode(key, value)
+	}
+
+	out, err := vm.EvaluateFile(filepath.Join(dir, JsonnetEntrypoint))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(out), nil
+}
+
+// cueModuleRoot returns the nearest directory in the repo containing a cue.mod directory, or the source directory
+func cueModuleRoot(repoRoot, dir string) string {
+	for d := dir; strings.HasPrefix(d+"/", repoRoot+"/"); d = filepath.Dir(d) {
+		if info, err := os.Stat(filepath.Join(d, "cue.mod")); err == nil && info.IsDir() {
+			return d
+		}
+
+		if d == repoRoot {
+			break
+		}
+	}
+
+	return dir
+}
+
+// cueModulePath returns the module path declared in cue.mod/module.cue of the module root
+func cueModulePath(moduleRoot string) string {
+	file, err := parser.ParseFile("module.cue", readFileOrEmpty(filepath.Join(moduleRoot, "cue.mod", "module.cue")))
+	if err != nil {
+		return ""
+	}
+
+	for _, decl := range file.Decls {
+		if field, ok := decl.(*ast.Field); ok {
+			if name, _, _ := ast.LabelName(field.Label); name == "module" {
+				if lit, ok := field.Value.(*ast.BasicLit); ok {
+					if path, err := strconv.Unquote(lit.Value); err == nil {
+						return path
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func readFileOrEmpty(path string) []byte {
+	b, err := ioutil.ReadFile(path) // #nosec G304 path is in the cloned repo
+	if err != nil {
+		return []byte{}
+	}
+
+	return b
+}
+
+// cueLoader builds CUE package instances from a Git repo. Unlike cue/load, it resolves imports only from the
+// module in the repo and its cue.mod directory. Standard library imports are left to the CUE runtime.
+type cueLoader struct {
+	repoRoot   string
+	moduleRoot string
+	modulePath string
+	tags       map[string]string
+	ctx        *build.Context
+}
+
+func (l *cueLoader) importDirs(importPath string) []string {
+	if l.modulePath != "" && (importPath == l.modulePath || strings.HasPrefix(importPath, l.modulePath+"/")) {
+		return []string{filepath.Join(l.moduleRoot, strings.TrimPrefix(importPath, l.modulePath))}
+	}
+
+	dirs := []string{}
+
+	for _, sub := range []string{"gen", "pkg", "usr"} {
+		dirs = append(dirs, filepath.Join(l.moduleRoot, "cue.mod", sub, importPath))
+	}
+
+	return dirs
+}
+
+func (l *cueLoader) load(pos token.Pos, importPath string) *build.Instance {
+	path := importPath
+	pkgName := ""
+
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		path, pkgName = path[:i], path[i+1:]
+	}
+
+	// The first element of a standard library import path is not a domain name
+	if !strings.Contains(strings.Split(path, "/")[0], ".") {
+		return nil
+	}
+
+	if pkgName == "" {
+		pkgName = filepath.Base(path)
+	}
+
+	inst := l.ctx.NewInstance(path, l.load)
+
+	found := false
+
+	for _, dir := range l.importDirs(path) {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := l.addFiles(inst, dir, pkgName); err != nil {
+			inst.Err = cueerrors.Promote(err, "import failed")
+			return inst
+		}
+
+		found = true
+	}
+
+	if !found {
+		inst.Err = cueerrors.Newf(pos, "package %q not found in the CUE module", importPath)
+		return inst
+	}
+
+	if err := inst.Complete(); err != nil {
+		inst.Err = cueerrors.Promote(err, "import failed")
+	}
+
+	return inst
+}
+
+// addFiles adds the CUE files of the package in the directory to the instance
+func (l *cueLoader) addFiles(inst *build.Instance, dir, pkgName string) error {
+	if !isInDir(l.repoRoot, dir) {
+		return fmt.Errorf("%s is outside of the Git repo", dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.cue"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		base := filepath.Base(file)
+		if strings.HasPrefix(base, "_") || strings.HasPrefix(base, ".") || strings.HasSuffix(base, "_tool.cue") {
+			continue
+		}
+
+		if !isInDir(l.repoRoot, file) {
+			return fmt.Errorf("%s is outside of the Git repo", file)
+		}
+
+		src, err := ioutil.ReadFile(file) // #nosec G304 file is in the cloned repo
+		if err != nil {
+			return err
+		}
+
+		f, err := parser.ParseFile(file, src, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+
+		if name := f.PackageName(); pkgName != "" && name != pkgName {
+			continue
+		}
+
+		injectCueTags(f, l.tags)
+
+		if err := inst.AddSyntax(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// injectCueTags unifies fields that have a @tag(name) attribute with the value of the tag, like cue -t name=value
+func injectCueTags(f *ast.File, tags map[string]string) {
+	ast.Walk(f, func(node ast.Node) bool {
+		field, ok := node.(*ast.Field)
+		if !ok {
+			return true
+		}
+
+		for _, attr := range field.Attrs {
+			if !strings.HasPrefix(attr.Text, "@tag(") || !strings.HasSuffix(attr.Text, ")") {
+				continue
+			}
+
+			args := strings.Split(strings.TrimSuffix(strings.TrimPrefix(attr.Text, "@tag("), ")"), ",")
+
+			value, ok := tags[strings.TrimSpace(args[0])]
+			if !ok {
+				continue
+			}
+
+			var expr ast.Expr = ast.NewString(value)
+
+			for _, arg := range args[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(arg), "=", 2); len(kv) == 2 && kv[0] == "type" && kv[1] != "string" {
+					if parsed, err := parser.ParseExpr("tag", value); err == nil {
+						expr = parsed
+					}
+				}
+			}
+
+			field.Value = ast.NewBinExpr(token.AND, field.Value, expr)
+		}
+
+		return true
+	}, nil)
+}
+
+func renderCue(repoRoot, dir string, sub *appv1.Subscription) ([]byte, error) {
+	strs, codes, err := getRenderValues(sub, appv1.AnnotationCueTags)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range codes {
+		strs[key] = value
+	}
+
+	moduleRoot := cueModuleRoot(repoRoot, dir)
+
+	loader := &cueLoader{
+		repoRoot:   repoRoot,
+		moduleRoot: moduleRoot,
+		modulePath: cueModulePath(moduleRoot),
+		tags:       strs,
+	}
+	loader.ctx = build.NewContext(build.Loader(loader.load))
+
+	entrypoint, err := parser.ParseFile(CueEntrypoint, readFileOrEmpty(filepath.Join(dir, CueEntrypoint)), parser.PackageClauseOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := loader.ctx.NewInstance(dir, loader.load)
+
+	if err := loader.addFiles(inst, dir, entrypoint.PackageName()); err != nil {
+		return nil, err
+	}
+
+	if err := inst.Complete(); err != nil {
+		return nil, err
+	}
+
+	value := cuecontext.New().BuildInstance(inst)
+	if err := value.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := value.Validate(cue.Concrete(true)); err != nil {
+		return nil, err
+	}
+
+	return value.MarshalJSON()
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func writeTestFiles(g *gomega.GomegaWithT, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(gomega.Succeed())
+		g.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+	}
+}
+
+func readTestResource(g *gomega.GomegaWithT, path string) *unstructured.Unstructured {
+	b, err := ioutil.ReadFile(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	obj := &unstructured.Unstructured{}
+	g.Expect(yaml.Unmarshal(b, obj)).To(gomega.Succeed())
+
+	return obj
+}
+
+func nestedValue(value interface{}, found bool, err error) interface{} {
+	if !found || err != nil {
+		return nil
+	}
+
+	return value
+}
+
+func TestRenderJsonnet(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	repoRoot, err := ioutil.TempDir("", "jsonnet")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(repoRoot)
+
+	writeTestFiles(g, repoRoot, map[string]string{
+		"jsonnetfile.json":             `{"version": 1, "dependencies": []}`,
+		"vendor/k/k.libsonnet":         `{ configMap(name, data):: { apiVersion: "v1", kind: "ConfigMap", metadata: { name: name }, data: data } }`,
+		"vendor/k/ignored.yaml":        "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: vendored\n",
+		"app/main.jsonnet":             "local k = import 'k/k.libsonnet';\nfunction(replicas=1) {\n  config: k.configMap('app-config', { env: std.extVar('env') }),\n  namespace: { apiVersion: 'v1', kind: 'Namespace', metadata: { name: 'app' } },\n  deployments: [{ apiVersion: 'apps/v1', kind: 'Deployment', metadata: { name: 'app' }, spec: { replicas: replicas } }],\n}\n",
+		"app/lib/ignored.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: lib\n",
+		"plain/configmap.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: plain\n",
+		"escape/main.jsonnet":          "import '/etc/hostname'",
+		"chart/Chart.yaml":             "apiVersion: v2\nname: chart\nversion: 0.1.0\n",
+		"chart/templates/main.jsonnet": "{}",
+	})
+
+	sub := &appv1.Subscription{}
+	sub.SetAnnotations(map[string]string{
+		appv1.AnnotationJsonnetExtVars: `{"env": "prod"}`,
+		appv1.AnnotationJsonnetTLAs:    `{"replicas": 3}`,
+	})
+
+	// The source importing a file outside of the repo fails to render
+	err = RenderManifestSources(repoRoot, repoRoot, sub)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("escape/main.jsonnet"))
+	g.Expect(err.Error()).To(gomega.ContainSubstring("outside of the Git repo"))
+
+	g.Expect(os.RemoveAll(filepath.Join(repoRoot, "escape"))).To(gomega.Succeed())
+	g.Expect(RenderManifestSources(repoRoot, repoRoot, sub)).To(gomega.Succeed())
+
+	chartDirs, _, crdsAndNamespaceFiles, _, otherFiles, err := SortResources(repoRoot, repoRoot)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(chartDirs).To(gomega.HaveLen(1))
+
+	g.Expect(crdsAndNamespaceFiles).To(gomega.Equal([]string{filepath.Join(repoRoot, "app", "main.jsonnet.rendered-003.yaml")}))
+	g.Expect(otherFiles).To(gomega.Equal([]string{
+		filepath.Join(repoRoot, "app", "main.jsonnet.rendered-001.yaml"),
+		filepath.Join(repoRoot, "app", "main.jsonnet.rendered-002.yaml"),
+		filepath.Join(repoRoot, "plain", "configmap.yaml"),
+		filepath.Join(repoRoot, "vendor", "k", "ignored.yaml"),
+	}))
+
+	config := readTestResource(g, otherFiles[0])
+	g.Expect(config.GetName()).To(gomega.Equal("app-config"))
+	g.Expect(nestedValue(unstructured.NestedString(config.Object, "data", "env"))).To(gomega.Equal("prod"))
+
+	deployment := readTestResource(g, otherFiles[1])
+	g.Expect(deployment.GetKind()).To(gomega.Equal("Deployment"))
+	g.Expect(nestedValue(unstructured.NestedInt64(deployment.Object, "spec", "replicas"))).To(gomega.Equal(int64(3)))
+
+	// Rendering again replaces the previous output
+	sub.SetAnnotations(map[string]string{appv1.AnnotationJsonnetExtVars: `{"env": "dev"}`})
+	g.Expect(RenderManifestSources(repoRoot, filepath.Join(repoRoot, "app"), sub)).To(gomega.Succeed())
+
+	rendered, err := filepath.Glob(filepath.Join(repoRoot, "app", "main.jsonnet.rendered-*"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(rendered).To(gomega.HaveLen(3))
+
+	config = readTestResource(g, otherFiles[0])
+	g.Expect(nestedValue(unstructured.NestedString(config.Object, "data", "env"))).To(gomega.Equal("dev"))
+}
+
+func TestRenderCue(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	repoRoot, err := ioutil.TempDir("", "cue")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(repoRoot)
+
+	writeTestFiles(g, repoRoot, map[string]string{
+		"cue.mod/module.cue": `module: "example.com/apps"`,
+		"lib/lib.cue": `package lib
+
+#ConfigMap: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: name: string
+	data: [string]: string
+}
+`,
+		"app/main.cue": `package app
+
+environment: *"dev" | string @tag(env)
+replicas:    *1 | int        @tag(replicas,type=int)
+`,
+		"app/objects.cue": `package app
+
+import (
+	"strings"
+	"example.com/apps/lib"
+)
+
+objects: [
+	lib.#ConfigMap & {
+		metadata: name: "app-config"
+		data: env: strings.ToUpper(environment)
+	},
+	{
+		apiVersion: "apps/v1"
+		kind:       "Deployment"
+		metadata: name: "app"
+		spec: "replicas": replicas
+	},
+]
+`,
+	})
+
+	sub := &appv1.Subscription{}
+	sub.SetAnnotations(map[string]string{appv1.AnnotationCueTags: `{"env": "prod", "replicas": 2}`})
+
+	g.Expect(RenderManifestSources(repoRoot, repoRoot, sub)).To(gomega.Succeed())
+
+	_, _, _, _, otherFiles, err := SortResources(repoRoot, repoRoot)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(otherFiles).To(gomega.HaveLen(2))
+
+	config := readTestResource(g, otherFiles[0])
+	g.Expect(config.GetName()).To(gomega.Equal("app-config"))
+	g.Expect(nestedValue(unstructured.NestedString(config.Object, "data", "env"))).To(gomega.Equal("PROD"))
+
+	deployment := readTestResource(g, otherFiles[1])
+	g.Expect(nestedValue(unstructured.NestedInt64(deployment.Object, "spec", "replicas"))).To(gomega.Equal(int64(2)))
+
+	// Without tags the defaults are used
+	g.Expect(RenderManifestSources(repoRoot, repoRoot, &appv1.Subscription{})).To(gomega.Succeed())
+
+	config = readTestResource(g, otherFiles[0])
+	g.Expect(nestedValue(unstructured.NestedString(config.Object, "data", "env"))).To(gomega.Equal("DEV"))
+
+	// Incomplete values fail to render
+	writeTestFiles(g, repoRoot, map[string]string{"app/objects.cue": "package app\n\nobjects: [{apiVersion: \"v1\", kind: \"ConfigMap\", metadata: name: string}]\n"})
+	g.Expect(RenderManifestSources(repoRoot, repoRoot, sub)).NotTo(gomega.Succeed())
+}
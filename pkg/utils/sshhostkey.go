@@ -0,0 +1,390 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	// SSHKnownHosts is the known_hosts bundle used to verify the SSH host key of the Git server
+	SSHKnownHosts = "sshKnownHosts"
+	// SSHHostKeyPolicyStrict rejects Git servers whose host key is not in the known_hosts bundle
+	SSHHostKeyPolicyStrict = "strict"
+	// SSHHostKeyPolicyAcceptNew accepts and remembers the host key of a Git server not in the known_hosts bundle,
+	// but still rejects a host key that does not match a known one
+	SSHHostKeyPolicyAcceptNew = "accept-new"
+
+	// acceptedKnownHostsData is the config map data key of the host keys accepted for a channel
+	acceptedKnownHostsData = "known_hosts"
+	// AnnotationAcceptedKnownHostsChannel is the channel of the config map keeping its accepted host keys
+	AnnotationAcceptedKnownHostsChannel = "apps.open-cluster-management.io/ssh-known-hosts-channel"
+)
+
+// HostKeyMismatchError is returned when a Git server presents an SSH host key different from the known one
+type HostKeyMismatchError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("SSH host key mismatch for %s: the server presented %s key %s which does not match the known_hosts entry",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+}
+
+// IsHostKeyMismatch returns true if the error is caused by an SSH host key mismatch
+func IsHostKeyMismatch(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var mismatchErr *HostKeyMismatchError
+
+	if errors.As(err, &mismatchErr) {
+		return true
+	}
+
+	// go-git flattens the transport errors into strings
+	return strings.Contains(err.Error(), "SSH host key mismatch")
+}
+
+// GetKnownHosts returns the known_hosts bundle and the host key policy from the channel secret and config map. The
+// policy is strict unless the channel config map opts in to the accept-new policy.
+func GetKnownHosts(secret *corev1.Secret, configMap *corev1.ConfigMap) ([]byte, string) {
+	knownHosts := []byte{}
+	policy := SSHHostKeyPolicyStrict
+
+	if configMap != nil {
+		if configMap.Data[appv1.ChannelSSHKnownHostsData] != "" {
+			knownHosts = append(knownHosts, []byte(configMap.Data[appv1.ChannelSSHKnownHostsData])...)
+			knownHosts = append(knownHosts, '\n')
+		}
+
+		if strings.EqualFold(configMap.Data[appv1.ChannelSSHHostKeyPolicy], SSHHostKeyPolicyAcceptNew) {
+			policy = SSHHostKeyPolicyAcceptNew
+		}
+	}
+
+	if secret != nil && len(bytes.TrimSpace(secret.Data[SSHKnownHosts])) > 0 {
+		knownHosts = append(knownHosts, bytes.TrimSpace(secret.Data[SSHKnownHosts])...)
+		knownHosts = append(knownHosts, '\n')
+	}
+
+	return knownHosts, policy
+}
+
+// HostKeyStore keeps the SSH host keys accepted under the accept-new policy
+type HostKeyStore interface {
+	// KnownHosts returns the accepted host keys in the known_hosts format
+	KnownHosts() ([]byte, error)
+	// Add remembers an accepted host key given as a known_hosts line
+	Add(line string) error
+}
+
+// configMapHostKeyStore keeps the host keys accepted for a channel in a config map of the controller namespace, so
+// that they are not shared with the other channels and a changed host key is still detected after a restart
+type configMapHostKeyStore struct {
+	clt     client.Client
+	key     types.NamespacedName
+	channel string
+}
+
+// NewChannelHostKeyStore returns the store of the host keys accepted for the channel
+func NewChannelHostKeyStore(clt client.Client, chn *chnv1.Channel) HostKeyStore {
+	if clt == nil || chn == nil {
+		return nil
+	}
+
+	// the namespace can't have dots, the channel key is unique
+	channel := chn.Namespace + "." + chn.Name
+	sum := sha256.Sum256([]byte(channel))
+
+	ns, err := GetComponentNamespace()
+	if err != nil {
+		klog.Warning("Failed to get the controller namespace, using ", ns, ", err: ", err)
+	}
+
+	return &configMapHostKeyStore{
+		clt:     clt,
+		key:     types.NamespacedName{Name: "ssh-known-hosts-" + hex.EncodeToString(sum[:8]), Namespace: ns},
+		channel: chn.Namespace + "/" + chn.Name,
+	}
+}
+
+func (s *configMapHostKeyStore) KnownHosts() ([]byte, error) {
+	cm := &corev1.ConfigMap{}
+
+	if err := s.clt.Get(context.TODO(), s.key, cm); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return []byte(cm.Data[acceptedKnownHostsData]), nil
+}
+
+func (s *configMapHostKeyStore) Add(line string) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cm := &corev1.ConfigMap{}
+
+		if err := s.clt.Get(context.TODO(), s.key, cm); err != nil {
+			if !kerrors.IsNotFound(err) {
+				return err
+			}
+
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        s.key.Name,
+					Namespace:   s.key.Namespace,
+					Annotations: map[string]string{AnnotationAcceptedKnownHostsChannel: s.channel},
+				},
+				Data: map[string]string{acceptedKnownHostsData: line + "\n"},
+			}
+
+			return s.clt.Create(context.TODO(), cm)
+		}
+
+		for _, known := range strings.Split(cm.Data[acceptedKnownHostsData], "\n") {
+			if known == line {
+				return nil
+			}
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+
+		cm.Data[acceptedKnownHostsData] += line + "\n"
+
+		return s.clt.Update(context.TODO(), cm)
+	})
+}
+
+// withAcceptedHostKeys adds the host keys accepted under the accept-new policy to the known_hosts bundle
+func withAcceptedHostKeys(knownHosts []byte, policy string, store HostKeyStore) ([]byte, error) {
+	if !strings.EqualFold(policy, SSHHostKeyPolicyAcceptNew) || store == nil {
+		return knownHosts, nil
+	}
+
+	accepted, err := store.KnownHosts()
+	if err != nil {
+		klog.Error("failed to get the accepted known_hosts: ", err)
+		return nil, err
+	}
+
+	return append(append([]byte{}, knownHosts...), accepted...), nil
+}
+
+// getSSHHostFromURL returns the host and host:port of a Git SSH URL
+func getSSHHostFromURL(sshURL string) (string, string, error) {
+	if strings.HasPrefix(sshURL, "ssh:") {
+		u, err := url.Parse(sshURL)
+
+		if err != nil {
+			klog.Error("failed to parse SSH URL: ", err)
+			return "", "", err
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "22"
+		}
+
+		return u.Hostname(), net.JoinHostPort(u.Hostname(), port), nil
+	}
+
+	// scp-like syntax user@host:path
+	hostpath := sshURL
+	if strings.Contains(hostpath, "@") {
+		hostpath = strings.SplitN(hostpath, "@", 2)[1]
+	}
+
+	host := strings.Split(hostpath, ":")[0]
+
+	if host == "" {
+		return "", "", errors.New("failed to get the host name from SSH URL " + sshURL)
+	}
+
+	return host, net.JoinHostPort(host, "22"), nil
+}
+
+// loadKnownHosts returns the known_hosts bundle as a HostKeyCallback of the knownhosts package, the bundle is written
+// to the file for the package to read it. It's nil if the bundle is empty.
+func loadKnownHosts(knownHosts []byte, bundleFile string) (ssh.HostKeyCallback, error) {
+	if len(bytes.TrimSpace(knownHosts)) == 0 {
+		return nil, nil
+	}
+
+	if err := ioutil.WriteFile(bundleFile, knownHosts, 0600); err != nil {
+		klog.Error("failed to write known_hosts file: ", err)
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(bundleFile)
+	if err != nil {
+		klog.Error("failed to load known_hosts ", err)
+		return nil, err
+	}
+
+	return callback, nil
+}
+
+// newHostKeyCallback returns a HostKeyCallback that verifies the host key against the known hosts loaded by
+// loadKnownHosts. With the accept-new policy, the host key of an unknown host is accepted and remembered in the store.
+func newHostKeyCallback(known ssh.HostKeyCallback, policy string, store HostKeyStore) (ssh.HostKeyCallback, error) {
+	acceptNew := strings.EqualFold(policy, SSHHostKeyPolicyAcceptNew)
+
+	if known == nil {
+		if !acceptNew {
+			return nil, errors.New("no SSH known_hosts found in the channel secret or config map. " +
+				"Add " + SSHKnownHosts + " to the channel secret or config map, or set " +
+				appv1.ChannelSSHHostKeyPolicy + " to " + SSHHostKeyPolicyAcceptNew)
+		}
+
+		callback, err := knownhosts.New()
+		if err != nil {
+			return nil, err
+		}
+
+		known = callback
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			klog.Errorf("SSH host key mismatch for %s, got %s", hostname, ssh.FingerprintSHA256(key))
+			return &HostKeyMismatchError{Host: hostname, Key: key}
+		}
+
+		if !acceptNew {
+			return fmt.Errorf("SSH host key %s for %s is not in the known_hosts", ssh.FingerprintSHA256(key), hostname)
+		}
+
+		klog.Infof("Accepting new SSH host key %s for %s", ssh.FingerprintSHA256(key), hostname)
+
+		if store == nil {
+			return nil
+		}
+
+		if err := store.Add(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			klog.Error("failed to remember the accepted SSH host key: ", err)
+			return err
+		}
+
+		return nil
+	}, nil
+}
+
+// probeHostKey is a host key of no known type, the known hosts callback returns all the keys known for a host when
+// it's checked with it
+type probeHostKey struct{}
+
+func (probeHostKey) Type() string {
+	return "probe"
+}
+
+func (probeHostKey) Marshal() []byte {
+	return []byte("probe")
+}
+
+func (probeHostKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("probe host key")
+}
+
+// knownHostKeyAlgorithms returns the key types known for the host so that the SSH handshake negotiates
+// a host key that can actually be verified. The host is matched by the knownhosts package, with the wildcard,
+// negated, hashed and [host]:port patterns of OpenSSH.
+func knownHostKeyAlgorithms(known ssh.HostKeyCallback, hostport string) []string {
+	algos := []string{}
+
+	if known == nil {
+		return algos
+	}
+
+	var keyErr *knownhosts.KeyError
+
+	if !errors.As(known(hostport, &net.TCPAddr{IP: net.IPv4zero}, probeHostKey{}), &keyErr) {
+		return algos
+	}
+
+	// the keys in the order of the known_hosts lines
+	sort.Slice(keyErr.Want, func(i, j int) bool { return keyErr.Want[i].Line < keyErr.Want[j].Line })
+
+	for _, want := range keyErr.Want {
+		// an RSA key is verified with the SHA-2 signatures too, which servers without SHA-1 support require
+		if want.Key.Type() == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256)
+		}
+
+		algos = append(algos, want.Key.Type())
+	}
+
+	return algos
+}
+
+// sshPublicKeys restricts the negotiated host key algorithms to the ones in the known_hosts
+type sshPublicKeys struct {
+	*gitssh.PublicKeys
+	hostKeyAlgorithms []string
+}
+
+func (a *sshPublicKeys) ClientConfig() (*ssh.ClientConfig, error) {
+	config, err := a.PublicKeys.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.hostKeyAlgorithms) > 0 {
+		config.HostKeyAlgorithms = a.hostKeyAlgorithms
+	}
+
+	return config, nil
+}
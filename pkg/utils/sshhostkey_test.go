@@ -0,0 +1,262 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	chnv1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func newTestHostKey(g *gomega.GomegaWithT) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	key, err := ssh.NewPublicKey(pub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return key
+}
+
+func TestGetSSHHostFromURL(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	host, hostport, err := getSSHHostFromURL("git@github.com:open-cluster-management/multicloud-operators-subscription.git")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(host).To(gomega.Equal("github.com"))
+	g.Expect(hostport).To(gomega.Equal("github.com:22"))
+
+	host, hostport, err = getSSHHostFromURL("ssh://git@gitlab.example.com:2222/group/repo.git")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(host).To(gomega.Equal("gitlab.example.com"))
+	g.Expect(hostport).To(gomega.Equal("gitlab.example.com:2222"))
+}
+
+func TestGetKnownHosts(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// the policy is strict unless the channel opts in to accept-new
+	knownHosts, policy := GetKnownHosts(nil, nil)
+	g.Expect(knownHosts).To(gomega.BeEmpty())
+	g.Expect(policy).To(gomega.Equal(SSHHostKeyPolicyStrict))
+
+	_, policy = GetKnownHosts(nil, &corev1.ConfigMap{Data: map[string]string{}})
+	g.Expect(policy).To(gomega.Equal(SSHHostKeyPolicyStrict))
+
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			SSHKnownHosts: []byte("gitlab.com ssh-ed25519 BBBB\n"),
+		},
+	}
+
+	_, policy = GetKnownHosts(secret, nil)
+	g.Expect(policy).To(gomega.Equal(SSHHostKeyPolicyStrict))
+
+	_, policy = GetKnownHosts(nil, &corev1.ConfigMap{Data: map[string]string{appv1.ChannelSSHHostKeyPolicy: "strict"}})
+	g.Expect(policy).To(gomega.Equal(SSHHostKeyPolicyStrict))
+
+	cm := &corev1.ConfigMap{
+		Data: map[string]string{
+			appv1.ChannelSSHKnownHostsData: "github.com ssh-ed25519 AAAA",
+			appv1.ChannelSSHHostKeyPolicy:  "accept-new",
+		},
+	}
+
+	knownHosts, policy = GetKnownHosts(secret, cm)
+	g.Expect(string(knownHosts)).To(gomega.Equal("github.com ssh-ed25519 AAAA\ngitlab.com ssh-ed25519 BBBB\n"))
+	g.Expect(policy).To(gomega.Equal(SSHHostKeyPolicyAcceptNew))
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "knownhosts")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	store := &memoryHostKeyStore{}
+
+	knownKey := newTestHostKey(g)
+	otherKey := newTestHostKey(g)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	knownHosts := []byte(knownhosts.Line([]string{"github.com"}, knownKey) + "\n")
+
+	known, err := loadKnownHosts(knownHosts, filepath.Join(dir, "bundle"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// strict: known host with the pinned key
+	callback, err := newHostKeyCallback(known, SSHHostKeyPolicyStrict, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(callback("github.com:22", remote, knownKey)).To(gomega.Succeed())
+
+	// strict: known host with a different key
+	err = callback("github.com:22", remote, otherKey)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(IsHostKeyMismatch(err)).To(gomega.BeTrue())
+
+	// strict: unknown host
+	err = callback("gitlab.com:22", remote, otherKey)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(IsHostKeyMismatch(err)).To(gomega.BeFalse())
+
+	// accept-new: unknown host is accepted and remembered
+	callback, err = newHostKeyCallback(known, SSHHostKeyPolicyAcceptNew, store)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(callback("gitlab.com:22", remote, otherKey)).To(gomega.Succeed())
+
+	// accept-new: known host with a different key is still rejected
+	err = callback("github.com:22", remote, otherKey)
+	g.Expect(IsHostKeyMismatch(err)).To(gomega.BeTrue())
+
+	// accept-new: the remembered key is pinned from now on
+	accepted, err := withAcceptedHostKeys(nil, SSHHostKeyPolicyAcceptNew, store)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	known, err = loadKnownHosts(accepted, filepath.Join(dir, "bundle"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	callback, err = newHostKeyCallback(known, SSHHostKeyPolicyAcceptNew, store)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(callback("gitlab.com:22", remote, otherKey)).To(gomega.Succeed())
+	g.Expect(IsHostKeyMismatch(callback("gitlab.com:22", remote, knownKey))).To(gomega.BeTrue())
+
+	// strict without known_hosts
+	known, err = loadKnownHosts(nil, filepath.Join(dir, "bundle"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(known).To(gomega.BeNil())
+
+	_, err = newHostKeyCallback(known, SSHHostKeyPolicyStrict, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// accept-new without known_hosts
+	callback, err = newHostKeyCallback(known, SSHHostKeyPolicyAcceptNew, &memoryHostKeyStore{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(callback("bitbucket.org:22", remote, otherKey)).To(gomega.Succeed())
+}
+
+func TestKnownHostKeyAlgorithms(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "knownhosts")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	key := newTestHostKey(g)
+	keyLine := string(ssh.MarshalAuthorizedKey(key))
+
+	knownHosts := []byte(knownhosts.Line([]string{"github.com"}, key) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("[gitlab.example.com]:2222")}, key) + "\n" +
+		"*.example.org,!secret.example.org " + keyLine +
+		"[git.example.net]:7999 " + keyLine)
+
+	known, err := loadKnownHosts(knownHosts, filepath.Join(dir, "bundle"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(knownHostKeyAlgorithms(known, "github.com:22")).To(gomega.Equal([]string{ssh.KeyAlgoED25519}))
+	g.Expect(knownHostKeyAlgorithms(known, "gitlab.example.com:2222")).To(gomega.Equal([]string{ssh.KeyAlgoED25519}))
+	g.Expect(knownHostKeyAlgorithms(known, "bitbucket.org:22")).To(gomega.BeEmpty())
+
+	// wildcard, negated and [host]:port patterns
+	g.Expect(knownHostKeyAlgorithms(known, "git.example.org:22")).To(gomega.Equal([]string{ssh.KeyAlgoED25519}))
+	g.Expect(knownHostKeyAlgorithms(known, "secret.example.org:22")).To(gomega.BeEmpty())
+	g.Expect(knownHostKeyAlgorithms(known, "git.example.net:7999")).To(gomega.Equal([]string{ssh.KeyAlgoED25519}))
+	g.Expect(knownHostKeyAlgorithms(known, "git.example.net:22")).To(gomega.BeEmpty())
+	g.Expect(knownHostKeyAlgorithms(nil, "github.com:22")).To(gomega.BeEmpty())
+
+	// RSA keys are negotiated with the SHA-2 signature algorithms too
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	knownHosts = []byte(knownhosts.Line([]string{"github.com"}, rsaPub) + "\n" + knownhosts.Line([]string{"github.com"}, key) + "\n")
+
+	known, err = loadKnownHosts(knownHosts, filepath.Join(dir, "bundle"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(knownHostKeyAlgorithms(known, "github.com:22")).To(gomega.Equal(
+		[]string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA, ssh.KeyAlgoED25519}))
+}
+
+func TestChannelHostKeyStore(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	clt := fake.NewFakeClientWithScheme(scheme.Scheme)
+	chn := &chnv1.Channel{ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "ch-git"}}
+	other := &chnv1.Channel{ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "ch-other"}}
+
+	store := NewChannelHostKeyStore(clt, chn)
+
+	knownHosts, err := store.KnownHosts()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(knownHosts).To(gomega.BeEmpty())
+
+	key := newTestHostKey(g)
+	line := knownhosts.Line([]string{"github.com"}, key)
+
+	g.Expect(store.Add(line)).To(gomega.Succeed())
+	g.Expect(store.Add(line)).To(gomega.Succeed())
+
+	// the accepted keys are kept in a config map, they outlive the controller
+	knownHosts, err = NewChannelHostKeyStore(clt, chn).KnownHosts()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(knownHosts)).To(gomega.Equal(line + "\n"))
+
+	// the accepted keys are not shared with the other channels
+	knownHosts, err = NewChannelHostKeyStore(clt, other).KnownHosts()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(knownHosts).To(gomega.BeEmpty())
+}
+
+// memoryHostKeyStore keeps the accepted host keys in memory for the tests
+type memoryHostKeyStore struct {
+	lines []string
+}
+
+func (s *memoryHostKeyStore) KnownHosts() ([]byte, error) {
+	knownHosts := []byte{}
+
+	for _, line := range s.lines {
+		knownHosts = append(knownHosts, []byte(line+"\n")...)
+	}
+
+	return knownHosts, nil
+}
+
+func (s *memoryHostKeyStore) Add(line string) error {
+	s.lines = append(s.lines, line)
+
+	return nil
+}
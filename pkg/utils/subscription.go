@@ -43,6 +43,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -228,6 +229,10 @@ func IsHubRelatedStatusChanged(old, nnew *appv1.SubscriptionStatus) bool {
 		return true
 	}
 
+	if !reflect.DeepEqual(old.HooksStatus, nnew.HooksStatus) {
+		return true
+	}
+
 	if old.Phase != nnew.Phase || !isSameMessage(old.Message, nnew.Message) {
 		return true
 	}
@@ -578,13 +583,142 @@ func isEqualSubscriptionUnitStatus(a, b *appv1.SubscriptionUnitStatus) bool {
 	}
 
 	if a.Phase != b.Phase || a.Reason != b.Reason ||
-		!reflect.DeepEqual(a.ResourceStatus, b.ResourceStatus) {
+		!reflect.DeepEqual(a.ResourceStatus, b.ResourceStatus) ||
+		!reflect.DeepEqual(a.ChartVerification, b.ChartVerification) ||
+		!reflect.DeepEqual(a.HelmTest, b.HelmTest) || a.ChartVersion != b.ChartVersion {
 		return false
 	}
 
 	return true
 }
 
+// SetInClusterPackageChartVerification records the Helm chart verification results of packages, keyed by package name,
+// in the subscription status. A nil result removes the previous result. A package whose chart is refused fails.
+func SetInClusterPackageChartVerification(statusClient client.Client, subkey types.NamespacedName,
+	results map[string]*appv1.ChartVerificationStatus) error {
+	pkgnames := make([]string, 0, len(results))
+	for pkgname := range results {
+		pkgnames = append(pkgnames, pkgname)
+	}
+
+	return UpdateInClusterPackageStatus(statusClient, subkey, pkgnames, func(pkgname string, pkgstatus *appv1.SubscriptionUnitStatus) bool {
+		result := results[pkgname]
+		if reflect.DeepEqual(pkgstatus.ChartVerification, result) {
+			return false
+		}
+
+		pkgstatus.ChartVerification = result
+
+		if result != nil && result.Refused {
+			pkgstatus.Phase = appv1.SubscriptionFailed
+			pkgstatus.Reason = "chart " + result.Chart + " is refused by the chart verification"
+			pkgstatus.Message = result.Message
+		}
+
+		return true
+	})
+}
+
+// SetInClusterPackageChartVersions records the versions of the Helm charts deployed for packages, keyed by package
+// name, in the subscription status
+func SetInClusterPackageChartVersions(statusClient client.Client, subkey types.NamespacedName, versions map[string]string) error {
+	pkgnames := make([]string, 0, len(versions))
+	for pkgname := range versions {
+		pkgnames = append(pkgnames, pkgname)
+	}
+
+	return UpdateInClusterPackageStatus(statusClient, subkey, pkgnames, func(pkgname string, pkgstatus *appv1.SubscriptionUnitStatus) bool {
+		if pkgstatus.ChartVersion == versions[pkgname] {
+			return false
+		}
+
+		pkgstatus.ChartVersion = versions[pkgname]
+
+		return true
+	})
+}
+
+// SetInClusterPackageHelmTest records the Helm test result of a package in the subscription status. A nil result
+// removes the previous result. A package whose tests failed fails.
+func SetInClusterPackageHelmTest(statusClient client.Client, subkey types.NamespacedName, pkgname string,
+	result *appv1.HelmTestStatus) error {
+	return UpdateInClusterPackageStatus(statusClient, subkey, []string{pkgname}, func(_ string, pkgstatus *appv1.SubscriptionUnitStatus) bool {
+		if reflect.DeepEqual(pkgstatus.HelmTest, result) {
+			return false
+		}
+
+		pkgstatus.HelmTest = result
+
+		if result != nil && result.Result == appv1.HelmTestFailed {
+			pkgstatus.Phase = appv1.SubscriptionFailed
+			pkgstatus.Reason = fmt.Sprintf("helm test of revision %d failed", result.Revision)
+			pkgstatus.Message = result.Message
+		}
+
+		return true
+	})
+}
+
+// UpdateInClusterPackageStatus applies set to the status of each package in the subscription status and updates the
+// status if it is changed. set returns false if it leaves the package status unchanged, a package without status
+// only gets one if set changes it.
+func UpdateInClusterPackageStatus(statusClient client.Client, subkey types.NamespacedName, pkgnames []string,
+	set func(pkgname string, pkgstatus *appv1.SubscriptionUnitStatus) bool) error {
+	return updateInClusterPackageStatuses(statusClient, subkey, func(pkgStatuses map[string]*appv1.SubscriptionUnitStatus) {
+		for _, pkgname := range pkgnames {
+			pkgstatus := pkgStatuses[pkgname]
+			if pkgstatus == nil {
+				pkgstatus = &appv1.SubscriptionUnitStatus{}
+			}
+
+			if !set(pkgname, pkgstatus) {
+				continue
+			}
+
+			pkgstatus.LastUpdateTime = metav1.Now()
+			pkgStatuses[pkgname] = pkgstatus
+		}
+	})
+}
+
+// updateInClusterPackageStatuses applies update to the package statuses of the subscription status and updates the
+// status if it is changed
+func updateInClusterPackageStatuses(statusClient client.Client, subkey types.NamespacedName,
+	update func(pkgStatuses map[string]*appv1.SubscriptionUnitStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		sub := &appv1.Subscription{}
+		if err := statusClient.Get(context.TODO(), subkey, sub); err != nil {
+			return err
+		}
+
+		newStatus := sub.Status.DeepCopy()
+		if newStatus.Statuses == nil {
+			newStatus.Statuses = make(map[string]*appv1.SubscriptionPerClusterStatus)
+		}
+
+		clst := newStatus.Statuses["/"]
+		if clst == nil {
+			clst = &appv1.SubscriptionPerClusterStatus{}
+			newStatus.Statuses["/"] = clst
+		}
+
+		if clst.SubscriptionPackageStatus == nil {
+			clst.SubscriptionPackageStatus = make(map[string]*appv1.SubscriptionUnitStatus)
+		}
+
+		update(clst.SubscriptionPackageStatus)
+
+		if isEqualSubscriptionStatus(&sub.Status, newStatus) {
+			return nil
+		}
+
+		newStatus.DeepCopyInto(&sub.Status)
+		sub.Status.LastUpdateTime = metav1.Now()
+
+		return statusClient.Status().Update(context.TODO(), sub)
+	})
+}
+
 // DeleteInClusterPackageStatus deletes a package status
 func DeleteInClusterPackageStatus(substatus *appv1.SubscriptionStatus, pkgname string, pkgerr error, status interface{}) {
 	if substatus.Statuses != nil {
@@ -1115,3 +1249,52 @@ func AddPartOfLabel(s *appv1.Subscription, m map[string]string) map[string]strin
 
 	return m
 }
+
+// HookRollbackPin is the commit pinned by the rollback hook failure policy, saved in the hook rollback pin annotation
+type HookRollbackPin struct {
+	// Commit is the desired commit the subscription is rolled back to
+	Commit string `json:"commit"`
+	// FailedCommit is the commit of which the hooks failed
+	FailedCommit string `json:"failedCommit,omitempty"`
+	// Previous is the desired commit before the rollback
+	Previous string `json:"previous,omitempty"`
+}
+
+// GetHookRollbackPin returns the rollback pin of the subscription annotations, nil is returned if there's none
+func GetHookRollbackPin(annotations map[string]string) *HookRollbackPin {
+	pinJSON := annotations[appv1.AnnotationHookRollbackPin]
+	if pinJSON == "" {
+		return nil
+	}
+
+	pin := &HookRollbackPin{}
+	if err := json.Unmarshal([]byte(pinJSON), pin); err != nil {
+		klog.Info("Failed to parse the hook rollback pin annotation. error: ", err)
+		return &HookRollbackPin{}
+	}
+
+	return pin
+}
+
+// RemoveHookRollbackPin removes the rollback pin of the subscription annotations. The previous desired commit is
+// restored, unless the desired commit was changed since the rollback. It returns false if there's no rollback pin.
+func RemoveHookRollbackPin(annotations map[string]string) bool {
+	pin := GetHookRollbackPin(annotations)
+	if pin == nil {
+		return false
+	}
+
+	delete(annotations, appv1.AnnotationHookRollbackPin)
+
+	if pin.Commit == "" || annotations[appv1.AnnotationGitTargetCommit] != pin.Commit {
+		return true
+	}
+
+	if pin.Previous == "" {
+		delete(annotations, appv1.AnnotationGitTargetCommit)
+	} else {
+		annotations[appv1.AnnotationGitTargetCommit] = pin.Previous
+	}
+
+	return true
+}
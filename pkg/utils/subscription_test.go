@@ -727,3 +727,43 @@ func TestSetPartOfLabel(t *testing.T) {
 	g.Expect(labels).NotTo(gomega.BeNil())
 	g.Expect(labels["app.kubernetes.io/part-of"]).To(gomega.Equal("testApp"))
 }
+
+func TestRemoveHookRollbackPin(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(RemoveHookRollbackPin(map[string]string{})).To(gomega.BeFalse())
+
+	pin, err := json.Marshal(HookRollbackPin{Commit: "good", FailedCommit: "bad", Previous: "pinned"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the previous desired commit is restored
+	annotations := map[string]string{
+		appv1.AnnotationHookRollbackPin: string(pin),
+		appv1.AnnotationGitTargetCommit: "good",
+	}
+
+	g.Expect(GetHookRollbackPin(annotations)).To(gomega.Equal(&HookRollbackPin{Commit: "good", FailedCommit: "bad", Previous: "pinned"}))
+	g.Expect(RemoveHookRollbackPin(annotations)).To(gomega.BeTrue())
+	g.Expect(annotations).To(gomega.Equal(map[string]string{appv1.AnnotationGitTargetCommit: "pinned"}))
+
+	// the desired commit changed since the rollback is kept
+	annotations = map[string]string{
+		appv1.AnnotationHookRollbackPin: string(pin),
+		appv1.AnnotationGitTargetCommit: "other",
+	}
+
+	g.Expect(RemoveHookRollbackPin(annotations)).To(gomega.BeTrue())
+	g.Expect(annotations).To(gomega.Equal(map[string]string{appv1.AnnotationGitTargetCommit: "other"}))
+
+	// without previous desired commit, the subscription follows its branch again
+	pin, err = json.Marshal(HookRollbackPin{Commit: "good", FailedCommit: "bad"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	annotations = map[string]string{
+		appv1.AnnotationHookRollbackPin: string(pin),
+		appv1.AnnotationGitTargetCommit: "good",
+	}
+
+	g.Expect(RemoveHookRollbackPin(annotations)).To(gomega.BeTrue())
+	g.Expect(annotations).To(gomega.BeEmpty())
+}
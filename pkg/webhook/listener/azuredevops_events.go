@@ -0,0 +1,137 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+)
+
+const (
+	// AzureDevOpsActivityHeader is sent with Azure DevOps service hook requests
+	AzureDevOpsActivityHeader = "X-Vss-Activityid"
+	azureDevOpsUserAgent      = "VSServices/"
+	AzureDevOpsPushEvent      = "git.push"
+)
+
+// AzureDevOpsPayload is the payload of Azure DevOps service hook events
+type AzureDevOpsPayload struct {
+	// ID is the ID of the event, the same in the retries of the event
+	ID        string `json:"id"`
+	EventType string `json:"eventType"`
+	Resource  struct {
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			OldObjectID string `json:"oldObjectId"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		Repository AzureDevOpsRepository `json:"repository"`
+	} `json:"resource"`
+}
+
+type AzureDevOpsRepository struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	RemoteURL string `json:"remoteUrl"`
+	SSHURL    string `json:"sshUrl"`
+	WebURL    string `json:"webUrl"`
+}
+
+// change returns the change of a push event. Azure DevOps does not list the changed files.
+func (p AzureDevOpsPayload) change() gitChange {
+	change := gitChange{}
+
+	for _, ref := range p.Resource.RefUpdates {
+		change.refs = append(change.refs, ref.Name)
+	}
+
+	return change
+}
+
+// azureDevOpsProvider handles the push events of Azure DevOps service hooks
+type azureDevOpsProvider struct{}
+
+func (azureDevOpsProvider) Name() string {
+	return "Azure DevOps"
+}
+
+func (azureDevOpsProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeGit
+}
+
+// Matches returns true for Azure DevOps requests, which do not have an event header
+func (azureDevOpsProvider) Matches(r *http.Request) bool {
+	return r.Header.Get(AzureDevOpsActivityHeader) != "" || strings.HasPrefix(r.UserAgent(), azureDevOpsUserAgent)
+}
+
+func (azureDevOpsProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(body) == 0 {
+		klog.Error("Failed to parse the payload: ", err)
+		return nil, errors.New("failed to parse the payload")
+	}
+
+	var payload AzureDevOpsPayload
+	err = json.Unmarshal(body, &payload)
+
+	if err != nil {
+		klog.Error("Failed to parse the webhook event payload. error: ", err)
+		return nil, err
+	}
+
+	klog.Info("Handling Azure DevOps webhook event: " + payload.EventType)
+
+	if !strings.EqualFold(payload.EventType, AzureDevOpsPushEvent) {
+		klog.Infof("Unhandled webhook event %s\n", payload.EventType)
+		return nil, nil
+	}
+
+	// The service hook sends the webhook secret as the basic authentication password
+	_, password, _ := r.BasicAuth()
+
+	return &WebhookEvent{
+		Type:       payload.EventType,
+		Repo:       payload.Resource.Repository.RemoteURL,
+		Body:       body,
+		Signature:  password,
+		DeliveryID: payload.ID,
+		Payload:    payload,
+		change:     payload.change(),
+	}, nil
+}
+
+func (azureDevOpsProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(AzureDevOpsPayload)
+	if !ok {
+		return false
+	}
+
+	return sameRepoURL(pathname, payload.Resource.Repository.RemoteURL) ||
+		sameRepoURL(pathname, payload.Resource.Repository.WebURL) ||
+		sameRepoURL(pathname, payload.Resource.Repository.SSHURL)
+}
+
+// ValidateSecret compares the basic authentication password with the channel's webhook secret
+func (azureDevOpsProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(event.Signature), []byte(secret)) == 1
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+const azureDevOpsPushPayload = `{
+  "subscriptionId": "00000000-0000-0000-0000-000000000000",
+  "notificationId": 1,
+  "id": "03c164c2-8912-4d5e-8009-3707d5f83734",
+  "eventType": "git.push",
+  "publisherId": "tfs",
+  "message": {"text": "Joe pushed updates to example:main."},
+  "resource": {
+    "commits": [
+      {
+        "commitId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74",
+        "comment": "Update the frontend",
+        "url": "https://dev.azure.com/example/_git/apps/commit/33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+      }
+    ],
+    "refUpdates": [
+      {
+        "name": "refs/heads/main",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+      }
+    ],
+    "repository": {
+      "id": "278d5cd2-584d-4b63-824a-2ba458937249",
+      "name": "apps",
+      "url": "https://dev.azure.com/example/_apis/git/repositories/278d5cd2-584d-4b63-824a-2ba458937249",
+      "project": {"name": "platform"},
+      "defaultBranch": "refs/heads/main",
+      "remoteUrl": "https://example@dev.azure.com/example/platform/_git/apps",
+      "sshUrl": "git@ssh.dev.azure.com:v3/example/platform/apps",
+      "webUrl": "https://dev.azure.com/example/platform/_git/apps"
+    },
+    "pushedBy": {"displayName": "Joe"},
+    "pushId": 14
+  },
+  "resourceVersion": "1.0"
+}`
+
+func TestAzureDevOpsProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBufferString(azureDevOpsPushPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("User-Agent", "VSServices/16.181.31225.1")
+	req.SetBasicAuth("subscription", "azure-secret")
+
+	provider := getWebhookProvider(req)
+	g.Expect(provider).To(gomega.Equal(azureDevOpsProvider{}))
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.Type).To(gomega.Equal(AzureDevOpsPushEvent))
+	g.Expect(event.change.(gitChange).refs).To(gomega.Equal([]string{"refs/heads/main"}))
+	g.Expect(event.change.(gitChange).files).To(gomega.BeNil())
+
+	g.Expect(provider.MatchesRepo(event, "https://dev.azure.com/example/platform/_git/apps")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://example@dev.azure.com/example/platform/_git/apps")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://dev.azure.com/example/platform/_git/apps-config")).To(gomega.BeFalse())
+
+	g.Expect(provider.ValidateSecret(event, "azure-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "other-secret")).To(gomega.BeFalse())
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	// Other events do not trigger subscriptions
+	req, err = http.NewRequest("POST", "/webhook", bytes.NewBufferString(`{"eventType": "git.pullrequest.created"}`))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set(AzureDevOpsActivityHeader, "4b6a1f53-9f24-4a7a-9a4c-7d1e1e1c6f3b")
+
+	g.Expect(getWebhookProvider(req)).To(gomega.Equal(azureDevOpsProvider{}))
+
+	event, err = provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event).To(gomega.BeNil())
+}
@@ -15,28 +15,77 @@
 package listener
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
-	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 )
 
 const (
-	RepoPushEvent          = "repo:push"
-	PullRequestMergedEvent = "pullrequest:fulfilled"
+	RepoPushEvent           = "repo:push"
+	PullRequestMergedEvent  = "pullrequest:fulfilled"
+	bitbucketDeliveryHeader = "X-Request-UUID"
 )
 
 type BitBucketPayload struct {
 	Repository BitBucketRepository `json:"repository"`
+	// Push is set in push events
+	Push struct {
+		Changes []struct {
+			New *BitBucketRef `json:"new"`
+			Old *BitBucketRef `json:"old"`
+		} `json:"changes"`
+	} `json:"push"`
+	// PullRequest is set in pull request events
+	PullRequest struct {
+		Destination struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"destination"`
+	} `json:"pullrequest"`
+}
+
+// BitBucketRef is a branch or tag of a push event
+type BitBucketRef struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// change returns the change of a push or pull request event. Bitbucket does not list the changed files.
+func (p BitBucketPayload) change(event string) gitChange {
+	change := gitChange{}
+
+	if strings.EqualFold(event, PullRequestMergedEvent) {
+		change.refs = []string{branchRef(p.PullRequest.Destination.Branch.Name)}
+
+		return change
+	}
+
+	for _, c := range p.Push.Changes {
+		// The new ref is not set for deleted branches and tags
+		ref := c.New
+		if ref == nil {
+			ref = c.Old
+		}
+
+		if ref == nil {
+			continue
+		}
+
+		if ref.Type == "tag" {
+			change.refs = append(change.refs, tagRefPrefix+ref.Name)
+		} else {
+			change.refs = append(change.refs, branchRef(ref.Name))
+		}
+	}
+
+	return change
 }
 
 type BitBucketRepository struct {
@@ -57,7 +106,22 @@ type BitBucketRepository struct {
 	Website  string `json:"website"`
 }
 
-func (listener *WebhookListener) handleBitbucketWebhook(r *http.Request) error {
+// bitbucketProvider handles the push and pull request merged events of Bitbucket repositories
+type bitbucketProvider struct{}
+
+func (bitbucketProvider) Name() string {
+	return "BitBucket"
+}
+
+func (bitbucketProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeGit
+}
+
+func (bitbucketProvider) Matches(r *http.Request) bool {
+	return r.Header.Get(BitbucketEventHeader) != ""
+}
+
+func (bitbucketProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
 	event := r.Header.Get(BitbucketEventHeader) // has to have value. webhook_listner ensures.
 
 	klog.Info("Handling BitBucket webhook event: " + event)
@@ -65,7 +129,7 @@ func (listener *WebhookListener) handleBitbucketWebhook(r *http.Request) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil || len(body) == 0 {
 		klog.Error("Failed to parse the payload: ", err)
-		return errors.New("failed to parse the payload")
+		return nil, errors.New("failed to parse the payload")
 	}
 
 	var payload BitBucketPayload
@@ -73,69 +137,37 @@ func (listener *WebhookListener) handleBitbucketWebhook(r *http.Request) error {
 
 	if err != nil {
 		klog.Error("Failed to parse the webhook event payload. error: ", err)
-		return err
-	}
-
-	subList := &appv1alpha1.SubscriptionList{}
-	listopts := &client.ListOptions{}
-
-	err = listener.LocalClient.List(context.TODO(), subList, listopts)
-	if err != nil {
-		klog.Error("Failed to get subscriptions. error: ", err)
-		return err
+		return nil, err
 	}
 
-	if strings.EqualFold(event, RepoPushEvent) || strings.EqualFold(event, PullRequestMergedEvent) { // process only push or PR merge events
-		// Loop through all subscriptions
-		for _, sub := range subList.Items {
-			if !listener.processBitbucketEvent(sub, event, payload) {
-				continue
-			}
-		}
-	} else {
+	// process only push or PR merge events
+	if !strings.EqualFold(event, RepoPushEvent) && !strings.EqualFold(event, PullRequestMergedEvent) {
 		klog.Infof("Unhandled webhook event %s\n", event)
-		return nil
+		return nil, nil
 	}
 
-	return nil
+	return &WebhookEvent{
+		Type:       event,
+		Repo:       payload.Repository.FullName,
+		Body:       body,
+		DeliveryID: r.Header.Get(bitbucketDeliveryHeader),
+		Payload:    payload,
+		change:     payload.change(event),
+	}, nil
 }
 
-func (listener *WebhookListener) processBitbucketEvent(sub appv1alpha1.Subscription, event string, payload BitBucketPayload) bool {
-	klog.V(2).Info("Evaluating subscription: " + sub.GetName())
-
-	chNamespace := ""
-	chName := ""
-
-	if sub.Spec.Channel != "" {
-		strs := strings.Split(sub.Spec.Channel, "/")
-		if len(strs) == 2 {
-			chNamespace = strs[0]
-			chName = strs[1]
-		} else {
-			klog.Error("Failed to get channel namespace and name.")
-			return false
-		}
-	}
-
-	chkey := types.NamespacedName{Name: chName, Namespace: chNamespace}
-	chobj := &chnv1alpha1.Channel{}
-	err := listener.RemoteClient.Get(context.TODO(), chkey, chobj)
-
-	if err != nil {
-		klog.Error("Failed to get subscription's channel. error: ", err)
-		return false
-	}
-
-	if !listener.validateChannel(chobj, "", chNamespace, []byte("")) {
+func (bitbucketProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(BitBucketPayload)
+	if !ok {
 		return false
 	}
 
-	if chobj.Spec.Pathname == payload.Repository.FullName ||
-		chobj.Spec.Pathname == payload.Repository.Links.HTML.Href ||
-		strings.Contains(chobj.Spec.Pathname, payload.Repository.Links.HTML.Href) {
-		klog.Infof("Processing %s event from %s repository for subscription %s", event, payload.Repository.FullName, sub.Name)
-		listener.updateSubscription(sub)
-	}
+	return pathname == payload.Repository.FullName ||
+		pathname == payload.Repository.Links.HTML.Href ||
+		strings.Contains(pathname, payload.Repository.Links.HTML.Href)
+}
 
+// ValidateSecret accepts all events because Bitbucket does not send a secret
+func (bitbucketProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
 	return true
 }
@@ -0,0 +1,161 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// certReloadInterval is the interval of the checks for new key and cert files and for expiring certificates
+	certReloadInterval = time.Minute
+	// selfSignedCertRenewBefore is how long before it expires the generated self-signed certificate is renewed
+	selfSignedCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// certReloader serves the certificate of the TLS key and cert files, reloading it when the files change. It renews
+// the generated self-signed certificate before it expires.
+type certReloader struct {
+	keyFile string
+	crtFile string
+	// renew generates a new self-signed certificate in the files. It is nil when the files are provided.
+	renew       func() error
+	renewBefore time.Duration
+
+	mu      sync.RWMutex
+	keyPEM  []byte
+	crtPEM  []byte
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+func newCertReloader(keyFile, crtFile string, renew func() error) (*certReloader, error) {
+	c := &certReloader{
+		keyFile:     keyFile,
+		crtFile:     crtFile,
+		renew:       renew,
+		renewBefore: selfSignedCertRenewBefore,
+	}
+
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate returns the current certificate for the TLS handshakes
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// reload loads the certificate if the key or cert file changed. It returns true if the certificate was reloaded.
+func (c *certReloader) reload() (bool, error) {
+	keyPEM, err := ioutil.ReadFile(filepath.Clean(c.keyFile))
+	if err != nil {
+		return false, err
+	}
+
+	crtPEM, err := ioutil.ReadFile(filepath.Clean(c.crtFile))
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := bytes.Equal(keyPEM, c.keyPEM) && bytes.Equal(crtPEM, c.crtPEM)
+	c.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	// The files can be read while they are being written, the next check loads them
+	cert, err := tls.X509KeyPair(crtPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.keyPEM = keyPEM
+	c.crtPEM = crtPEM
+	c.cert = &cert
+	c.expires = leaf.NotAfter
+	c.mu.Unlock()
+
+	klog.Infof("Loaded the webhook listener certificate %s, it expires at %s", c.crtFile, leaf.NotAfter)
+
+	return true, nil
+}
+
+// renewIfExpiring renews the self-signed certificate if it expires within the renew period. It returns true if the
+// certificate was renewed.
+func (c *certReloader) renewIfExpiring() (bool, error) {
+	if c.renew == nil {
+		return false, nil
+	}
+
+	c.mu.RLock()
+	expires := c.expires
+	c.mu.RUnlock()
+
+	if time.Until(expires) > c.renewBefore {
+		return false, nil
+	}
+
+	klog.Infof("Renewing the self-signed webhook listener certificate, it expires at %s", expires)
+
+	if err := c.renew(); err != nil {
+		return false, err
+	}
+
+	return c.reload()
+}
+
+// run checks the files and the certificate expiration at every interval until the stop channel is closed
+func (c *certReloader) run(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := c.renewIfExpiring(); err != nil {
+				klog.Error("Failed to renew the self-signed webhook listener certificate. error: ", err)
+			}
+
+			if _, err := c.reload(); err != nil {
+				klog.Error("Failed to reload the webhook listener certificate. error: ", err)
+			}
+		}
+	}
+}
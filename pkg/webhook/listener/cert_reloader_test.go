@@ -0,0 +1,167 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+func TestCertReloader(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "certreloader")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "tls.key")
+	crtFile := filepath.Join(dir, "tls.crt")
+
+	g.Expect(utils.GenerateServerCertsWithValidity(dir, time.Hour)).To(gomega.Succeed())
+
+	reloader, err := newCertReloader(keyFile, crtFile, func() error { return utils.GenerateServerCerts(dir) })
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cert, err := reloader.GetCertificate(nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(cert).NotTo(gomega.BeNil())
+
+	// The files did not change
+	reloaded, err := reloader.reload()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(reloaded).To(gomega.BeFalse())
+
+	// The self-signed certificate expiring within the renew period is renewed
+	renewed, err := reloader.renewIfExpiring()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(renewed).To(gomega.BeTrue())
+	g.Expect(reloader.expires).To(gomega.BeTemporally(">", time.Now().Add(365*24*time.Hour)))
+
+	renewedCert, err := reloader.GetCertificate(nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(renewedCert.Certificate[0]).NotTo(gomega.Equal(cert.Certificate[0]))
+
+	renewed, err = reloader.renewIfExpiring()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(renewed).To(gomega.BeFalse())
+
+	// Provided certificates are reloaded but never renewed
+	g.Expect(utils.GenerateServerCertsWithValidity(dir, time.Hour)).To(gomega.Succeed())
+
+	reloader.renew = nil
+
+	reloaded, err = reloader.reload()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(reloaded).To(gomega.BeTrue())
+
+	renewed, err = reloader.renewIfExpiring()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(renewed).To(gomega.BeFalse())
+
+	// An invalid key keeps the current certificate
+	currentCert, _ := reloader.GetCertificate(nil)
+
+	g.Expect(ioutil.WriteFile(keyFile, []byte("invalid"), 0600)).To(gomega.Succeed())
+
+	_, err = reloader.reload()
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	cert, _ = reloader.GetCertificate(nil)
+	g.Expect(cert).To(gomega.BeIdenticalTo(currentCert))
+}
+
+// startListener serves the listener on a local port until the returned stop function is called. The stop function
+// returns the error of serve.
+func startListener(g *gomega.GomegaWithT, listener *WebhookListener) (string, func() error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+
+	go func() { errc <- listener.serve(ln, stop) }()
+
+	return ln.Addr().String(), func() error {
+		close(stop)
+
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(5 * time.Second):
+			return os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func TestWebhookListenerServe(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	addr, stop := startListener(g, &WebhookListener{})
+
+	resp, err := http.Get("http://" + addr + deliveryHistoryPath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusUnauthorized))
+
+	g.Expect(stop()).To(gomega.Succeed())
+
+	_, err = http.Get("http://" + addr + deliveryHistoryPath)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestWebhookListenerServeTLS(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "listenertls")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(dir)
+
+	g.Expect(utils.GenerateServerCerts(dir)).To(gomega.Succeed())
+
+	listener := &WebhookListener{TLSKeyFile: filepath.Join(dir, "tls.key"), TLSCrtFile: filepath.Join(dir, "tls.crt")}
+	addr, stop := startListener(g, listener)
+
+	client := &http.Client{Transport: &http.Transport{
+		// #nosec G402 the test server certificate is self-signed
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	resp, err := client.Get("https://" + addr + deliveryHistoryPath)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusUnauthorized))
+	g.Expect(resp.TLS.PeerCertificates).NotTo(gomega.BeEmpty())
+
+	g.Expect(stop()).To(gomega.Succeed())
+
+	// Missing key and cert files fail the listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	listener.TLSKeyFile = filepath.Join(dir, "missing.key")
+	g.Expect(listener.serve(ln, make(chan struct{}))).NotTo(gomega.Succeed())
+}
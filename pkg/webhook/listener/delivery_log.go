@@ -0,0 +1,416 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	deliveryHistoryPath = "/deliveries"
+	// deliveryIDTTL is how long delivery IDs are remembered to drop the retries of a delivery
+	deliveryIDTTL = time.Hour
+	// maxRateLimiters bounds the number of source IPs and repositories with a rate limiter
+	maxRateLimiters = 10000
+
+	DeliveryAccepted        = "Accepted"
+	DeliveryDuplicate       = "Duplicate"
+	DeliveryRateLimited     = "RateLimited"
+	DeliveryIgnored         = "Ignored"
+	DeliveryUnsupported     = "Unsupported"
+	DeliveryUnauthenticated = "Unauthenticated"
+	DeliveryFailed          = "Failed"
+
+	SubscriptionTriggered     = "Triggered"
+	SubscriptionCoalesced     = "Coalesced"
+	SubscriptionSecretInvalid = "SecretInvalid"
+	SubscriptionSkipped       = "Skipped"
+)
+
+// DeliveryOptions configure the deduplication, coalescing, rate limiting and history of webhook deliveries
+type DeliveryOptions struct {
+	// CoalesceWindow is the minimum time between two syncs of a subscription triggered by webhook deliveries. The
+	// deliveries received within the window trigger a single sync at the end of the window. Zero disables coalescing.
+	CoalesceWindow time.Duration
+	// RateLimit is the number of deliveries per minute accepted from a source IP and for a repository. Zero disables
+	// rate limiting.
+	RateLimit int
+	// HistorySize is the number of deliveries kept in the delivery history
+	HistorySize int
+	// TrustedProxies are the networks of the proxies, like the ingress controller or the router, whose
+	// X-Forwarded-For header gives the source IP of the deliveries
+	TrustedProxies []*net.IPNet
+}
+
+// DefaultDeliveryOptions are the delivery options of listeners created with CreateWebhookListener
+var DefaultDeliveryOptions = DeliveryOptions{
+	CoalesceWindow: 10 * time.Second,
+	RateLimit:      120,
+	HistorySize:    100,
+}
+
+// Delivery is a webhook delivery in the delivery history
+type Delivery struct {
+	Time       metav1.Time `json:"time"`
+	DeliveryID string      `json:"deliveryID,omitempty"`
+	Provider   string      `json:"provider,omitempty"`
+	Event      string      `json:"event,omitempty"`
+	Repo       string      `json:"repo,omitempty"`
+	Refs       []string    `json:"refs,omitempty"`
+	SourceIP   string      `json:"sourceIP,omitempty"`
+	// Result is Accepted, Duplicate, RateLimited, Ignored, Unsupported, Unauthenticated or Failed
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+	// Subscriptions are the subscriptions of the channels of the repository
+	Subscriptions []DeliverySubscription `json:"subscriptions,omitempty"`
+}
+
+// DeliverySubscription is a subscription of the channel of a delivery
+type DeliverySubscription struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Result is Triggered, Coalesced, SecretInvalid or Skipped
+	Result string `json:"result"`
+}
+
+// coalesceState is the last sync of a subscription triggered by a delivery
+type coalesceState struct {
+	last    time.Time
+	pending bool
+}
+
+// rateLimiter is the rate limiter of a source IP or repository
+type rateLimiter struct {
+	limiter *rate.Limiter
+	last    time.Time
+}
+
+// deliveryLog dedupes, coalesces, rate limits and records webhook deliveries. A nil log does none of them.
+type deliveryLog struct {
+	opts DeliveryOptions
+
+	mu       sync.Mutex
+	history  []Delivery
+	seen     map[string]time.Time
+	limiters map[string]*rateLimiter
+	syncs    map[types.NamespacedName]*coalesceState
+}
+
+func newDeliveryLog(opts DeliveryOptions) *deliveryLog {
+	return &deliveryLog{
+		opts:     opts,
+		seen:     make(map[string]time.Time),
+		limiters: make(map[string]*rateLimiter),
+		syncs:    make(map[types.NamespacedName]*coalesceState),
+	}
+}
+
+// record adds the delivery to the history, dropping the oldest deliveries
+func (l *deliveryLog) record(delivery Delivery) {
+	if l == nil || l.opts.HistorySize <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.history = append(l.history, delivery)
+
+	if len(l.history) > l.opts.HistorySize {
+		l.history = l.history[len(l.history)-l.opts.HistorySize:]
+	}
+}
+
+// deliveries returns the history, the latest delivery first
+func (l *deliveryLog) deliveries() []Delivery {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deliveries := make([]Delivery, 0, len(l.history))
+
+	for i := len(l.history) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, l.history[i])
+	}
+
+	return deliveries
+}
+
+// duplicate returns true if the delivery ID of the provider was received for the repository within the delivery ID
+// TTL. Only the authenticated deliveries are checked, so that a forged delivery can not suppress a genuine one.
+func (l *deliveryLog) duplicate(provider, repo, deliveryID string) bool {
+	if l == nil || deliveryID == "" {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for id, received := range l.seen {
+		if now.Sub(received) > deliveryIDTTL {
+			delete(l.seen, id)
+		}
+	}
+
+	key := provider + "/" + repo + "/" + deliveryID
+
+	if _, ok := l.seen[key]; ok {
+		return true
+	}
+
+	l.seen[key] = now
+
+	return false
+}
+
+// allow returns false if the source IP or repository key exceeded the rate limit. The limiters idle for the time it
+// takes to refill them are dropped, since a new limiter is the same. New keys are refused while all the limiters are
+// in use.
+func (l *deliveryLog) allow(key string) bool {
+	if l == nil || l.opts.RateLimit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= maxRateLimiters {
+			for k, idle := range l.limiters {
+				if now.Sub(idle.last) >= time.Minute {
+					delete(l.limiters, k)
+				}
+			}
+		}
+
+		if len(l.limiters) >= maxRateLimiters {
+			klog.Warning("Too many webhook delivery sources, refusing the deliveries of ", key)
+			return false
+		}
+
+		limiter = &rateLimiter{limiter: rate.NewLimiter(rate.Limit(float64(l.opts.RateLimit)/60), l.opts.RateLimit)}
+		l.limiters[key] = limiter
+	}
+
+	limiter.last = now
+
+	return limiter.limiter.AllowN(now, 1)
+}
+
+// coalesce returns false if the subscription was not synced within the coalesce window, and the caller syncs it now.
+// Otherwise, it returns true and a single trailing sync runs at the end of the window.
+func (l *deliveryLog) coalesce(key types.NamespacedName, trailing func()) bool {
+	if l == nil || l.opts.CoalesceWindow <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for k, state := range l.syncs {
+		if !state.pending && now.Sub(state.last) >= l.opts.CoalesceWindow {
+			delete(l.syncs, k)
+		}
+	}
+
+	state, ok := l.syncs[key]
+	if !ok {
+		l.syncs[key] = &coalesceState{last: now}
+		return false
+	}
+
+	if !state.pending {
+		state.pending = true
+
+		time.AfterFunc(state.last.Add(l.opts.CoalesceWindow).Sub(now), func() {
+			l.mu.Lock()
+			state.pending = false
+			state.last = time.Now()
+			l.mu.Unlock()
+
+			trailing()
+		})
+	}
+
+	return true
+}
+
+// sourceIP returns the IP address of the client of the request. When the request comes from a trusted proxy, it is
+// the last address of the X-Forwarded-For header that is not a trusted proxy.
+func (l *deliveryLog) sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if l == nil || !l.trustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+
+		if net.ParseIP(addr) == nil {
+			return host
+		}
+
+		if !l.trustedProxy(addr) {
+			return addr
+		}
+	}
+
+	return host
+}
+
+func (l *deliveryLog) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l.opts.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HandleDeliveries returns the delivery history as JSON. The provider, repo and subscription query parameters filter
+// the deliveries. The user of the bearer token must be able to list the subscriptions of all namespaces.
+func (listener *WebhookListener) HandleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := listener.authorizeRequest(r, types.NamespacedName{}, "list"); err != nil {
+		klog.Info("Delivery history request failed. error: ", err)
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	query := r.URL.Query()
+	deliveries := []Delivery{}
+
+	for _, delivery := range listener.deliveries.deliveries() {
+		if matchesDeliveryQuery(delivery, query.Get("provider"), query.Get("repo"), query.Get("subscription")) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	body, err := json.Marshal(deliveries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(body); err != nil {
+		klog.Error(err.Error())
+	}
+}
+
+// matchesDeliveryQuery returns true if the delivery has the provider, repo and subscription of the query. The
+// subscription is <namespace>/<name>.
+func matchesDeliveryQuery(delivery Delivery, provider, repo, subscription string) bool {
+	if provider != "" && !strings.EqualFold(delivery.Provider, provider) {
+		return false
+	}
+
+	if repo != "" && !strings.Contains(delivery.Repo, repo) {
+		return false
+	}
+
+	if subscription == "" {
+		return true
+	}
+
+	for _, sub := range delivery.Subscriptions {
+		if sub.Namespace+"/"+sub.Name == subscription {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changeRefs returns the changed branches and tags, chart or objects of the change for the delivery history
+func changeRefs(change subscriptionFilter) []string {
+	switch c := change.(type) {
+	case gitChange:
+		return c.refs
+	case chartChange:
+		if c.chart != "" {
+			return []string{c.chart}
+		}
+	case bucketChange:
+		return c.keys
+	}
+
+	return nil
+}
+
+// recordDeliveryEvent records the result of the delivery as an event of the subscription
+func (listener *WebhookListener) recordDeliveryEvent(sub *appv1alpha1.Subscription, provider WebhookProvider,
+	event *WebhookEvent, result string) {
+	if listener.EventRecorder == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("%s %s event from %s: %s", provider.Name(), event.Type, event.Repo, result)
+	if event.DeliveryID != "" {
+		msg = fmt.Sprintf("%s %s event %s from %s: %s", provider.Name(), event.Type, event.DeliveryID, event.Repo, result)
+	}
+
+	var err error
+	if result == SubscriptionSecretInvalid {
+		err = errors.New("the webhook secret of the channel does not match")
+	}
+
+	listener.EventRecorder.RecordEvent(sub, "WebhookDelivery", msg, err)
+}
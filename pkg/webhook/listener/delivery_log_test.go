@@ -0,0 +1,296 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" // #nosec G505 GitHub signs the X-Hub-Signature header with SHA1
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+const deliveryPushPayload = `{
+  "ref": "refs/heads/master",
+  "repository": {"full_name": "example/apps", "html_url": "https://github.com/example/apps"}
+}`
+
+func TestDeliveryLog(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	l := newDeliveryLog(DeliveryOptions{CoalesceWindow: 200 * time.Millisecond, RateLimit: 2, HistorySize: 2})
+
+	// Retries of a delivery are duplicates, deliveries without ID never are
+	g.Expect(l.duplicate("GitHub", "example/apps", "1")).To(gomega.BeFalse())
+	g.Expect(l.duplicate("GitHub", "example/apps", "1")).To(gomega.BeTrue())
+	g.Expect(l.duplicate("GitLab", "example/apps", "1")).To(gomega.BeFalse())
+	g.Expect(l.duplicate("GitHub", "example/apps", "")).To(gomega.BeFalse())
+	g.Expect(l.duplicate("GitHub", "example/apps", "")).To(gomega.BeFalse())
+
+	// The rate limit applies to each source IP and repository
+	g.Expect(l.allow("ip/192.0.2.1")).To(gomega.BeTrue())
+	g.Expect(l.allow("ip/192.0.2.1")).To(gomega.BeTrue())
+	g.Expect(l.allow("ip/192.0.2.1")).To(gomega.BeFalse())
+	g.Expect(l.allow("ip/192.0.2.2")).To(gomega.BeTrue())
+
+	// The same delivery ID of another repository is not a duplicate
+	g.Expect(l.duplicate("GitHub", "example/other", "1")).To(gomega.BeFalse())
+
+	// The history keeps the latest deliveries
+	l.record(Delivery{DeliveryID: "1"})
+	l.record(Delivery{DeliveryID: "2"})
+	l.record(Delivery{DeliveryID: "3"})
+
+	deliveries := l.deliveries()
+	g.Expect(deliveries).To(gomega.HaveLen(2))
+	g.Expect(deliveries[0].DeliveryID).To(gomega.Equal("3"))
+	g.Expect(deliveries[1].DeliveryID).To(gomega.Equal("2"))
+
+	// The deliveries within the coalesce window trigger a single trailing sync
+	var trailing int32
+
+	key := types.NamespacedName{Namespace: "default", Name: "apps"}
+	sync := func() { atomic.AddInt32(&trailing, 1) }
+
+	g.Expect(l.coalesce(key, sync)).To(gomega.BeFalse())
+	g.Expect(l.coalesce(key, sync)).To(gomega.BeTrue())
+	g.Expect(l.coalesce(key, sync)).To(gomega.BeTrue())
+	g.Expect(l.coalesce(types.NamespacedName{Namespace: "default", Name: "other"}, sync)).To(gomega.BeFalse())
+
+	g.Eventually(func() int32 { return atomic.LoadInt32(&trailing) }, time.Second).Should(gomega.Equal(int32(1)))
+	g.Consistently(func() int32 { return atomic.LoadInt32(&trailing) }, 400*time.Millisecond).Should(gomega.Equal(int32(1)))
+
+	// A nil log does nothing
+	var nilLog *deliveryLog
+
+	g.Expect(nilLog.duplicate("GitHub", "example/apps", "1")).To(gomega.BeFalse())
+	g.Expect(nilLog.allow("ip/192.0.2.1")).To(gomega.BeTrue())
+	g.Expect(nilLog.coalesce(key, sync)).To(gomega.BeFalse())
+	nilLog.record(Delivery{})
+	g.Expect(nilLog.deliveries()).To(gomega.BeEmpty())
+}
+
+func doDeliveryRequest(listener *WebhookListener, deliveryID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(deliveryPushPayload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(GithubEventHeader, "push")
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+
+	rr := httptest.NewRecorder()
+	listener.HandleWebhook(rr, req)
+
+	return rr
+}
+
+func getDeliveries(g *gomega.GomegaWithT, listener *WebhookListener, token, query string) []Delivery {
+	req := httptest.NewRequest(http.MethodGet, deliveryHistoryPath+query, nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	listener.HandleDeliveries(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+
+	deliveries := []Delivery{}
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &deliveries)).To(gomega.Succeed())
+
+	return deliveries
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	listener := newSyncListener(g)
+	recorder := record.NewFakeRecorder(10)
+	listener.EventRecorder = &utils.EventRecorder{EventRecorder: recorder}
+	listener.deliveries = newDeliveryLog(DeliveryOptions{CoalesceWindow: time.Hour, HistorySize: 10})
+
+	channel := &chnv1alpha1.Channel{}
+	g.Expect(listener.RemoteClient.Get(context.TODO(), types.NamespacedName{Name: "git-channel", Namespace: "channels"}, channel)).To(gomega.Succeed())
+	channel.SetAnnotations(map[string]string{appv1alpha1.AnnotationWebhookEnabled: "true"})
+	g.Expect(listener.RemoteClient.Update(context.TODO(), channel)).To(gomega.Succeed())
+
+	key := types.NamespacedName{Name: "apps", Namespace: "default"}
+	eventCount := func() string {
+		sub := &appv1alpha1.Subscription{}
+		g.Expect(listener.LocalClient.Get(context.TODO(), key, sub)).To(gomega.Succeed())
+
+		return sub.GetAnnotations()[appv1alpha1.AnnotationWebhookEventCount]
+	}
+
+	g.Expect(doDeliveryRequest(listener, "delivery-1").Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(eventCount()).To(gomega.Equal("0"))
+
+	// The retry of the delivery is dropped
+	g.Expect(doDeliveryRequest(listener, "delivery-1").Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(eventCount()).To(gomega.Equal("0"))
+
+	// The next delivery is coalesced
+	g.Expect(doDeliveryRequest(listener, "delivery-2").Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(eventCount()).To(gomega.Equal("0"))
+
+	g.Expect(recorder.Events).To(gomega.HaveLen(2))
+	g.Expect(<-recorder.Events).To(gomega.ContainSubstring("delivery-1 from https://github.com/example/apps: Triggered"))
+	g.Expect(<-recorder.Events).To(gomega.ContainSubstring("delivery-2 from https://github.com/example/apps: Coalesced"))
+
+	deliveries := getDeliveries(g, listener, "ci-token", "")
+	g.Expect(deliveries).To(gomega.HaveLen(3))
+	g.Expect(deliveries[0].DeliveryID).To(gomega.Equal("delivery-2"))
+	g.Expect(deliveries[0].Subscriptions).To(gomega.Equal([]DeliverySubscription{{Namespace: "default", Name: "apps", Result: SubscriptionCoalesced}}))
+	g.Expect(deliveries[1].Result).To(gomega.Equal(DeliveryDuplicate))
+	g.Expect(deliveries[2].Result).To(gomega.Equal(DeliveryAccepted))
+	g.Expect(deliveries[2].Provider).To(gomega.Equal("GitHub"))
+	g.Expect(deliveries[2].Refs).To(gomega.Equal([]string{"refs/heads/master"}))
+	g.Expect(deliveries[2].SourceIP).To(gomega.Equal("192.0.2.1"))
+	g.Expect(deliveries[2].Subscriptions).To(gomega.Equal([]DeliverySubscription{{Namespace: "default", Name: "apps", Result: SubscriptionTriggered}}))
+
+	g.Expect(getDeliveries(g, listener, "ci-token", "?subscription=default/apps")).To(gomega.HaveLen(2))
+	g.Expect(getDeliveries(g, listener, "ci-token", "?provider=gitlab")).To(gomega.BeEmpty())
+
+	// Only the users that can list all the subscriptions get the history
+	req := httptest.NewRequest(http.MethodGet, deliveryHistoryPath, nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Authorization", "Bearer viewer-token")
+
+	rr := httptest.NewRecorder()
+	listener.HandleDeliveries(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusForbidden))
+}
+
+func TestWebhookDeliveryRateLimit(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	listener := newSyncListener(g)
+	listener.deliveries = newDeliveryLog(DeliveryOptions{RateLimit: 1, HistorySize: 10})
+
+	g.Expect(doDeliveryRequest(listener, "delivery-1").Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(doDeliveryRequest(listener, "delivery-2").Code).To(gomega.Equal(http.StatusTooManyRequests))
+
+	deliveries := listener.deliveries.deliveries()
+	g.Expect(deliveries).To(gomega.HaveLen(2))
+	g.Expect(deliveries[0].Result).To(gomega.Equal(DeliveryRateLimited))
+	g.Expect(deliveries[1].Result).To(gomega.Equal(DeliveryAccepted))
+	g.Expect(deliveries[1].Subscriptions).To(gomega.BeEmpty())
+}
+
+func TestDeliveryRateLimiters(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	l := newDeliveryLog(DeliveryOptions{RateLimit: 1})
+
+	for i := 0; i < maxRateLimiters; i++ {
+		g.Expect(l.allow(fmt.Sprintf("ip/%d", i))).To(gomega.BeTrue())
+	}
+
+	// The limiters in use are kept, so new sources can not reset the limits
+	g.Expect(l.allow("ip/new")).To(gomega.BeFalse())
+	g.Expect(l.allow("ip/0")).To(gomega.BeFalse())
+
+	// The idle limiters make room for the new sources
+	l.limiters["ip/1"].last = time.Now().Add(-time.Minute)
+	g.Expect(l.allow("ip/new")).To(gomega.BeTrue())
+	g.Expect(l.allow("ip/0")).To(gomega.BeFalse())
+}
+
+func TestDeliverySourceIP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	_, proxies, err := net.ParseCIDR("10.128.0.0/14")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	l := newDeliveryLog(DeliveryOptions{TrustedProxies: []*net.IPNet{proxies}})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.10, 10.128.0.3")
+
+	// The header of untrusted clients is ignored
+	req.RemoteAddr = "192.0.2.1:1234"
+	g.Expect(l.sourceIP(req)).To(gomega.Equal("192.0.2.1"))
+
+	// The client of the trusted proxies is the last address that is not a trusted proxy
+	req.RemoteAddr = "10.128.0.2:1234"
+	g.Expect(l.sourceIP(req)).To(gomega.Equal("192.0.2.10"))
+
+	req.Header.Del("X-Forwarded-For")
+	g.Expect(l.sourceIP(req)).To(gomega.Equal("10.128.0.2"))
+}
+
+func TestWebhookDeliveryAuthentication(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	listener := newSyncListener(g)
+	listener.deliveries = newDeliveryLog(DeliveryOptions{RateLimit: 1, HistorySize: 10})
+
+	channel := &chnv1alpha1.Channel{}
+	g.Expect(listener.RemoteClient.Get(context.TODO(), types.NamespacedName{Name: "git-channel", Namespace: "channels"}, channel)).To(gomega.Succeed())
+	channel.SetAnnotations(map[string]string{
+		appv1alpha1.AnnotationWebhookEnabled: "true",
+		appv1alpha1.AnnotationWebhookSecret:  "webhook-secret",
+	})
+	g.Expect(listener.RemoteClient.Update(context.TODO(), channel)).To(gomega.Succeed())
+	g.Expect(listener.RemoteClient.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-secret", Namespace: "channels"},
+		Data:       map[string][]byte{"secret": []byte("github-secret")},
+	})).To(gomega.Succeed())
+
+	deliver := func(sourceIP, deliveryID, secret string) int {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write([]byte(deliveryPushPayload))
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(deliveryPushPayload))
+		req.RemoteAddr = sourceIP + ":1234"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(GithubEventHeader, "push")
+		req.Header.Set("X-GitHub-Delivery", deliveryID)
+		req.Header.Set(githubSignatureHeader, "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+		rr := httptest.NewRecorder()
+		listener.HandleWebhook(rr, req)
+
+		return rr.Code
+	}
+
+	// A forged delivery neither uses up the repository rate limit nor marks the delivery ID as seen
+	g.Expect(deliver("192.0.2.1", "delivery-1", "forged")).To(gomega.Equal(http.StatusOK))
+	g.Expect(deliver("192.0.2.2", "delivery-1", "github-secret")).To(gomega.Equal(http.StatusOK))
+	g.Expect(deliver("192.0.2.3", "delivery-2", "github-secret")).To(gomega.Equal(http.StatusTooManyRequests))
+
+	deliveries := listener.deliveries.deliveries()
+	g.Expect(deliveries).To(gomega.HaveLen(3))
+	g.Expect(deliveries[0].Result).To(gomega.Equal(DeliveryRateLimited))
+	g.Expect(deliveries[1].Result).To(gomega.Equal(DeliveryAccepted))
+	g.Expect(deliveries[1].Subscriptions).To(gomega.Equal([]DeliverySubscription{{Namespace: "default", Name: "apps", Result: SubscriptionTriggered}}))
+	g.Expect(deliveries[2].Result).To(gomega.Equal(DeliveryUnauthenticated))
+	g.Expect(deliveries[2].Subscriptions).To(gomega.Equal([]DeliverySubscription{{Namespace: "default", Name: "apps", Result: SubscriptionSecretInvalid}}))
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+)
+
+const (
+	GiteaEventHeader     = "X-Gitea-Event"
+	GogsEventHeader      = "X-Gogs-Event"
+	giteaSignatureHeader = "X-Gitea-Signature"
+	gogsSignatureHeader  = "X-Gogs-Signature"
+	giteaDeliveryHeader  = "X-Gitea-Delivery"
+	gogsDeliveryHeader   = "X-Gogs-Delivery"
+	GiteaPushEvent       = "push"
+)
+
+// GiteaPayload is the payload of Gitea and Gogs push events
+type GiteaPayload struct {
+	Ref     string        `json:"ref"`
+	Commits []GiteaCommit `json:"commits"`
+	// TotalCommits is only set by Gitea
+	TotalCommits int             `json:"total_commits"`
+	Repository   GiteaRepository `json:"repository"`
+}
+
+type GiteaCommit struct {
+	ID       string   `json:"id"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+type GiteaRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
+}
+
+// change returns the change of a push event
+func (p GiteaPayload) change() gitChange {
+	change := gitChange{refs: []string{p.Ref}}
+
+	// Large pushes and pushes without commits, like created and deleted branches, do not list all the changed files
+	if len(p.Commits) == 0 || len(p.Commits) >= maxPayloadCommits || p.TotalCommits > len(p.Commits) {
+		return change
+	}
+
+	for _, commit := range p.Commits {
+		change.addCommitFiles(commit.Added, commit.Modified, commit.Removed)
+	}
+
+	return change
+}
+
+// giteaProvider handles the push events of Gitea and Gogs repositories
+type giteaProvider struct{}
+
+func (giteaProvider) Name() string {
+	return "Gitea"
+}
+
+func (giteaProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeGit
+}
+
+func (giteaProvider) Matches(r *http.Request) bool {
+	return r.Header.Get(GiteaEventHeader) != "" || r.Header.Get(GogsEventHeader) != ""
+}
+
+func (giteaProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
+	event := r.Header.Get(GiteaEventHeader)
+	signature := r.Header.Get(giteaSignatureHeader)
+	deliveryID := r.Header.Get(giteaDeliveryHeader)
+
+	if event == "" {
+		event = r.Header.Get(GogsEventHeader)
+		signature = r.Header.Get(gogsSignatureHeader)
+		deliveryID = r.Header.Get(gogsDeliveryHeader)
+	}
+
+	klog.Info("Handling Gitea webhook event: " + event)
+
+	// The signature is computed on the JSON payload of both content types
+	_, body, err := readPayload(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(event, GiteaPushEvent) {
+		klog.Infof("Unhandled webhook event %s\n", event)
+		return nil, nil
+	}
+
+	var payload GiteaPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		klog.Error("Failed to parse the webhook event payload. error: ", err)
+		return nil, err
+	}
+
+	return &WebhookEvent{
+		Type:       event,
+		Repo:       payload.Repository.HTMLURL,
+		Body:       body,
+		Signature:  signature,
+		DeliveryID: deliveryID,
+		Payload:    payload,
+		change:     payload.change(),
+	}, nil
+}
+
+func (giteaProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(GiteaPayload)
+	if !ok {
+		return false
+	}
+
+	return sameRepoURL(pathname, payload.Repository.HTMLURL) ||
+		sameRepoURL(pathname, payload.Repository.CloneURL) ||
+		sameRepoURL(pathname, payload.Repository.SSHURL)
+}
+
+// ValidateSecret validates the HMAC-SHA256 signature of the payload. Gitea and Gogs only sign the payload when the
+// webhook has a secret.
+func (giteaProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	if secret == "" {
+		return event.Signature == ""
+	}
+
+	return validateHMACSHA256(event.Signature, event.Body, secret)
+}
+
+// validateHMACSHA256 validates the hex encoded HMAC-SHA256 signature of the body
+func validateHMACSHA256(signature string, body []byte, secret string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		klog.Info("Failed to decode webhook event signature, error: ", err)
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+const giteaPushPayload = `{
+  "ref": "refs/heads/main",
+  "before": "28e1879d029cb852e4844d9c718537df08844e03",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "compare_url": "https://gitea.example.com/example/apps/compare/28e1879d029c...bffeb7422404",
+  "commits": [
+    {
+      "id": "bffeb74224043ba2feb48d137756c8a9331c449a",
+      "message": "Update the frontend",
+      "url": "https://gitea.example.com/example/apps/commit/bffeb74224043ba2feb48d137756c8a9331c449a",
+      "added": [],
+      "removed": [],
+      "modified": ["frontend/deployment.yaml"]
+    }
+  ],
+  "total_commits": 1,
+  "repository": {
+    "id": 1,
+    "name": "apps",
+    "full_name": "example/apps",
+    "html_url": "https://gitea.example.com/example/apps",
+    "ssh_url": "git@gitea.example.com:example/apps.git",
+    "clone_url": "https://gitea.example.com/example/apps.git"
+  },
+  "pusher": {"login": "joe"},
+  "sender": {"login": "joe"}
+}`
+
+func giteaSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGiteaProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBufferString(giteaPushPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// Gitea sends the GitHub and Gogs headers too
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(GiteaEventHeader, "push")
+	req.Header.Set(GogsEventHeader, "push")
+	req.Header.Set(GithubEventHeader, "push")
+	req.Header.Set(giteaSignatureHeader, giteaSignature([]byte(giteaPushPayload), "gitea-secret"))
+
+	provider := getWebhookProvider(req)
+	g.Expect(provider).To(gomega.Equal(giteaProvider{}))
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.Type).To(gomega.Equal("push"))
+	g.Expect(event.change.(gitChange).refs).To(gomega.Equal([]string{"refs/heads/main"}))
+	g.Expect(event.change.(gitChange).files).To(gomega.Equal([]string{"frontend/deployment.yaml"}))
+
+	g.Expect(provider.MatchesRepo(event, "https://gitea.example.com/example/apps.git")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://joe@gitea.example.com/example/apps/")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "git@gitea.example.com:example/apps.git")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://gitea.example.com/example/apps-config.git")).To(gomega.BeFalse())
+
+	g.Expect(provider.ValidateSecret(event, "gitea-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "other-secret")).To(gomega.BeFalse())
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	event.Signature = ""
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "gitea-secret")).To(gomega.BeFalse())
+}
+
+func TestGogsProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// Gogs can send form requests. The signature is computed on the JSON payload.
+	form := url.Values{payloadFormParam: []string{giteaPushPayload}}
+
+	req, err := http.NewRequest("POST", "/webhook", strings.NewReader(form.Encode()))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(GogsEventHeader, "push")
+	req.Header.Set(gogsSignatureHeader, giteaSignature([]byte(giteaPushPayload), "gogs-secret"))
+
+	provider := getWebhookProvider(req)
+	g.Expect(provider).To(gomega.Equal(giteaProvider{}))
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.Repo).To(gomega.Equal("https://gitea.example.com/example/apps"))
+	g.Expect(provider.ValidateSecret(event, "gogs-secret")).To(gomega.BeTrue())
+
+	// Other events do not trigger subscriptions
+	req, err = http.NewRequest("POST", "/webhook", bytes.NewBufferString(`{"ref": "refs/heads/main"}`))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(GogsEventHeader, "create")
+
+	event, err = provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event).To(gomega.BeNil())
+}
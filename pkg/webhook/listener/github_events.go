@@ -15,7 +15,6 @@
 package listener
 
 import (
-	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -23,9 +22,7 @@ import (
 	"strings"
 
 	"github.com/google/go-github/v32/github"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
 	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
@@ -37,99 +34,87 @@ const (
 	githubSignatureHeader = "X-Hub-Signature"
 )
 
-func (listener *WebhookListener) handleGithubWebhook(r *http.Request) error {
-	var body []byte
+// githubProvider handles the push events of GitHub repositories
+type githubProvider struct{}
 
-	var signature string
+func (githubProvider) Name() string {
+	return "GitHub"
+}
 
-	var event interface{}
+func (githubProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeGit
+}
 
-	var err error
+func (githubProvider) Matches(r *http.Request) bool {
+	return r.Header.Get(GithubEventHeader) != ""
+}
 
+func (githubProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
 	eventType := github.WebHookType(r)
 
-	body, signature, event, err = listener.ParseRequest(r)
-	if err != nil {
-		klog.Error("Failed to parse the request. error:", err)
-		return err
-	}
-
-	subList := &appv1alpha1.SubscriptionList{}
-	listopts := &client.ListOptions{}
-
-	err = listener.LocalClient.List(context.TODO(), subList, listopts)
+	body, signature, event, err := parseGithubRequest(r)
 	if err != nil {
-		klog.Error("Failed to get subscriptions. error: ", err)
-		return err
+		return nil, err
 	}
 
-	if strings.EqualFold(eventType, "push") || strings.EqualFold(eventType, "pull") {
-		// Loop through all subscriptions
-		for _, sub := range subList.Items {
-			if !listener.processSubscription(sub, event, signature, eventType, body) {
-				continue
-			}
-		}
-	} else {
+	if !strings.EqualFold(eventType, "push") && !strings.EqualFold(eventType, "pull") {
 		klog.V(2).Infof("Unhandled webhook event type %s\n", eventType)
+		return nil, nil
 	}
 
-	return nil
-}
-
-func (listener *WebhookListener) processSubscription(sub appv1alpha1.Subscription, event interface{}, signature, eventType string, body []byte) bool {
-	klog.V(2).Info("Evaluating subscription: " + sub.GetName())
+	repo := ""
 
-	chNamespace := ""
-	chName := ""
-
-	if sub.Spec.Channel != "" {
-		strs := strings.Split(sub.Spec.Channel, "/")
-		if len(strs) == 2 {
-			chNamespace = strs[0]
-			chName = strs[1]
-		} else {
-			klog.Error("Failed to get channel namespace and name.")
-			return false
-		}
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		repo = e.GetRepo().GetHTMLURL()
+	case *github.PushEvent:
+		repo = e.GetRepo().GetHTMLURL()
+	default:
+		klog.Infof("Unhandled webhook event type %s\n", eventType)
+		return nil, nil
 	}
 
-	chkey := types.NamespacedName{Name: chName, Namespace: chNamespace}
-	chobj := &chnv1alpha1.Channel{}
-	err := listener.RemoteClient.Get(context.TODO(), chkey, chobj)
-
-	if err != nil {
-		klog.Error("Failed to get subscription's channel. error: ", err)
-		return false
-	}
+	return &WebhookEvent{
+		Type:       eventType,
+		Repo:       repo,
+		Body:       body,
+		Signature:  signature,
+		DeliveryID: github.DeliveryID(r),
+		Payload:    event,
+		change:     githubChange(event),
+	}, nil
+}
 
-	if !listener.validateChannel(chobj, signature, chNamespace, body) {
-		return false
+func (githubProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	var repo interface {
+		GetCloneURL() string
+		GetHTMLURL() string
+		GetURL() string
+		GetFullName() string
 	}
 
-	switch e := event.(type) {
+	switch e := event.Payload.(type) {
 	case *github.PullRequestEvent:
-		if chobj.Spec.Pathname == e.GetRepo().GetCloneURL() ||
-			chobj.Spec.Pathname == e.GetRepo().GetHTMLURL() ||
-			chobj.Spec.Pathname == e.GetRepo().GetURL() ||
-			strings.Contains(chobj.Spec.Pathname, e.GetRepo().GetFullName()) {
-			klog.Info("Processing PR event from " + e.GetRepo().GetHTMLURL())
-			listener.updateSubscription(sub)
-		}
+		repo = e.GetRepo()
 	case *github.PushEvent:
-		if chobj.Spec.Pathname == e.GetRepo().GetCloneURL() ||
-			chobj.Spec.Pathname == e.GetRepo().GetHTMLURL() ||
-			chobj.Spec.Pathname == e.GetRepo().GetURL() ||
-			strings.Contains(chobj.Spec.Pathname, e.GetRepo().GetFullName()) {
-			klog.Info("Processing PUSH event from " + e.GetRepo().GetHTMLURL())
-			listener.updateSubscription(sub)
-		}
+		repo = e.GetRepo()
 	default:
-		klog.Infof("Unhandled webhook event type %s\n", eventType)
 		return false
 	}
 
-	return true
+	return pathname == repo.GetCloneURL() ||
+		pathname == repo.GetHTMLURL() ||
+		pathname == repo.GetURL() ||
+		strings.Contains(pathname, repo.GetFullName())
+}
+
+// ValidateSecret validates the signature only when the event is signed
+func (githubProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	if event.Signature == "" {
+		return true
+	}
+
+	return validateGithubSignature(event.Signature, event.Body, secret)
 }
 
 func (listener *WebhookListener) validateChannel(chobj *chnv1alpha1.Channel, signature, chNamespace string, body []byte) bool {
@@ -163,56 +148,72 @@ func (listener *WebhookListener) validateChannel(chobj *chnv1alpha1.Channel, sig
 
 // ParseRequest parses incoming WebHook event request
 func (listener *WebhookListener) ParseRequest(r *http.Request) (body []byte, signature string, event interface{}, err error) {
-	var payload []byte
+	return parseGithubRequest(r)
+}
+
+func parseGithubRequest(r *http.Request) (body []byte, signature string, event interface{}, err error) {
+	body, payload, err := readPayload(r)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	signature = r.Header.Get(githubSignatureHeader)
+
+	event, err = github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		klog.Error("could not parse webhook. error:", err)
+		return nil, "", nil, err
+	}
 
+	return body, signature, event, nil
+}
+
+// readPayload reads the request body and the JSON payload of a JSON or form request
+func readPayload(r *http.Request) (body, payload []byte, err error) {
 	switch contentType := r.Header.Get("Content-Type"); contentType {
 	case "application/json":
 		if body, err = ioutil.ReadAll(r.Body); err != nil {
 			klog.Error("Failed to read the request body. error: ", err)
-			return nil, "", nil, err
+			return nil, nil, err
 		}
 
 		payload = body //the JSON payload
 	case "application/x-www-form-urlencoded":
 		if body, err = ioutil.ReadAll(r.Body); err != nil {
 			klog.Error("Failed to read the request body. error: ", err)
-			return nil, "", nil, err
+			return nil, nil, err
 		}
 
 		form, err := url.ParseQuery(string(body))
 		if err != nil {
 			klog.Error("Failed to parse the request body. error: ", err)
-			return nil, "", nil, err
+			return nil, nil, err
 		}
 
 		payload = []byte(form.Get(payloadFormParam))
 	default:
 		klog.Warningf("Webhook request has unsupported Content-Type %q", contentType)
-		return nil, "", nil, errors.New("Unsupported Content-Type: " + contentType)
+		return nil, nil, errors.New("Unsupported Content-Type: " + contentType)
 	}
 
 	defer r.Body.Close()
 
-	signature = r.Header.Get(githubSignatureHeader)
-
-	event, err = github.ParseWebHook(github.WebHookType(r), payload)
-	if err != nil {
-		klog.Error("could not parse webhook. error:", err)
-		return nil, "", nil, err
-	}
-
-	return body, signature, event, nil
+	return body, payload, nil
 }
 
-func (listener *WebhookListener) validateSecret(signature string, annotations map[string]string, chNamespace string, body []byte) (ret bool) {
+func (listener *WebhookListener) validateSecret(signature string, annotations map[string]string, chNamespace string, body []byte) bool {
 	secret := listener.getWebhookSecret(annotations[appv1alpha1.AnnotationWebhookSecret], chNamespace)
 
-	// Using the channel's webhook secret, validate it against the request's body
+	return validateGithubSignature(signature, body, secret)
+}
+
+// validateGithubSignature validates the signature of the request body with the channel's webhook secret
+func validateGithubSignature(signature string, body []byte, secret string) bool {
 	if err := github.ValidateSignature(signature, body, []byte(secret)); err != nil {
 		klog.Info("Failed to validate webhook event signature, error: ", err)
 		// If validation fails, this webhook event is not for this subscription. Skip.
-		ret = false
+		return false
 	}
 
-	return ret
+	return true
 }
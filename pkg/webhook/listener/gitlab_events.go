@@ -16,6 +16,7 @@ package listener
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -25,21 +26,59 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/ghodss/yaml"
 	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
-	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
 )
 
 const (
 	GitLabPushEvents         = "Push Hook"
 	GitLabMergeRequestEvents = "Merge Request Hook"
 	gitlabSignatureHeader    = "X-Gitlab-Token"
+	gitlabDeliveryHeader     = "X-Gitlab-Event-UUID"
 )
 
 type GitLabPayload struct {
 	Repository GitLabRepository `json:"repository"`
+	// Ref, Commits and TotalCommitsCount are set in push events
+	Ref               string         `json:"ref"`
+	Commits           []GitLabCommit `json:"commits"`
+	TotalCommitsCount int            `json:"total_commits_count"`
+	// ObjectAttributes is set in merge request events
+	ObjectAttributes struct {
+		TargetBranch string `json:"target_branch"`
+	} `json:"object_attributes"`
+}
+
+type GitLabCommit struct {
+	ID       string   `json:"id"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// change returns the change of a push or merge request event
+func (p GitLabPayload) change(event string) gitChange {
+	change := gitChange{}
+
+	if strings.EqualFold(event, GitLabMergeRequestEvents) {
+		change.refs = []string{branchRef(p.ObjectAttributes.TargetBranch)}
+
+		return change
+	}
+
+	change.refs = []string{p.Ref}
+
+	// Large pushes and pushes without commits, like created and deleted branches, do not list all the changed files
+	if len(p.Commits) == 0 || len(p.Commits) >= maxPayloadCommits || p.TotalCommitsCount > len(p.Commits) {
+		return change
+	}
+
+	for _, commit := range p.Commits {
+		change.addCommitFiles(commit.Added, commit.Modified, commit.Removed)
+	}
+
+	return change
 }
 
 type GitLabRepository struct {
@@ -49,7 +88,22 @@ type GitLabRepository struct {
 	Homepage    string `json:"homepage"`
 }
 
-func (listener *WebhookListener) handleGitlabWebhook(r *http.Request) error {
+// gitlabProvider handles the push and merge request events of GitLab repositories
+type gitlabProvider struct{}
+
+func (gitlabProvider) Name() string {
+	return "GitLab"
+}
+
+func (gitlabProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeGit
+}
+
+func (gitlabProvider) Matches(r *http.Request) bool {
+	return r.Header.Get(GitlabEventHeader) != ""
+}
+
+func (gitlabProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
 	event := r.Header.Get(GitlabEventHeader) // has to have value. webhook_listner ensures.
 
 	klog.Info("Handling GitLab webhook event: " + event)
@@ -57,7 +111,7 @@ func (listener *WebhookListener) handleGitlabWebhook(r *http.Request) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil || len(body) == 0 {
 		klog.Error("Failed to parse the payload: ", err)
-		return errors.New("failed to parse the payload")
+		return nil, errors.New("failed to parse the payload")
 	}
 
 	var payload GitLabPayload
@@ -65,82 +119,40 @@ func (listener *WebhookListener) handleGitlabWebhook(r *http.Request) error {
 
 	if err != nil {
 		klog.Error("Failed to parse the webhook event payload. error: ", err)
-		return err
-	}
-
-	secret := r.Header.Get(gitlabSignatureHeader)
-
-	subList := &appv1alpha1.SubscriptionList{}
-	listopts := &client.ListOptions{}
-
-	err = listener.LocalClient.List(context.TODO(), subList, listopts)
-	if err != nil {
-		klog.Error("Failed to get subscriptions. error: ", err)
-		return err
+		return nil, err
 	}
 
-	if strings.EqualFold(event, GitLabPushEvents) || strings.EqualFold(event, GitLabMergeRequestEvents) { // process only push or PR merge events
-		// Loop through all subscriptions
-		for _, sub := range subList.Items {
-			if !listener.processGitLabEvent(sub, event, payload, secret) {
-				continue
-			}
-		}
-	} else {
+	// process only push or PR merge events
+	if !strings.EqualFold(event, GitLabPushEvents) && !strings.EqualFold(event, GitLabMergeRequestEvents) {
 		klog.Infof("Unhandled webhook event %s\n", event)
-		return nil
+		return nil, nil
 	}
 
-	return nil
+	return &WebhookEvent{
+		Type:       event,
+		Repo:       payload.Repository.URL,
+		Body:       body,
+		Signature:  r.Header.Get(gitlabSignatureHeader),
+		DeliveryID: r.Header.Get(gitlabDeliveryHeader),
+		Payload:    payload,
+		change:     payload.change(event),
+	}, nil
 }
 
-func (listener *WebhookListener) processGitLabEvent(sub appv1alpha1.Subscription, event string, payload GitLabPayload, hookSecret string) bool {
-	klog.Info("Evaluating subscription: " + sub.GetName())
-
-	chNamespace := ""
-	chName := ""
-
-	if sub.Spec.Channel != "" {
-		strs := strings.Split(sub.Spec.Channel, "/")
-		if len(strs) == 2 {
-			chNamespace = strs[0]
-			chName = strs[1]
-		} else {
-			klog.Error("Failed to get channel namespace and name.")
-			return false
-		}
-	}
-
-	chkey := types.NamespacedName{Name: chName, Namespace: chNamespace}
-	chobj := &chnv1alpha1.Channel{}
-	err := listener.RemoteClient.Get(context.TODO(), chkey, chobj)
-
-	if err != nil {
-		klog.Error("Failed to get subscription's channel. error: ", err)
-		return false
-	}
-
-	if !listener.validateChannel(chobj, "", chNamespace, []byte("")) {
-		klog.Info("Failed to validate channel: ")
+func (gitlabProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(GitLabPayload)
+	if !ok {
 		return false
 	}
 
-	chnAnnotations := chobj.GetAnnotations()
-	channelSecret := ""
-
-	if chnAnnotations != nil {
-		channelSecret = listener.getWebhookSecret(chnAnnotations[appv1alpha1.AnnotationWebhookSecret], chNamespace)
-	}
-
-	if (strings.EqualFold(chobj.Spec.Pathname, payload.Repository.Homepage) ||
-		strings.Contains(chobj.Spec.Pathname, payload.Repository.Homepage)) &&
-		strings.TrimSpace(payload.Repository.Homepage) != "" &&
-		strings.EqualFold(channelSecret, hookSecret) {
-		klog.Infof("Processing %s event from %s repository for subscription %s", event, payload.Repository.URL, sub.Name)
-		listener.updateSubscription(sub)
-	}
+	return (strings.EqualFold(pathname, payload.Repository.Homepage) ||
+		strings.Contains(pathname, payload.Repository.Homepage)) &&
+		strings.TrimSpace(payload.Repository.Homepage) != ""
+}
 
-	return true
+// ValidateSecret compares the secret token of the event with the channel's webhook secret
+func (gitlabProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(event.Signature), []byte(secret)) == 1
 }
 
 func (listener *WebhookListener) getWebhookSecret(channelSecret, channelNs string) string {
@@ -364,3 +364,19 @@ func TestWithWebhookSecret(t *testing.T) {
 	err = c.Delete(context.TODO(), channel)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 }
+
+func TestGitLabValidateSecret(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	provider := gitlabProvider{}
+	event := &WebhookEvent{Signature: "gitlab-secret"}
+
+	g.Expect(provider.ValidateSecret(event, "gitlab-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "GitLab-Secret")).To(gomega.BeFalse())
+	g.Expect(provider.ValidateSecret(event, "other-secret")).To(gomega.BeFalse())
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	event.Signature = ""
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "gitlab-secret")).To(gomega.BeFalse())
+}
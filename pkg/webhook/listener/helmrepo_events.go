@@ -0,0 +1,141 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	helmRepoWebhookPath = "/webhook/helmrepo"
+	HarborUploadChart   = "UPLOAD_CHART"
+	HarborDeleteChart   = "DELETE_CHART"
+)
+
+// HelmRepoPayload is the payload of Harbor chart events. The resource URLs are the URLs of the chart archives in
+// the ChartMuseum repository, like harbor.example.com/chartrepo/library/charts/nginx-1.0.0.tgz
+type HelmRepoPayload struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			Name         string `json:"name"`
+			Namespace    string `json:"namespace"`
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+// chartChange is the chart of a Helm repository event
+type chartChange struct {
+	chart string
+}
+
+// matches returns true if the subscription subscribes to all the charts of the repository or to the chart
+func (c chartChange) matches(sub *appv1alpha1.Subscription) bool {
+	return c.chart == "" || sub.Spec.Package == "" || sub.Spec.Package == c.chart
+}
+
+// helmRepoProvider handles the chart upload and delete events of Harbor and ChartMuseum Helm repositories
+type helmRepoProvider struct{}
+
+func (helmRepoProvider) Name() string {
+	return "Helm repository"
+}
+
+func (helmRepoProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeHelmRepo
+}
+
+func (helmRepoProvider) Matches(r *http.Request) bool {
+	return r.URL != nil && r.URL.Path == helmRepoWebhookPath
+}
+
+func (helmRepoProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(body) == 0 {
+		klog.Error("Failed to parse the payload: ", err)
+		return nil, errors.New("failed to parse the payload")
+	}
+
+	var payload HelmRepoPayload
+	err = json.Unmarshal(body, &payload)
+
+	if err != nil {
+		klog.Error("Failed to parse the webhook event payload. error: ", err)
+		return nil, err
+	}
+
+	klog.Info("Handling Helm repository webhook event: " + payload.Type)
+
+	if !strings.EqualFold(payload.Type, HarborUploadChart) && !strings.EqualFold(payload.Type, HarborDeleteChart) {
+		klog.Infof("Unhandled webhook event %s\n", payload.Type)
+		return nil, nil
+	}
+
+	if len(payload.EventData.Resources) == 0 {
+		klog.Info("No chart in the Helm repository webhook event")
+		return nil, nil
+	}
+
+	return &WebhookEvent{
+		Type:      payload.Type,
+		Repo:      payload.EventData.Repository.RepoFullName,
+		Body:      body,
+		Signature: bearerToken(r),
+		Payload:   payload,
+		change:    chartChange{chart: payload.EventData.Repository.Name},
+	}, nil
+}
+
+// MatchesRepo returns true if a chart archive of the event is in the Helm repository of the channel
+func (helmRepoProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(HelmRepoPayload)
+	if !ok {
+		return false
+	}
+
+	repoURL := normalizeRepoURL(strings.TrimSuffix(pathname, "/index.yaml"))
+	if repoURL == "" {
+		return false
+	}
+
+	for _, resource := range payload.EventData.Resources {
+		if strings.HasPrefix(normalizeRepoURL(resource.ResourceURL), repoURL+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateSecret compares the token of the Authorization header with the channel's webhook secret. The events of
+// channels without a webhook secret are rejected.
+func (helmRepoProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(event.Signature), []byte(secret)) == 1
+}
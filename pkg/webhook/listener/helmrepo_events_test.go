@@ -0,0 +1,108 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/onsi/gomega"
+
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const harborUploadChartPayload = `{
+  "type": "UPLOAD_CHART",
+  "occur_at": 1612339543,
+  "operator": "admin",
+  "event_data": {
+    "resources": [
+      {
+        "tag": "1.0.0",
+        "resource_url": "harbor.example.com/chartrepo/library/charts/nginx-1.0.0.tgz"
+      }
+    ],
+    "repository": {
+      "date_created": 1612339543,
+      "name": "nginx",
+      "namespace": "library",
+      "repo_full_name": "library/nginx",
+      "repo_type": "public"
+    }
+  }
+}`
+
+func TestHelmRepoProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", helmRepoWebhookPath, bytes.NewBufferString(harborUploadChartPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer harbor-secret")
+
+	provider := getWebhookProvider(req)
+	g.Expect(provider).To(gomega.Equal(helmRepoProvider{}))
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.Type).To(gomega.Equal(HarborUploadChart))
+
+	g.Expect(provider.MatchesRepo(event, "https://harbor.example.com/chartrepo/library")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://harbor.example.com/chartrepo/library/")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "https://harbor.example.com/chartrepo/lib")).To(gomega.BeFalse())
+	g.Expect(provider.MatchesRepo(event, "https://charts.example.com/chartrepo/library")).To(gomega.BeFalse())
+
+	g.Expect(provider.ValidateSecret(event, "harbor-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "other-secret")).To(gomega.BeFalse())
+
+	// Channels without a webhook secret do not accept events
+	event.Signature = ""
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	sub := newFilterSubscription(nil)
+	g.Expect(event.change.matches(sub)).To(gomega.BeTrue())
+
+	sub.Spec.Package = "nginx"
+	g.Expect(event.change.matches(sub)).To(gomega.BeTrue())
+
+	sub.Spec.Package = "redis"
+	g.Expect(event.change.matches(sub)).To(gomega.BeFalse())
+
+	// Other events do not trigger subscriptions
+	req, err = http.NewRequest("POST", helmRepoWebhookPath, bytes.NewBufferString(`{"type": "DOWNLOAD_CHART"}`))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	event, err = provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event).To(gomega.BeNil())
+}
+
+func TestBearerToken(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", "/webhook", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(bearerToken(req)).To(gomega.BeEmpty())
+
+	req.Header.Set("Authorization", "secret")
+	g.Expect(bearerToken(req)).To(gomega.Equal("secret"))
+
+	req.Header.Set("Authorization", "bearer secret")
+	g.Expect(bearerToken(req)).To(gomega.Equal("secret"))
+
+	g.Expect(chartChange{}.matches(&appv1alpha1.Subscription{})).To(gomega.BeTrue())
+}
@@ -0,0 +1,512 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+const (
+	// manualSyncPath is followed by the namespace and name of the subscription, like /sync/default/my-subscription
+	manualSyncPath = "/sync/"
+	// maxSyncRequestBytes bounds the body of the manual sync requests, it only holds a commit, tag or chart version
+	maxSyncRequestBytes = 4 << 10
+
+	SyncPhasePending    = "Pending"
+	SyncPhaseCompleted  = "Completed"
+	SyncPhaseFailed     = "Failed"
+	SyncPhaseSuperseded = "Superseded"
+
+	syncPinCommit       = "commit"
+	syncPinTag          = "tag"
+	syncPinChartVersion = "chart-version"
+)
+
+// SyncRequest is the body of a manual sync request. At most one of the fields is set.
+type SyncRequest struct {
+	// Commit is the Git commit to deploy
+	Commit string `json:"commit,omitempty"`
+	// Tag is the Git tag to deploy
+	Tag string `json:"tag,omitempty"`
+	// ChartVersion is the version of the Helm chart to deploy
+	ChartVersion string `json:"chartVersion,omitempty"`
+}
+
+// SyncResponse is the response of manual sync requests and sync status requests
+type SyncResponse struct {
+	SyncID    string `json:"syncID"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Phase is the sync phase, it is only set in sync status responses
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// syncPin is the field pinned by a manual sync request and its previous value, saved in the manual sync pin annotation
+type syncPin struct {
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// statusError is an error with the HTTP status of the response
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func newStatusError(status int, format string, args ...interface{}) error {
+	return &statusError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// errorStatus returns the HTTP status of the error, internal server error for errors without status
+func errorStatus(err error) int {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		return serr.status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// HandleSync handles manual sync requests. A POST request triggers a sync of the subscription and returns the sync
+// ID. A GET request with the syncID query parameter returns the phase of the sync.
+func (listener *WebhookListener) HandleSync(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSyncRequestBytes)
+
+	resp, err := listener.handleSync(r)
+	if err != nil {
+		klog.Info("Manual sync request failed. error: ", err)
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		klog.Error(err.Error())
+	}
+}
+
+func (listener *WebhookListener) handleSync(r *http.Request) (*SyncResponse, error) {
+	key, err := parseSyncPath(r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := ""
+
+	switch r.Method {
+	case http.MethodPost:
+		verb = "update"
+	case http.MethodGet:
+		verb = "get"
+	default:
+		return nil, newStatusError(http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+	}
+
+	if err := listener.authorizeRequest(r, key, verb); err != nil {
+		return nil, err
+	}
+
+	sub := &appv1alpha1.Subscription{}
+	if err := listener.LocalClient.Get(context.TODO(), key, sub); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, newStatusError(http.StatusNotFound, "subscription %s not found", key)
+		}
+
+		return nil, err
+	}
+
+	if r.Method == http.MethodGet {
+		return getSyncStatus(sub, r.URL.Query().Get("syncID"))
+	}
+
+	req := SyncRequest{}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "failed to read the sync request: %v", err)
+	}
+
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, newStatusError(http.StatusBadRequest, "failed to parse the sync request: %v", err)
+		}
+	}
+
+	return listener.syncSubscription(sub, req)
+}
+
+// parseSyncPath returns the subscription of a manual sync path
+func parseSyncPath(path string) (types.NamespacedName, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, manualSyncPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, newStatusError(http.StatusNotFound, "the path must be %s<namespace>/<name>", manualSyncPath)
+	}
+
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// authorizeRequest reviews the bearer token of the request and checks that its user can get or update the
+// subscription, or list the subscriptions of all namespaces when the key is empty. The requests that are not sent over
+// TLS are refused, their bearer token would be sent in clear text.
+func (listener *WebhookListener) authorizeRequest(r *http.Request, key types.NamespacedName, verb string) error {
+	if r.TLS == nil {
+		return newStatusError(http.StatusForbidden, "the request must be sent over TLS")
+	}
+
+	token := bearerToken(r)
+	if token == "" {
+		return newStatusError(http.StatusUnauthorized, "no bearer token")
+	}
+
+	review, err := listener.KubeClient.AuthenticationV1().TokenReviews().Create(context.TODO(),
+		&authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	if !review.Status.Authenticated {
+		return newStatusError(http.StatusUnauthorized, "invalid bearer token")
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	access, err := listener.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(),
+		&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: key.Namespace,
+					Name:      key.Name,
+					Verb:      verb,
+					Group:     appv1alpha1.SchemeGroupVersion.Group,
+					Resource:  "subscriptions",
+				},
+				User:   user.Username,
+				Groups: user.Groups,
+				UID:    user.UID,
+				Extra:  extra,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	if !access.Status.Allowed {
+		if key.Name == "" {
+			return newStatusError(http.StatusForbidden, "user %s cannot %s subscriptions", user.Username, verb)
+		}
+
+		return newStatusError(http.StatusForbidden, "user %s cannot %s subscription %s", user.Username, verb, key)
+	}
+
+	return nil
+}
+
+// syncSubscription sets the manual sync ID and refresh time of the subscription and pins the requested commit, tag
+// or chart version. The pin of the previous manual sync request is removed.
+func (listener *WebhookListener) syncSubscription(sub *appv1alpha1.Subscription, req SyncRequest) (*SyncResponse, error) {
+	pin, err := listener.newSyncPin(sub, req)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := sub.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	// A manual sync supersedes the rollback of failed hooks, which was pinned after the previous manual sync request
+	utils.RemoveHookRollbackPin(annotations)
+
+	if previous := annotations[appv1alpha1.AnnotationManualSyncPin]; previous != "" {
+		unpinSubscription(sub, annotations, previous)
+	}
+
+	delete(annotations, appv1alpha1.AnnotationManualSyncPin)
+
+	if pin != nil {
+		pin.Previous = pinSubscription(sub, annotations, pin.Kind, pin.Value)
+
+		pinJSON, err := json.Marshal(pin)
+		if err != nil {
+			return nil, err
+		}
+
+		annotations[appv1alpha1.AnnotationManualSyncPin] = string(pinJSON)
+	}
+
+	syncID := string(uuid.NewUUID())
+	annotations[appv1alpha1.AnnotationManualSyncID] = syncID
+	annotations[appv1alpha1.AnnotationManualReconcileTime] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	sub.SetAnnotations(annotations)
+
+	if err := listener.LocalClient.Update(context.TODO(), sub); err != nil {
+		klog.Error("Failed to update subscription annotations. error: ", err)
+		return nil, err
+	}
+
+	klog.Infof("Manual sync %s of subscription %s/%s requested", syncID, sub.Namespace, sub.Name)
+
+	return &SyncResponse{SyncID: syncID, Namespace: sub.Namespace, Name: sub.Name, Phase: SyncPhasePending}, nil
+}
+
+// newSyncPin validates the pin of the request against the channel type of the subscription
+func (listener *WebhookListener) newSyncPin(sub *appv1alpha1.Subscription, req SyncRequest) (*syncPin, error) {
+	pins := []syncPin{}
+
+	if req.Commit != "" {
+		pins = append(pins, syncPin{Kind: syncPinCommit, Value: req.Commit})
+	}
+
+	if req.Tag != "" {
+		pins = append(pins, syncPin{Kind: syncPinTag, Value: req.Tag})
+	}
+
+	if req.ChartVersion != "" {
+		pins = append(pins, syncPin{Kind: syncPinChartVersion, Value: req.ChartVersion})
+	}
+
+	if len(pins) == 0 {
+		return nil, nil
+	}
+
+	if len(pins) > 1 {
+		return nil, newStatusError(http.StatusBadRequest, "only one of commit, tag and chartVersion can be set")
+	}
+
+	chobj, err := listener.getSubscriptionChannel(*sub)
+	if err != nil {
+		return nil, err
+	}
+
+	pin := pins[0]
+	chType := string(chobj.Spec.Type)
+
+	switch pin.Kind {
+	case syncPinCommit, syncPinTag:
+		if !strings.EqualFold(chType, chnv1alpha1.ChannelTypeGit) && !strings.EqualFold(chType, chnv1alpha1.ChannelTypeGitHub) {
+			return nil, newStatusError(http.StatusBadRequest, "%s can only be pinned for Git channels, the channel type is %s", pin.Kind, chType)
+		}
+	case syncPinChartVersion:
+		if !strings.EqualFold(chType, chnv1alpha1.ChannelTypeHelmRepo) {
+			return nil, newStatusError(http.StatusBadRequest, "chartVersion can only be pinned for Helm repo channels, the channel type is %s", chType)
+		}
+
+		if sub.Spec.PackageFilter != nil && len(sub.Spec.PackageFilter.Packages) > 0 {
+			return nil, newStatusError(http.StatusBadRequest, "chartVersion cannot be pinned for subscriptions of multiple charts")
+		}
+	}
+
+	return &pin, nil
+}
+
+// pinSubscription sets the pinned field and returns its previous value
+func pinSubscription(sub *appv1alpha1.Subscription, annotations map[string]string, kind, value string) string {
+	previous := ""
+
+	switch kind {
+	case syncPinCommit:
+		previous = annotations[appv1alpha1.AnnotationGitTargetCommit]
+		annotations[appv1alpha1.AnnotationGitTargetCommit] = value
+	case syncPinTag:
+		previous = annotations[appv1alpha1.AnnotationGitTag]
+		annotations[appv1alpha1.AnnotationGitTag] = value
+	case syncPinChartVersion:
+		if sub.Spec.PackageFilter == nil {
+			sub.Spec.PackageFilter = &appv1alpha1.PackageFilter{}
+		}
+
+		previous = sub.Spec.PackageFilter.Version
+		sub.Spec.PackageFilter.Version = value
+	}
+
+	return previous
+}
+
+// unpinSubscription restores the previous value of the field pinned by a manual sync request, unless the field was
+// changed since
+func unpinSubscription(sub *appv1alpha1.Subscription, annotations map[string]string, pinJSON string) {
+	pin := syncPin{}
+
+	if err := json.Unmarshal([]byte(pinJSON), &pin); err != nil {
+		klog.Info("Failed to parse the manual sync pin annotation. error: ", err)
+		return
+	}
+
+	restore := func(key string) {
+		if annotations[key] != pin.Value {
+			return
+		}
+
+		if pin.Previous == "" {
+			delete(annotations, key)
+		} else {
+			annotations[key] = pin.Previous
+		}
+	}
+
+	switch pin.Kind {
+	case syncPinCommit:
+		restore(appv1alpha1.AnnotationGitTargetCommit)
+	case syncPinTag:
+		restore(appv1alpha1.AnnotationGitTag)
+	case syncPinChartVersion:
+		if sub.Spec.PackageFilter != nil && sub.Spec.PackageFilter.Version == pin.Value {
+			sub.Spec.PackageFilter.Version = pin.Previous
+		}
+	}
+}
+
+// getSyncStatus returns the phase of a manual sync. The sync is done when the subscription status is updated after
+// the sync was requested and the pinned commit, tag or chart version is deployed. The status update time only has a
+// precision of seconds, an update in the same second as the request counts.
+func getSyncStatus(sub *appv1alpha1.Subscription, syncID string) (*SyncResponse, error) {
+	if syncID == "" {
+		return nil, newStatusError(http.StatusBadRequest, "no syncID query parameter")
+	}
+
+	resp := &SyncResponse{SyncID: syncID, Namespace: sub.Namespace, Name: sub.Name}
+	annotations := sub.GetAnnotations()
+
+	if annotations[appv1alpha1.AnnotationManualSyncID] != syncID {
+		resp.Phase = SyncPhaseSuperseded
+		resp.Message = "the subscription was synced again with sync ID " + annotations[appv1alpha1.AnnotationManualSyncID]
+
+		return resp, nil
+	}
+
+	requested, err := time.Parse(time.RFC3339Nano, annotations[appv1alpha1.AnnotationManualReconcileTime])
+	if err != nil {
+		return nil, newStatusError(http.StatusConflict, "invalid manual refresh time of the subscription: %v", err)
+	}
+
+	resp.Phase = SyncPhasePending
+
+	if sub.Status.LastUpdateTime.Time.Before(requested.Truncate(time.Second)) {
+		return resp, nil
+	}
+
+	resp.Message = sub.Status.Message
+
+	pin := getSyncPin(sub)
+
+	switch {
+	case sub.Status.Phase == appv1alpha1.SubscriptionFailed || sub.Status.Phase == appv1alpha1.SubscriptionPropagationFailed:
+		resp.Phase = SyncPhaseFailed
+	case pin != nil && !isPinDeployed(sub, pin):
+		resp.Message = fmt.Sprintf("the pinned %s %s is not deployed yet", pin.Kind, pin.Value)
+	default:
+		resp.Phase = SyncPhaseCompleted
+	}
+
+	return resp, nil
+}
+
+// isPinDeployed returns true if the commit, tag or chart version pinned by the manual sync is deployed. The hub
+// records the deployed commit and tag of Git subscriptions, a subscription without a recorded commit, like a
+// standalone subscription, is not checked. The chart versions are reported per package by the clusters.
+func isPinDeployed(sub *appv1alpha1.Subscription, pin *syncPin) bool {
+	annotations := sub.GetAnnotations()
+	deployedCommit := annotations[appv1alpha1.AnnotationGitCommit]
+
+	switch pin.Kind {
+	case syncPinCommit:
+		return deployedCommit == "" || strings.HasPrefix(deployedCommit, pin.Value)
+	case syncPinTag:
+		return deployedCommit == "" || annotations[appv1alpha1.AnnotationGitCurrentTag] == pin.Value
+	case syncPinChartVersion:
+		deployed := false
+
+		for _, clst := range sub.Status.Statuses {
+			if clst == nil {
+				continue
+			}
+
+			for _, pkg := range clst.SubscriptionPackageStatus {
+				if pkg == nil || pkg.ChartVersion == "" {
+					continue
+				}
+
+				if !utils.ChartVersionInRange(pkg.ChartVersion, pin.Value) {
+					return false
+				}
+
+				deployed = true
+			}
+		}
+
+		return deployed
+	}
+
+	return true
+}
+
+// getSyncPin returns the pin of the manual sync, nil if the sync has no pin
+func getSyncPin(sub *appv1alpha1.Subscription) *syncPin {
+	pinJSON := sub.GetAnnotations()[appv1alpha1.AnnotationManualSyncPin]
+	if pinJSON == "" {
+		return nil
+	}
+
+	pin := &syncPin{}
+	if err := json.Unmarshal([]byte(pinJSON), pin); err != nil {
+		klog.Info("Failed to parse the manual sync pin annotation. error: ", err)
+		return nil
+	}
+
+	return pin
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+// newSyncListener returns a listener with a subscription of a Git channel. The token of the ci user can update the
+// subscription and list all the subscriptions, the token of the viewer user can only get the subscription.
+func newSyncListener(g *gomega.GomegaWithT) *WebhookListener {
+	scheme := runtime.NewScheme()
+	g.Expect(appv1alpha1.SchemeBuilder.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(chnv1alpha1.SchemeBuilder.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(corev1.AddToScheme(scheme)).To(gomega.Succeed())
+
+	channel := &chnv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Name: "git-channel", Namespace: "channels"},
+		Spec:       chnv1alpha1.ChannelSpec{Type: chnv1alpha1.ChannelTypeGit, Pathname: "https://github.com/example/apps.git"},
+	}
+
+	sub := &appv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "default"},
+		Spec:       appv1alpha1.SubscriptionSpec{Channel: "channels/git-channel"},
+	}
+
+	c := fake.NewFakeClientWithScheme(scheme, channel, sub)
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+
+		switch review.Spec.Token {
+		case "ci-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "ci"}}
+		case "viewer-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "viewer"}}
+		}
+
+		return true, review, nil
+	})
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+
+		review.Status.Allowed = attrs.Group == appv1alpha1.SchemeGroupVersion.Group && attrs.Resource == "subscriptions" &&
+			((attrs.Namespace == "default" && attrs.Name == "apps" &&
+				(review.Spec.User == "ci" || (review.Spec.User == "viewer" && attrs.Verb == "get"))) ||
+				(attrs.Namespace == "" && attrs.Name == "" && attrs.Verb == "list" && review.Spec.User == "ci"))
+
+		return true, review, nil
+	})
+
+	return &WebhookListener{LocalClient: c, RemoteClient: c, KubeClient: kubeClient}
+}
+
+func doSyncRequest(listener *WebhookListener, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.TLS = &tls.ConnectionState{}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	listener.HandleSync(rr, req)
+
+	return rr
+}
+
+func TestManualSync(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	listener := newSyncListener(g)
+	key := types.NamespacedName{Name: "apps", Namespace: "default"}
+
+	g.Expect(doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "", "").Code).To(gomega.Equal(http.StatusUnauthorized))
+	g.Expect(doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "bad-token", "").Code).To(gomega.Equal(http.StatusUnauthorized))
+	g.Expect(doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "viewer-token", "").Code).To(gomega.Equal(http.StatusForbidden))
+	g.Expect(doSyncRequest(listener, http.MethodPost, "/sync/default", "ci-token", "").Code).To(gomega.Equal(http.StatusNotFound))
+
+	// The bearer tokens are only accepted over TLS
+	req := httptest.NewRequest(http.MethodPost, "/sync/default/apps", nil)
+	req.Header.Set("Authorization", "Bearer ci-token")
+
+	rr := httptest.NewRecorder()
+	listener.HandleSync(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusForbidden))
+
+	rr = doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "ci-token", `{"tag": "`+strings.Repeat("v", maxSyncRequestBytes)+`"}`)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusBadRequest))
+
+	// Pin a tag
+	rr = doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "ci-token", `{"tag": "v1.0.0"}`)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusAccepted))
+
+	resp := SyncResponse{}
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(gomega.Succeed())
+	g.Expect(resp.SyncID).NotTo(gomega.BeEmpty())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhasePending))
+
+	firstSyncID := resp.SyncID
+
+	sub := &appv1alpha1.Subscription{}
+	g.Expect(listener.LocalClient.Get(context.TODO(), key, sub)).To(gomega.Succeed())
+	g.Expect(sub.GetAnnotations()[appv1alpha1.AnnotationGitTag]).To(gomega.Equal("v1.0.0"))
+	g.Expect(sub.GetAnnotations()[appv1alpha1.AnnotationManualSyncID]).To(gomega.Equal(firstSyncID))
+	g.Expect(sub.GetAnnotations()[appv1alpha1.AnnotationManualReconcileTime]).NotTo(gomega.BeEmpty())
+
+	// Chart versions can not be pinned for Git channels
+	rr = doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "ci-token", `{"chartVersion": "1.0.0"}`)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusBadRequest))
+
+	rr = doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "ci-token", `{"commit": "abc", "tag": "v1.0.0"}`)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusBadRequest))
+
+	// The next sync removes the pin
+	rr = doSyncRequest(listener, http.MethodPost, "/sync/default/apps", "ci-token", "")
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusAccepted))
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(gomega.Succeed())
+
+	sub = &appv1alpha1.Subscription{}
+	g.Expect(listener.LocalClient.Get(context.TODO(), key, sub)).To(gomega.Succeed())
+	g.Expect(sub.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationGitTag))
+	g.Expect(sub.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationManualSyncPin))
+	g.Expect(sub.GetAnnotations()[appv1alpha1.AnnotationManualSyncID]).To(gomega.Equal(resp.SyncID))
+
+	// Poll the sync status
+	rr = doSyncRequest(listener, http.MethodGet, "/sync/default/apps?syncID="+firstSyncID, "viewer-token", "")
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(gomega.Succeed())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhaseSuperseded))
+
+	syncID := sub.GetAnnotations()[appv1alpha1.AnnotationManualSyncID]
+
+	rr = doSyncRequest(listener, http.MethodGet, "/sync/default/apps?syncID="+syncID, "viewer-token", "")
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(gomega.Succeed())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhasePending))
+
+	sub.Status.Phase = appv1alpha1.SubscriptionPropagated
+	sub.Status.LastUpdateTime = metav1.NewTime(time.Now().Add(time.Minute))
+	g.Expect(listener.LocalClient.Status().Update(context.TODO(), sub)).To(gomega.Succeed())
+
+	rr = doSyncRequest(listener, http.MethodGet, "/sync/default/apps?syncID="+syncID, "viewer-token", "")
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(gomega.Succeed())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhaseCompleted))
+}
+
+func TestGetSyncStatusRefreshTime(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	requested := time.Date(2021, 3, 1, 10, 0, 0, 500000000, time.UTC)
+
+	sub := &appv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			appv1alpha1.AnnotationManualSyncID:        "sync-1",
+			appv1alpha1.AnnotationManualReconcileTime: requested.Format(time.RFC3339Nano),
+		}},
+	}
+
+	sub.Status.Phase = appv1alpha1.SubscriptionSubscribed
+	sub.Status.LastUpdateTime = metav1.NewTime(requested.Add(-time.Second))
+
+	resp, err := getSyncStatus(sub, "sync-1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhasePending))
+
+	// The status update time is stored with a precision of seconds
+	sub.Status.LastUpdateTime = metav1.NewTime(requested.Truncate(time.Second))
+
+	resp, err = getSyncStatus(sub, "sync-1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhaseCompleted))
+
+	// The refresh time set by the webhooks of older versions has a precision of seconds
+	sub.Annotations[appv1alpha1.AnnotationManualReconcileTime] = requested.Format(time.RFC3339)
+
+	resp, err = getSyncStatus(sub, "sync-1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.Phase).To(gomega.Equal(SyncPhaseCompleted))
+}
+
+func TestIsPinDeployed(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{appv1alpha1.AnnotationGitCommit: "abc123"}},
+	}
+
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinCommit, Value: "abc"})).To(gomega.BeTrue())
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinCommit, Value: "def"})).To(gomega.BeFalse())
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinTag, Value: "v1.0.0"})).To(gomega.BeFalse())
+
+	sub.Annotations[appv1alpha1.AnnotationGitCurrentTag] = "v1.0.0"
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinTag, Value: "v1.0.0"})).To(gomega.BeTrue())
+
+	// The chart version must be reported by all the clusters
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinChartVersion, Value: "1.2.3"})).To(gomega.BeFalse())
+
+	sub.Status.Statuses = appv1alpha1.SubscriptionClusterStatusMap{
+		"cluster1": &appv1alpha1.SubscriptionPerClusterStatus{
+			SubscriptionPackageStatus: map[string]*appv1alpha1.SubscriptionUnitStatus{"nginx": {ChartVersion: "1.2.3"}},
+		},
+		"cluster2": &appv1alpha1.SubscriptionPerClusterStatus{
+			SubscriptionPackageStatus: map[string]*appv1alpha1.SubscriptionUnitStatus{"nginx": {ChartVersion: "1.2.0"}},
+		},
+	}
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinChartVersion, Value: "1.2.3"})).To(gomega.BeFalse())
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinChartVersion, Value: ">=1.2.0"})).To(gomega.BeTrue())
+
+	sub.Status.Statuses["cluster2"].SubscriptionPackageStatus["nginx"].ChartVersion = "1.2.3"
+	g.Expect(isPinDeployed(sub, &syncPin{Kind: syncPinChartVersion, Value: "1.2.3"})).To(gomega.BeTrue())
+}
+
+func TestManualSyncPin(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &appv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{appv1alpha1.AnnotationGitTargetCommit: "abc"}},
+		Spec:       appv1alpha1.SubscriptionSpec{PackageFilter: &appv1alpha1.PackageFilter{Version: ">=1.0.0"}},
+	}
+	annotations := sub.GetAnnotations()
+
+	// The previous values are restored
+	g.Expect(pinSubscription(sub, annotations, syncPinCommit, "def")).To(gomega.Equal("abc"))
+	unpinSubscription(sub, annotations, `{"kind": "commit", "value": "def", "previous": "abc"}`)
+	g.Expect(annotations[appv1alpha1.AnnotationGitTargetCommit]).To(gomega.Equal("abc"))
+
+	g.Expect(pinSubscription(sub, annotations, syncPinChartVersion, "1.2.3")).To(gomega.Equal(">=1.0.0"))
+	unpinSubscription(sub, annotations, `{"kind": "chart-version", "value": "1.2.3", "previous": ">=1.0.0"}`)
+	g.Expect(sub.Spec.PackageFilter.Version).To(gomega.Equal(">=1.0.0"))
+
+	// Fields changed since the pin are kept
+	g.Expect(pinSubscription(sub, annotations, syncPinTag, "v1.0.0")).To(gomega.BeEmpty())
+	annotations[appv1alpha1.AnnotationGitTag] = "v2.0.0"
+	unpinSubscription(sub, annotations, `{"kind": "tag", "value": "v1.0.0"}`)
+	g.Expect(annotations[appv1alpha1.AnnotationGitTag]).To(gomega.Equal("v2.0.0"))
+}
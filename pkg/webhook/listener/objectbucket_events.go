@@ -0,0 +1,217 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/klog"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	awsutils "github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils/aws"
+)
+
+const (
+	objectBucketWebhookPath = "/webhook/objectbucket"
+	s3ObjectCreatedEvent    = "s3:ObjectCreated:"
+	s3ObjectRemovedEvent    = "s3:ObjectRemoved:"
+	// objectBucketEndpointParam is the query parameter of the payload URL with the endpoint of the object store
+	objectBucketEndpointParam = "endpoint"
+	// minioOriginEndpoint is the response element of MinIO events with the endpoint of the object store
+	minioOriginEndpoint = "x-minio-origin-endpoint"
+)
+
+// ObjectBucketPayload is the payload of S3 event notifications, sent by MinIO and Ceph webhook targets
+type ObjectBucketPayload struct {
+	Records []ObjectBucketRecord `json:"Records"`
+	// Endpoint is the endpoint of the object store set in the payload URL
+	Endpoint string `json:"-"`
+}
+
+type ObjectBucketRecord struct {
+	EventName        string            `json:"eventName"`
+	ResponseElements map[string]string `json:"responseElements,omitempty"`
+	S3               struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// bucketChange is the objects changed by a bucket event
+type bucketChange struct {
+	// keys are the keys of the created and removed objects
+	keys []string
+}
+
+// matches returns true if a changed object is in the bucket path of the subscription
+func (c bucketChange) matches(sub *appv1alpha1.Subscription) bool {
+	folder := sub.GetAnnotations()[appv1alpha1.AnnotationBucketPath]
+	if folder == "" {
+		return true
+	}
+
+	prefix := strings.TrimSuffix(folder, "/") + "/"
+
+	for _, key := range c.keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// objectBucketProvider handles the object created and removed events of S3 compatible object stores
+type objectBucketProvider struct{}
+
+func (objectBucketProvider) Name() string {
+	return "Object bucket"
+}
+
+func (objectBucketProvider) ChannelType() chnv1alpha1.ChannelType {
+	return chnv1alpha1.ChannelTypeObjectBucket
+}
+
+func (objectBucketProvider) Matches(r *http.Request) bool {
+	return r.URL != nil && r.URL.Path == objectBucketWebhookPath
+}
+
+func (objectBucketProvider) ParseEvent(r *http.Request) (*WebhookEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(body) == 0 {
+		klog.Error("Failed to parse the payload: ", err)
+		return nil, errors.New("failed to parse the payload")
+	}
+
+	var payload ObjectBucketPayload
+	err = json.Unmarshal(body, &payload)
+
+	if err != nil {
+		klog.Error("Failed to parse the webhook event payload. error: ", err)
+		return nil, err
+	}
+
+	// Only keep the created and removed objects
+	records := []ObjectBucketRecord{}
+	change := bucketChange{}
+
+	for _, record := range payload.Records {
+		if !strings.HasPrefix(record.EventName, s3ObjectCreatedEvent) && !strings.HasPrefix(record.EventName, s3ObjectRemovedEvent) {
+			klog.Infof("Unhandled webhook event %s\n", record.EventName)
+			continue
+		}
+
+		// The object keys are URL encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+
+		records = append(records, record)
+		change.keys = append(change.keys, key)
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	payload.Records = records
+	payload.Endpoint = r.URL.Query().Get(objectBucketEndpointParam)
+
+	klog.Infof("Handling object bucket webhook event %s of bucket %s", records[0].EventName, records[0].S3.Bucket.Name)
+
+	return &WebhookEvent{
+		Type:      records[0].EventName,
+		Repo:      records[0].S3.Bucket.Name,
+		Body:      body,
+		Signature: bearerToken(r),
+		Payload:   payload,
+		change:    change,
+	}, nil
+}
+
+// MatchesRepo returns true if the bucket of the channel has a changed object. The object store endpoint is the
+// endpoint query parameter of the payload URL, or the origin endpoint of MinIO events, and it must be the endpoint
+// of the channel.
+func (objectBucketProvider) MatchesRepo(event *WebhookEvent, pathname string) bool {
+	payload, ok := event.Payload.(ObjectBucketPayload)
+	if !ok {
+		return false
+	}
+
+	endpoint, bucket, err := awsutils.ParseObjectBucketPath(pathname)
+	if err != nil {
+		return false
+	}
+
+	for _, record := range payload.Records {
+		if record.S3.Bucket.Name != bucket {
+			continue
+		}
+
+		eventEndpoint := payload.Endpoint
+		if eventEndpoint == "" {
+			eventEndpoint = record.ResponseElements[minioOriginEndpoint]
+		}
+
+		if sameEndpoint(eventEndpoint, endpoint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameEndpoint returns true if the URLs have the same host and port, the default port of the scheme if they have none
+func sameEndpoint(a, b string) bool {
+	hostPort := func(endpoint string) string {
+		u, err := url.Parse(strings.TrimSpace(endpoint))
+		if err != nil || u.Hostname() == "" {
+			return ""
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "443"
+
+			if strings.EqualFold(u.Scheme, "http") {
+				port = "80"
+			}
+		}
+
+		return strings.ToLower(u.Hostname()) + ":" + port
+	}
+
+	host := hostPort(a)
+
+	return host != "" && host == hostPort(b)
+}
+
+// ValidateSecret compares the token of the Authorization header with the channel's webhook secret. The events of
+// channels without a webhook secret are rejected.
+func (objectBucketProvider) ValidateSecret(event *WebhookEvent, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(event.Signature), []byte(secret)) == 1
+}
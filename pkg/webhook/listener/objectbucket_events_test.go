@@ -0,0 +1,106 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/onsi/gomega"
+
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const minioPutObjectPayload = `{
+  "EventName": "s3:ObjectCreated:Put",
+  "Key": "apps/frontend/deployment+v2.yaml",
+  "Records": [
+    {
+      "eventVersion": "2.0",
+      "eventSource": "minio:s3",
+      "awsRegion": "",
+      "eventTime": "2021-05-12T09:30:12.418Z",
+      "eventName": "s3:ObjectCreated:Put",
+      "userIdentity": {"principalId": "minio"},
+      "s3": {
+        "s3SchemaVersion": "1.0",
+        "configurationId": "Config",
+        "bucket": {"name": "apps", "ownerIdentity": {"principalId": "minio"}, "arn": "arn:aws:s3:::apps"},
+        "object": {"key": "frontend%2Fdeployment%2Bv2.yaml", "size": 512, "eTag": "0d7c23d7b6a5d2c1e8d5d5b3a5f2c1e8"}
+      },
+      "responseElements": {"x-minio-origin-endpoint": "http://minio.example.com:9000"}
+    }
+  ]
+}`
+
+func TestObjectBucketProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", objectBucketWebhookPath, bytes.NewBufferString(minioPutObjectPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer minio-secret")
+
+	provider := getWebhookProvider(req)
+	g.Expect(provider).To(gomega.Equal(objectBucketProvider{}))
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.Type).To(gomega.Equal("s3:ObjectCreated:Put"))
+	g.Expect(event.change.(bucketChange).keys).To(gomega.Equal([]string{"frontend/deployment+v2.yaml"}))
+
+	g.Expect(provider.MatchesRepo(event, "http://minio.example.com:9000/apps")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "http://MINIO.example.com:9000/apps/")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "http://minio.example.com:9000/apps-config")).To(gomega.BeFalse())
+
+	// The bucket of another object store is not the bucket of the event
+	g.Expect(provider.MatchesRepo(event, "https://s3.amazonaws.com/apps/")).To(gomega.BeFalse())
+
+	g.Expect(provider.ValidateSecret(event, "minio-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	// Channels without a webhook secret do not accept events
+	event.Signature = ""
+	g.Expect(provider.ValidateSecret(event, "")).To(gomega.BeFalse())
+
+	// The endpoint of the payload URL takes precedence over the origin endpoint of the event
+	req, err = http.NewRequest("POST", objectBucketWebhookPath+"?endpoint=https://s3.amazonaws.com",
+		bytes.NewBufferString(minioPutObjectPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	event, err = provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(provider.MatchesRepo(event, "https://s3.amazonaws.com/apps/")).To(gomega.BeTrue())
+	g.Expect(provider.MatchesRepo(event, "http://minio.example.com:9000/apps")).To(gomega.BeFalse())
+
+	g.Expect(event.change.matches(newFilterSubscription(nil))).To(gomega.BeTrue())
+	g.Expect(event.change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationBucketPath: "frontend",
+	}))).To(gomega.BeTrue())
+	g.Expect(event.change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationBucketPath: "backend/",
+	}))).To(gomega.BeFalse())
+
+	// Other events do not trigger subscriptions
+	req, err = http.NewRequest("POST", objectBucketWebhookPath,
+		bytes.NewBufferString(`{"Records": [{"eventName": "s3:ObjectAccessed:Get", "s3": {"bucket": {"name": "apps"}}}]}`))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	event, err = provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event).To(gomega.BeNil())
+}
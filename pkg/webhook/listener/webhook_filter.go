@@ -0,0 +1,249 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/utils"
+)
+
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+	// maxPayloadCommits is the number of commits GitHub and GitLab list in push payloads
+	maxPayloadCommits = 20
+)
+
+// localGitFolder returns the local clone of the Git repo of a subscription
+var localGitFolder = utils.GetLocalGitFolder
+
+// errReferencingSource stops the walk of a Git path at the first source referencing files outside the path
+var errReferencingSource = errors.New("the Git path has a source referencing files outside the path")
+
+// gitChange is the Git refs and files changed by a webhook event
+type gitChange struct {
+	// refs are the full names of the changed branches and tags, like refs/heads/main. The event applies to all
+	// branches when there is no ref.
+	refs []string
+	// files are the paths of the changed files relative to the repo root. The event applies to all paths when
+	// files is nil, because the payload does not list all the changed files.
+	files []string
+}
+
+// branchRef returns the full name of a branch
+func branchRef(branch string) string {
+	if branch == "" || strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+
+	return branchRefPrefix + branch
+}
+
+// addCommitFiles adds the files of a commit of a push payload
+func (c *gitChange) addCommitFiles(added, modified, removed []string) {
+	if c.files == nil {
+		c.files = []string{}
+	}
+
+	c.files = append(c.files, added...)
+	c.files = append(c.files, modified...)
+	c.files = append(c.files, removed...)
+}
+
+// githubChange returns the change of a GitHub push or pull request event
+func githubChange(event interface{}) gitChange {
+	change := gitChange{}
+
+	switch e := event.(type) {
+	case *github.PushEvent:
+		change.refs = []string{e.GetRef()}
+
+		// Created and deleted branches, force pushes and large pushes do not list all the changed files
+		if e.GetCreated() || e.GetDeleted() || e.GetForced() || len(e.Commits) == 0 || len(e.Commits) >= maxPayloadCommits {
+			return change
+		}
+
+		for _, commit := range e.Commits {
+			change.addCommitFiles(commit.Added, commit.Modified, commit.Removed)
+		}
+	case *github.PullRequestEvent:
+		change.refs = []string{branchRef(e.GetPullRequest().GetBase().GetRef())}
+	}
+
+	return change
+}
+
+// matches returns true if the change is on the branch or tag of the subscription and in one of its paths
+func (c gitChange) matches(sub *appv1alpha1.Subscription) bool {
+	return c.matchesRef(sub) && c.matchesPaths(sub)
+}
+
+// matchesRef returns true if one of the refs is the branch of the subscription, or the tag of the subscription
+func (c gitChange) matchesRef(sub *appv1alpha1.Subscription) bool {
+	if len(c.refs) == 0 {
+		return true
+	}
+
+	branch := utils.GetSubscriptionBranch(sub).String()
+	tag := sub.GetAnnotations()[appv1alpha1.AnnotationGitTag]
+
+	for _, ref := range c.refs {
+		if ref == "" || ref == branch {
+			return true
+		}
+
+		if tag != "" && ref == tagRefPrefix+tag {
+			return true
+		}
+	}
+
+	klog.V(2).Infof("The webhook event refs %v are not the branch %s of subscription %s/%s", c.refs, branch, sub.Namespace, sub.Name)
+
+	return false
+}
+
+// matchesPaths returns true if one of the changed files is in one of the Git paths of the subscription
+func (c gitChange) matchesPaths(sub *appv1alpha1.Subscription) bool {
+	if c.files == nil {
+		return true
+	}
+
+	// The path of the filterRef ConfigMap is not known here, all the changes apply to the subscription
+	if sub.Spec.PackageFilter != nil && sub.Spec.PackageFilter.FilterRef != nil {
+		return true
+	}
+
+	gitPaths, err := utils.GetGitPaths(sub, nil)
+	if err != nil {
+		klog.Warning("Failed to get the Git paths of subscription ", sub.Namespace, "/", sub.Name, ", err: ", err)
+
+		return true
+	}
+
+	for _, gitPath := range gitPaths {
+		for _, file := range c.files {
+			if isInGitPath(gitPath.Path, file) {
+				return true
+			}
+		}
+	}
+
+	// The sources that reference files outside their Git path are rendered again on any change
+	repoRoot := localGitFolder(nil, sub)
+
+	for _, gitPath := range gitPaths {
+		if hasReferencingSources(repoRoot, gitPath.Path) {
+			klog.V(2).Infof("The Git path %s of subscription %s/%s may reference the changed files", gitPath.Path, sub.Namespace, sub.Name)
+
+			return true
+		}
+	}
+
+	klog.V(2).Infof("The webhook event changed no file in the Git paths of subscription %s/%s", sub.Namespace, sub.Name)
+
+	return false
+}
+
+// isInGitPath returns true if the file is the Git path or in the Git path. A Git path glob matches the file or one of
+// its parent directories.
+func isInGitPath(gitPath, file string) bool {
+	gitPath = strings.Trim(path.Clean("/"+gitPath), "/")
+	file = strings.Trim(path.Clean("/"+file), "/")
+
+	if gitPath == "" {
+		return true
+	}
+
+	if !strings.ContainsAny(gitPath, "*?[") {
+		return file == gitPath || strings.HasPrefix(file, gitPath+"/")
+	}
+
+	parts := strings.Split(file, "/")
+
+	for i := range parts {
+		if matched, err := path.Match(gitPath, strings.Join(parts[:i+1], "/")); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasReferencingSources returns true if the Git path in the local clone of the repo has a source that can reference
+// files outside the Git path: a kustomization with a relative base or component, a Jsonnet or CUE entrypoint, whose
+// imports resolve from the vendor and module directories of the repo, or a Helm chart with a file:// dependency. It
+// also returns true if there is no local clone to look at. The references themselves are not resolved.
+func hasReferencingSources(repoRoot, gitPath string) bool {
+	if _, err := os.Stat(repoRoot); err != nil {
+		return true
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(repoRoot, filepath.FromSlash(strings.Trim(gitPath, "/"))))
+	if err != nil {
+		return true
+	}
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && isReferencingSource(file, info.Name()) {
+				return errReferencingSource
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReferencingSource returns true if the file is a source that can reference files outside its directory
+func isReferencingSource(file, name string) bool {
+	switch name {
+	case utils.JsonnetEntrypoint, utils.CueEntrypoint:
+		return true
+	case "kustomization.yaml", "kustomization.yml", "Kustomization":
+		content, err := ioutil.ReadFile(filepath.Clean(file))
+
+		return err != nil || strings.Contains(string(content), "..")
+	case "Chart.yaml":
+		content, err := ioutil.ReadFile(filepath.Clean(file))
+
+		return err != nil || strings.Contains(string(content), "file://")
+	}
+
+	return false
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+const (
+	githubPushPayload = `{
+  "ref": "refs/heads/main",
+  "commits": [
+    {"id": "1", "added": ["apps/frontend/deployment.yaml"], "removed": [], "modified": []},
+    {"id": "2", "added": [], "removed": ["docs/old.md"], "modified": ["README.md"]}
+  ],
+  "repository": {"full_name": "example/monorepo"}
+}`
+
+	gitlabPushPayload = `{
+  "object_kind": "push",
+  "ref": "refs/heads/main",
+  "total_commits_count": 1,
+  "commits": [
+    {"id": "1", "added": [], "modified": ["apps/backend/service.yaml"], "removed": []}
+  ],
+  "repository": {"homepage": "https://gitlab.com/example/monorepo"}
+}`
+
+	gitlabMergeRequestPayload = `{
+  "object_kind": "merge_request",
+  "object_attributes": {"source_branch": "feature", "target_branch": "release"},
+  "repository": {"homepage": "https://gitlab.com/example/monorepo"}
+}`
+
+	bitbucketPushPayload = `{
+  "push": {
+    "changes": [
+      {"new": {"type": "branch", "name": "main"}, "old": {"type": "branch", "name": "main"}},
+      {"new": null, "old": {"type": "tag", "name": "v1.0.0"}}
+    ]
+  },
+  "repository": {"full_name": "example/monorepo"}
+}`
+)
+
+func newFilterSubscription(annotations map[string]string) *appv1alpha1.Subscription {
+	return &appv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "default", Annotations: annotations},
+	}
+}
+
+func TestIsInGitPath(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(isInGitPath("", "README.md")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("apps/frontend", "apps/frontend/deployment.yaml")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("/apps/frontend/", "apps/frontend/deployment.yaml")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("apps/frontend/deployment.yaml", "apps/frontend/deployment.yaml")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("apps/frontend", "apps/frontend-v2/deployment.yaml")).To(gomega.BeFalse())
+	g.Expect(isInGitPath("apps/frontend", "docs/frontend.md")).To(gomega.BeFalse())
+	g.Expect(isInGitPath("apps/*", "apps/backend/service.yaml")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("apps/*/service.yaml", "apps/backend/service.yaml")).To(gomega.BeTrue())
+	g.Expect(isInGitPath("apps/*/service.yaml", "apps/backend/deployment.yaml")).To(gomega.BeFalse())
+}
+
+func TestGitChangeMatches(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	repoRoot, err := ioutil.TempDir("", "webhook-filter")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	defer os.RemoveAll(repoRoot)
+
+	defer func(resolver func(*chnv1alpha1.Channel, *appv1alpha1.Subscription) string) { localGitFolder = resolver }(localGitFolder)
+	localGitFolder = func(*chnv1alpha1.Channel, *appv1alpha1.Subscription) string { return repoRoot }
+
+	change := gitChange{refs: []string{"refs/heads/main"}, files: []string{"apps/frontend/deployment.yaml", "README.md"}}
+
+	// The default branch is master
+	g.Expect(change.matches(newFilterSubscription(nil))).To(gomega.BeFalse())
+
+	g.Expect(change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGitBranch: "main",
+	}))).To(gomega.BeTrue())
+
+	g.Expect(change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGitBranch: "main",
+		appv1alpha1.AnnotationGitPath:   "apps/frontend",
+	}))).To(gomega.BeTrue())
+
+	g.Expect(change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGitBranch: "main",
+		appv1alpha1.AnnotationGitPath:   "apps/backend",
+	}))).To(gomega.BeFalse())
+
+	g.Expect(change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGithubBranch: "main",
+		appv1alpha1.AnnotationGitPaths:     "- apps/backend\n- path: apps/frontend\n  exclude: [\"*.md\"]\n",
+	}))).To(gomega.BeTrue())
+
+	// The kustomizations, Jsonnet and CUE sources and Helm charts of the path can reference the changed files
+	writeRepoFile := func(file, content string) {
+		g.Expect(os.MkdirAll(filepath.Dir(filepath.Join(repoRoot, file)), 0750)).To(gomega.Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(repoRoot, file), []byte(content), 0600)).To(gomega.Succeed())
+	}
+
+	backend := newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGitBranch: "main",
+		appv1alpha1.AnnotationGitPath:   "apps/backend",
+	})
+
+	writeRepoFile("apps/backend/kustomization.yaml", "resources:\n- service.yaml\n")
+	g.Expect(change.matches(backend)).To(gomega.BeFalse())
+
+	writeRepoFile("apps/backend/kustomization.yaml", "resources:\n- ../frontend\n")
+	g.Expect(change.matches(backend)).To(gomega.BeTrue())
+
+	g.Expect(os.RemoveAll(filepath.Join(repoRoot, "apps"))).To(gomega.Succeed())
+	writeRepoFile("apps/backend/chart/Chart.yaml", "dependencies:\n- name: frontend\n  repository: file://../../frontend\n")
+	g.Expect(change.matches(backend)).To(gomega.BeTrue())
+
+	g.Expect(os.RemoveAll(filepath.Join(repoRoot, "apps"))).To(gomega.Succeed())
+	writeRepoFile("apps/backend/main.jsonnet", "import '../lib.libsonnet'")
+	g.Expect(change.matches(backend)).To(gomega.BeTrue())
+
+	g.Expect(os.RemoveAll(filepath.Join(repoRoot, "apps"))).To(gomega.Succeed())
+
+	// The path of the filterRef ConfigMap is not known
+	sub := newFilterSubscription(map[string]string{appv1alpha1.AnnotationGitBranch: "main"})
+	sub.Spec.PackageFilter = &appv1alpha1.PackageFilter{FilterRef: &corev1.LocalObjectReference{Name: "filter"}}
+	sub.SetAnnotations(map[string]string{appv1alpha1.AnnotationGitBranch: "main", appv1alpha1.AnnotationGitPath: "apps/backend"})
+	g.Expect(change.matches(sub)).To(gomega.BeTrue())
+
+	// All paths match when the changed files are not known
+	change.files = nil
+	g.Expect(change.matches(newFilterSubscription(map[string]string{
+		appv1alpha1.AnnotationGitBranch: "main",
+		appv1alpha1.AnnotationGitPath:   "apps/backend",
+	}))).To(gomega.BeTrue())
+
+	// Tags match the tag of the subscription
+	change = gitChange{refs: []string{"refs/tags/v1.0.0"}}
+	g.Expect(change.matches(newFilterSubscription(map[string]string{appv1alpha1.AnnotationGitTag: "v1.0.0"}))).To(gomega.BeTrue())
+	g.Expect(change.matches(newFilterSubscription(map[string]string{appv1alpha1.AnnotationGitTag: "v2.0.0"}))).To(gomega.BeFalse())
+
+	// All branches match without ref
+	g.Expect(gitChange{}.matches(newFilterSubscription(nil))).To(gomega.BeTrue())
+}
+
+func TestGithubChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	event := &github.PushEvent{}
+	g.Expect(json.Unmarshal([]byte(githubPushPayload), event)).To(gomega.Succeed())
+
+	change := githubChange(event)
+	g.Expect(change.refs).To(gomega.Equal([]string{"refs/heads/main"}))
+	g.Expect(change.files).To(gomega.ConsistOf("apps/frontend/deployment.yaml", "docs/old.md", "README.md"))
+
+	// The files of force pushes are not known
+	event.Forced = github.Bool(true)
+	g.Expect(githubChange(event).files).To(gomega.BeNil())
+
+	pr := &github.PullRequestEvent{PullRequest: &github.PullRequest{Base: &github.PullRequestBranch{Ref: github.String("release")}}}
+	change = githubChange(pr)
+	g.Expect(change.refs).To(gomega.Equal([]string{"refs/heads/release"}))
+	g.Expect(change.files).To(gomega.BeNil())
+}
+
+func TestGitLabChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	payload := GitLabPayload{}
+	g.Expect(json.Unmarshal([]byte(gitlabPushPayload), &payload)).To(gomega.Succeed())
+
+	change := payload.change(GitLabPushEvents)
+	g.Expect(change.refs).To(gomega.Equal([]string{"refs/heads/main"}))
+	g.Expect(change.files).To(gomega.Equal([]string{"apps/backend/service.yaml"}))
+
+	// The payload lists at most 20 commits
+	payload.TotalCommitsCount = 30
+	g.Expect(payload.change(GitLabPushEvents).files).To(gomega.BeNil())
+
+	payload = GitLabPayload{}
+	g.Expect(json.Unmarshal([]byte(gitlabMergeRequestPayload), &payload)).To(gomega.Succeed())
+
+	change = payload.change(GitLabMergeRequestEvents)
+	g.Expect(change.refs).To(gomega.Equal([]string{"refs/heads/release"}))
+	g.Expect(change.files).To(gomega.BeNil())
+}
+
+func TestBitbucketChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	payload := BitBucketPayload{}
+	g.Expect(json.Unmarshal([]byte(bitbucketPushPayload), &payload)).To(gomega.Succeed())
+
+	change := payload.change(RepoPushEvent)
+	g.Expect(change.refs).To(gomega.Equal([]string{"refs/heads/main", "refs/tags/v1.0.0"}))
+	g.Expect(change.files).To(gomega.BeNil())
+
+	sub := newFilterSubscription(map[string]string{appv1alpha1.AnnotationGitBranch: "main", appv1alpha1.AnnotationGitPath: "apps"})
+	g.Expect(change.matches(sub)).To(gomega.BeTrue())
+	g.Expect(change.matches(newFilterSubscription(nil))).To(gomega.BeFalse())
+}
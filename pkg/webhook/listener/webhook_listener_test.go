@@ -17,9 +17,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -432,7 +434,32 @@ func TestServiceCreation(t *testing.T) {
 
 	os.Setenv("DEPLOYMENT_LABEL", "test-deployment")
 
-	err = createWebhookListnerService(c, "default")
+	err = createWebhookListnerService(c, "default", DefaultPort)
 	// It will fail because the deployment resource for the owner reference is not found in the cluster.
 	g.Expect(err).To(gomega.HaveOccurred())
 }
+
+func TestLimitBody(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	var readErr error
+
+	handler := limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = ioutil.ReadAll(r.Body)
+	}), 8)
+
+	// a request with a larger content length is rejected before it's handled
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader("0123456789")))
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusRequestEntityTooLarge))
+
+	// reading a larger body without content length fails
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader("0123456789"))
+	req.ContentLength = -1
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	g.Expect(readErr).To(gomega.HaveOccurred())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/webhook", strings.NewReader("01234567")))
+	g.Expect(readErr).NotTo(gomega.HaveOccurred())
+}
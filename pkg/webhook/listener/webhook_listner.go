@@ -16,13 +16,16 @@ package listener
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -33,6 +36,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -44,6 +48,19 @@ import (
 )
 
 const (
+	// DefaultPort is the default port of the webhook listener
+	DefaultPort = 8443
+	// shutdownTimeout is how long the listener waits for the requests in progress when it stops
+	shutdownTimeout = 30 * time.Second
+	// readHeaderTimeout, readTimeout, writeTimeout and idleTimeout bound how long a client can hold a connection
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = time.Minute
+	idleTimeout       = 2 * time.Minute
+	// maxHeaderBytes bounds the request headers, the event headers and signatures are small
+	maxHeaderBytes = 64 << 10
+	// maxBodyBytes bounds the request bodies, it's the size limit of the GitHub webhook payloads
+	maxBodyBytes           = 25 << 20
 	defaultKeyFile         = "/etc/subscription/tls.key"
 	defaultCrtFile         = "/etc/subscription/tls.crt"
 	GithubEventHeader      = "X-Github-Event"
@@ -59,16 +76,25 @@ type WebhookListener struct {
 	LocalClient   client.Client
 	RemoteClient  client.Client
 	DynamicClient dynamic.Interface
+	KubeClient    kubernetes.Interface
+	EventRecorder *utils.EventRecorder
 	TLSKeyFile    string
 	TLSCrtFile    string
+	Port          int
+	deliveries    *deliveryLog
+	// renewCert generates a new self-signed key and certificate in the TLS files. It is nil for provided files.
+	renewCert func() error
 }
 
 var webhookListener *WebhookListener
 
 // Add does nothing for namespace subscriber, it generates cache for each of the item
-func Add(mgr manager.Manager, hubconfig *rest.Config, tlsKeyFile, tlsCrtFile string, disableTLS bool, createService bool) error {
+func Add(mgr manager.Manager, hubconfig *rest.Config, tlsKeyFile, tlsCrtFile string, disableTLS bool, createService bool,
+	port int, deliveryOpts DeliveryOptions) error {
 	klog.V(2).Info("Setting up webhook listener ...")
 
+	var renewCert func() error
+
 	if !disableTLS {
 		dir := "/root/certs"
 
@@ -82,22 +108,36 @@ func Add(mgr manager.Manager, hubconfig *rest.Config, tlsKeyFile, tlsCrtFile str
 
 			tlsKeyFile = filepath.Join(dir, "tls.key")
 			tlsCrtFile = filepath.Join(dir, "tls.crt")
+			renewCert = func() error { return utils.GenerateServerCerts(dir) }
 		}
 	}
 
 	var err error
 
-	webhookListener, err = CreateWebhookListener(mgr.GetConfig(), hubconfig, mgr.GetScheme(), tlsKeyFile, tlsCrtFile, createService)
+	webhookListener, err = CreateWebhookListener(mgr.GetConfig(), hubconfig, mgr.GetScheme(), tlsKeyFile, tlsCrtFile, false)
 
 	if err != nil {
 		klog.Error("Failed to create synchronizer. error: ", err)
 		return err
 	}
 
+	webhookListener.Port = port
+	webhookListener.deliveries = newDeliveryLog(deliveryOpts)
+
+	if renewCert != nil && webhookListener.TLSKeyFile == tlsKeyFile && webhookListener.TLSCrtFile == tlsCrtFile {
+		webhookListener.renewCert = renewCert
+	}
+
+	if createService {
+		if err := webhookListener.createService(); err != nil {
+			return err
+		}
+	}
+
 	return mgr.Add(webhookListener)
 }
 
-// Start the GutHub WebHook event listener
+// Start the GutHub WebHook event listener. It stops the listener when the stop channel is closed.
 func (listener *WebhookListener) Start(l <-chan struct{}) error {
 	if klog.V(utils.QuiteLogLel) {
 		fnName := utils.GetFnName()
@@ -106,21 +146,86 @@ func (listener *WebhookListener) Start(l <-chan struct{}) error {
 		defer klog.Infof("Exiting: %v()", fnName)
 	}
 
-	http.HandleFunc("/webhook", listener.HandleWebhook)
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", listener.Port))
+	if err != nil {
+		klog.Errorf("Failed to listen on port %d. error: %v", listener.Port, err)
+		return err
+	}
+
+	return listener.serve(ln, l)
+}
+
+// serve serves the webhook listener requests on the network listener until the stop channel is closed
+func (listener *WebhookListener) serve(ln net.Listener, stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", listener.HandleWebhook)
+	mux.HandleFunc(helmRepoWebhookPath, listener.HandleWebhook)
+	mux.HandleFunc(objectBucketWebhookPath, listener.HandleWebhook)
+	mux.HandleFunc(manualSyncPath, listener.HandleSync)
+	mux.HandleFunc(deliveryHistoryPath, listener.HandleDeliveries)
+
+	server := &http.Server{
+		Handler:           limitBody(mux, maxBodyBytes),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	errc := make(chan error, 1)
 
 	if listener.TLSKeyFile != "" && listener.TLSCrtFile != "" {
-		klog.Info("Starting the WebHook listener on port 8443 with TLS key and cert files: " + listener.TLSKeyFile + " " + listener.TLSCrtFile)
-		klog.Fatal(http.ListenAndServeTLS(":8443", listener.TLSCrtFile, listener.TLSKeyFile, nil))
+		reloader, err := newCertReloader(listener.TLSKeyFile, listener.TLSCrtFile, listener.renewCert)
+		if err != nil {
+			klog.Error("Failed to load the TLS key and cert files. error: ", err)
+			ln.Close()
+
+			return err
+		}
+
+		go reloader.run(stop, certReloadInterval)
+
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		klog.Infof("Starting the WebHook listener on %s with TLS key and cert files: %s %s", ln.Addr(), listener.TLSKeyFile, listener.TLSCrtFile)
+
+		go func() { errc <- server.ServeTLS(ln, "", "") }()
 	} else {
-		klog.Info("Starting the WebHook listener on port 8443 with no TLS.")
-		klog.Fatal(http.ListenAndServe(":8443", nil))
+		klog.Infof("Starting the WebHook listener on %s with no TLS.", ln.Addr())
+
+		go func() { errc <- server.Serve(ln) }()
+	}
+
+	select {
+	case err := <-errc:
+		klog.Error("The WebHook listener failed. error: ", err)
+		return err
+	case <-stop:
 	}
 
-	klog.Info("the WebHook listener started on port 8443.")
+	klog.Info("Stopping the WebHook listener.")
 
-	<-l
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	return nil
+	return server.Shutdown(ctx)
+}
+
+// limitBody rejects the requests whose content length is larger than limit, reading a larger body without a
+// content length fails in the handlers
+func limitBody(h http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("The request body is larger than %d bytes.", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
 }
 
 // CreateWebhookListener creates a WebHook listener instance
@@ -143,6 +248,8 @@ func CreateWebhookListener(config,
 	l := &WebhookListener{
 		DynamicClient: dynamicClient,
 		localConfig:   config,
+		Port:          DefaultPort,
+		deliveries:    newDeliveryLog(DefaultDeliveryOptions),
 	}
 
 	// The user-provided key and cert files take precedence over the default provided files if both sets exist.
@@ -169,6 +276,20 @@ func CreateWebhookListener(config,
 		return nil, err
 	}
 
+	l.KubeClient, err = kubernetes.NewForConfig(config)
+
+	if err != nil {
+		klog.Error("Failed to initialize client to review manual sync requests. error: ", err)
+		return nil, err
+	}
+
+	l.EventRecorder, err = utils.NewEventRecorder(config, scheme)
+
+	if err != nil {
+		klog.Error("Failed to create an event recorder for webhook deliveries. error: ", err)
+		return nil, err
+	}
+
 	l.RemoteClient = l.LocalClient
 	if remoteConfig != nil {
 		l.RemoteClient, err = client.New(remoteConfig, client.Options{})
@@ -180,25 +301,34 @@ func CreateWebhookListener(config,
 	}
 
 	if createService {
-		namespace, err := getOperatorNamespace()
-
-		if err != nil {
+		if err := l.createService(); err != nil {
 			return nil, err
 		}
+	}
+
+	return l, err
+}
 
-		// Create the webhook listener service only when the subscription controller runs in hub mode.
-		err = createWebhookListnerService(l.LocalClient, namespace)
+// createService creates the webhook listener service in the namespace of the operator
+func (listener *WebhookListener) createService() error {
+	namespace, err := getOperatorNamespace()
 
-		if err != nil {
-			klog.Error("Failed to create a service for Git webhook listener. error: ", err)
-			return nil, err
-		}
+	if err != nil {
+		return err
 	}
 
-	return l, err
+	// Create the webhook listener service only when the subscription controller runs in hub mode.
+	err = createWebhookListnerService(listener.LocalClient, namespace, listener.Port)
+
+	if err != nil {
+		klog.Error("Failed to create a service for Git webhook listener. error: ", err)
+		return err
+	}
+
+	return nil
 }
 
-func createWebhookListnerService(client client.Client, namespace string) error {
+func createWebhookListnerService(client client.Client, namespace string, port int) error {
 	var theServiceKey = types.NamespacedName{
 		Name:      serviceName,
 		Namespace: namespace,
@@ -208,7 +338,7 @@ func createWebhookListnerService(client client.Client, namespace string) error {
 
 	if err := client.Get(context.TODO(), theServiceKey, service); err != nil {
 		if errors.IsNotFound(err) {
-			service, err := webhookListnerService(client, namespace)
+			service, err := webhookListnerService(client, namespace, port)
 
 			if err != nil {
 				return err
@@ -222,12 +352,25 @@ func createWebhookListnerService(client client.Client, namespace string) error {
 		} else {
 			return err
 		}
+
+		return nil
+	}
+
+	// The service port stays the same when the listener port changes
+	if len(service.Spec.Ports) > 0 && service.Spec.Ports[0].TargetPort.IntValue() != port {
+		service.Spec.Ports[0].TargetPort = intstr.FromInt(port)
+
+		if err := client.Update(context.TODO(), service); err != nil {
+			return err
+		}
+
+		klog.Infof("Git webhook listner service target port updated to %d.", port)
 	}
 
 	return nil
 }
 
-func webhookListnerService(client client.Client, namespace string) (*corev1.Service, error) {
+func webhookListnerService(client client.Client, namespace string, port int) (*corev1.Service, error) {
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      serviceName,
@@ -242,8 +385,8 @@ func webhookListnerService(client client.Client, namespace string) (*corev1.Serv
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{
 				{
-					Port:       8443,
-					TargetPort: intstr.FromInt(8443),
+					Port:       DefaultPort,
+					TargetPort: intstr.FromInt(port),
 					Protocol:   "TCP",
 				},
 			},
@@ -289,47 +432,52 @@ func findEnvVariable(envName string) (string, error) {
 func (listener *WebhookListener) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	klog.Info("handleWebhook headers: ", r.Header)
 
-	if r.Header.Get(GithubEventHeader) != "" {
-		// This is an event from a GitHub repository.
-		err := listener.handleGithubWebhook(r)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
-
-			if err != nil {
-				klog.Error(err.Error())
-			}
-		}
-	} else if r.Header.Get(BitbucketEventHeader) != "" {
-		// This is an event from a BitBucket repository.
-		err := listener.handleBitbucketWebhook(r)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
+	delivery := Delivery{Time: metav1.Now(), SourceIP: listener.deliveries.sourceIP(r)}
 
-			if err != nil {
-				klog.Error(err.Error())
-			}
-		}
-	} else if r.Header.Get(GitlabEventHeader) != "" {
-		// This is an event from a GitLab repository.
-		err := listener.handleGitlabWebhook(r)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
+	defer func() {
+		listener.deliveries.record(delivery)
+	}()
 
-			if err != nil {
-				klog.Error(err.Error())
-			}
-		}
-	} else {
-		klog.Info("handleWebhook headers: ", r.Header)
+	provider := getWebhookProvider(r)
+	if provider == nil {
 		klog.Info("Unsupported webhook event type.")
+
+		delivery.Result = DeliveryUnsupported
+
 		w.WriteHeader(http.StatusBadRequest)
 		_, err := w.Write([]byte("Unsupported webhook event type."))
 		if err != nil {
 			klog.Error(err.Error())
 		}
+
+		return
+	}
+
+	delivery.Provider = provider.Name()
+
+	var err error
+	if listener.deliveries.allow("ip/" + delivery.SourceIP) {
+		err = listener.handleProviderWebhook(provider, r, &delivery)
+	} else {
+		delivery.Result = DeliveryRateLimited
+		err = newStatusError(http.StatusTooManyRequests, "too many webhook deliveries from %s", delivery.SourceIP)
+	}
+
+	if err != nil {
+		klog.Info("Webhook delivery failed. error: ", err)
+
+		if delivery.Result == "" {
+			delivery.Result = DeliveryFailed
+		}
+
+		delivery.Message = err.Error()
+
+		w.WriteHeader(errorStatus(err))
+		_, err = w.Write([]byte(err.Error()))
+
+		if err != nil {
+			klog.Error(err.Error())
+		}
 	}
 }
 
@@ -362,6 +510,20 @@ func (listener *WebhookListener) updateSubscription(sub appv1alpha1.Subscription
 	return newsub
 }
 
+// refreshSubscription sets the manual refresh time of the subscription so that its subscriber syncs it immediately
+func (listener *WebhookListener) refreshSubscription(sub appv1alpha1.Subscription) *appv1alpha1.Subscription {
+	subAnnotations := sub.GetAnnotations()
+
+	if subAnnotations == nil {
+		subAnnotations = make(map[string]string)
+	}
+
+	subAnnotations[appv1alpha1.AnnotationManualReconcileTime] = time.Now().UTC().Format(time.RFC3339Nano)
+	sub.SetAnnotations(subAnnotations)
+
+	return listener.updateSubscription(sub)
+}
+
 func getOperatorNamespace() (string, error) {
 	nsBytes, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 	if err != nil {
@@ -0,0 +1,344 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	chnv1alpha1 "github.com/open-cluster-management/multicloud-operators-channel/pkg/apis/apps/v1"
+	appv1alpha1 "github.com/open-cluster-management/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+var errInvalidChannel = errors.New("failed to get channel namespace and name")
+
+// WebhookProvider parses and validates the webhook events of a Git hosting service, Helm repository or object store
+type WebhookProvider interface {
+	// Name returns the name of the service
+	Name() string
+	// ChannelType returns the type of the channels of the service
+	ChannelType() chnv1alpha1.ChannelType
+	// Matches returns true if the request is sent by the service
+	Matches(r *http.Request) bool
+	// ParseEvent reads the event of the request. It returns a nil event for the event types that do not trigger
+	// subscriptions.
+	ParseEvent(r *http.Request) (*WebhookEvent, error)
+	// MatchesRepo returns true if the channel pathname is the repository or bucket of the event
+	MatchesRepo(event *WebhookEvent, pathname string) bool
+	// ValidateSecret returns true if the event is sent with the webhook secret of the channel
+	ValidateSecret(event *WebhookEvent, secret string) bool
+}
+
+// WebhookEvent is a webhook event parsed by a provider
+type WebhookEvent struct {
+	// Type is the event type of the provider, like push
+	Type string
+	// Repo is the name or URL of the repository or bucket used in logs
+	Repo string
+	// Body is the payload the signature is computed on
+	Body []byte
+	// Signature is the signature, token or password sent with the event
+	Signature string
+	// DeliveryID is the unique ID of the delivery set by the provider, the same in the retries of the delivery
+	DeliveryID string
+	// Payload is the parsed payload of the provider
+	Payload interface{}
+	// change selects the subscriptions of the channel affected by the event
+	change subscriptionFilter
+}
+
+// subscriptionFilter selects the subscriptions affected by a webhook event
+type subscriptionFilter interface {
+	matches(sub *appv1alpha1.Subscription) bool
+}
+
+// webhookProviders are the supported services. The Helm repository and object bucket providers are matched by the
+// request path. Gitea sends the GitHub and Gogs event headers too, so it is matched before GitHub.
+var webhookProviders = []WebhookProvider{
+	helmRepoProvider{},
+	objectBucketProvider{},
+	giteaProvider{},
+	githubProvider{},
+	bitbucketProvider{},
+	gitlabProvider{},
+	azureDevOpsProvider{},
+}
+
+// getWebhookProvider returns the provider of the request or nil if the request is not from a supported provider
+func getWebhookProvider(r *http.Request) WebhookProvider {
+	for _, provider := range webhookProviders {
+		if provider.Matches(r) {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// handleProviderWebhook triggers the subscriptions affected by the event of the request and records the event and
+// the subscription results in the delivery. The repository rate limit and the duplicate check only count the
+// deliveries authenticated with the webhook secret of a channel of the repository.
+func (listener *WebhookListener) handleProviderWebhook(provider WebhookProvider, r *http.Request, delivery *Delivery) error {
+	klog.Infof("Handling %s webhook event", provider.Name())
+
+	event, err := provider.ParseEvent(r)
+	if err != nil {
+		klog.Errorf("Failed to parse the %s webhook event. error: %v", provider.Name(), err)
+		return err
+	}
+
+	if event == nil {
+		delivery.Result = DeliveryIgnored
+		return nil
+	}
+
+	delivery.Event = event.Type
+	delivery.Repo = event.Repo
+	delivery.DeliveryID = event.DeliveryID
+	delivery.Refs = changeRefs(event.change)
+
+	subList := &appv1alpha1.SubscriptionList{}
+	listopts := &client.ListOptions{}
+
+	err = listener.LocalClient.List(context.TODO(), subList, listopts)
+	if err != nil {
+		klog.Error("Failed to get subscriptions. error: ", err)
+		return err
+	}
+
+	authenticated := []appv1alpha1.Subscription{}
+	rejected := []appv1alpha1.Subscription{}
+
+	for _, sub := range subList.Items {
+		matched, valid := listener.authenticateProviderEvent(provider, sub, event)
+
+		switch {
+		case matched && valid:
+			authenticated = append(authenticated, sub)
+		case matched:
+			rejected = append(rejected, sub)
+		}
+	}
+
+	for i := range rejected {
+		listener.addDeliverySubscription(delivery, &rejected[i], provider, event, SubscriptionSecretInvalid)
+	}
+
+	if len(authenticated) == 0 {
+		delivery.Result = DeliveryAccepted
+
+		if len(rejected) > 0 {
+			delivery.Result = DeliveryUnauthenticated
+		}
+
+		return nil
+	}
+
+	if !listener.deliveries.allow("repo/" + provider.Name() + "/" + event.Repo) {
+		delivery.Result = DeliveryRateLimited
+		return newStatusError(http.StatusTooManyRequests, "too many webhook deliveries for repository %s", event.Repo)
+	}
+
+	if listener.deliveries.duplicate(provider.Name(), event.Repo, event.DeliveryID) {
+		klog.Infof("Skipping duplicate %s webhook delivery %s", provider.Name(), event.DeliveryID)
+
+		delivery.Result = DeliveryDuplicate
+
+		return nil
+	}
+
+	delivery.Result = DeliveryAccepted
+
+	for i := range authenticated {
+		result := listener.processProviderEvent(provider, authenticated[i], event)
+
+		listener.addDeliverySubscription(delivery, &authenticated[i], provider, event, result)
+	}
+
+	return nil
+}
+
+// addDeliverySubscription records the result of the delivery for the subscription
+func (listener *WebhookListener) addDeliverySubscription(delivery *Delivery, sub *appv1alpha1.Subscription,
+	provider WebhookProvider, event *WebhookEvent, result string) {
+	delivery.Subscriptions = append(delivery.Subscriptions, DeliverySubscription{
+		Namespace: sub.GetNamespace(),
+		Name:      sub.GetName(),
+		Result:    result,
+	})
+
+	listener.recordDeliveryEvent(sub, provider, event, result)
+}
+
+// authenticateProviderEvent returns whether the subscription channel is the repository of the event, and whether the
+// event is sent with the webhook secret of the channel
+func (listener *WebhookListener) authenticateProviderEvent(provider WebhookProvider, sub appv1alpha1.Subscription,
+	event *WebhookEvent) (matched, valid bool) {
+	klog.V(2).Info("Evaluating subscription: " + sub.GetName())
+
+	chobj, err := listener.getSubscriptionChannel(sub)
+	if err != nil {
+		return false, false
+	}
+
+	if !listener.validateProviderChannel(provider, chobj) {
+		return false, false
+	}
+
+	if !provider.MatchesRepo(event, chobj.Spec.Pathname) {
+		return false, false
+	}
+
+	channelSecret := listener.getWebhookSecret(chobj.GetAnnotations()[appv1alpha1.AnnotationWebhookSecret], chobj.GetNamespace())
+
+	if !provider.ValidateSecret(event, channelSecret) {
+		klog.V(2).Infof("WebHook secret validation failed. Skipping to process this subscription.")
+		return true, false
+	}
+
+	return true, true
+}
+
+// processProviderEvent triggers the authenticated subscription if the event affects it. It returns the subscription
+// result of the delivery.
+func (listener *WebhookListener) processProviderEvent(provider WebhookProvider, sub appv1alpha1.Subscription, event *WebhookEvent) string {
+	if !event.change.matches(&sub) {
+		klog.Infof("Skipping %s event from %s repository for subscription %s", event.Type, event.Repo, sub.Name)
+		return SubscriptionSkipped
+	}
+
+	klog.Infof("Processing %s event from %s repository for subscription %s", event.Type, event.Repo, sub.Name)
+
+	key := types.NamespacedName{Namespace: sub.GetNamespace(), Name: sub.GetName()}
+
+	if listener.deliveries.coalesce(key, func() { listener.triggerLatestSubscription(provider, key) }) {
+		klog.Infof("Coalesced %s event from %s repository for subscription %s", event.Type, event.Repo, sub.Name)
+		return SubscriptionCoalesced
+	}
+
+	listener.triggerSubscription(provider, sub)
+
+	return SubscriptionTriggered
+}
+
+// triggerSubscription makes the subscriber of the subscription sync it
+func (listener *WebhookListener) triggerSubscription(provider WebhookProvider, sub appv1alpha1.Subscription) {
+	// Git subscribers of webhook-enabled channels only sync on webhook events. The subscribers of other channels keep
+	// polling, the manual refresh time makes them sync immediately.
+	if provider.ChannelType() != chnv1alpha1.ChannelTypeGit {
+		listener.refreshSubscription(sub)
+		return
+	}
+
+	listener.updateSubscription(sub)
+}
+
+// triggerLatestSubscription gets the subscription and triggers it. It runs the coalesced syncs at the end of the
+// coalesce window.
+func (listener *WebhookListener) triggerLatestSubscription(provider WebhookProvider, key types.NamespacedName) {
+	sub := &appv1alpha1.Subscription{}
+
+	if err := listener.LocalClient.Get(context.TODO(), key, sub); err != nil {
+		klog.Errorf("Failed to get subscription %s for a coalesced webhook sync. error: %v", key, err)
+		return
+	}
+
+	listener.triggerSubscription(provider, *sub)
+}
+
+// validateProviderChannel returns true if the channel has the type of the provider and webhook events are enabled
+func (listener *WebhookListener) validateProviderChannel(provider WebhookProvider, chobj *chnv1alpha1.Channel) bool {
+	if provider.ChannelType() == chnv1alpha1.ChannelTypeGit {
+		return listener.validateChannel(chobj, "", chobj.GetNamespace(), []byte(""))
+	}
+
+	if !strings.EqualFold(string(chobj.Spec.Type), string(provider.ChannelType())) {
+		klog.V(2).Infof("The channel type is %s. Skipping to process this subscription.", chobj.Spec.Type)
+		return false
+	}
+
+	if !strings.EqualFold(chobj.GetAnnotations()[appv1alpha1.AnnotationWebhookEnabled], "true") {
+		klog.V(2).Infof("WebHook event listening is not enabled on the channel. Skipping to process this subscription.")
+		return false
+	}
+
+	return true
+}
+
+// getSubscriptionChannel returns the channel of the subscription
+func (listener *WebhookListener) getSubscriptionChannel(sub appv1alpha1.Subscription) (*chnv1alpha1.Channel, error) {
+	chNamespace := ""
+	chName := ""
+
+	if sub.Spec.Channel != "" {
+		strs := strings.Split(sub.Spec.Channel, "/")
+		if len(strs) == 2 {
+			chNamespace = strs[0]
+			chName = strs[1]
+		} else {
+			klog.Error("Failed to get channel namespace and name.")
+			return nil, errInvalidChannel
+		}
+	}
+
+	chkey := types.NamespacedName{Name: chName, Namespace: chNamespace}
+	chobj := &chnv1alpha1.Channel{}
+
+	err := listener.RemoteClient.Get(context.TODO(), chkey, chobj)
+	if err != nil {
+		klog.Error("Failed to get subscription's channel. error: ", err)
+		return nil, err
+	}
+
+	return chobj, nil
+}
+
+// bearerToken returns the token of the Authorization header, with or without the Bearer scheme
+func bearerToken(r *http.Request) string {
+	token := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	if len(token) > len("bearer ") && strings.EqualFold(token[:len("bearer ")], "bearer ") {
+		token = strings.TrimSpace(token[len("bearer "):])
+	}
+
+	return token
+}
+
+// sameRepoURL returns true if the URLs are the same repository, ignoring the user info, case, trailing slash and
+// .git suffix
+func sameRepoURL(url1, url2 string) bool {
+	url1 = normalizeRepoURL(url1)
+
+	return url1 != "" && url1 == normalizeRepoURL(url2)
+}
+
+func normalizeRepoURL(repoURL string) string {
+	repoURL = strings.ToLower(strings.TrimSpace(repoURL))
+	repoURL = strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return repoURL
+	}
+
+	return u.Host + strings.TrimSuffix(u.Path, "/")
+}
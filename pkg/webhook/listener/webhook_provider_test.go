@@ -0,0 +1,87 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" // #nosec G505 GitHub signs the X-Hub-Signature header with SHA1
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+func TestGetWebhookProvider(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	headers := map[string]WebhookProvider{
+		GithubEventHeader:    githubProvider{},
+		BitbucketEventHeader: bitbucketProvider{},
+		GitlabEventHeader:    gitlabProvider{},
+		GiteaEventHeader:     giteaProvider{},
+		GogsEventHeader:      giteaProvider{},
+	}
+
+	for header, provider := range headers {
+		req, err := http.NewRequest("POST", "/webhook", nil)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		req.Header.Set(header, "push")
+		g.Expect(getWebhookProvider(req)).To(gomega.Equal(provider))
+	}
+
+	req, err := http.NewRequest("POST", "/webhook", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(getWebhookProvider(req)).To(gomega.BeNil())
+}
+
+func TestGithubProviderSecret(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBufferString(githubPushPayload))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	mac := hmac.New(sha1.New, []byte("github-secret"))
+	mac.Write([]byte(githubPushPayload))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(GithubEventHeader, "push")
+	req.Header.Set(githubSignatureHeader, "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	provider := githubProvider{}
+
+	event, err := provider.ParseEvent(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(event.change.(gitChange).refs).To(gomega.Equal([]string{"refs/heads/main"}))
+	g.Expect(provider.MatchesRepo(event, "https://github.com/example/monorepo.git")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "github-secret")).To(gomega.BeTrue())
+	g.Expect(provider.ValidateSecret(event, "other-secret")).To(gomega.BeFalse())
+
+	// Unsigned events are not validated
+	event.Signature = ""
+	g.Expect(provider.ValidateSecret(event, "github-secret")).To(gomega.BeTrue())
+}
+
+func TestSameRepoURL(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(sameRepoURL("https://github.com/example/apps.git", "https://github.com/Example/apps")).To(gomega.BeTrue())
+	g.Expect(sameRepoURL("https://user@github.com/example/apps/", "https://github.com/example/apps")).To(gomega.BeTrue())
+	g.Expect(sameRepoURL("https://github.com/example/apps", "https://github.com/example/apps2")).To(gomega.BeFalse())
+	g.Expect(sameRepoURL("https://github.com/example/apps", "https://gitlab.com/example/apps")).To(gomega.BeFalse())
+	g.Expect(sameRepoURL("", "")).To(gomega.BeFalse())
+}
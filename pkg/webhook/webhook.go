@@ -17,15 +17,18 @@ package webhook
 import (
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/open-cluster-management/multicloud-operators-subscription/pkg/webhook/listener"
 )
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager, *rest.Config, string, string, bool, bool) error
+var AddToManagerFuncs []func(manager.Manager, *rest.Config, string, string, bool, bool, int, listener.DeliveryOptions) error
 
 // AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager, hubconfig *rest.Config, tlsKeyFile, tlsCrtFile string, disableTLS bool, createService bool) error {
+func AddToManager(m manager.Manager, hubconfig *rest.Config, tlsKeyFile, tlsCrtFile string, disableTLS bool, createService bool,
+	port int, deliveryOpts listener.DeliveryOptions) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m, hubconfig, tlsKeyFile, tlsCrtFile, disableTLS, createService); err != nil {
+		if err := f(m, hubconfig, tlsKeyFile, tlsCrtFile, disableTLS, createService, port, deliveryOpts); err != nil {
 			return err
 		}
 	}